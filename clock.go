@@ -0,0 +1,23 @@
+package fileinbox
+
+import "time"
+
+// Clock tells the time.  Everything that depends on the date, such as
+// the check for dates too far in the future, asks a Clock so tests and
+// bug reports can pin it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the real time.
+var SystemClock Clock = systemClock{}
+
+// FixedClock is a Clock that is always at the same moment.
+type FixedClock time.Time
+
+// Now returns the fixed moment.
+func (c FixedClock) Now() time.Time { return time.Time(c) }
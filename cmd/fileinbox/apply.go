@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// applyPlan carries out a plan, honoring Config.Immutable and the
+// configured modes, and adds what happened to fr.  It returns an error
+// only if it had to stop, as the filed tree went away.
+func applyPlan(config *Config, plan *fileinbox.Plan, im *immutability, fr *fileResult) error {
+	tasks := len(plan.Moves)
+	var filed []journalEntry
+	var workDir string
+	if fr.work != nil {
+		var err error
+		if workDir, err = fr.work.partial(); err != nil {
+			return err
+		}
+	}
+	r := plan.Apply(fileinbox.ApplyOptions{
+		DirMode:  config.perms.dir,
+		FileMode: config.perms.file,
+		Group:    config.perms.group,
+		WorkDir:  workDir,
+		Before: func(m fileinbox.Move) error {
+			for _, name := range append([]string{m.To}, m.Copies...) {
+				if err := im.unlock(config.destDir(name), path.Dir(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		After: func(m fileinbox.Move) error {
+			for _, name := range append([]string{m.To}, m.Copies...) {
+				if err := im.lock(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Retries: config.retries(),
+		Backoff: config.retryBackoff(),
+		Retrying: func(i int, m fileinbox.Move, err error) {
+			printf(progress, styleNotice, "Unable to file %q, will try again: %v\n", m.From, err)
+		},
+		Halt:           filedInterlock(config.filed()),
+		KeepDuplicates: config.Duplicates == duplicatesKeep,
+		Versioned:      config.Collisions == collisionsVersioned,
+		Report: func(i int, m fileinbox.Move, moved bool, err error) {
+			switch {
+			case fileinbox.IsDuplicate(err):
+				if config.Duplicates == duplicatesKeep {
+					printf(progress, styleNotice, "%q is already filed as %s, leaving it in the inbox\n", m.From, m.To)
+				} else {
+					printf(progress, styleNotice, "%q is already filed as %s, removed it from the inbox\n", m.From, m.To)
+				}
+				return
+			case fileinbox.IsConflict(err):
+				printf(progress, styleFailure, "Unable to file %q, as a different document is already filed as %s\n", m.From, m.To)
+				fr.conflicts = append(fr.conflicts, m.From)
+				fr.failures = append(fr.failures, newFailure(m.From, failFile, err))
+				events.publish(eventFailed, m.From, m.To, err)
+				return
+			case fileinbox.IsCCConflict(err):
+				printf(progress, styleFailure, "Unable to file %q, as a different document is already mirrored as %s\n", m.From, m.CC)
+				fr.ccConflicts = append(fr.ccConflicts, m.From)
+				fr.failures = append(fr.failures, newFailure(m.From, failFile, err))
+				events.publish(eventFailed, m.From, m.To, err)
+				return
+			case err != nil && moved:
+				// it was filed all the same, so undo, recent and touch
+				// need to know where it went
+				printf(progress, styleFailure, "Filed %q as %s, but unable to lock it: %v\n", m.From, m.To, err)
+				fr.unlocked = append(fr.unlocked, m.To)
+				events.publish(eventFiled, m.From, m.To, err)
+			case err != nil:
+				printf(progress, styleFailure, "Unable to file %q: %v\n", m.From, err)
+				fr.failures = append(fr.failures, newFailure(m.From, failFile, err))
+				events.publish(eventFailed, m.From, m.To, err)
+				return
+			default:
+				events.publish(eventFiled, m.From, m.To, nil)
+				printf(progress, styleSuccess, "(%d/%d) Filed\r", i+1, tasks)
+			}
+			if fr.touched == nil {
+				fr.touched = map[string]int{}
+			}
+			fr.touched[config.destName(m.To)]++
+			filed = append(filed, journalEntry{Time: clock.Now(), From: m.From, To: m.To, CC: m.CC, Copies: m.Copies, Tags: m.Tags, Run: thisRun.id, Label: thisRun.label, User: thisRun.user})
+		},
+	})
+	if err := config.appendJournal(filed); err != nil {
+		printf(progress, styleFailure, "Unable to record what was filed in the journal: %v\n", err)
+	}
+	if err := config.indexTags(filed); err != nil {
+		printf(progress, styleFailure, "Unable to record the tags of what was filed in the index: %v\n", err)
+	}
+	fr.okCount += uint32(r.Moved)
+	fr.retriedCount += uint32(r.Retried)
+	fr.failureCount += uint32(r.Failed)
+	fr.movedBytes += r.MovedBytes
+	fr.copiedBytes += r.CopiedBytes
+	fr.ccBytes += r.CCBytes
+	fr.duplicates += uint32(r.Duplicates)
+	fr.versions += uint32(r.Versions)
+	fr.copies += uint32(r.Copies)
+	fr.skippedCount += uint32(r.Skipped)
+	fr.skippedBytes += r.SkippedBytes
+	fmt.Fprint(progress, " \n")
+	if r.Halted != nil {
+		return errors.Wrap(r.Halted, "stopped filing; what is left is still in the inbox, to be filed once it is back")
+	}
+	return nil
+}
+
+// filedInterlock returns a check that filed is still the directory it
+// was when we started.  A network share that drops mid run either can't
+// be stat'ed, or leaves behind its empty mount point, which is a
+// different directory.  Without the check, every move from then on
+// would fail, or worse, fill the mount point.
+func filedInterlock(filed string) func() error {
+	start, err := os.Stat(filed)
+	if err != nil {
+		// the moves will say what is wrong
+		return nil
+	}
+	return func() error {
+		now, err := os.Stat(filed)
+		if err != nil {
+			return errors.Wrapf(err, "%s has gone away", filed)
+		}
+		if !os.SameFile(start, now) {
+			return errors.Errorf("%s has gone away, and something else is in its place, probably an unmounted share", filed)
+		}
+		return nil
+	}
+}
+
+// What to do with duplicates, see Config.Duplicates.
+const (
+	duplicatesDrop = "drop"
+	duplicatesKeep = "keep"
+)
+
+// What to do with a name taken by a different document, see
+// Config.Collisions.
+const (
+	collisionsConflict  = "conflict"
+	collisionsVersioned = "versioned"
+)
+
+// Defaults for Config.Retries and Config.RetryBackoff.
+const (
+	defaultRetries      = 3
+	defaultRetryBackoff = time.Second
+)
+
+func (c *Config) retries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries == 0:
+		return defaultRetries
+	}
+	return c.Retries
+}
+
+func (c *Config) retryBackoff() time.Duration {
+	if c.RetryBackoff == 0 {
+		return defaultRetryBackoff
+	}
+	return c.RetryBackoff
+}
+
+// destDir returns the dest directory a filed document is in.
+func (c *Config) destDir(name string) string {
+	return c.dest(c.destName(name))
+}
+
+// destName returns the dest a filed document is in.
+func (c *Config) destName(name string) string {
+	rel, err := filepath.Rel(c.filed(), path.Dir(name))
+	if err != nil {
+		return ""
+	}
+	return nestedDest("", rel)
+}
+
+func doApply(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("apply expects exactly one argument, a plan file or - for stdin")
+	}
+	return runApply(ctx, func(*Config) (*fileinbox.Plan, error) {
+		var r io.Reader = os.Stdin
+		if name := ctx.Args().First(); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		return fileinbox.ReadPlan(r)
+	})
+}
+
+// runApply carries out the plan read gives, and summarizes it.
+func runApply(ctx *cli.Context, read func(*Config) (*fileinbox.Plan, error)) error {
+	output := ctx.String(outputFlag)
+	startOutput(output)
+	startSummaryOnly(ctx)
+	startRun(ctx.String(runLabelFlag))
+
+	start := time.Now()
+	fr := fileResult{missingDirs: map[string]bool{}}
+	err := func() (err error) {
+		config, _, err := queryConfig(ctx)
+		if err != nil {
+			return err
+		}
+		if err = checkRoot(config.Root); err != nil {
+			return err
+		}
+		if err = config.checkOverlaps(); err != nil {
+			return err
+		}
+		if err = config.shareRoot(); err != nil {
+			return err
+		}
+		if fr.work, err = newWorkspace(config); err != nil {
+			return errors.Wrap(err, "making the run's workspace")
+		}
+		defer func() { fr.work.finish(&fr, err) }()
+
+		plan, err := read(config)
+		if err != nil {
+			return err
+		}
+		if err = plan.Check(config.filed(), config.inboxes(), config.ccRoots()); err != nil {
+			return err
+		}
+
+		im := newImmutability(config.Immutable)
+		defer func() {
+			if restoreErr := im.restore(); restoreErr != nil && err == nil {
+				err = errors.Wrap(restoreErr, "locking filed documents")
+			}
+		}()
+		if err := applyPlan(config, plan, im, &fr); err != nil {
+			return err
+		}
+		if err := config.updateDestCache(config.parseOptions(true), fr.touched); err != nil {
+			printf(progress, styleNotice, "Unable to update the dest summaries: %v\n", err)
+		}
+		return nil
+	}()
+
+	duration := time.Since(start)
+	summarizeErr := fr.summarizeIfAny(ctx, output, duration, err)
+	if err != nil {
+		return errors.Wrap(err, "apply")
+	}
+	return summarizeErr
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestDryRunThenApply(t *testing.T) {
+	start := []string{
+		"filed/foo/",
+		"inbox/20160701_foo.pdf",
+		"inbox/20150702_foo.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/foo/",
+		"filed/foo/2015/",
+		"filed/foo/2015/20150702_foo.pdf",
+		"filed/foo/2016/",
+		"filed/foo/2016/20160701_foo.pdf",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root}
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, true, &fr))
+	equals(t, 2, len(fr.plan))
+	equals(t, uint32(0), fr.okCount)
+
+	// nothing has moved yet
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{"filed/", "filed/foo/", "inbox/", "inbox/20150702_foo.pdf", "inbox/20160701_foo.pdf"}, found)
+
+	var buf bytes.Buffer
+	ok(t, fr.summarizeJSON(&buf, 0, nil))
+	plan, err := fileinbox.ReadPlan(&buf)
+	ok(t, err)
+	equals(t, fr.plan, plan.Moves)
+	ok(t, plan.Check(config.filed(), config.inboxes(), config.ccRoots()))
+
+	// a plan can't reach outside the inboxes
+	stray := &fileinbox.Plan{Moves: []fileinbox.Move{{From: path.Join(root, "secret.txt"), To: path.Join(config.filed(), "foo/2016/20160101_foo.pdf")}}}
+	assert(t, stray.Check(config.filed(), config.inboxes(), config.ccRoots()) != nil, "expected a move from outside the inboxes to be rejected")
+
+	applied := fileResult{missingDirs: map[string]bool{}}
+	ok(t, applyPlan(config, plan, newImmutability(false), &applied))
+	equals(t, uint32(2), applied.okCount)
+	equals(t, uint32(0), applied.failureCount)
+
+	found = readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+// A document that is filed but can't be locked afterwards is still
+// journaled, so undo and recent know where it went.
+func TestFiledButNotLocked(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/",
+		"inbox/20150301_pge.pdf",
+	})
+
+	// with nothing on the path, there is no chattr or chflags to lock it
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	ok(t, os.Setenv("PATH", root))
+	defer func() { clock = fileinbox.SystemClock }()
+	now := time.Date(2016, 8, 25, 12, 0, 0, 0, time.UTC)
+	clock = fileinbox.FixedClock(now)
+
+	config := &Config{Root: root, Immutable: true}
+	ok(t, config.validate())
+	from, to := path.Join(root, "inbox", "20150301_pge.pdf"), path.Join(root, "filed", "pge", "2015", "20150301_pge.pdf")
+	plan := &fileinbox.Plan{Moves: []fileinbox.Move{{From: from, To: to}}}
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, applyPlan(config, plan, newImmutability(true), &fr))
+	equals(t, 0, len(fr.failures))
+	equals(t, uint32(0), fr.failureCount)
+	equals(t, uint32(1), fr.okCount)
+	equals(t, []string{to}, fr.unlocked)
+	equals(t, []string{to}, fr.jsonSummary(time.Second, nil).Unlocked)
+	_, err = os.Stat(to)
+	ok(t, err)
+
+	journal, err := config.readJournal()
+	ok(t, err)
+	equals(t, 1, len(journal))
+	equals(t, from, journal[0].From)
+	equals(t, to, journal[0].To)
+	assert(t, journal[0].Time.Equal(now), "expected the journal to use the clock, got %v", journal[0].Time)
+	equals(t, 1, fr.touched["pge"])
+}
+
+func TestRetryDefaults(t *testing.T) {
+	config := &Config{}
+	ok(t, config.validate())
+	equals(t, defaultRetries, config.retries())
+	equals(t, defaultRetryBackoff, config.retryBackoff())
+
+	config = &Config{Retries: -1, RetryBackoff: 5 * time.Second}
+	ok(t, config.validate())
+	equals(t, 0, config.retries())
+	equals(t, 5*time.Second, config.retryBackoff())
+
+	config.RetryBackoff = -time.Second
+	assert(t, config.validate() != nil, "Expected a negative backoff to be rejected")
+}
+
+func TestDuplicates(t *testing.T) {
+	start := []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"inbox/20160825_pge.pdf",
+		"inbox/20160901_pge.pdf",
+	}
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	// same name, different contents, as if misdated
+	conflict := path.Join(root, "filed/pge/2016/20160901_pge.pdf")
+	ok(t, ioutil.WriteFile(conflict, []byte("a different bill"), 0600))
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(0), fr.okCount)
+	equals(t, uint32(1), fr.duplicates)
+	equals(t, uint32(1), fr.failureCount)
+	equals(t, []string{path.Join(root, "inbox/20160901_pge.pdf")}, fr.conflicts)
+
+	_, err = os.Stat(path.Join(root, "inbox/20160825_pge.pdf"))
+	assert(t, os.IsNotExist(err), "expected the duplicate to be dropped from the inbox")
+	contents, err := ioutil.ReadFile(conflict)
+	ok(t, err)
+	equals(t, "a different bill", string(contents))
+
+	config.Duplicates = "shrug"
+	assert(t, config.validate() != nil, "Expected an unknown duplicates policy to be rejected")
+
+	// with versions, the reissued bill is filed alongside
+	config.Duplicates, config.Collisions = "", collisionsVersioned
+	ok(t, config.validate())
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(1), fr.versions)
+	equals(t, 0, len(fr.conflicts))
+	versions, err := fileinbox.Versions(conflict)
+	ok(t, err)
+	equals(t, []string{conflict, path.Join(root, "filed/pge/2016/20160901_pge.v2.pdf")}, versions)
+
+	config.Collisions = "shrug"
+	assert(t, config.validate() != nil, "Expected an unknown collisions policy to be rejected")
+}
+
+func TestDuplicatesAnywhere(t *testing.T) {
+	start := []string{
+		"filed/chase/2016/",
+		"filed/scans/",
+		"filed/pge/",
+		"inbox/20160901_pge.pdf",
+	}
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	for name, contents := range map[string]string{
+		"filed/chase/2016/20160825_chase_statement.pdf": "the statement",
+		// the same statement, scanned from paper
+		"inbox/20160826_scans.pdf": "the statement",
+		// and two copies of a bill
+		"inbox/20160902_pge.pdf": "the bill",
+		"inbox/20160903_pge.pdf": "the bill",
+	} {
+		ok(t, ioutil.WriteFile(path.Join(root, name), []byte(contents), 0600))
+	}
+	inbox := path.Join(root, "inbox")
+
+	config := &Config{Root: root, DuplicatesAnywhere: true}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(2), fr.okCount)
+	equals(t, uint32(1), fr.duplicates)
+	equals(t, uint32(0), fr.failureCount)
+	_, err = os.Stat(path.Join(inbox, "20160826_scans.pdf"))
+	assert(t, os.IsNotExist(err), "expected the scan to be dropped from the inbox")
+	_, err = os.Stat(path.Join(root, "filed/scans/2016/20160826_scans.pdf"))
+	assert(t, os.IsNotExist(err), "expected the scan not to be filed")
+	_, err = os.Stat(path.Join(root, "filed/pge/2016/20160902_pge.pdf"))
+	ok(t, err)
+	_, err = os.Stat(path.Join(inbox, "20160903_pge.pdf"))
+	ok(t, err)
+	_, err = os.Stat(config.index())
+	ok(t, err)
+
+	// the second copy of the bill is caught by the next run
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(0), fr.okCount)
+	equals(t, uint32(1), fr.duplicates)
+	left, err := ioutil.ReadDir(inbox)
+	ok(t, err)
+	equals(t, 0, len(left))
+}
+
+func TestCCConflicts(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"inbox/20160825_pge.pdf",
+		"inbox/20160901_pge.pdf",
+		"mirror/pge/2016/20160825_pge.pdf",
+	}
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	mirrored := path.Join(root, "mirror/pge/2016/20160901_pge.pdf")
+	ok(t, ioutil.WriteFile(mirrored, []byte("an older bill"), 0600))
+
+	config := &Config{Root: root}
+	config.CC.Root = path.Join(root, "mirror")
+	config.CC.Dests = []string{"pge"}
+	ok(t, config.validate())
+
+	// the plan says which conflict, before anything is copied
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, true, &fr))
+	equals(t, []string{path.Join(root, "inbox/20160901_pge.pdf")}, fr.ccConflicts)
+	equals(t, 2, len(fr.plan))
+	assert(t, !fr.plan[0].CCConflict, "expected the same document in the mirror not to conflict")
+	assert(t, fr.plan[1].CCConflict, "expected a different document in the mirror to conflict")
+
+	// failing, by default, leaves it in the inbox
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(1), fr.failureCount)
+	equals(t, failCCConflict, fr.failures[0].Category)
+	equals(t, []string{path.Join(root, "inbox/20160901_pge.pdf")}, fr.ccConflicts)
+
+	config.CC.Conflicts = "version"
+	ok(t, config.validate())
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	contents, err := ioutil.ReadFile(path.Join(root, "mirror/pge/2016/20160901_pge.v2.pdf"))
+	ok(t, err)
+	equals(t, "contents for 20160901_pge.pdf", string(contents))
+
+	config.CC.Conflicts = "shrug"
+	assert(t, config.validate() != nil, "Expected an unknown cc conflicts policy to be rejected")
+}
+
+func TestChunkedInbox(t *testing.T) {
+	defer func(n int) { inboxChunk = n }(inboxChunk)
+	inboxChunk = 2
+
+	start := []string{"filed/foo/", "inbox/"}
+	expected := []string{"filed/", "filed/foo/", "filed/foo/2016/", "inbox/"}
+	for d := 1; d <= 7; d++ {
+		name := fmt.Sprintf("201607%02d_foo.pdf", d)
+		start = append(start, "inbox/"+name)
+		expected = append(expected, "filed/foo/2016/"+name)
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root}
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, true, &fr))
+	equals(t, 7, len(fr.plan))
+
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(7), fr.okCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+func TestFiledInterlock(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	filed := path.Join(root, "filed")
+	createFiles(t, root, []string{"filed/pge/"})
+	assert(t, filedInterlock(path.Join(root, "missing")) == nil, "expected no check for a filed tree that was never there")
+
+	check := filedInterlock(filed)
+	ok(t, check())
+
+	// the share drops, leaving its mount point
+	ok(t, os.Rename(filed, path.Join(root, "share")))
+	assert(t, check() != nil, "expected a missing filed tree to stop the run")
+	ok(t, os.Mkdir(filed, 0700))
+	assert(t, check() != nil, "expected an empty mount point to stop the run")
+}
+
+func TestFileWaiting(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"inbox/20160701_pge.pdf",
+		"inbox/20160702_water.pdf",
+		"inbox/20160703_gas.pdf",
+		"scans/20160704_water.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160701_pge.pdf",
+		"filed/water/",
+		"filed/water/2016/",
+		"filed/water/2016/20160702_water.pdf",
+		"filed/water/2016/20160704_water.pdf",
+		"inbox/",
+		"inbox/20160703_gas.pdf",
+		"scans/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(2), fr.failureCount)
+
+	// water is made by hand while the run goes on, so what was left for
+	// it is filed in the same run, and only gas is still missing
+	ok(t, os.Mkdir(path.Join(root, "filed/water"), 0700))
+	ok(t, processInbox(path.Join(root, "scans"), config, config.parseOptions(false), false, false, &fr))
+	ok(t, fileWaiting(config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+	equals(t, uint32(1), fr.failureCount)
+	equals(t, map[string]bool{path.Join(root, "filed/gas"): true}, fr.missingDirs)
+	equals(t, uint32(1), fr.skippedCount)
+	equals(t, 1, len(fr.waiting))
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	filesFlag string = "files"
+	sizeFlag  string = "size"
+	onFlag    string = "on"
+	crossFlag string = "cross"
+)
+
+// benchDests is how many dests the synthetic documents are spread over,
+// and benchYears how many years.
+const (
+	benchDests = 10
+	benchYears = 3
+)
+
+// benchStage is how fast one part of filing went.  Organizing is per
+// document filed, as it has to look over each.
+type benchStage struct {
+	Name           string  `json:"name"`
+	Files          int     `json:"files"`
+	Bytes          int64   `json:"bytes"`
+	Seconds        float64 `json:"seconds"`
+	FilesPerSecond float64 `json:"filesPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+}
+
+func newBenchStage(name string, files int, bytes int64, d time.Duration) benchStage {
+	s := benchStage{Name: name, Files: files, Bytes: bytes, Seconds: d.Seconds()}
+	if s.Seconds > 0 {
+		s.FilesPerSecond = float64(files) / s.Seconds
+		s.BytesPerSecond = float64(bytes) / s.Seconds
+	}
+	return s
+}
+
+// benchNames returns the names of n synthetic documents, which parse as
+// dests benchDests ways, dated over benchYears.
+func benchNames(n int) []string {
+	names := make([]string, n)
+	start := time.Date(clock.Now().Year()-benchYears, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := range names {
+		day := start.AddDate(0, 0, i%(benchYears*365))
+		names[i] = fmt.Sprintf("%s_bench%d_%06d.pdf", day.Format("20060102"), i%benchDests, i)
+	}
+	return names
+}
+
+// makeBenchFiles writes a file of size bytes in dir for each of names,
+// each with different contents.
+func makeBenchFiles(dir string, names []string, size int64) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	for i, name := range names {
+		if size >= 8 {
+			binary.BigEndian.PutUint64(data, uint64(i))
+		}
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBench times filing files synthetic documents of size bytes into a
+// root made for it in on: parsing their names, filing them, organizing
+// what was filed, and moving them within on.  With cross set, it also
+// times moving them from on to cross, which copies when cross is on
+// another device.  Everything it makes is removed.
+func runBench(on, cross string, files int, size int64) ([]benchStage, error) {
+	if files <= 0 {
+		return nil, errors.Errorf("--%s must be positive", filesFlag)
+	}
+	if size < 0 {
+		return nil, errors.Errorf("--%s must not be negative", sizeFlag)
+	}
+	root, err := ioutil.TempDir(on, "fileinbox-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+	if err := writeRootMarker(root); err != nil {
+		return nil, err
+	}
+	config := &Config{Root: root}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < benchDests; i++ {
+		if err := os.MkdirAll(config.dest(fmt.Sprintf("bench%d", i)), 0700); err != nil {
+			return nil, err
+		}
+	}
+	names := benchNames(files)
+	total := int64(files) * size
+	var stages []benchStage
+
+	opts := config.parseOptions(false)
+	start := time.Now()
+	for _, name := range names {
+		if _, err := parseFileName(opts, name); err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", name)
+		}
+	}
+	stages = append(stages, newBenchStage("parse", files, 0, time.Since(start)))
+
+	// what we would say about each document would slow us down
+	saved := progress
+	progress = ioutil.Discard
+	defer func() { progress = saved }()
+
+	if err := makeBenchFiles(config.inbox(), names, size); err != nil {
+		return nil, err
+	}
+	fr := fileResult{missingDirs: map[string]bool{}}
+	if fr.work, err = newWorkspace(config); err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	err = processInbox(config.inbox(), config, opts, false, false, &fr)
+	took := time.Since(start)
+	fr.work.finish(&fr, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(fr.failures) != 0 {
+		f := fr.failures[0]
+		return nil, errors.Errorf("filing %s failed: %s", f.Path, f.Error)
+	}
+	stages = append(stages,
+		newBenchStage("file", int(fr.okCount), fr.movedBytes, took-fr.orgDuration),
+		newBenchStage("organize", int(fr.okCount), 0, fr.orgDuration))
+
+	from, to := path.Join(root, "from"), path.Join(root, "to")
+	if err := makeBenchFiles(from, names, size); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(to, 0700); err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	for _, name := range names {
+		if _, err := fileinbox.MoveFile(path.Join(from, name), path.Join(to, name)); err != nil {
+			return nil, err
+		}
+	}
+	stages = append(stages, newBenchStage("move", files, total, time.Since(start)))
+
+	if cross == "" {
+		return stages, nil
+	}
+	away, err := ioutil.TempDir(cross, "fileinbox-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(away)
+	work := path.Join(away, "work")
+	var copied int64
+	start = time.Now()
+	for _, name := range names {
+		n, err := fileinbox.MoveFileVia(path.Join(to, name), path.Join(away, name), work)
+		if err != nil {
+			return nil, err
+		}
+		copied += n
+	}
+	took = time.Since(start)
+	if copied == 0 && total != 0 {
+		printf(saved, styleNotice, "%s is on the same device as %s, so nothing was copied\n", cross, on)
+		return stages, nil
+	}
+	return append(stages, newBenchStage("copy", files, copied, took)), nil
+}
+
+func doBench(ctx *cli.Context) error {
+	on := ctx.String(onFlag)
+	if on == "" {
+		on = os.TempDir()
+	}
+	files, size := ctx.Int(filesFlag), ctx.Int64(sizeFlag)
+	printf(progress, stylePlain, "Filing %s of %s each in %s\n",
+		plural(uint32(files), "synthetic document", "synthetic documents"), formatBytes(size), on)
+	stages, err := runBench(on, ctx.String(crossFlag), files, size)
+	if err != nil {
+		return errors.Wrap(err, "bench")
+	}
+
+	if ctx.String(outputFlag) == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stages)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "STAGE\tFILES\tSIZE\tTIME\tFILES/S\tBYTES/S\n")
+	for _, s := range stages {
+		size, rate := "-", "-"
+		if s.Bytes != 0 {
+			size, rate = formatBytes(s.Bytes), formatBytes(int64(s.BytesPerSecond))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, formatCount(int64(s.Files)), size,
+			formatDuration(time.Duration(s.Seconds*float64(time.Second))), formatCount(int64(s.FilesPerSecond)), rate)
+	}
+	return tw.Flush()
+}
+
+func benchCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "bench",
+		Usage:  "Time filing synthetic documents in a root of their own, to see how fast names are parsed, documents filed and organized, and moved or copied across devices.",
+		Action: doBench,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  filesFlag,
+				Value: 1000,
+				Usage: "How many documents to file.",
+			},
+			&cli.Int64Flag{
+				Name:  sizeFlag,
+				Value: 256 * 1024,
+				Usage: "How big each document is, in bytes.",
+			},
+			&cli.StringFlag{
+				Name:  onFlag,
+				Usage: "A directory on the filesystem to time, such as where the root is.  The default is the temp directory.",
+			},
+			&cli.StringFlag{
+				Name:  crossFlag,
+				Usage: "A directory on another device, such as a NAS, to time copying documents to.",
+			},
+		},
+	}
+}
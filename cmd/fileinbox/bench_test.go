@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	on, cross := path.Join(dir, "on"), path.Join(dir, "cross")
+	ok(t, os.Mkdir(on, 0700))
+	ok(t, os.Mkdir(cross, 0700))
+
+	// cross is on the same device here, so there is no copy to time
+	stages, err := runBench(on, cross, 25, 1024)
+	ok(t, err)
+	var names []string
+	for _, s := range stages {
+		names = append(names, s.Name)
+		equals(t, 25, s.Files)
+	}
+	equals(t, []string{"parse", "file", "organize", "move"}, names)
+	equals(t, int64(25*1024), stages[1].Bytes)
+	equals(t, int64(25*1024), stages[3].Bytes)
+
+	// nothing is left behind
+	for _, d := range []string{on, cross} {
+		left, err := ioutil.ReadDir(d)
+		ok(t, err)
+		equals(t, 0, len(left))
+	}
+
+	_, err = runBench(on, "", 0, 1024)
+	assert(t, err != nil, "expected no files to be rejected")
+}
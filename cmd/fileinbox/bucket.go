@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// How a dest with MaxFiles or MaxBytes set spreads files out once a
+// year directory is full.
+const (
+	rolloverLetter = "letter" // 2016, then 2016b, 2016c...
+	rolloverMonth  = "month"  // 2016, then 2016/07, 2016/08...
+)
+
+// How documents are laid out under a dest, see DestConfig.Dirs.
+const (
+	dirsYear    = "year"    // 2016/
+	dirsMonth   = "month"   // 2016/08/
+	dirsQuarter = "quarter" // 2016/Q3/
+	dirsFlat    = "flat"    // in the dest itself
+	dirsFiscal  = "fiscal"  // FY2015-16/, see DestConfig.FiscalStart
+)
+
+// defaultFiscalStart is the month fiscal years start in when a dest
+// doesn't say: April, as for the UK tax year.
+const defaultFiscalStart = 4
+
+// bucketer picks the directory, under a dest, that each file goes in.
+type bucketer struct {
+	destDir  string
+	maxFiles int
+	maxBytes int64
+	rollover string
+	dirs     string
+	// fiscalStart is the month, 1 to 12, that fiscal years start in
+	fiscalStart int
+	fills       map[string]*fill // what is in each bucket, loaded as needed
+}
+
+// fill is what a bucket holds.
+type fill struct {
+	files int
+	bytes int64
+}
+
+func newBucketer(destDir string, dc DestConfig) *bucketer {
+	return &bucketer{
+		destDir:  destDir,
+		maxFiles: dc.MaxFiles,
+		maxBytes: dc.MaxBytes,
+		rollover: dc.Rollover,
+		dirs:     dc.Dirs,
+		fills:    map[string]*fill{},
+
+		fiscalStart: dc.fiscalStart(),
+	}
+}
+
+// yearDirs returns true if documents are filed under a directory named
+// for their year, which organize makes sure of up front.
+func (b *bucketer) yearDirs() bool {
+	return b == nil || (b.dirs != dirsFlat && b.dirs != dirsFiscal)
+}
+
+// quarter returns the quarter month falls in, e.g. Q3 for 08.
+func quarter(month string) string {
+	m, _ := strconv.Atoi(month)
+	return fmt.Sprintf("Q%d", (m+2)/3)
+}
+
+// fiscalYear returns the fiscal year starting in month start that year
+// and month fall in, e.g. FY2023-24 for 2024-03 when start is 4.
+func fiscalYear(year, month string, start int) string {
+	y, _ := strconv.Atoi(year)
+	if m, _ := strconv.Atoi(month); m < start {
+		y--
+	}
+	return fmt.Sprintf("FY%04d-%02d", y, (y+1)%100)
+}
+
+// dir returns the directory, relative to the dest, for a file of size
+// bytes dated in year and month, and counts the file against it.
+func (b *bucketer) dir(year, month string, size int64) string {
+	if b != nil && b.dirs == dirsMonth {
+		return path.Join(year, month)
+	}
+	if b != nil && b.dirs == dirsQuarter {
+		return path.Join(year, quarter(month))
+	}
+	if b != nil && b.dirs == dirsFlat {
+		return ""
+	}
+	if b != nil && b.dirs == dirsFiscal {
+		return fiscalYear(year, month, b.fiscalStart)
+	}
+	if b == nil || (b.maxFiles <= 0 && b.maxBytes <= 0) {
+		return year
+	}
+
+	bucket := year
+	switch b.rollover {
+	case rolloverMonth:
+		if b.full(year, size) {
+			bucket = path.Join(year, month)
+		}
+	default:
+		for suffix := 'b'; b.full(bucket, size) && suffix <= 'z'; suffix++ {
+			bucket = year + string(suffix)
+		}
+	}
+	f := b.fill(bucket)
+	f.files++
+	f.bytes += size
+	return bucket
+}
+
+// full returns true if a file of size bytes doesn't fit in bucket.  An
+// empty bucket takes any one file, however big.
+func (b *bucketer) full(bucket string, size int64) bool {
+	f := b.fill(bucket)
+	if b.maxFiles > 0 && f.files >= b.maxFiles {
+		return true
+	}
+	return b.maxBytes > 0 && f.files > 0 && f.bytes+size > b.maxBytes
+}
+
+func (b *bucketer) fill(bucket string) *fill {
+	if f, ok := b.fills[bucket]; ok {
+		return f
+	}
+	// a bucket we can't read doesn't exist yet, so it is empty
+	children, _ := ioutil.ReadDir(path.Join(b.destDir, bucket))
+	f := &fill{}
+	for _, c := range children {
+		if !c.IsDir() {
+			f.files++
+			f.bytes += c.Size()
+		}
+	}
+	b.fills[bucket] = f
+	return f
+}
+
+func validateRollover(dc DestConfig) error {
+	if dc.MaxFiles < 0 {
+		return errors.Errorf("maxfiles must not be negative, not %d", dc.MaxFiles)
+	}
+	if dc.MaxBytes < 0 {
+		return errors.Errorf("maxbytes must not be negative, not %d", dc.MaxBytes)
+	}
+	switch dc.Dirs {
+	case "", dirsYear:
+	case dirsMonth, dirsQuarter, dirsFlat, dirsFiscal:
+		if dc.MaxFiles > 0 || dc.MaxBytes > 0 {
+			return errors.Errorf("dirs %s can't be combined with maxfiles or maxbytes", dc.Dirs)
+		}
+	default:
+		return errors.Errorf("unknown dirs %q.  We expect %s, %s, %s, %s or %s", dc.Dirs, dirsYear, dirsMonth, dirsQuarter, dirsFlat, dirsFiscal)
+	}
+	if dc.FiscalStart < 0 || dc.FiscalStart > 12 {
+		return errors.Errorf("fiscalstart must be a month from 1 to 12, not %d", dc.FiscalStart)
+	}
+	switch dc.Rollover {
+	case "", rolloverLetter, rolloverMonth:
+	default:
+		return errors.Errorf("unknown rollover %q.  We expect %s or %s", dc.Rollover, rolloverLetter, rolloverMonth)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestRollover(t *testing.T) {
+	for _, tc := range []struct {
+		dc       DestConfig
+		expected []string
+	}{
+		{DestConfig{MaxFiles: 2, Rollover: rolloverLetter}, []string{
+			"filed/receipts/2016b/",
+			"filed/receipts/2016b/20160703_receipts.pdf",
+			"filed/receipts/2016b/20160801_receipts.pdf",
+			"filed/receipts/2016c/",
+			"filed/receipts/2016c/20160802_receipts.pdf",
+		}},
+		// each file is 34 bytes, so only two fit
+		{DestConfig{MaxBytes: 70}, []string{
+			"filed/receipts/2016b/",
+			"filed/receipts/2016b/20160703_receipts.pdf",
+			"filed/receipts/2016b/20160801_receipts.pdf",
+			"filed/receipts/2016c/",
+			"filed/receipts/2016c/20160802_receipts.pdf",
+		}},
+		{DestConfig{MaxFiles: 2, Rollover: rolloverMonth}, []string{
+			"filed/receipts/2016/07/",
+			"filed/receipts/2016/07/20160703_receipts.pdf",
+			"filed/receipts/2016/08/",
+			"filed/receipts/2016/08/20160801_receipts.pdf",
+			"filed/receipts/2016/08/20160802_receipts.pdf",
+		}},
+	} {
+		start := []string{
+			"filed/receipts/2016/20160701_receipts.pdf",
+			"filed/receipts/2016/20160702_receipts.pdf",
+			"inbox/20160703_receipts.pdf",
+			"inbox/20160801_receipts.pdf",
+			"inbox/20160802_receipts.pdf",
+		}
+		expected := append([]string{
+			"filed/",
+			"filed/receipts/",
+			"filed/receipts/2016/",
+			"filed/receipts/2016/20160701_receipts.pdf",
+			"filed/receipts/2016/20160702_receipts.pdf",
+			"inbox/",
+		}, tc.expected...)
+
+		root, err := ioutil.TempDir("", "file_inbox_test")
+		ok(t, err)
+		createFiles(t, root, start)
+
+		config := &Config{
+			Root:  root,
+			Dests: map[string]DestConfig{"receipts": tc.dc},
+		}
+		ok(t, config.validate())
+		fr := fileResult{missingDirs: map[string]bool{}}
+		ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+		equals(t, uint32(0), fr.failureCount)
+
+		found := readFiles(t, root)
+		sort.Strings(found)
+		sort.Strings(expected)
+		equals(t, expected, found)
+		os.RemoveAll(root)
+	}
+}
+
+func TestRolloverCC(t *testing.T) {
+	config := &Config{}
+	config.CC.Root = "/mirror"
+	config.CC.Dests = []string{"receipts"}
+	ok(t, config.validate())
+
+	// the mirror is laid out like the archive, rollover and all
+	parsed := &parsedName{baseName: "20160703_receipts.pdf", year: "2016", month: "07", date: "03", dest: "receipts"}
+	equals(t, "/mirror/receipts/2016b/20160703_receipts.pdf", cc(config, "2016b", parsed))
+	equals(t, "/mirror/receipts/2016/07/20160703_receipts.pdf", cc(config, "2016/07", parsed))
+	equals(t, "", cc(&Config{}, "2016", parsed))
+}
+
+func TestFiscalYears(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/hmrc/",
+		"filed/irs/",
+		"inbox/20240310_hmrc.pdf",
+		"inbox/20240406_hmrc.pdf",
+		"inbox/20240930_irs.pdf",
+		"inbox/20241001_irs.pdf",
+	})
+	config := &Config{
+		Root: root,
+		Dests: map[string]DestConfig{
+			"hmrc": {Dirs: dirsFiscal},
+			"irs":  {Dirs: dirsFiscal, FiscalStart: 10},
+		},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(0), fr.failureCount)
+
+	found := readFiles(t, path.Join(root, "filed"))
+	sort.Strings(found)
+	equals(t, []string{
+		"hmrc/",
+		"hmrc/FY2023-24/",
+		"hmrc/FY2023-24/20240310_hmrc.pdf",
+		"hmrc/FY2024-25/",
+		"hmrc/FY2024-25/20240406_hmrc.pdf",
+		"irs/",
+		"irs/FY2023-24/",
+		"irs/FY2023-24/20240930_irs.pdf",
+		"irs/FY2024-25/",
+		"irs/FY2024-25/20241001_irs.pdf",
+	}, found)
+
+	// queries see through fiscal years as they do calendar ones
+	docs, err := findFiled(config, config.parseOptions(false), "hmrc")
+	ok(t, err)
+	equals(t, 2, len(docs))
+	equals(t, "hmrc", docs[0].dest)
+	equals(t, "FY2023-24", yearOf("FY2023-24"))
+
+	config.Dests["irs"] = DestConfig{Dirs: dirsFiscal, FiscalStart: 13}
+	assert(t, config.validate() != nil, "expected a fiscal start past December to be rejected")
+}
+
+func TestQuarters(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/invoices/2016/20160105_invoices.pdf",
+		"inbox/20160331_invoices.pdf",
+		"inbox/20160701_invoices.pdf",
+	})
+	config := &Config{Root: root, Dests: map[string]DestConfig{"invoices": {Dirs: dirsQuarter}}}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(0), fr.failureCount)
+
+	// what was filed before the switch is moved by migrate-layout
+	opts := config.parseOptions(true)
+	m, err := planMigration(config, opts, []string{"invoices"}, dirsQuarter)
+	ok(t, err)
+	equals(t, []fileinbox.Move{{
+		From: path.Join(root, "filed/invoices/2016/20160105_invoices.pdf"),
+		To:   path.Join(root, "filed/invoices/2016/Q1/20160105_invoices.pdf"),
+	}}, m.Moves)
+	ok(t, m.run(config, path.Join(root, migrationFile)))
+
+	found := readFiles(t, path.Join(root, "filed"))
+	sort.Strings(found)
+	equals(t, []string{
+		"invoices/",
+		"invoices/2016/",
+		"invoices/2016/Q1/",
+		"invoices/2016/Q1/20160105_invoices.pdf",
+		"invoices/2016/Q1/20160331_invoices.pdf",
+		"invoices/2016/Q3/",
+		"invoices/2016/Q3/20160701_invoices.pdf",
+	}, found)
+	docs, err := findFiled(config, opts, "invoices")
+	ok(t, err)
+	equals(t, 3, len(docs))
+	equals(t, "invoices", docs[2].dest)
+}
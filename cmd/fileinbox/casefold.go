@@ -0,0 +1,255 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// caseVariants returns the dests whose names differ only in case, such
+// as PGE and pge, each group sorted.  On macOS and Windows these are the
+// same directory, so an archive that has both forks when it is moved
+// there from Linux, or back.
+func caseVariants(config *Config) ([][]string, error) {
+	filed := config.filed()
+	byKey := map[string][]string{}
+	err := filepath.Walk(filed, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || p == filed {
+			return nil
+		}
+		if yearDir.MatchString(info.Name()) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(filed, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		key := path.Join(path.Dir(rel), strings.ToLower(path.Base(rel)))
+		byKey[key] = append(byKey[key], rel)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", filed)
+	}
+	var groups [][]string
+	for _, g := range byKey {
+		if len(g) > 1 {
+			sort.Strings(g)
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups, nil
+}
+
+// warnCaseVariants says which dests differ only in case, and how to
+// merge them.
+func warnCaseVariants(config *Config) {
+	groups, err := caseVariants(config)
+	if err != nil {
+		printf(progress, styleNotice, "Unable to check for dests that differ only in case: %v\n", err)
+		return
+	}
+	for _, g := range groups {
+		printf(progress, styleNotice, "The dests %s differ only in case, so they are one directory on macOS and Windows.  Run fileinbox merge-dests %s %s to merge them\n",
+			strings.Join(g, ", "), g[0], g[len(g)-1])
+	}
+}
+
+// mergeResult is what merging one dest into another did.
+type mergeResult struct {
+	moved, duplicates int
+	conflicts         []string // left where they were, as into has a different document by that name
+}
+
+// mergedName returns base, the name of a document filed under from,
+// with from in its name replaced by into, as it is named once merged,
+// e.g. 20160825_pge.pdf for 20160825_PGE.pdf.  Names that don't have
+// from after their date are left as they are.
+func mergedName(config *Config, base, from, into string) string {
+	i := strings.Index(base, "_")
+	if i < 0 {
+		return base
+	}
+	token := strings.ReplaceAll(from, "/", config.DestSeparator)
+	rest := base[i+1:]
+	if len(rest) < len(token) || !strings.EqualFold(rest[:len(token)], token) {
+		return base
+	}
+	after := rest[len(token):]
+	// the dest ends where the description, other dests or the extension
+	// start, or a nested dest does
+	if after != "" && !strings.ContainsAny(after[:1], "_+.") &&
+		(config.DestSeparator == "" || !strings.HasPrefix(after, config.DestSeparator)) {
+		return base
+	}
+	return base[:i+1] + strings.ReplaceAll(into, "/", config.DestSeparator) + after
+}
+
+// mergeDest moves everything filed under from to the same place under
+// into, renamed for into.  A document already there with the same
+// contents is dropped, and one with different contents is left be.
+// from goes once it is empty.  The index, if there is one, is read and
+// written once.
+func mergeDest(config *Config, from, into string) (r *mergeResult, err error) {
+	fromDir, intoDir := config.dest(from), config.dest(into)
+	fromInfo, err := os.Stat(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	if intoInfo, err := os.Stat(intoDir); err == nil && os.SameFile(fromInfo, intoInfo) {
+		return nil, errors.Errorf("%s and %s are already the same directory", from, into)
+	}
+
+	var idx *hashIndex
+	if _, err := os.Stat(config.index()); err == nil {
+		if idx, err = config.readIndex(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if iErr := idx.write(); iErr != nil && err == nil {
+				err = iErr
+			}
+		}()
+	}
+
+	r = &mergeResult{}
+	var dirs []string
+	err = filepath.Walk(fromDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		rel, err := filepath.Rel(fromDir, p)
+		if err != nil {
+			return err
+		}
+		rel = path.Join(path.Dir(filepath.ToSlash(rel)), mergedName(config, info.Name(), from, into))
+		to := path.Join(intoDir, rel)
+		moved := false
+		if _, err := os.Lstat(to); err == nil {
+			same, err := fileinbox.SameContents(p, to)
+			if err != nil {
+				return err
+			}
+			if !same {
+				printf(progress, styleNotice, "Leaving %s, as a different document is filed as %s\n", p, to)
+				r.conflicts = append(r.conflicts, p)
+				return nil
+			}
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			r.duplicates++
+		} else {
+			if err := config.perms.mkdirAll(path.Dir(to)); err != nil {
+				return errors.Wrapf(err, "creating %s", path.Dir(to))
+			}
+			if _, err := fileinbox.MoveFile(p, to); err != nil {
+				return errors.Wrapf(err, "moving %s", p)
+			}
+			moved = true
+			r.moved++
+		}
+		if idx == nil {
+			return nil
+		}
+		oldRel, err := config.filedRel(p)
+		if err != nil {
+			return err
+		}
+		e, ok := idx.entries[oldRel]
+		delete(idx.entries, oldRel)
+		newRel := path.Join(into, rel)
+		if _, there := idx.entries[newRel]; ok && moved && !there {
+			// a rename keeps the contents and the modification time, so
+			// the hash still holds
+			idx.entries[newRel] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	// deepest first, leaving what still holds conflicts
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return r, nil
+}
+
+func doMergeDests(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return errors.New("merge-dests expects two arguments, the dest to merge and the dest to merge it into")
+	}
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "merge-dests")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "merge-dests")
+	}
+	if config.Immutable {
+		return errors.Errorf("merge-dests: filed documents are immutable.  Run fileinbox immutable lift first, and immutable restore once done")
+	}
+	from, into := ctx.Args().Get(0), ctx.Args().Get(1)
+	for _, d := range []string{from, into} {
+		if err := checkDest(d); err != nil {
+			return errors.Wrap(err, "merge-dests")
+		}
+	}
+	if err := config.frozen(into); err != nil {
+		return errors.Wrap(err, "merge-dests")
+	}
+	if config.persist {
+		p, err := config.path()
+		if err != nil {
+			return errors.Wrap(err, "merge-dests")
+		}
+		snap, err := snapshotConfig(p, config.Root)
+		if err != nil {
+			return errors.Wrap(err, "merge-dests: snapshotting the config and index")
+		}
+		if snap != "" {
+			printf(progress, stylePlain, "Took snapshot %s of the config and index, see restore-config\n", snap)
+		}
+	}
+	r, err := mergeDest(config, from, into)
+	if err != nil {
+		return errors.Wrap(err, "merge-dests")
+	}
+	printf(progress, styleSuccess, "Moved %s from %s into %s", plural(uint32(r.moved), "document", "documents"), from, into)
+	if r.duplicates != 0 {
+		printf(progress, stylePlain, ", dropping %s already there", plural(uint32(r.duplicates), "duplicate", "duplicates"))
+	}
+	printf(progress, stylePlain, "\n")
+	if len(r.conflicts) != 0 {
+		printf(progress, styleNotice, "%s left in %s, as different documents have their names in %s\n",
+			plural(uint32(len(r.conflicts)), "document is", "documents are"), from, into)
+	}
+	printf(progress, stylePlain, "Add the alias %s: %s, or set normalize, so new documents for %s go to %s too\n", from, into, from, into)
+	return nil
+}
+
+func mergeDestsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "merge-dests",
+		Usage:     "Move everything filed under one dest into another, e.g. to merge dests that differ only in case.",
+		ArgsUsage: "<from> <into>",
+		Action:    doMergeDests,
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestMergeCaseVariants(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/PGE/2016/20160825_PGE.pdf",
+		"filed/PGE/2017/20170101_pge.pdf",
+		"filed/pge/2017/20170101_pge.pdf",
+		"filed/pge/2016/2016b/",
+		"filed/insurance/Auto/",
+		"filed/insurance/auto/",
+		"filed/tax/",
+	})
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	groups, err := caseVariants(config)
+	ok(t, err)
+	equals(t, [][]string{{"PGE", "pge"}, {"insurance/Auto", "insurance/auto"}}, groups)
+
+	// a different document under the same name stays put
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/PGE/2017/20170101_pge.pdf"), []byte("contents for 20170101_pge.pdf"), 0600))
+	ok(t, os.MkdirAll(path.Join(root, "filed/PGE/2018"), 0700))
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/PGE/2018/20180101_pge.pdf"), []byte("a correction"), 0600))
+	ok(t, os.MkdirAll(path.Join(root, "filed/pge/2018"), 0700))
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/pge/2018/20180101_pge.pdf"), []byte("contents for 20180101_pge.pdf"), 0600))
+	// what is moved is named for the dest it is moved into
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/PGE/2016/20160825_PGE.pdf"), []byte("contents for 20160825_pge.pdf"), 0600))
+
+	idx, err := config.readIndex()
+	ok(t, err)
+	_, err = updateIndex(config, idx, false, 1)
+	ok(t, err)
+	ok(t, idx.write())
+	moving := idx.entries["PGE/2016/20160825_PGE.pdf"]
+
+	r, err := mergeDest(config, "PGE", "pge")
+	ok(t, err)
+	equals(t, 1, r.moved)
+	equals(t, 1, r.duplicates)
+	equals(t, []string{path.Join(root, "filed/PGE/2018/20180101_pge.pdf")}, r.conflicts)
+
+	found := readFiles(t, path.Join(root, "filed/pge"))
+	sort.Strings(found)
+	equals(t, []string{"2016/", "2016/20160825_pge.pdf", "2016/2016b/", "2017/", "2017/20170101_pge.pdf", "2018/", "2018/20180101_pge.pdf"}, found)
+	_, err = os.Stat(path.Join(root, "filed/PGE/2016"))
+	assert(t, os.IsNotExist(err), "expected the emptied years to go")
+
+	// the index follows what moved, and what was dropped
+	idx, err = config.readIndex()
+	ok(t, err)
+	moved := idx.entries["pge/2016/20160825_pge.pdf"]
+	equals(t, moving.SHA256, moved.SHA256)
+	assert(t, moving.ModTime.Equal(moved.ModTime), "expected the moved document to keep its entry, got %+v", moved)
+	for _, rel := range []string{"PGE/2016/20160825_PGE.pdf", "PGE/2017/20170101_pge.pdf"} {
+		_, ok := idx.entries[rel]
+		assert(t, !ok, "expected %s to be dropped from the index", rel)
+	}
+	_, kept := idx.entries["PGE/2018/20180101_pge.pdf"]
+	assert(t, kept, "expected the conflict left behind to stay in the index")
+
+	_, err = mergeDest(config, "tax", "tax")
+	assert(t, err != nil, "expected merging a dest into itself to be refused")
+}
+
+func TestMergedName(t *testing.T) {
+	config := &Config{DestSeparator: "-"}
+	for _, tc := range []struct{ base, from, into, want string }{
+		{"20160825_PGE.pdf", "PGE", "pge", "20160825_pge.pdf"},
+		{"20160825_pge_bill.pdf", "pge", "power", "20160825_power_bill.pdf"},
+		{"20160825_pge+tax.pdf", "pge", "power", "20160825_power+tax.pdf"},
+		{"20160825_insurance-auto.pdf", "insurance", "cover", "20160825_cover-auto.pdf"},
+		{"20160825_insurance-auto.pdf", "insurance/auto", "cover/car", "20160825_cover-car.pdf"},
+		// not named for from
+		{"20160825_pgeandmore.pdf", "pge", "power", "20160825_pgeandmore.pdf"},
+		{"IMG_0001.jpg", "photos", "pictures", "IMG_0001.jpg"},
+	} {
+		equals(t, tc.want, mergedName(config, tc.base, tc.from, tc.into))
+	}
+}
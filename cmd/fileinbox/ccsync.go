@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	pruneFlag string = "prune"
+	yesFlag   string = "yes"
+)
+
+// ccSync is what reconciling the mirrors with the config found.
+type ccSync struct {
+	copied      []string // mirrored copies we made
+	copiedBytes int64
+	differ      []string // mirrored copies whose size doesn't match the archive
+	stale       []string // mirrored copies of dests no longer mirrored there
+}
+
+// syncCC copies every filed document that should be mirrored, but
+// isn't, to its mirror, e.g. after a dest is added to CC.Dests.  It
+// also finds copies in the mirrors of dests that are no longer mirrored
+// there.  With dryRun, nothing is copied.
+func syncCC(config *Config, opts fileinbox.ParseOptions, dryRun bool) (*ccSync, error) {
+	s := &ccSync{}
+	infos, err := ioutil.ReadDir(config.filed())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading dests")
+	}
+	for _, fi := range infos {
+		if !config.destEntry(fi) {
+			continue
+		}
+		docs, err := findFiled(config, opts, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			mirror := config.ccDest(d.dest)
+			if mirror == "" {
+				continue
+			}
+			rel, err := filepath.Rel(config.dest(d.dest), d.path)
+			if err != nil {
+				return nil, err
+			}
+			to := path.Join(mirror, filepath.ToSlash(rel))
+			if mfi, err := os.Lstat(to); err == nil {
+				if mfi.Size() != d.size {
+					s.differ = append(s.differ, to)
+				}
+				continue
+			}
+			s.copied = append(s.copied, to)
+			s.copiedBytes += d.size
+			if dryRun {
+				continue
+			}
+			if err := copyToMirror(config, d.path, to); err != nil {
+				return s, err
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, root := range config.ccRoots() {
+		if seen[path.Clean(root)] {
+			continue
+		}
+		seen[path.Clean(root)] = true
+		if err := s.findStale(config, opts, root); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+func copyToMirror(config *Config, from, to string) error {
+	if err := config.perms.mkdirAll(path.Dir(to)); err != nil {
+		return errors.Wrapf(err, "creating %s", path.Dir(to))
+	}
+	_, err := fileinbox.CopyFile(from, to)
+	if err == nil && config.perms.file != 0 {
+		err = os.Chmod(to, config.perms.file)
+	}
+	if err != nil {
+		if !os.IsExist(err) {
+			os.Remove(to)
+		}
+		return errors.Wrapf(err, "copying %s to %s", from, to)
+	}
+	return nil
+}
+
+// findStale looks for documents in the mirror under root whose dests
+// are no longer mirrored there.  Anything that isn't named like a
+// document is left alone, as it isn't ours.
+func (s *ccSync) findStale(config *Config, opts fileinbox.ParseOptions, root string) error {
+	if !isDir(root) {
+		return nil
+	}
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if _, err := fileinbox.ParseFileName(info.Name(), opts); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+		if len(parts) < 2 {
+			return nil
+		}
+		dest := nestedDest(parts[0], path.Dir(parts[1]))
+		if config.ccDest(dest) != path.Join(root, dest) {
+			s.stale = append(s.stale, p)
+		}
+		return nil
+	})
+}
+
+// pruneStale removes the stale copies, and any directories that leaves
+// empty, up to the mirror's root.
+func pruneStale(config *Config, stale []string) error {
+	var roots []string
+	for _, r := range config.ccRoots() {
+		roots = append(roots, path.Clean(r))
+	}
+	for _, p := range stale {
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		for dir := path.Dir(p); !hasString(roots, dir) && dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func doCCSync(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "cc sync")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "cc sync")
+	}
+	dryRun := ctx.Bool(dryRunFlag)
+
+	s, err := syncCC(config, opts, dryRun)
+	if s != nil {
+		verb := "Copied"
+		if dryRun {
+			verb = "Would copy"
+		}
+		for _, c := range s.copied {
+			printf(progress, stylePlain, "%s %s\n", verb, c)
+		}
+		printf(progress, styleSuccess, "%s %s files, %s, to the mirrors\n", verb, formatCount(int64(len(s.copied))), formatBytes(s.copiedBytes))
+		if len(s.differ) != 0 {
+			printf(progress, styleNotice, "\nThese mirrored copies differ in size from the archive, and were left alone:\n")
+			for _, d := range s.differ {
+				printf(progress, styleNotice, "    %s\n", d)
+			}
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "cc sync")
+	}
+	if len(s.stale) == 0 {
+		return nil
+	}
+
+	printf(progress, styleNotice, "\nThese are mirrored copies of dests no longer mirrored there:\n")
+	for _, p := range s.stale {
+		printf(progress, styleNotice, "    %s\n", p)
+	}
+	if !ctx.Bool(pruneFlag) {
+		printf(progress, styleNotice, "Run cc sync with --%s to remove them.\n", pruneFlag)
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	if !ctx.Bool(yesFlag) && !confirm(fmt.Sprintf("Remove these %d files from the mirrors?", len(s.stale))) {
+		return nil
+	}
+	if err := pruneStale(config, s.stale); err != nil {
+		return errors.Wrap(err, "cc sync")
+	}
+	printf(progress, styleSuccess, "Removed %s files from the mirrors\n", formatCount(int64(len(s.stale))))
+	return nil
+}
+
+func ccCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cc",
+		Usage: "Look after the mirrors set up with CC.",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "sync",
+				Usage:  "Bring the mirrors in line with the config, copying anything that should be mirrored but isn't.",
+				Action: doCCSync,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  pruneFlag,
+						Usage: "Also remove copies of dests that are no longer mirrored, once you confirm.",
+					},
+					&cli.BoolFlag{
+						Name:  yesFlag,
+						Usage: fmt.Sprintf("Don't ask before --%s removes anything.", pruneFlag),
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCCSync(t *testing.T) {
+	start := []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/tax/2017/20170415_tax.pdf",
+		"filed/bank/2016/20160101_bank.pdf",
+		"mirror/bank/2016/20160101_bank.pdf",
+		"mirror/pge/2015/20150825_pge.pdf",
+		"mirror/notes.txt",
+	}
+	expected := []string{
+		"mirror/",
+		"mirror/notes.txt",
+		"mirror/pge/",
+		"mirror/pge/2015/",
+		"mirror/pge/2015/20150825_pge.pdf",
+		"mirror/pge/2016/",
+		"mirror/pge/2016/20160825_pge.pdf",
+		"mirror/tax/",
+		"mirror/tax/2017/",
+		"mirror/tax/2017/20170415_tax.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	// bank used to be mirrored, and pge and tax now are
+	config := &Config{Root: root}
+	config.CC.Root = path.Join(root, "mirror")
+	config.CC.Dests = []string{"pge", "tax"}
+	ok(t, config.validate())
+	opts := config.parseOptions(true)
+
+	s, err := syncCC(config, opts, true)
+	ok(t, err)
+	equals(t, 2, len(s.copied))
+	_, err = os.Stat(path.Join(root, "mirror", "tax"))
+	assert(t, os.IsNotExist(err), "expected a dry run to copy nothing")
+
+	s, err = syncCC(config, opts, false)
+	ok(t, err)
+	equals(t, 2, len(s.copied))
+	equals(t, []string{path.Join(root, "mirror/bank/2016/20160101_bank.pdf")}, s.stale)
+
+	defer func() { confirmInput = os.Stdin }()
+	confirmInput = strings.NewReader("y\n")
+	assert(t, confirm("Remove them?"), "expected y to confirm")
+	confirmInput = strings.NewReader("\n")
+	assert(t, !confirm("Remove them?"), "expected no answer to decline")
+
+	ok(t, pruneStale(config, s.stale))
+	var found []string
+	for _, f := range readFiles(t, root) {
+		if strings.HasPrefix(f, "mirror/") {
+			found = append(found, f)
+		}
+	}
+	sort.Strings(found)
+	equals(t, expected, found)
+
+	// once in sync, there is nothing to do
+	s, err = syncCC(config, opts, false)
+	ok(t, err)
+	equals(t, 0, len(s.copied)+len(s.stale)+len(s.differ))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// clock is what we ask for today's date.  --now pins it, to reproduce
+// what a run would have done on another day.
+var clock fileinbox.Clock = fileinbox.SystemClock
+
+// parseNow parses --now, e.g. 2024-01-01, 20240101 or a full RFC 3339
+// time.  A date alone is the start of that day in loc.
+func parseNow(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("unable to parse --%s %q.  We expect a date like 2024-01-01", nowFlag, s)
+	}
+	return t, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestParseNow(t *testing.T) {
+	want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	for _, s := range []string{"2024-01-01", "20240101"} {
+		got, err := parseNow(s, time.Local)
+		ok(t, err)
+		equals(t, want, got)
+	}
+	got, err := parseNow("2024-01-01T10:00:00Z", time.Local)
+	ok(t, err)
+	equals(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), got.UTC())
+
+	_, err = parseNow("next tuesday", time.Local)
+	assert(t, err != nil, "expected an error for a date we can't read")
+}
+
+func TestNowFlag(t *testing.T) {
+	defer func() { clock = fileinbox.SystemClock }()
+
+	// the future check follows --now, so a document dated 2026 is fine
+	// at the end of 2024 but not at the start of 2023
+	app := newCli()
+	app.Commands = nil
+	app.Action = func(ctx *cli.Context) error {
+		opts := (&Config{}).parseOptions(false)
+		_, err := fileinbox.ParseFileName("20260101_pge.pdf", opts)
+		return err
+	}
+	ok(t, app.Run([]string{"fileinbox", flagify(nowFlag), "2024-12-31"}))
+	assert(t, app.Run([]string{"fileinbox", flagify(nowFlag), "2023-01-01"}) != nil,
+		"expected 2026 to be too far in the future in 2023")
+	assert(t, app.Run([]string{"fileinbox", flagify(nowFlag), "bogus"}) != nil,
+		"expected an error for a bad --now")
+}
+
+func TestTimeZone(t *testing.T) {
+	defer func() { clock = fileinbox.SystemClock }()
+	// New Year's Day in Tokyo, still New Year's Eve in UTC
+	clock = fileinbox.FixedClock(time.Date(2023, 12, 31, 18, 0, 0, 0, time.UTC))
+
+	config := &Config{TimeZone: "Asia/Tokyo"}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	equals(t, 2024, opts.Now().Year())
+	p, err := fileinbox.ParseFileName("20240101_pge.pdf", opts)
+	ok(t, err)
+	equals(t, "Asia/Tokyo", p.Date.Location().String())
+
+	config = &Config{TimeZone: "UTC"}
+	ok(t, config.validate())
+	equals(t, 2023, config.parseOptions(false).Now().Year())
+
+	config = &Config{TimeZone: "Mars/Olympus_Mons"}
+	assert(t, config.validate() != nil, "expected an unknown time zone to be rejected")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// rootComparison is what differs between what is filed under two roots
+// that are meant to mirror each other, such as on a laptop and a
+// desktop kept in step by a sync tool.  Paths are relative to filed.
+type rootComparison struct {
+	OnlyHere  []string `json:"onlyHere"`
+	OnlyThere []string `json:"onlyThere"`
+	Differ    []string `json:"differ"` // filed under the same name on both, with different contents
+}
+
+func (r rootComparison) count() int {
+	return len(r.OnlyHere) + len(r.OnlyThere) + len(r.Differ)
+}
+
+// compareRoots lists and hashes what is filed under both roots, as
+// snapshot does, and compares them by name and hash.
+func compareRoots(here, there *Config, rehash bool, jobs int) (rootComparison, error) {
+	r := rootComparison{OnlyHere: []string{}, OnlyThere: []string{}, Differ: []string{}}
+	a, err := takeTreeSnapshot(here, rehash, jobs)
+	if err != nil {
+		return r, errors.Wrapf(err, "reading %s", here.Root)
+	}
+	b, err := takeTreeSnapshot(there, rehash, jobs)
+	if err != nil {
+		return r, errors.Wrapf(err, "reading %s", there.Root)
+	}
+	theirs := map[string]snapshotFile{}
+	for _, f := range b.Files {
+		theirs[f.Path] = f
+	}
+	for _, f := range a.Files {
+		g, ok := theirs[f.Path]
+		switch {
+		case !ok:
+			r.OnlyHere = append(r.OnlyHere, f.Path)
+		case g.SHA256 != f.SHA256 || g.Size != f.Size:
+			r.Differ = append(r.Differ, f.Path)
+		}
+		delete(theirs, f.Path)
+	}
+	// what is left, in order
+	for _, f := range b.Files {
+		if _, ok := theirs[f.Path]; ok {
+			r.OnlyThere = append(r.OnlyThere, f.Path)
+		}
+	}
+	return r, nil
+}
+
+func doCompare(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("compare expects one argument, the other root")
+	}
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "compare")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "compare")
+	}
+	otherRoot, err := filepath.Abs(ctx.Args().Get(0))
+	if err != nil {
+		return errors.Wrap(err, "compare")
+	}
+	if err = checkRoot(otherRoot); err != nil {
+		return errors.Wrap(err, "compare")
+	}
+	other := &Config{Root: otherRoot, DestSeparator: config.DestSeparator}
+	if err = other.validate(); err != nil {
+		return errors.Wrap(err, "compare")
+	}
+
+	r, err := compareRoots(config, other, ctx.Bool(rehashFlag), ctx.Int(jobsFlag))
+	if err != nil {
+		return errors.Wrap(err, "compare")
+	}
+	if ctx.String(outputFlag) == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range r.OnlyHere {
+			printf(os.Stdout, styleNotice, "< %s\n", p)
+		}
+		for _, p := range r.OnlyThere {
+			printf(os.Stdout, styleNotice, "> %s\n", p)
+		}
+		for _, p := range r.Differ {
+			printf(os.Stdout, styleFailure, "! %s\n", p)
+		}
+	}
+	if r.count() == 0 {
+		printf(progress, styleSuccess, "%s and %s match\n", config.Root, otherRoot)
+		return nil
+	}
+	printf(progress, stylePlain, "%s only in %s, %s only in %s, %s different\n",
+		formatCount(int64(len(r.OnlyHere))), config.Root, formatCount(int64(len(r.OnlyThere))), otherRoot,
+		formatCount(int64(len(r.Differ))))
+	// so cron, or a script, notices
+	return errors.Errorf("compare: %s and %s differ", config.Root, otherRoot)
+}
+
+func compareCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "compare",
+		Usage:     "Compare what is filed with another root that should mirror it, by name and hash: < only here, > only there, ! different contents.",
+		ArgsUsage: "<otherRoot>",
+		Action:    doCompare,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  rehashFlag,
+				Usage: "Hash every document again, rather than trusting each root's index for those that look unchanged.",
+			},
+			&cli.IntFlag{
+				Name:  jobsFlag,
+				Value: runtime.NumCPU(),
+				Usage: "How many documents to hash at once.",
+			},
+		},
+	}
+}
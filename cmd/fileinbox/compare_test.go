@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCompareRoots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	laptop, desktop := path.Join(dir, "laptop"), path.Join(dir, "desktop")
+	createFiles(t, laptop, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2017/20170101_pge.pdf",
+		"filed/tax/2016/20160415_tax.pdf",
+	})
+	createFiles(t, desktop, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2017/20170101_pge.pdf",
+		"filed/bank/2016/20160301_bank.pdf",
+	})
+	ok(t, ioutil.WriteFile(path.Join(desktop, "filed/pge/2017/20170101_pge.pdf"), []byte("half a sync"), 0600))
+
+	here, there := &Config{Root: laptop}, &Config{Root: desktop}
+	ok(t, here.validate())
+	ok(t, there.validate())
+	r, err := compareRoots(here, there, false, 2)
+	ok(t, err)
+	equals(t, []string{"tax/2016/20160415_tax.pdf"}, r.OnlyHere)
+	equals(t, []string{"bank/2016/20160301_bank.pdf"}, r.OnlyThere)
+	equals(t, []string{"pge/2017/20170101_pge.pdf"}, r.Differ)
+
+	r, err = compareRoots(here, here, false, 2)
+	ok(t, err)
+	equals(t, 0, r.count())
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestCCDest(t *testing.T) {
+	config := &Config{}
+	config.CC.Root = "/mirror"
+	config.CC.Dests = []string{"pge", "tax*"}
+	ok(t, config.validate())
+
+	equals(t, "/mirror/pge", config.ccDest("pge"))
+	equals(t, "/mirror/taxes", config.ccDest("taxes"))
+	equals(t, "/mirror/tax", config.ccDest("tax"))
+	equals(t, "", config.ccDest("bank"))
+
+	config.CC.Dests = []string{"*"}
+	ok(t, config.validate())
+	equals(t, "/mirror/bank", config.ccDest("bank"))
+	equals(t, "/mirror/insurance/auto", config.ccDest("insurance/auto"))
+
+	config.CC.Dests = []string{"tax*"}
+	ok(t, config.validate())
+	equals(t, "/mirror/taxes/state", config.ccDest("taxes/state"))
+	equals(t, "", config.ccDest("bank/tax"))
+}
+
+func TestDestCC(t *testing.T) {
+	config := &Config{Dests: map[string]DestConfig{
+		"taxes":  {CC: "/encrypted"},
+		"photos": {CC: "/nas"},
+	}}
+	config.CC.Root = "/mirror"
+	config.CC.Dests = []string{"*"}
+	ok(t, config.validate())
+
+	equals(t, "/encrypted/taxes", config.ccDest("taxes"))
+	equals(t, "/nas/photos", config.ccDest("photos"))
+	equals(t, "/mirror/pge", config.ccDest("pge"))
+	equals(t, "/encrypted/taxes/state", config.ccDest("taxes/state"))
+	equals(t, []string{"/encrypted", "/mirror", "/nas"}, config.ccRoots())
+
+	// without a CC.Root, only dests with their own are mirrored
+	config.CC.Root = ""
+	ok(t, config.validate())
+	equals(t, "/nas/photos", config.ccDest("photos"))
+	equals(t, "", config.ccDest("pge"))
+
+	config.CC.Root = "/mirror"
+	config.CC.Dests = []string{"pge", "taxes"}
+	assert(t, config.validate() != nil, "Expected taxes mirrored to two roots to be rejected")
+	config.Dests["taxes"] = DestConfig{CC: "/mirror/"}
+	ok(t, config.validate())
+}
+
+func TestCCValidation(t *testing.T) {
+	for _, dests := range [][]string{
+		{"pge", "pge"},
+		{"tax*", "taxes"},
+		{"taxes", "tax*"},
+		{"*", "pge"},
+		{"t*", "tax*"},
+		{"[pge"},
+	} {
+		config := &Config{}
+		config.CC.Dests = dests
+		assert(t, config.validate() != nil, "Expected %q to be rejected", dests)
+	}
+}
+
+func TestDefaultDate(t *testing.T) {
+	now := time.Date(2016, 7, 12, 15, 4, 5, 0, time.Local)
+	equals(t, time.Date(2016, 7, 12, 0, 0, 0, 0, time.Local), defaultDate(defaultDateToday, now))
+	equals(t, time.Date(2016, 7, 1, 0, 0, 0, 0, time.Local), defaultDate(defaultDateFirstOfMonth, now))
+	// July 31st 2016 was a Sunday
+	equals(t, time.Date(2016, 7, 29, 0, 0, 0, 0, time.Local), defaultDate(defaultDateLastBusinessDay, now))
+}
+
+func TestUndated(t *testing.T) {
+	config := &Config{Dests: map[string]DestConfig{
+		"payslip": {DefaultDate: defaultDateFirstOfMonth},
+	}}
+	ok(t, config.validate())
+	defer func() { clock = fileinbox.SystemClock }()
+	clock = fileinbox.FixedClock(time.Date(2016, 8, 25, 12, 0, 0, 0, time.Local))
+	opts := config.parseOptions(false)
+
+	parsed := config.undated(opts, "payslip_acme.pdf")
+	assert(t, parsed != nil, "Expected payslip_acme.pdf to get a default date")
+	equals(t, "payslip", parsed.dest)
+	equals(t, "2016", parsed.year)
+	equals(t, "20160801_payslip_acme.pdf", parsed.filedName())
+
+	assert(t, config.undated(opts, "pge.pdf") == nil, "Expected pge.pdf to stay undated")
+
+	config.Dests["payslip"] = DestConfig{DefaultDate: "whenever"}
+	assert(t, config.validate() != nil, "Expected an unknown default date to be rejected")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "fileinbox.yaml")
+	ok(t, writeFileAtomic(p, []byte("root: /a\n"), 0600))
+	ok(t, writeFileAtomic(p, []byte("root: /b\n"), 0600))
+
+	bytes, err := ioutil.ReadFile(p)
+	ok(t, err)
+	equals(t, "root: /b\n", string(bytes))
+	fi, err := os.Stat(p)
+	ok(t, err)
+	equals(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// no temp files left behind
+	children, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	equals(t, 1, len(children))
+}
+
+func TestConfigUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { configFile = "" }()
+	configFile = path.Join(dir, "fileinbox.yaml")
+
+	// each update starts from what is on disk, so none of them are lost
+	// when they race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			config := &Config{persist: true}
+			for j := 0; j < 10; j++ {
+				inbox := fmt.Sprintf("/inbox-%d-%d", i, j)
+				if err := config.update(func(c *Config) { c.ExtraInboxes = append(c.ExtraInboxes, inbox) }); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	saved := &Config{persist: true}
+	ok(t, saved.read())
+	equals(t, 40, len(saved.ExtraInboxes))
+}
+
+func TestConfigFormats(t *testing.T) {
+	c := &Config{
+		Root:          "/docs",
+		ExtraInboxes:  []string{"/scans"},
+		Rules:         []Rule{{Ext: "jpg", Dest: "photos", MinSize: 1024}, {Glob: "*.ofx", Also: []string{"tax"}}},
+		Dests:         map[string]DestConfig{"payslip": {DefaultDate: "lastbusinessday", MaxFiles: 100}},
+		Schedules:     []Schedule{{When: "@daily", Run: []string{"plan"}}},
+		RetryBackoff:  90 * time.Second,
+		DestSeparator: "-",
+	}
+	c.CC.Root = "/backup"
+	c.CC.Dests = []string{"tax"}
+	want, err := marshalConfig(formatYAML, c)
+	ok(t, err)
+
+	// each format reads back as the same config
+	for _, format := range []string{formatTOML, formatJSON} {
+		data, err := marshalConfig(format, c)
+		ok(t, err)
+		back := &Config{}
+		ok(t, unmarshalConfig(format, data, back))
+		got, err := marshalConfig(formatYAML, back)
+		ok(t, err)
+		equals(t, string(want), string(got))
+	}
+
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	defer func() { configFile = "" }()
+	configFile = path.Join(dir, "fileinbox.yaml")
+	ok(t, ioutil.WriteFile(configFile, want, 0600))
+
+	to, err := convertConfig(configFile, formatTOML)
+	ok(t, err)
+	equals(t, path.Join(dir, "fileinbox.toml"), to)
+	equals(t, to, configIn(dir))
+	_, err = os.Stat(configFile)
+	assert(t, os.IsNotExist(err), "expected the YAML to be gone, got %v", err)
+	_, err = convertConfig(to, formatTOML)
+	assert(t, err != nil, "expected converting to the same format to be rejected")
+
+	// updates keep to the format the config is in
+	configFile = to
+	config := &Config{persist: true}
+	ok(t, config.update(func(c *Config) { c.DestSeparator = "." }))
+	data, err := ioutil.ReadFile(to)
+	ok(t, err)
+	assert(t, strings.Contains(string(data), `destseparator = "."`), "expected TOML, got %s", data)
+	saved := &Config{persist: true}
+	ok(t, saved.read())
+	equals(t, 90*time.Second, saved.RetryBackoff)
+	equals(t, "/backup", saved.CC.Root)
+}
+
+func TestNestedDests(t *testing.T) {
+	start := []string{
+		"filed/insurance/auto/20230301_insurance-auto.pdf",
+		"filed/insurance/home/",
+		"inbox/20240101_insurance-auto_policy.pdf",
+		"inbox/20240102_ins-home.pdf",
+		"inbox/20240103_insurance.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/insurance/",
+		"filed/insurance/2024/",
+		"filed/insurance/2024/20240103_insurance.pdf",
+		"filed/insurance/auto/",
+		"filed/insurance/auto/2023/",
+		"filed/insurance/auto/2023/20230301_insurance-auto.pdf",
+		"filed/insurance/auto/2024/",
+		"filed/insurance/auto/2024/20240101_insurance-auto_policy.pdf",
+		"filed/insurance/home/",
+		"filed/insurance/home/2024/",
+		"filed/insurance/home/2024/20240102_ins-home.pdf",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{
+		Root:          root,
+		DestSeparator: "-",
+		Aliases:       map[string]string{"ins": "insurance"},
+	}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+	equals(t, uint32(1), fr.orgCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+
+	docs, err := findFiled(config, opts, "insurance")
+	ok(t, err)
+	var dests []string
+	for _, d := range docs {
+		dests = append(dests, d.dest)
+	}
+	equals(t, []string{"insurance/auto", "insurance/auto", "insurance/home", "insurance"}, dests)
+
+	for _, sep := range []string{"_", "/", "-."} {
+		config.DestSeparator = sep
+		assert(t, config.validate() != nil, "expected an error for a %s separator", sep)
+	}
+
+	// a dot separates the dest's parts, up to the final extension
+	createFiles(t, root, []string{"inbox/20240101_insurance.auto.pdf"})
+	config.DestSeparator = "."
+	ok(t, config.validate())
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	_, err = os.Stat(path.Join(root, "filed/insurance/auto/2024/20240101_insurance.auto.pdf"))
+	ok(t, err)
+}
+
+func TestHoldDest(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"filed/medical/",
+		"inbox/20160701_pge.pdf",
+		"inbox/20160702_medical_xray.pdf",
+		"inbox/20160703_medical.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/medical/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160701_pge.pdf",
+		"inbox/",
+		"inbox/20160702_medical_xray.pdf",
+		"inbox/20160703_medical.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{
+		Root:  root,
+		Dests: map[string]DestConfig{"medical": {Hold: true}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+	equals(t, map[string]int{"medical": 2}, fr.held)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+func TestFrozenDest(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"filed/newbank/",
+		"filed/oldbank/2015/20150101_oldbank.pdf",
+		"inbox/20160701_pge.pdf",
+		"inbox/20160702_oldbank.pdf",
+		"inbox/20160703_pge+oldbank.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/newbank/",
+		"filed/oldbank/",
+		"filed/oldbank/2015/",
+		"filed/oldbank/2015/20150101_oldbank.pdf",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160701_pge.pdf",
+		"inbox/",
+		"inbox/20160702_oldbank.pdf",
+		"inbox/20160703_pge+oldbank.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{
+		Root:  root,
+		Dests: map[string]DestConfig{"oldbank": {Frozen: true, Successor: "newbank"}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, 2, len(fr.failures))
+	for _, f := range fr.failures {
+		equals(t, failFrozen, f.Category)
+		assert(t, strings.Contains(f.Error, "newbank"), "expected the successor in %q", f.Error)
+	}
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+
+	// what was filed under it before is still covered
+	idx, err := config.readIndex()
+	ok(t, err)
+	_, err = updateIndex(config, idx, false, 1)
+	ok(t, err)
+	_, indexed := idx.entries["oldbank/2015/20150101_oldbank.pdf"]
+	assert(t, indexed, "expected the frozen dest to be indexed, got %v", idx.entries)
+
+	for _, dests := range []map[string]DestConfig{
+		{"oldbank": {Successor: "newbank"}},
+		{"oldbank": {Frozen: true, Successor: "newbank"}, "newbank": {Frozen: true}},
+		{"oldbank": {Frozen: true, Successor: "oldbank"}},
+	} {
+		assert(t, (&Config{Root: root, Dests: dests}).validate() != nil, "expected %v to be rejected", dests)
+	}
+	err = (&Config{Root: root, Dests: map[string]DestConfig{"oldbank": {Frozen: true, Successor: "oldbank"}}}).validate()
+	assert(t, err != nil && strings.Contains(err.Error(), "its own successor"), "expected a dest naming itself to be told so, got %v", err)
+}
+
+func TestPatternPacks(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/"})
+	// filed under its canonical name, so give it the contents readFiles
+	// will expect under that name
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "25Aug2016_pge.pdf"), []byte("contents for 20160825_pge.pdf"), 0600))
+
+	config := &Config{Root: root, PatternPacks: []string{"monthnames"}}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{"filed/", "filed/pge/", "filed/pge/2016/", "filed/pge/2016/20160825_pge.pdf", "inbox/"}, found)
+
+	config.PatternPacks = []string{"klingon"}
+	assert(t, config.validate() != nil, "Expected an unknown pattern pack to be rejected")
+}
+
+func TestStripPrefixes(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/"})
+	// filed without the prefix, so give them the contents readFiles will
+	// expect under that name
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "Scan_20160825_pge.pdf"), []byte("contents for 20160825_pge.pdf"), 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "SKM_C3350_20160901_pge.pdf"), []byte("contents for 20160901_pge.pdf"), 0600))
+
+	config := &Config{Root: root, StripPrefixes: []string{"Scan_", `SKM_C\d+_`}}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(2), fr.okCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{"filed/", "filed/pge/", "filed/pge/2016/", "filed/pge/2016/20160825_pge.pdf", "filed/pge/2016/20160901_pge.pdf", "inbox/"}, found)
+
+	config.StripPrefixes = []string{"Scan("}
+	assert(t, config.validate() != nil, "Expected a bad strip prefix to be rejected")
+}
+
+func TestIgnore(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/",
+		"inbox/20160825_pge.pdf",
+		"inbox/20160826_pge.pdf.partial",
+		"inbox/~$20160827_pge.docx",
+		"inbox/20160828_pge.pdf.sync",
+	})
+
+	config := &Config{Root: root, Ignore: []string{"*.sync"}}
+	ok(t, config.validate())
+	inbox := path.Join(root, "inbox")
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+	equals(t, []string{
+		path.Join(inbox, "20160826_pge.pdf.partial"),
+		path.Join(inbox, "20160828_pge.pdf.sync"),
+		path.Join(inbox, "~$20160827_pge.docx"),
+	}, fr.jsonSummary(time.Second, nil).Ignored)
+
+	config.Ignore = []string{"[pdf"}
+	assert(t, config.validate() != nil, "Expected a bad ignore glob to be rejected")
+}
+
+func TestRootOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	defer func() { configFile = "" }()
+	configFile = path.Join(dir, "fileinbox.yaml")
+
+	saved, usb := path.Join(dir, "saved"), path.Join(dir, "usb")
+	createFiles(t, saved, []string{"filed/pge/", "inbox/20160825_pge.pdf"})
+	createFiles(t, usb, []string{"filed/pge/", "inbox/20170101_pge.pdf"})
+	yaml := fmt.Sprintf("root: %s\nextrainboxes: [%s]\n", saved, path.Join(saved, "inbox"))
+	ok(t, ioutil.WriteFile(configFile, []byte(yaml), 0600))
+
+	ok(t, newCli().Run([]string{"file_inbox", flagify(rootOnceFlag), usb}))
+
+	// only the usb root's own inbox was filed, and into the usb root
+	_, err = os.Stat(path.Join(usb, "filed/pge/2017/20170101_pge.pdf"))
+	ok(t, err)
+	_, err = os.Stat(path.Join(saved, "inbox/20160825_pge.pdf"))
+	ok(t, err)
+
+	after, err := ioutil.ReadFile(configFile)
+	ok(t, err)
+	equals(t, yaml, string(after))
+
+	err = newCli().Run([]string{"file_inbox", flagify(rootFlag), saved, flagify(rootOnceFlag), usb, "export"})
+	assert(t, err != nil, "expected --root and --root-once together to be rejected")
+}
+
+func TestDestFuture(t *testing.T) {
+	defer func() { clock = fileinbox.SystemClock }()
+	clock = fileinbox.FixedClock(time.Date(2016, 8, 25, 12, 0, 0, 0, time.Local))
+
+	ten := 10
+	config := &Config{Dests: map[string]DestConfig{
+		"warranty": {FutureYears: &ten},
+		"receipts": {NeverFuture: true},
+	}}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	for name, want := range map[string]bool{
+		"20240101_warranty.pdf": true,
+		"20160826_receipts.pdf": false,
+		"20160825_receipts.pdf": true,
+		"20190101_pge.pdf":      false,
+	} {
+		_, err := parseFileName(opts, name)
+		equals(t, want, err == nil)
+	}
+	// --force trusts every date
+	_, err := parseFileName(config.parseOptions(true), "20160826_receipts.pdf")
+	ok(t, err)
+
+	config.Dests["receipts"] = DestConfig{NeverFuture: true, FutureYears: &ten}
+	assert(t, config.validate() != nil, "expected futureyears and neverfuture together to be rejected")
+}
+
+func TestConfigHome(t *testing.T) {
+	defer os.Setenv(configHomeEnv, os.Getenv(configHomeEnv))
+	ok(t, os.Setenv(configHomeEnv, "/data/config"))
+	p, err := (&Config{}).path()
+	ok(t, err)
+	equals(t, "/data/config/fileinbox.yaml", p)
+
+	// without it, the config is under the home directory, which is only
+	// looked up once
+	ok(t, os.Unsetenv(configHomeEnv))
+	home, err := homeDir()
+	ok(t, err)
+	p, err = (&Config{}).path()
+	ok(t, err)
+	equals(t, path.Join(home, ".config/fileinbox/fileinbox.yaml"), p)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	ok(t, os.Setenv("HOME", "/elsewhere"))
+	again, err := homeDir()
+	ok(t, err)
+	equals(t, home, again)
+}
+
+func TestContainerEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	defer func() { configFile = "" }()
+
+	// a config mounted read only, and a root mounted as a volume
+	mounted := path.Join(dir, "config/fileinbox.yaml")
+	createFiles(t, dir, []string{"config/", "data/filed/pge/", "data/inbox/20170101_pge.pdf"})
+	ok(t, ioutil.WriteFile(mounted, []byte("collisions: versioned\n"), 0400))
+	for k, v := range map[string]string{"FILEINBOX_CONFIG": mounted, "FILEINBOX_ROOT": path.Join(dir, "data")} {
+		defer os.Unsetenv(k)
+		ok(t, os.Setenv(k, v))
+	}
+
+	ok(t, newCli().Run([]string{"fileinbox"}))
+	equals(t, mounted, configFile)
+	_, err = os.Stat(path.Join(dir, "data/filed/pge/2017/20170101_pge.pdf"))
+	ok(t, err)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The config may be kept as YAML, TOML or JSON, going by its extension,
+// with the same keys and values in each: those of the YAML, such as
+// retrybackoff: 2s.  The others are read by way of the YAML, and
+// written from it, so they can't drift apart.  JSON is formatJSON, as
+// for export.
+const (
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormats are the formats, in the order we look for them.
+var configFormats = []string{formatYAML, formatTOML, formatJSON}
+
+// configFormat returns the format of the config at name, YAML unless
+// its extension says otherwise.
+func configFormat(name string) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")) {
+	case formatTOML:
+		return formatTOML
+	case formatJSON:
+		return formatJSON
+	}
+	return formatYAML
+}
+
+// configIn returns the config in dir: the first of fileinbox.yaml,
+// fileinbox.toml and fileinbox.json there is, or fileinbox.yaml if
+// there is none yet.
+func configIn(dir string) string {
+	for _, f := range configFormats {
+		p := path.Join(dir, "fileinbox."+f)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return path.Join(dir, "fileinbox."+formatYAML)
+}
+
+// withFormat returns name with the extension of format.
+func withFormat(name, format string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+}
+
+// unmarshalConfig reads data, in format, into c.
+func unmarshalConfig(format string, data []byte, c *Config) error {
+	var generic interface{}
+	switch format {
+	case formatTOML:
+		m := map[string]interface{}{}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return err
+		}
+		generic = m
+	case formatJSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&generic); err != nil {
+			return err
+		}
+	default:
+		return yaml.Unmarshal(data, c)
+	}
+	data, err := yaml.Marshal(fromGeneric(generic))
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, c)
+}
+
+// marshalConfig writes c in format.
+func marshalConfig(format string, c *Config) ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil || format == formatYAML {
+		return data, err
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	generic = toGeneric(generic)
+	if generic == nil {
+		generic = map[string]interface{}{}
+	}
+	if format == formatJSON {
+		data, err := json.MarshalIndent(generic, "", "  ")
+		return append(data, '\n'), err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toGeneric turns what YAML decodes into what JSON and TOML encode:
+// maps keyed by strings, without the nulls neither has a place for.
+func toGeneric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			if e = toGeneric(e); e != nil {
+				m[yamlKey(k)] = e
+			}
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			if e = toGeneric(e); e != nil {
+				l = append(l, e)
+			}
+		}
+		if len(l) == 0 {
+			return nil
+		}
+		// TOML wants a list of tables to hold nothing else
+		if _, ok := l[0].(map[string]interface{}); ok {
+			tables := make([]map[string]interface{}, 0, len(l))
+			for _, e := range l {
+				if t, ok := e.(map[string]interface{}); ok {
+					tables = append(tables, t)
+				}
+			}
+			if len(tables) == len(l) {
+				return tables
+			}
+		}
+		return l
+	}
+	return v
+}
+
+func yamlKey(k interface{}) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	data, _ := yaml.Marshal(k)
+	return strings.TrimSpace(string(data))
+}
+
+// fromGeneric turns what JSON and TOML decode into what YAML encodes as
+// it would have written it.
+func fromGeneric(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		m := yaml.MapSlice{}
+		for _, k := range keys {
+			m = append(m, yaml.MapItem{Key: k, Value: fromGeneric(v[k])})
+		}
+		return m
+	case []map[string]interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = fromGeneric(e)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = fromGeneric(e)
+		}
+		return l
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// convertConfig rewrites the config at name in format, next to it,
+// removing the original once the new one is in place.  It returns the
+// new config's path.
+func convertConfig(name, format string) (string, error) {
+	to := withFormat(name, format)
+	if to == name {
+		return "", errors.Errorf("%s is already %s", name, strings.ToUpper(format))
+	}
+	if _, err := os.Stat(to); err == nil {
+		return "", errors.Errorf("%s already exists", to)
+	}
+
+	unlock, err := lockFile(name + ".lock")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	c := &Config{}
+	if err := unmarshalConfig(configFormat(name), data, c); err != nil {
+		return "", errors.Wrapf(err, "reading %s", name)
+	}
+	if _, err := snapshotConfig(name, c.Root); err != nil {
+		return "", errors.Wrap(err, "snapshotting the config")
+	}
+	converted, err := marshalConfig(format, c)
+	if err != nil {
+		return "", err
+	}
+	// make sure it reads back the same
+	check := &Config{}
+	if err := unmarshalConfig(format, converted, check); err != nil {
+		return "", errors.Wrapf(err, "reading back the %s", strings.ToUpper(format))
+	}
+	again, err := marshalConfig(formatYAML, check)
+	if err != nil {
+		return "", err
+	}
+	if original, _ := marshalConfig(formatYAML, c); !bytes.Equal(original, again) {
+		return "", errors.Errorf("the config doesn't survive being written as %s", strings.ToUpper(format))
+	}
+	if err := writeFileAtomic(to, converted, 0600); err != nil {
+		return "", err
+	}
+	return to, os.Remove(name)
+}
+
+func doConvertConfig(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.Errorf("config convert expects one argument, %s", strings.Join(configFormats, ", "))
+	}
+	if ctx.Bool(skipConfigFlag) {
+		return errors.Errorf("config convert: there is no config with --%s", skipConfigFlag)
+	}
+	format := strings.ToLower(ctx.Args().Get(0))
+	if format == "yml" {
+		format = formatYAML
+	}
+	if !hasString(configFormats, format) {
+		return errors.Errorf("config convert: unknown format %q.  We expect %s", format, strings.Join(configFormats, ", "))
+	}
+	name, err := (&Config{}).path()
+	if err != nil {
+		return errors.Wrap(err, "config convert")
+	}
+	to, err := convertConfig(name, format)
+	if err != nil {
+		return errors.Wrap(err, "config convert")
+	}
+	printf(progress, styleSuccess, "Converted %s to %s\n", name, to)
+	if configFile != "" {
+		printf(progress, styleNotice, "Point --%s at %s from now on\n", configFlag, to)
+	}
+	return nil
+}
+
+func configCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Work with the config file.",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "convert",
+				Usage:     "Rewrite the config as YAML, TOML or JSON, which are told apart by their extensions and have the same keys.",
+				ArgsUsage: "<yaml|toml|json>",
+				Action:    doConvertConfig,
+			},
+		},
+	}
+}
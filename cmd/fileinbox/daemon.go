@@ -0,0 +1,620 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const socketFlag string = "socket"
+
+// Commands the daemon takes over its control socket.
+const (
+	ctlRunNow = "run-now"
+	ctlStatus = "status"
+	ctlPause  = "pause"
+	ctlResume = "resume"
+	ctlReload = "reload-config"
+)
+
+// ctlRequest is what ctl sends the daemon, one JSON object per
+// connection.
+type ctlRequest struct {
+	Command string `json:"command"`
+}
+
+// ctlResponse is the daemon's answer.
+type ctlResponse struct {
+	Error  string        `json:"error,omitempty"`
+	Status *daemonStatus `json:"status,omitempty"`
+}
+
+// runStatus is how a run went.
+type runStatus struct {
+	Started  time.Time `json:"started"`
+	Seconds  float64   `json:"seconds"`
+	Filed    uint32    `json:"filed"`
+	Failures uint32    `json:"failures"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// scheduleStatus is where a schedule is at.
+type scheduleStatus struct {
+	When    string     `json:"when"`
+	Run     []string   `json:"run"`
+	Next    time.Time  `json:"next"`
+	Running bool       `json:"running,omitempty"`
+	LastRun *time.Time `json:"lastRun,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// daemonStatus is what ctl status reports.
+type daemonStatus struct {
+	Started time.Time  `json:"started"`
+	Paused  bool       `json:"paused"`
+	Running bool       `json:"running"`
+	Backend string     `json:"backend"`
+	Inboxes []string   `json:"inboxes"`
+	Runs    int        `json:"runs"`
+	LastRun *runStatus `json:"lastRun,omitempty"`
+	// LastSuccess is when the last run that didn't stop with an error
+	// started.
+	LastSuccess *time.Time       `json:"lastSuccess,omitempty"`
+	Schedules   []scheduleStatus `json:"schedules,omitempty"`
+}
+
+type ctlCall struct {
+	req   ctlRequest
+	reply chan ctlResponse
+}
+
+// scheduled is a Schedule the daemon is keeping.
+type scheduled struct {
+	s      Schedule
+	status scheduleStatus
+}
+
+// taskResult is how a scheduled command went.
+type taskResult struct {
+	sc      *scheduled
+	started time.Time
+	err     error
+}
+
+// daemon files whatever arrives in the inboxes, as the watch command
+// does, and takes commands over a control socket.  Everything but the
+// runs themselves happens on the serve goroutine, so there is no
+// locking.
+type daemon struct {
+	// load reads the config, and file does a single run.  They are
+	// fields so tests can replace them.
+	load func() (*Config, error)
+	file func() (fileResult, time.Duration, error)
+	// task runs a scheduled command other than file.
+	task func(args []string) error
+
+	status    daemonStatus
+	w         watcher
+	wakes     chan error
+	requests  chan ctlCall
+	runDone   chan runStatus
+	schedules []*scheduled
+	taskDone  chan taskResult
+	tasks     int // scheduled commands running
+
+	pending bool               // another run is needed once this one is done
+	waiting []chan ctlResponse // run-now callers waiting on this run
+	next    []chan ctlResponse // run-now callers waiting on the pending run
+}
+
+func newDaemon(load func() (*Config, error), file func() (fileResult, time.Duration, error)) *daemon {
+	return &daemon{
+		load:     load,
+		file:     file,
+		task:     runCommand,
+		status:   daemonStatus{Started: time.Now()},
+		wakes:    make(chan error),
+		requests: make(chan ctlCall),
+		runDone:  make(chan runStatus),
+		taskDone: make(chan taskResult),
+	}
+}
+
+// schedule replaces the schedules with those in config.  Commands
+// already running are left to finish.
+func (d *daemon) schedule(config *Config) {
+	now := time.Now()
+	d.schedules = nil
+	for _, s := range config.schedules() {
+		d.schedules = append(d.schedules, &scheduled{
+			s:      s,
+			status: scheduleStatus{When: s.When, Run: s.Run, Next: s.next(now)},
+		})
+	}
+}
+
+// nextDue returns when the next schedule is due, or the zero time if
+// there are none.
+func (d *daemon) nextDue() time.Time {
+	var next time.Time
+	for _, sc := range d.schedules {
+		if t := sc.status.Next; !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// runDue starts whatever is due at now.  A command still running from
+// last time is skipped, rather than run twice at once.
+func (d *daemon) runDue(now time.Time) {
+	for _, sc := range d.schedules {
+		if sc.status.Next.IsZero() || sc.status.Next.After(now) {
+			continue
+		}
+		sc.status.Next = sc.s.next(now)
+		if sc.s.Run[0] == scheduleFile {
+			if d.status.Paused {
+				d.pending = true
+				continue
+			}
+			d.run()
+			continue
+		}
+		if sc.status.Running {
+			continue
+		}
+		sc.status.Running = true
+		d.tasks++
+		go func(sc *scheduled, args []string) {
+			d.taskDone <- taskResult{sc, now, d.task(args)}
+		}(sc, sc.s.Run)
+	}
+}
+
+func (d *daemon) taskFinished(tr taskResult) {
+	d.tasks--
+	tr.sc.status.Running = false
+	tr.sc.status.LastRun = &tr.started
+	tr.sc.status.Error = ""
+	if tr.err != nil {
+		tr.sc.status.Error = tr.err.Error()
+		printf(progress, styleFailure, "Scheduled %s failed: %v\n", strings.Join(tr.sc.s.Run, " "), tr.err)
+	}
+}
+
+// watch replaces the watcher with one for the inboxes in config.
+func (d *daemon) watch(config *Config) error {
+	if len(config.ExtraInboxes) == 0 {
+		return errors.New("there are no inboxes to watch")
+	}
+	w, backend, err := newWatcher(config.Watch, config.ExtraInboxes)
+	if err != nil {
+		return err
+	}
+	if d.w != nil {
+		d.w.close()
+	}
+	d.w = w
+	d.status.Backend = backend
+	d.status.Inboxes = append([]string(nil), config.ExtraInboxes...)
+	go func() {
+		for {
+			err := w.wait()
+			if err == errWatcherClosed {
+				return
+			}
+			d.wakes <- err
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// serve runs until stop fires, filing once at the start and then
+// whenever the watcher or a ctl client asks.  A run in progress is
+// finished before we return.
+func (d *daemon) serve(l net.Listener, stop <-chan os.Signal) error {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.handle(conn)
+		}
+	}()
+
+	d.run()
+	for {
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next := d.nextDue(); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case now := <-due:
+			d.runDue(now)
+		case tr := <-d.taskDone:
+			d.taskFinished(tr)
+		case err := <-d.wakes:
+			if err != nil {
+				return d.shutdown(errors.Wrap(err, "watching"))
+			}
+			if d.status.Paused {
+				d.pending = true
+			} else {
+				d.run()
+			}
+		case c := <-d.requests:
+			d.dispatch(c)
+		case rs := <-d.runDone:
+			d.finished(rs)
+		case <-stop:
+			return d.shutdown(nil)
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// shutdown waits for a run and any scheduled commands in progress,
+// then stops the watcher.
+func (d *daemon) shutdown(err error) error {
+	if d.status.Running {
+		d.finished(<-d.runDone)
+	}
+	for d.tasks != 0 {
+		d.taskFinished(<-d.taskDone)
+	}
+	if d.w != nil {
+		d.w.close()
+	}
+	return err
+}
+
+// run starts a run, or if one is going, arranges for another once it is
+// done.
+func (d *daemon) run() {
+	if d.status.Running {
+		d.pending = true
+		return
+	}
+	d.status.Running = true
+	d.pending = false
+	go func() {
+		start := time.Now()
+		fr, duration, err := d.file()
+		rs := runStatus{Started: start, Seconds: duration.Seconds(), Filed: fr.okCount, Failures: fr.failureCount}
+		if err != nil {
+			rs.Error = err.Error()
+		}
+		d.runDone <- rs
+	}()
+}
+
+func (d *daemon) finished(rs runStatus) {
+	d.status.Running = false
+	d.status.Runs++
+	d.status.LastRun = &rs
+	if rs.Error == "" {
+		started := rs.Started
+		d.status.LastSuccess = &started
+	}
+	for _, reply := range d.waiting {
+		reply <- d.response()
+	}
+	d.waiting = nil
+	if d.pending && (!d.status.Paused || len(d.next) != 0) {
+		// run-now callers that came during this run wait on the next
+		d.waiting, d.next = d.next, nil
+		d.run()
+	}
+}
+
+func (d *daemon) response() ctlResponse {
+	s := d.status
+	for _, sc := range d.schedules {
+		s.Schedules = append(s.Schedules, sc.status)
+	}
+	return ctlResponse{Status: &s}
+}
+
+func (d *daemon) dispatch(c ctlCall) {
+	switch c.req.Command {
+	case ctlRunNow:
+		// answered once the run is done
+		if d.status.Running {
+			d.next = append(d.next, c.reply)
+		} else {
+			d.waiting = append(d.waiting, c.reply)
+		}
+		d.run()
+		return
+	case ctlStatus:
+	case ctlPause:
+		d.status.Paused = true
+	case ctlResume:
+		d.status.Paused = false
+		if d.pending {
+			d.run()
+		}
+	case ctlReload:
+		config, err := d.load()
+		if err == nil {
+			err = d.watch(config)
+		}
+		if err != nil {
+			c.reply <- ctlResponse{Error: fmt.Sprintf("reloading config: %v", err)}
+			return
+		}
+		d.schedule(config)
+	default:
+		c.reply <- ctlResponse{Error: fmt.Sprintf("unknown command %q", c.req.Command)}
+		return
+	}
+	c.reply <- d.response()
+}
+
+// handle answers a single ctl request.
+func (d *daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	var req ctlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: fmt.Sprintf("reading request: %v", err)})
+		return
+	}
+	if req.Command == ctlEvents {
+		streamEvents(conn)
+		return
+	}
+	c := ctlCall{req, make(chan ctlResponse, 1)}
+	d.requests <- c
+	json.NewEncoder(conn).Encode(<-c.reply)
+}
+
+// socketPath returns --socket, or the default next to the config.
+func socketPath(ctx *cli.Context) (string, error) {
+	if s := ctx.String(socketFlag); s != "" {
+		return s, nil
+	}
+	p, err := (&Config{}).path()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(p), "fileinbox.sock"), nil
+}
+
+// listenControl listens on the control socket.  A socket left behind by
+// a daemon that died is removed, but one that is answering is not.
+func listenControl(name string) (net.Listener, error) {
+	if _, err := os.Stat(name); err == nil {
+		if conn, err := net.Dial("unix", name); err == nil {
+			conn.Close()
+			return nil, errors.Errorf("a daemon is already listening on %s", name)
+		}
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		return nil, err
+	}
+	// only we may control the daemon
+	if err := os.Chmod(name, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadDaemonConfig loads the config, making sure the schedules only run
+// commands we have.
+func loadDaemonConfig(ctx *cli.Context) (*Config, error) {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range config.schedules() {
+		if s.Run[0] != scheduleFile && ctx.App.Command(s.Run[0]) == nil {
+			return nil, errors.Errorf("schedule %q runs %q, which is not a command", s.When, s.Run[0])
+		}
+	}
+	return config, nil
+}
+
+func doDaemon(ctx *cli.Context) error {
+	config, err := loadDaemonConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "daemon")
+	}
+	name, err := socketPath(ctx)
+	if err != nil {
+		return errors.Wrap(err, "daemon")
+	}
+
+	d := newDaemon(
+		func() (*Config, error) { return loadDaemonConfig(ctx) },
+		func() (fileResult, time.Duration, error) { return fileOnce(ctx) })
+	if err := d.watch(config); err != nil {
+		return errors.Wrap(err, "daemon")
+	}
+	d.schedule(config)
+	l, err := listenControl(name)
+	if err != nil {
+		return errors.Wrap(err, "daemon")
+	}
+	defer os.Remove(name)
+	defer l.Close()
+	if addr := ctx.String(httpFlag); addr != "" {
+		srv, err := d.serveHealth(addr)
+		if err != nil {
+			return errors.Wrap(err, "daemon")
+		}
+		defer srv.Close()
+		printf(progress, stylePlain, "Serving /healthz and /readyz on %s\n", addr)
+	}
+
+	stop := make(chan os.Signal, 1)
+	stopExitOnTerm()
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	printf(progress, stylePlain, "Watching %d inboxes using %s, control socket %s\n", len(d.status.Inboxes), d.status.Backend, name)
+	return errors.Wrap(d.serve(l, stop), "daemon")
+}
+
+// callDaemon sends command to the daemon listening on name.
+func callDaemon(name, command string) (*ctlResponse, error) {
+	conn, err := net.Dial("unix", name)
+	if err != nil {
+		return nil, errors.Wrap(err, "is the daemon running?")
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(ctlRequest{command}); err != nil {
+		return nil, err
+	}
+	var resp ctlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "reading response")
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+func doCtl(ctx *cli.Context) error {
+	command := ctx.Command.Name
+	name, err := socketPath(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ctl")
+	}
+	resp, err := callDaemon(name, command)
+	if err != nil {
+		return errors.Wrapf(err, "ctl %s", command)
+	}
+	if ctx.String(outputFlag) == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp.Status)
+	}
+	resp.Status.write(command)
+	return nil
+}
+
+// write prints the status, or just the part of it that command changed.
+func (s *daemonStatus) write(command string) {
+	switch command {
+	case ctlPause:
+		fmt.Println("Paused.  Files will wait in the inboxes until resumed.")
+		return
+	case ctlResume:
+		fmt.Println("Resumed.")
+		return
+	case ctlReload:
+		fmt.Printf("Reloaded.  Watching %s using %s.\n", strings.Join(s.Inboxes, ", "), s.Backend)
+		return
+	case ctlRunNow:
+		s.LastRun.write()
+		return
+	}
+
+	state := "idle"
+	switch {
+	case s.Running:
+		state = "running"
+	case s.Paused:
+		state = "paused"
+	}
+	fmt.Printf("State:   %s\n", state)
+	fmt.Printf("Up:      since %s\n", s.Started.Format(time.RFC3339))
+	fmt.Printf("Watching %s using %s\n", strings.Join(s.Inboxes, ", "), s.Backend)
+	fmt.Printf("Runs:    %d\n", s.Runs)
+	if s.LastRun != nil {
+		fmt.Print("Last:    ")
+		s.LastRun.write()
+	}
+	if s.LastSuccess != nil && (s.LastRun == nil || s.LastRun.Error != "") {
+		fmt.Printf("Success: %s\n", s.LastSuccess.Format(time.RFC3339))
+	}
+	for _, sc := range s.Schedules {
+		fmt.Printf("Schedule: %s at %s, next %s", strings.Join(sc.Run, " "), sc.When, sc.Next.Format(time.RFC3339))
+		switch {
+		case sc.Running:
+			fmt.Print(", running")
+		case sc.Error != "":
+			fmt.Printf(", last failed: %s", sc.Error)
+		}
+		fmt.Println()
+	}
+}
+
+func (rs *runStatus) write() {
+	fmt.Printf("filed %s files with %s failures in %s at %s", formatCount(int64(rs.Filed)), formatCount(int64(rs.Failures)),
+		formatDuration(time.Duration(rs.Seconds*float64(time.Second))), rs.Started.Format(time.RFC3339))
+	if rs.Error != "" {
+		fmt.Printf(": %s", rs.Error)
+	}
+	fmt.Println()
+}
+
+func daemonCommands() []*cli.Command {
+	socket := &cli.StringFlag{
+		Name:    socketFlag,
+		Usage:   "The control socket.  Defaults to fileinbox.sock next to the config.",
+		EnvVars: []string{"FILEINBOX_SOCKET"},
+	}
+	var ctl []*cli.Command
+	for _, c := range []struct{ name, usage string }{
+		{ctlRunNow, "File everything in the inboxes now, even if paused, and wait for it to finish."},
+		{ctlStatus, "Show what the daemon is doing and how its last run went."},
+		{ctlPause, "Stop filing as files arrive, until resumed."},
+		{ctlResume, "Start filing as files arrive again, catching up on anything that came while paused."},
+		{ctlReload, "Check the config and watch the inboxes it lists."},
+	} {
+		ctl = append(ctl, &cli.Command{Name: c.name, Usage: c.usage, Action: doCtl})
+	}
+	ctl = append(ctl, &cli.Command{
+		Name:   ctlEvents,
+		Usage:  "Follow what the daemon does with each file as it happens: detected, filed or failed.",
+		Action: doCtlEvents,
+	})
+	return []*cli.Command{
+		{
+			Name:   "daemon",
+			Usage:  "Keep filing as files arrive, and run the config's schedules, taking commands from fileinbox ctl.",
+			Action: doDaemon,
+			Flags: []cli.Flag{
+				socket,
+				&cli.StringFlag{
+					Name:    httpFlag,
+					Usage:   "Serve /healthz and /readyz on this address, e.g. :8080, for container orchestrators and uptime monitors.",
+					EnvVars: []string{"FILEINBOX_HTTP"},
+				},
+			},
+		},
+		{
+			Name:        "ctl",
+			Usage:       "Control a running daemon.",
+			Flags:       []cli.Flag{socket},
+			Subcommands: ctl,
+		},
+	}
+}
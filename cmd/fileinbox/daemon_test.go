@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	inbox := path.Join(root, "inbox")
+	ok(t, os.Mkdir(inbox, 0700))
+	config := &Config{Root: root, ExtraInboxes: []string{inbox}}
+	config.Watch = WatchConfig{Backend: watchPoll, Interval: 10 * time.Millisecond}
+	ok(t, config.validate())
+
+	runs := make(chan bool, 10)
+	d := newDaemon(
+		func() (*Config, error) { return config, nil },
+		func() (fileResult, time.Duration, error) {
+			runs <- true
+			return fileResult{okCount: 1}, time.Millisecond, nil
+		})
+	ok(t, d.watch(config))
+
+	sock := path.Join(root, "fileinbox.sock")
+	l, err := listenControl(sock)
+	ok(t, err)
+	defer l.Close()
+	_, err = listenControl(sock)
+	assert(t, err != nil, "expected a second daemon on the same socket to be refused")
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- d.serve(l, stop) }()
+	waitRun := func(what string) {
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a run %s", what)
+		}
+	}
+	waitRun("at the start")
+
+	resp, err := callDaemon(sock, ctlRunNow)
+	ok(t, err)
+	waitRun("for run-now")
+	equals(t, uint32(1), resp.Status.LastRun.Filed)
+
+	resp, err = callDaemon(sock, ctlPause)
+	ok(t, err)
+	assert(t, resp.Status.Paused, "expected the daemon to be paused")
+	ok(t, ioutil.WriteFile(path.Join(inbox, "20160825_pge.pdf"), []byte("hello"), 0600))
+	time.Sleep(100 * time.Millisecond)
+	assert(t, len(runs) == 0, "expected nothing filed while paused")
+
+	_, err = callDaemon(sock, ctlResume)
+	ok(t, err)
+	waitRun("for what arrived while paused")
+
+	resp, err = callDaemon(sock, ctlReload)
+	ok(t, err)
+	equals(t, []string{inbox}, resp.Status.Inboxes)
+	config.ExtraInboxes = nil
+	_, err = callDaemon(sock, ctlReload)
+	assert(t, err != nil, "expected a reload without inboxes to fail")
+
+	resp, err = callDaemon(sock, ctlStatus)
+	ok(t, err)
+	equals(t, watchPoll, resp.Status.Backend)
+	assert(t, resp.Status.Runs >= 3, "expected at least 3 runs, got %d", resp.Status.Runs)
+
+	_, err = callDaemon(sock, "dance")
+	assert(t, err != nil, "expected an unknown command to be rejected")
+
+	stop <- os.Interrupt
+	select {
+	case err := <-served:
+		ok(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the daemon to stop")
+	}
+}
+
+func TestDaemonSchedules(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	inbox := path.Join(root, "inbox")
+	ok(t, os.Mkdir(inbox, 0700))
+	config := &Config{Root: root, ExtraInboxes: []string{inbox}}
+	config.Watch = WatchConfig{Backend: watchPoll, Interval: time.Hour}
+	config.Schedules = []Schedule{
+		{When: "@every 20ms", Run: []string{"file"}},
+		{When: "@every 20ms", Run: []string{"prune-empty"}},
+	}
+	ok(t, config.validate())
+
+	runs := make(chan bool, 100)
+	d := newDaemon(
+		func() (*Config, error) { return config, nil },
+		func() (fileResult, time.Duration, error) {
+			runs <- true
+			return fileResult{}, 0, nil
+		})
+	tasks := make(chan []string, 100)
+	d.task = func(args []string) error {
+		tasks <- args
+		return errors.New("no luck")
+	}
+	ok(t, d.watch(config))
+	d.schedule(config)
+
+	sock := path.Join(root, "fileinbox.sock")
+	l, err := listenControl(sock)
+	ok(t, err)
+	defer l.Close()
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- d.serve(l, stop) }()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected scheduled runs")
+		}
+	}
+	select {
+	case args := <-tasks:
+		equals(t, []string{"prune-empty"}, args)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected prune-empty to be run")
+	}
+
+	resp, err := callDaemon(sock, ctlStatus)
+	ok(t, err)
+	equals(t, 2, len(resp.Status.Schedules))
+	equals(t, "@every 20ms", resp.Status.Schedules[1].When)
+
+	stop <- os.Interrupt
+	select {
+	case err := <-served:
+		ok(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the daemon to stop")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// What to do with dates that read validly with the day and month
+// swapped.
+const (
+	ambiguousWarn = "warn"
+	ambiguousSkip = "skip"
+)
+
+// errAmbiguousDate is why a file is left in the inbox with
+// AmbiguousDates skip.
+var errAmbiguousDate = errors.New("the date could be read with the day and month swapped")
+
+func validateDateOrder(order string) error {
+	switch order {
+	case "", fileinbox.DateOrderYMD, fileinbox.DateOrderDMY, fileinbox.DateOrderMDY:
+		return nil
+	}
+	return errors.Errorf("unknown date order %q.  We expect one of %s, %s or %s",
+		order, fileinbox.DateOrderYMD, fileinbox.DateOrderDMY, fileinbox.DateOrderMDY)
+}
+
+// inboxOptions returns opts with the date order for names in inbox:
+// its own if it has one, or else Config.DateOrder.
+func (c *Config) inboxOptions(opts fileinbox.ParseOptions, inbox string) fileinbox.ParseOptions {
+	opts.DateOrder = c.DateOrder
+	for _, key := range []string{path.Clean(inbox), path.Base(inbox)} {
+		if order, ok := c.InboxDateOrders[key]; ok {
+			opts.DateOrder = order
+			return opts
+		}
+	}
+	return opts
+}
+
+// InboxConfig is how an extra inbox is handled.  e.g.
+//
+//	inboxes:
+//	  /mnt/scanner:
+//	    optional: true
+type InboxConfig struct {
+	// Optional inboxes, such as a share that isn't always mounted, are
+	// skipped with a notice when missing, rather than stopping the run.
+	Optional bool
+}
+
+// inboxConfig returns the config for inbox, by path or else base name.
+func (c *Config) inboxConfig(inbox string) InboxConfig {
+	for _, key := range []string{path.Clean(inbox), path.Base(inbox)} {
+		if ic, ok := c.Inboxes[key]; ok {
+			return ic
+		}
+	}
+	return InboxConfig{}
+}
+
+// missingInbox deals with inbox if it isn't there: creating it if
+// create is set, or else skipping it if it is optional.  Otherwise,
+// processInbox will complain.  skip is true if there is nothing to file.
+func missingInbox(config *Config, inbox string, create, dryRun bool) (skip bool, err error) {
+	if isDir(inbox) {
+		return false, nil
+	}
+	switch {
+	case create && dryRun:
+		printf(progress, styleNotice, "Would create the missing inbox %s\n", inbox)
+		return true, nil
+	case create:
+		if err := config.perms.mkdirAll(inbox); err != nil {
+			return false, errors.Wrapf(err, "creating %s", inbox)
+		}
+		printf(progress, styleNotice, "Created the missing inbox %s\n", inbox)
+		return false, nil
+	case config.inboxConfig(inbox).Optional:
+		printf(progress, styleNotice, "Skipping the inbox %s, which isn't there\n", inbox)
+		return true, nil
+	}
+	return false, nil
+}
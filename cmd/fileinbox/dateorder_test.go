@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestInboxDateOrders(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"inbox/20160702_pge.pdf",
+		"scans/",
+	}
+	// these are renamed as they are filed, so they get the contents
+	// readFiles expects under their new names
+	scans := map[string]string{
+		"25082016_pge.pdf":   "20160825_pge.pdf",
+		"01022016_pge.pdf":   "20160201_pge.pdf",
+		"13022016-2_pge.pdf": "20160213-2_pge.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160702_pge.pdf",
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2016/20160213-2_pge.pdf",
+		"inbox/",
+		"scans/",
+		"scans/01022016_pge.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	for name, filed := range scans {
+		ok(t, ioutil.WriteFile(path.Join(root, "scans", name), []byte(fmt.Sprintf("contents for %s", filed)), 0600))
+	}
+
+	config := &Config{
+		Root:            root,
+		InboxDateOrders: map[string]string{"scans": "dmy"},
+		AmbiguousDates:  ambiguousSkip,
+	}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	ok(t, processInbox(path.Join(root, "scans"), config, opts, false, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+	equals(t, uint32(1), fr.failureCount)
+	equals(t, []failure{newFailure(path.Join(root, "scans", "01022016_pge.pdf"), failAmbiguous, errAmbiguousDate)}, fr.failures)
+
+	// the ambiguous one is left for us, under the name it came with
+	ok(t, ioutil.WriteFile(path.Join(root, "scans", "01022016_pge.pdf"), []byte("contents for 01022016_pge.pdf"), 0600))
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+
+	config.InboxDateOrders["scans"] = "ydm"
+	assert(t, config.validate() != nil, "expected an error for an unknown date order")
+}
+
+// The archive is always YYYYMMDD, whatever order the inboxes use.
+func TestDateOrderLeavesArchiveAlone(t *testing.T) {
+	start := []string{
+		"filed/pge/20110305_pge.pdf",
+		"filed/pge/2016/20160825_pge.pdf",
+		"inbox/",
+	}
+	expected := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2011/",
+		"filed/pge/2011/20110305_pge.pdf",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2016/20160901_pge.pdf",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "01092016_pge.pdf"), []byte("contents for 20160901_pge.pdf"), 0600))
+
+	config := &Config{Root: root, DateOrder: "dmy"}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+
+	docs, err := findFiled(config, opts, "pge")
+	ok(t, err)
+	equals(t, 3, len(docs))
+}
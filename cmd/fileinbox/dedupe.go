@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// filedHashes is where each document in the archive is filed, by the
+// hash of its contents, for Config.DuplicatesAnywhere.  It comes from
+// the index, brought up to date once per run, and what the run files
+// is added as it goes.
+type filedHashes struct {
+	byHash map[string]string // relative to filed
+	sums   map[string]string // the hashes of documents in the inbox, by path
+}
+
+// filedHashes returns what is filed by hash, reading and updating the
+// index the first time.
+func (c *Config) filedHashes(fr *fileResult) (*filedHashes, error) {
+	if fr.archive != nil {
+		return fr.archive, nil
+	}
+	idx, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := updateIndex(c, idx, false, runtime.NumCPU()); err != nil {
+		return nil, err
+	}
+	if err := idx.write(); err != nil {
+		printf(progress, styleNotice, "Unable to save the index: %v\n", err)
+	}
+	h := &filedHashes{byHash: map[string]string{}, sums: map[string]string{}}
+	for rel, e := range idx.entries {
+		// the same for every run, when there are already duplicates
+		if have, ok := h.byHash[e.SHA256]; !ok || rel < have {
+			h.byHash[e.SHA256] = rel
+		}
+	}
+	fr.archive = h
+	return h, nil
+}
+
+// addFiled adds the documents of moves that were filed.
+func (h *filedHashes) addFiled(config *Config, moves []fileinbox.Move) {
+	if h == nil {
+		return
+	}
+	for _, m := range moves {
+		sum, ok := h.sums[m.From]
+		if !ok {
+			continue
+		}
+		delete(h.sums, m.From)
+		if _, err := os.Lstat(m.From); !os.IsNotExist(err) {
+			// left in the inbox
+			continue
+		}
+		if _, ok := h.byHash[sum]; ok {
+			continue
+		}
+		if rel, err := filepath.Rel(config.filed(), m.To); err == nil {
+			h.byHash[sum] = filepath.ToSlash(rel)
+		}
+	}
+}
+
+// dropFiledAnywhere returns moves without the documents already filed
+// elsewhere in the archive with the same contents, which are removed
+// from the inbox, unless Config.Duplicates says to keep them.  Those
+// filed under the name they would be filed as are left for the plan,
+// which handles them the same way.  A document with the same contents
+// as another in moves is left in the inbox until the other is filed.
+func (c *Config) dropFiledAnywhere(moves []fileinbox.Move, dryRun bool, fr *fileResult) ([]fileinbox.Move, error) {
+	h, err := c.filedHashes(fr)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the index, for duplicatesanywhere")
+	}
+	var kept []fileinbox.Move
+	planned := map[string]string{} // by hash
+	for _, m := range moves {
+		fi, err := os.Lstat(m.From)
+		var sum string
+		if err == nil {
+			sum, err = hashFile(m.From)
+		}
+		if err != nil {
+			printf(progress, styleFailure, "Unable to read %q: %v\n", m.From, err)
+			fr.fail(m.From, failContents, err)
+			continue
+		}
+		skip := func() {
+			fr.skippedCount++
+			fr.skippedBytes += fi.Size()
+		}
+		if first, ok := planned[sum]; ok {
+			printf(progress, styleNotice, "%q has the same contents as %q, leaving it in the inbox until that is filed\n", m.From, first)
+			skip()
+			continue
+		}
+		rel, ok := h.byHash[sum]
+		existing := path.Join(c.filed(), rel)
+		if ok && existing != m.To {
+			// the index may be behind what is on disk
+			same, err := fileinbox.SameContents(m.From, existing)
+			ok = err == nil && same
+		}
+		if !ok || existing == m.To {
+			planned[sum] = m.From
+			if !dryRun {
+				h.sums[m.From] = sum
+			}
+			kept = append(kept, m)
+			continue
+		}
+
+		fr.duplicates++
+		switch {
+		case dryRun:
+			printf(progress, styleNotice, "%q is already filed as %s, so it would not be filed again\n", m.From, existing)
+		case c.Duplicates == duplicatesKeep:
+			printf(progress, styleNotice, "%q is already filed as %s, leaving it in the inbox\n", m.From, existing)
+			skip()
+		default:
+			if err := os.Remove(m.From); err != nil {
+				printf(progress, styleFailure, "Unable to remove %q, already filed as %s: %v\n", m.From, existing, err)
+				fr.fail(m.From, failFile, err)
+				continue
+			}
+			printf(progress, styleNotice, "%q is already filed as %s, removed it from the inbox\n", m.From, existing)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// Ways a dest can date files that arrive without one.
+const (
+	defaultDateToday           = "today"
+	defaultDateFirstOfMonth    = "firstofmonth"
+	defaultDateLastBusinessDay = "lastbusinessday"
+)
+
+// DestConfig holds settings for a single dest, keyed by dest name in
+// Config.Dests.
+type DestConfig struct {
+	// DefaultDate lets files named with just the dest, such as
+	// payslip.pdf or payslip_acme.pdf, be filed.  The file is renamed
+	// with the date in front while filing.  One of today, firstofmonth
+	// or lastbusinessday (of the current month).
+	DefaultDate string
+
+	// MaxFiles caps how many files go in a year directory, and MaxBytes
+	// how big they may add up to, zero meaning no cap.  Past either,
+	// files roll over as Rollover says: letter (the default) for 2016b,
+	// 2016c..., or month for 2016/07, 2016/08...
+	MaxFiles int
+	MaxBytes int64
+	Rollover string
+
+	// Dirs is how documents are laid out under the dest: year (the
+	// default) for 2016/, month for 2016/08/, as suits photos, quarter
+	// for 2016/Q3/, as suits invoices, fiscal for FY2015-16/, as suits
+	// taxes, or flat for everything in the dest itself.  Only year can be combined with MaxFiles or MaxBytes.  To
+	// change it for a dest that already has documents, use
+	// migrate-layout.
+	Dirs string
+
+	// FiscalStart is the month, 1 to 12, that the fiscal years of Dirs
+	// fiscal start in, e.g. 10 for the US federal government.  It
+	// defaults to 4, for the UK tax year, so 20240310_hmrc.pdf is filed
+	// under FY2023-24/.
+	FiscalStart int
+
+	// Hold leaves files for this dest in the inbox, so they can be
+	// looked over before they are filed.  They are counted in the
+	// summary rather than treated as failures.
+	Hold bool
+
+	// Frozen closes a dest, e.g. for an account that was closed.  What
+	// is filed under it stays, and is still indexed and compared, but
+	// nothing more is filed there or moved around within it: files for
+	// it are left in the inbox as failures, pointing at Successor, the
+	// dest that took over, if there is one.
+	Frozen    bool
+	Successor string
+
+	// CC mirrors this dest under its own root, rather than CC.Root, e.g.
+	// taxes to an encrypted drive while everything else goes to the NAS.
+	// A dest listed by name in CC.Dests may not also have its own.
+	CC string
+
+	// Rename rewrites names as they are filed, e.g. with
+	// "{dest}-{date}.{ext}" 20160825_pge_bill.pdf is filed as
+	// pge-20160825.pdf.  The fields are {date}, {year}, {month}, {day},
+	// {dest}, {description} and {ext}, and {dest} and the date must be
+	// there so the new name can still be parsed.  The journal keeps the
+	// name it arrived with.
+	Rename string
+
+	// FutureYears overrides how many years ahead a document's date may
+	// be, e.g. 10 for warranties, or -1 for no limit.  NeverFuture
+	// rejects any date after today, for dests like receipts where a
+	// future date is always a typo.  --force overrides both.
+	FutureYears *int
+	NeverFuture bool
+}
+
+func (d DestConfig) fiscalStart() int {
+	if d.FiscalStart == 0 {
+		return defaultFiscalStart
+	}
+	return d.FiscalStart
+}
+
+func (d DestConfig) validate() error {
+	switch d.DefaultDate {
+	case "", defaultDateToday, defaultDateFirstOfMonth, defaultDateLastBusinessDay:
+	default:
+		return errors.Errorf("unknown default date %q.  We expect one of %s, %s or %s",
+			d.DefaultDate, defaultDateToday, defaultDateFirstOfMonth, defaultDateLastBusinessDay)
+	}
+	if d.FutureYears != nil && d.NeverFuture {
+		return errors.New("set only one of futureyears and neverfuture")
+	}
+	if d.Successor != "" {
+		if !d.Frozen {
+			return errors.New("only a frozen dest has a successor")
+		}
+		if err := checkDest(d.Successor); err != nil {
+			return errors.Wrap(err, "successor")
+		}
+	}
+	return validateRollover(d)
+}
+
+// frozen returns why nothing more may be filed under dest, if it is
+// frozen.
+func (c *Config) frozen(dest string) error {
+	d := c.Dests[dest]
+	switch {
+	case !d.Frozen:
+		return nil
+	case d.Successor != "":
+		return errors.Errorf("%s is frozen.  Name it for %s, which took over from it", dest, d.Successor)
+	}
+	return errors.Errorf("%s is frozen, so nothing more is filed under it", dest)
+}
+
+// frozenDests returns why parsed can't be filed, if its dest, or one of
+// the others it is copied to, is frozen.
+func (c *Config) frozenDests(parsed *parsedName) error {
+	for _, dest := range append([]string{parsed.dest}, parsed.also...) {
+		if err := c.frozen(dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destFuture returns the future date policies of the dests that have
+// one.
+func (c *Config) destFuture() map[string]fileinbox.FuturePolicy {
+	var policies map[string]fileinbox.FuturePolicy
+	for dest, dc := range c.Dests {
+		if dc.FutureYears == nil && !dc.NeverFuture {
+			continue
+		}
+		if policies == nil {
+			policies = map[string]fileinbox.FuturePolicy{}
+		}
+		p := fileinbox.FuturePolicy{Years: fileinbox.DefaultFutureYears, Never: dc.NeverFuture}
+		if dc.FutureYears != nil {
+			p.Years = *dc.FutureYears
+		}
+		policies[dest] = p
+	}
+	return policies
+}
+
+var undatedRe = regexp.MustCompile(`^([^_.]+)`)
+
+// undated returns how to file baseName if it starts with a dest that has
+// a default date, or nil.
+func (c *Config) undated(opts fileinbox.ParseOptions, baseName string) *parsedName {
+	matches := undatedRe.FindStringSubmatch(baseName)
+	if matches == nil {
+		return nil
+	}
+	dest := opts.ResolveDest(matches[1])
+	kind := c.Dests[dest].DefaultDate
+	if kind == "" {
+		return nil
+	}
+
+	t := defaultDate(kind, opts.Now())
+	parsed := &parsedName{baseName: baseName, dest: dest}
+	parsed.setDate(t)
+	parsed.newName = t.Format("20060102") + "_" + baseName
+	return parsed
+}
+
+func defaultDate(kind string, now time.Time) time.Time {
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	switch kind {
+	case defaultDateFirstOfMonth:
+		return first
+	case defaultDateLastBusinessDay:
+		d := first.AddDate(0, 1, -1)
+		for d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+			d = d.AddDate(0, 0, -1)
+		}
+		return d
+	default:
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	topFlag   string = "top"
+	chartFlag string = "chart"
+)
+
+// chartWidth is how wide the longest bar of a --chart is.
+const chartWidth = 40
+
+// usage is how much space some part of the archive takes up.
+type usage struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// bigFile is one of the largest documents.
+type bigFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// duReport is disk usage broken down by dest and by year.
+type duReport struct {
+	Total usage     `json:"total"`
+	Dests []usage   `json:"dests"` // largest first
+	Years []usage   `json:"years"` // oldest first
+	Top   []bigFile `json:"top,omitempty"`
+}
+
+// diskUsage adds up everything under dests, or all of filed if dests is
+// empty.  Unlike the other queries it counts every file, not just the
+// ones with names we can parse, as they all take up space.  A file's
+// year is that of the year directory it is in.
+func diskUsage(config *Config, dests []string, top int) (*duReport, error) {
+	if len(dests) == 0 {
+		infos, err := ioutil.ReadDir(config.filed())
+		if err != nil {
+			return nil, errors.Wrap(err, "reading dests")
+		}
+		for _, fi := range infos {
+			if config.destEntry(fi) {
+				dests = append(dests, fi.Name())
+			}
+		}
+	}
+
+	byDest := map[string]*usage{}
+	byYear := map[string]*usage{}
+	add := func(m map[string]*usage, name string, size int64) {
+		u, ok := m[name]
+		if !ok {
+			u = &usage{Name: name}
+			m[name] = u
+		}
+		u.Files++
+		u.Bytes += size
+	}
+	r := &duReport{Total: usage{Name: "total"}}
+	for _, dest := range dests {
+		destDir := config.dest(dest)
+		if !isDir(destDir) {
+			return nil, errors.Errorf("there is no dest %q", dest)
+		}
+		err := walkDest(destDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(destDir, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			add(byDest, nestedDest(dest, rel), info.Size())
+			add(byYear, yearOf(rel), info.Size())
+			r.Total.Files++
+			r.Total.Bytes += info.Size()
+			if top > 0 {
+				r.Top = append(r.Top, bigFile{p, info.Size()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", destDir)
+		}
+	}
+
+	for _, u := range byDest {
+		r.Dests = append(r.Dests, *u)
+	}
+	sort.Slice(r.Dests, func(i, j int) bool {
+		if r.Dests[i].Bytes != r.Dests[j].Bytes {
+			return r.Dests[i].Bytes > r.Dests[j].Bytes
+		}
+		return r.Dests[i].Name < r.Dests[j].Name
+	})
+	for _, u := range byYear {
+		r.Years = append(r.Years, *u)
+	}
+	sort.Slice(r.Years, func(i, j int) bool { return r.Years[i].Name < r.Years[j].Name })
+	sort.SliceStable(r.Top, func(i, j int) bool { return r.Top[i].Bytes > r.Top[j].Bytes })
+	if len(r.Top) > top {
+		r.Top = r.Top[:top]
+	}
+	return r, nil
+}
+
+// yearOf returns the year of the year directory in rel, without any
+// rollover letter, or "none" for files outside of one.  Fiscal years
+// are given as they are, e.g. FY2015-16.
+func yearOf(rel string) string {
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if yearDir.MatchString(part) && strings.HasPrefix(part, "FY") {
+			return part
+		}
+		if yearDir.MatchString(part) {
+			return part[:4]
+		}
+	}
+	return "none"
+}
+
+// write prints the report as tables, with a bar for each row if chart
+// is set.
+func (r *duReport) write(w io.Writer, chart bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	table := func(title string, rows []usage) {
+		fmt.Fprintf(tw, "%s\tFILES\tSIZE\n", title)
+		for _, u := range rows {
+			fmt.Fprintf(tw, "%s\t%s\t%s", u.Name, formatCount(int64(u.Files)), formatBytes(u.Bytes))
+			if chart {
+				fmt.Fprintf(tw, "\t%s", bar(u.Bytes, rows))
+			}
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintln(tw)
+	}
+	table("DEST", r.Dests)
+	table("YEAR", r.Years)
+	fmt.Fprintf(tw, "TOTAL\t%s\t%s\n", formatCount(int64(r.Total.Files)), formatBytes(r.Total.Bytes))
+	if len(r.Top) != 0 {
+		fmt.Fprintf(tw, "\nLARGEST\t\tSIZE\n")
+		for _, f := range r.Top {
+			fmt.Fprintf(tw, "%s\t\t%s\n", f.Path, formatBytes(f.Bytes))
+		}
+	}
+	return tw.Flush()
+}
+
+// bar draws n as a share of the largest of rows.
+func bar(n int64, rows []usage) string {
+	var max int64
+	for _, u := range rows {
+		if u.Bytes > max {
+			max = u.Bytes
+		}
+	}
+	if max == 0 {
+		return ""
+	}
+	width := int(n * chartWidth / max)
+	if width == 0 && n > 0 {
+		width = 1
+	}
+	return strings.Repeat("#", width)
+}
+
+func doDu(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "du")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "du")
+	}
+	if ctx.Int(topFlag) < 0 {
+		return errors.Errorf("du: --%s must not be negative", topFlag)
+	}
+	r, err := diskUsage(config, ctx.Args().Slice(), ctx.Int(topFlag))
+	if err != nil {
+		return errors.Wrap(err, "du")
+	}
+	if ctx.String(outputFlag) == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return r.write(os.Stdout, ctx.Bool(chartFlag))
+}
+
+func duCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "du",
+		Usage:        "Show how much space the archive takes up, by dest and by year.",
+		ArgsUsage:    "[<dest>...]",
+		Action:       doDu,
+		BashComplete: completeDests,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  topFlag,
+				Usage: "Also list this many of the largest documents.",
+			},
+			&cli.BoolFlag{
+				Name:  chartFlag,
+				Usage: "Draw a bar for each dest and year.",
+			},
+		},
+	}
+}
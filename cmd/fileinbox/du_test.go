@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	start := []string{
+		"filed/att/2015/20150702_att.pdf",
+		"filed/att/2016/20160702_att.pdf",
+		"filed/att/2016b/20161202_att.pdf",
+		"filed/insurance/auto/2016/20160301_insurance.auto.pdf",
+		"filed/insurance/notes.txt",
+		"filed/empty/",
+		"inbox/20170101_att.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	big := path.Join(root, "filed/att/2015/20150101_att_big.pdf")
+	ok(t, ioutil.WriteFile(big, bytes.Repeat([]byte("x"), 1000), 0600))
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+
+	r, err := diskUsage(config, nil, 1)
+	ok(t, err)
+	attBytes := int64(1000 + 3*len("contents for 20150702_att.pdf"))
+	equals(t, usage{"att", 4, attBytes}, r.Dests[0])
+	equals(t, []string{"att", "insurance/auto", "insurance"}, []string{r.Dests[0].Name, r.Dests[1].Name, r.Dests[2].Name})
+	equals(t, 3, len(r.Dests))
+	equals(t, []string{"2015", "2016", "none"}, []string{r.Years[0].Name, r.Years[1].Name, r.Years[2].Name})
+	equals(t, 3, r.Years[1].Files)
+	equals(t, 6, r.Total.Files)
+	equals(t, []bigFile{{big, 1000}}, r.Top)
+
+	r, err = diskUsage(config, []string{"insurance"}, 0)
+	ok(t, err)
+	equals(t, 2, r.Total.Files)
+	assert(t, r.Top == nil, "expected no largest files without --top, got %v", r.Top)
+
+	var out bytes.Buffer
+	ok(t, r.write(&out, true))
+	assert(t, strings.Contains(out.String(), strings.Repeat("#", chartWidth)), "expected a full bar in\n%s", out.String())
+
+	_, err = diskUsage(config, []string{"nosuch"}, 0)
+	assert(t, err != nil, "expected an unknown dest to be rejected")
+}
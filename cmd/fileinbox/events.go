@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// What can happen to a file, as told to event subscribers.
+const (
+	eventDetected = "detected"
+	eventPlanned  = "planned"
+	eventFiled    = "filed"
+	eventFailed   = "failed"
+
+	// eventSummary ends the events of an --output jsonl run
+	eventSummary = "summary"
+)
+
+// ctlEvents subscribes to events over the control socket.  Rather than
+// a single response, the daemon sends a fileEvent per line until the
+// subscriber hangs up.
+const ctlEvents = "events"
+
+// eventBuffer is how many events a subscriber may fall behind by.
+// Beyond that, it misses events rather than holding up filing.
+const eventBuffer = 256
+
+// fileEvent is something that happened to a file in an inbox.
+type fileEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	File  string    `json:"file"`
+	To    string    `json:"to,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// eventBus hands events to whoever is subscribed, such as a web UI or a
+// notification bridge listening to the daemon.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan fileEvent]bool
+	stream *json.Encoder
+}
+
+// events is where filing publishes what it does.  With no subscribers,
+// publishing costs next to nothing.
+var events = &eventBus{}
+
+func (b *eventBus) subscribe() chan fileEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = map[chan fileEvent]bool{}
+	}
+	ch := make(chan fileEvent, eventBuffer)
+	b.subs[ch] = true
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan fileEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// streamTo writes every event to w as it is published, one JSON object
+// per line, for --output jsonl.  Unlike a subscriber, it never misses
+// one.  A nil w stops it.
+func (b *eventBus) streamTo(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stream = nil
+	if w != nil {
+		b.stream = json.NewEncoder(w)
+	}
+}
+
+func (b *eventBus) publish(event, file, to string, err error) {
+	e := fileEvent{Time: time.Now(), Event: event, File: file, To: to}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stream != nil {
+		b.stream.Encode(e)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// streamEvents sends events to conn until it hangs up.
+func streamEvents(conn net.Conn) {
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	gone := make(chan bool)
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(gone)
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case e := <-ch:
+			if enc.Encode(e) != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// watchEvents subscribes to the daemon listening on name, calling f
+// with each event until the daemon goes away.
+func watchEvents(name string, f func(fileEvent)) error {
+	conn, err := net.Dial("unix", name)
+	if err != nil {
+		return errors.Wrap(err, "is the daemon running?")
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(ctlRequest{ctlEvents}); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var e fileEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "reading events")
+		}
+		f(e)
+	}
+}
+
+func doCtlEvents(ctx *cli.Context) error {
+	name, err := socketPath(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ctl events")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	err = watchEvents(name, func(e fileEvent) {
+		if ctx.String(outputFlag) == outputJSON {
+			enc.Encode(e)
+			return
+		}
+		e.write()
+	})
+	return errors.Wrap(err, "ctl events")
+}
+
+func (e fileEvent) write() {
+	fmt.Printf("%s %-8s %s", e.Time.Format("15:04:05"), e.Event, e.File)
+	if e.To != "" {
+		fmt.Printf(" as %s", e.To)
+	}
+	if e.Error != "" {
+		fmt.Printf(": %s", e.Error)
+	}
+	fmt.Println()
+}
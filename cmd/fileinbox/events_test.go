@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/"})
+	inbox := path.Join(root, "inbox")
+	config := &Config{Root: root, ExtraInboxes: []string{inbox}}
+	config.Watch = WatchConfig{Backend: watchPoll, Interval: time.Hour}
+	ok(t, config.validate())
+
+	d := newDaemon(
+		func() (*Config, error) { return config, nil },
+		func() (fileResult, time.Duration, error) {
+			fr := fileResult{missingDirs: map[string]bool{}}
+			err := processInbox(inbox, config, config.parseOptions(false), false, false, &fr)
+			return fr, time.Millisecond, err
+		})
+	ok(t, d.watch(config))
+	sock := path.Join(root, "fileinbox.sock")
+	l, err := listenControl(sock)
+	ok(t, err)
+	defer l.Close()
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- d.serve(l, stop) }()
+
+	got := make(chan fileEvent, 10)
+	go watchEvents(sock, func(e fileEvent) { got <- e })
+	for subscribed := false; !subscribed; {
+		time.Sleep(10 * time.Millisecond)
+		events.mu.Lock()
+		subscribed = len(events.subs) != 0
+		events.mu.Unlock()
+	}
+
+	createFiles(t, root, []string{"inbox/20160825_pge.pdf", "inbox/notes.txt"})
+	_, err = callDaemon(sock, ctlRunNow)
+	ok(t, err)
+
+	want := map[string]fileEvent{
+		path.Join(inbox, "notes.txt"):        {Event: eventFailed},
+		path.Join(inbox, "20160825_pge.pdf"): {Event: eventFiled, To: path.Join(root, "filed/pge/2016/20160825_pge.pdf")},
+	}
+	seen := map[string]bool{}
+	for len(seen) < 4 {
+		select {
+		case e := <-got:
+			seen[e.Event] = true
+			if e.Event == eventDetected || e.Event == eventPlanned {
+				continue
+			}
+			w := want[e.File]
+			equals(t, w.Event, e.Event)
+			equals(t, w.To, e.To)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected detected, planned, filed and failed events, got %v", seen)
+		}
+	}
+
+	stop <- os.Interrupt
+	ok(t, <-served)
+}
+
+func TestStreamJSONL(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/20160825_pge.pdf", "inbox/notes.txt"})
+	inbox := path.Join(root, "inbox")
+	config := &Config{Root: root}
+	ok(t, config.validate())
+
+	var out bytes.Buffer
+	events.streamTo(&out)
+	defer events.streamTo(nil)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	assert(t, fr.summarizeJSONL(&out, time.Second, nil) != nil, "expected notes.txt to count as a failure")
+
+	var got []string
+	var last map[string]interface{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		last = nil
+		ok(t, json.Unmarshal(scanner.Bytes(), &last))
+		got = append(got, last["event"].(string))
+	}
+	equals(t, []string{eventDetected, eventFailed, eventPlanned, eventFiled, eventSummary}, got)
+	equals(t, float64(1), last["moved"])
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	exifTagDateTime          = 0x0132
+	exifTagExifIFD           = 0x8769
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+
+	exifTimeLayout = "2006:01:02 15:04:05"
+)
+
+var (
+	errNoExifDate = errors.New("no exif date found")
+	errNotJpeg    = errors.New("not a jpeg")
+)
+
+// exifDate returns the time a JPEG or HEIC photo was taken, as recorded
+// in its EXIF data.  We prefer DateTimeOriginal, then
+// DateTimeDigitized, then the plain DateTime tag.  EXIF doesn't say
+// which zone the camera was set to, so it is taken to be loc.
+func exifDate(name string, loc *time.Location) (time.Time, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	tiff, err := findExif(bufio.NewReader(f))
+	if err == errNotJpeg {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			tiff, err = scanExif(f)
+		}
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "reading exif from %q", name)
+	}
+	return parseTiffDate(tiff, loc)
+}
+
+// exifScanLimit is how far into a file scanExif looks.  HEIC keeps its
+// metadata ahead of the image data.
+const exifScanLimit = 4 << 20
+
+// scanExif finds the EXIF block in containers other than JPEG, such as
+// HEIC, by looking for its header, returning its TIFF payload.
+func scanExif(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, exifScanLimit))
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range []string{"II*\x00", "MM\x00*"} {
+		if i := bytes.Index(data, []byte("Exif\x00\x00"+order)); i >= 0 {
+			return data[i+6:], nil
+		}
+	}
+	return nil, errNoExifDate
+}
+
+// findExif walks the JPEG segments looking for the APP1 segment that
+// holds the EXIF data, returning its TIFF payload.
+func findExif(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errNotJpeg
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("corrupt jpeg segment")
+		}
+		// start of scan or end of image, there is no more metadata
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, errNoExifDate
+		}
+
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if size < 2 {
+			return nil, errors.New("corrupt jpeg segment length")
+		}
+		payload := make([]byte, size-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:], nil
+		}
+	}
+}
+
+func parseTiffDate(tiff []byte, loc *time.Location) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errNoExifDate
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errors.New("unknown exif byte order")
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if off, ok := ifd0[exifTagExifIFD]; ok {
+		exif := readIFD(tiff, order, order.Uint32(off))
+		for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTimeDigitized} {
+			if t, ok := exifTime(tiff, order, exif[tag], loc); ok {
+				return t, nil
+			}
+		}
+	}
+	if t, ok := exifTime(tiff, order, ifd0[exifTagDateTime], loc); ok {
+		return t, nil
+	}
+	return time.Time{}, errNoExifDate
+}
+
+// readIFD returns the raw 4 byte value field of every entry in the IFD
+// at offset, keyed by tag.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := map[uint16][]byte{}
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	for i := 0; i < count; i++ {
+		e := start + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[e:])] = tiff[e+8 : e+12]
+	}
+	return entries
+}
+
+func exifTime(tiff []byte, order binary.ByteOrder, value []byte, loc *time.Location) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+	off := int(order.Uint32(value))
+	if off+len(exifTimeLayout) > len(tiff) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, string(tiff[off:off+len(exifTimeLayout)]), loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	formatFlag string = "format"
+	yearFlag   string = "year"
+
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// exported is one row of an export.
+type exported struct {
+	Name   string `json:"name"`
+	Date   string `json:"date"` // e.g. 2016-08-25
+	Dest   string `json:"dest"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Path   string `json:"path"`
+}
+
+var exportHeader = []string{"name", "date", "dest", "size", "sha256", "path"}
+
+// exportDocs lists the documents filed under dests, or all of them if
+// dests is empty, limited to year unless it is zero.
+func exportDocs(config *Config, opts fileinbox.ParseOptions, dests []string, year int) ([]exported, error) {
+	if len(dests) == 0 {
+		infos, err := ioutil.ReadDir(config.filed())
+		if err != nil {
+			return nil, errors.Wrap(err, "reading dests")
+		}
+		for _, fi := range infos {
+			if config.destEntry(fi) {
+				dests = append(dests, fi.Name())
+			}
+		}
+	}
+
+	var rows []exported
+	for _, dest := range dests {
+		if !isDir(config.dest(dest)) {
+			return nil, errors.Errorf("there is no dest %q", dest)
+		}
+		docs, err := findFiled(config, opts, dest)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			if year != 0 && d.date.Year() != year {
+				continue
+			}
+			sum, err := hashFile(d.path)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, exported{
+				Name:   filepath.Base(d.path),
+				Date:   d.date.Format("2006-01-02"),
+				Dest:   d.dest,
+				Size:   d.size,
+				SHA256: sum,
+				Path:   d.path,
+			})
+		}
+	}
+	return rows, nil
+}
+
+// hashFile returns the hex SHA-256 of name's contents.
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "hashing %s", name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeExportCSV(w io.Writer, rows []exported) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := cw.Write([]string{r.Name, r.Date, r.Dest, strconv.FormatInt(r.Size, 10), r.SHA256, r.Path}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func doExport(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	format := ctx.String(formatFlag)
+	if format != formatCSV && format != formatJSON {
+		return errors.Errorf("export: unknown --%s %q.  We expect %s or %s", formatFlag, format, formatCSV, formatJSON)
+	}
+
+	var dests []string
+	for _, d := range ctx.StringSlice(destFlag) {
+		dests = append(dests, opts.ResolveDest(d))
+	}
+	rows, err := exportDocs(config, opts, dests, ctx.Int(yearFlag))
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	if format == formatJSON {
+		if rows == nil {
+			rows = []exported{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	return writeExportCSV(os.Stdout, rows)
+}
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "export",
+		Usage:  "List filed documents, with their dates, sizes and hashes, as CSV or JSON for spreadsheets and other tools.",
+		Action: doExport,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  formatFlag,
+				Value: formatCSV,
+				Usage: "One of csv or json.",
+			},
+			&cli.StringSliceFlag{
+				Name:  destFlag,
+				Usage: "Only export this dest.  May be repeated.",
+			},
+			&cli.IntFlag{
+				Name:  yearFlag,
+				Usage: "Only export documents dated in this year.",
+			},
+		},
+	}
+}
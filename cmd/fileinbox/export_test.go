@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	start := []string{
+		"filed/taxes/2022/20220415_taxes_federal.pdf",
+		"filed/taxes/2023/20230415_taxes_federal.pdf",
+		"filed/taxes/2023/notes.txt",
+		"filed/pge/2023/20230101_pge.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	opts := config.parseOptions(true)
+
+	rows, err := exportDocs(config, opts, []string{"taxes"}, 2023)
+	ok(t, err)
+	name := "20230415_taxes_federal.pdf"
+	sum := sha256.Sum256([]byte("contents for " + name))
+	equals(t, []exported{{
+		Name:   name,
+		Date:   "2023-04-15",
+		Dest:   "taxes",
+		Size:   int64(len("contents for " + name)),
+		SHA256: hex.EncodeToString(sum[:]),
+		Path:   path.Join(root, "filed/taxes/2023", name),
+	}}, rows)
+
+	rows, err = exportDocs(config, opts, nil, 0)
+	ok(t, err)
+	equals(t, 3, len(rows))
+
+	var out bytes.Buffer
+	ok(t, writeExportCSV(&out, rows))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	equals(t, 4, len(lines))
+	equals(t, "name,date,dest,size,sha256,path", lines[0])
+
+	_, err = exportDocs(config, opts, []string{"nosuch"}, 0)
+	assert(t, err != nil, "expected an unknown dest to be rejected")
+}
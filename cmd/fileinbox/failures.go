@@ -0,0 +1,78 @@
+package main
+
+import (
+	stderrors "errors"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// What went wrong, broadly, for a failure.  The more specific causes,
+// found by unwrapping the error, win over the step that failed.
+const (
+	failParse      = "parse"       // the name doesn't say where it goes
+	failContents   = "contents"    // the contents couldn't be checked
+	failQuarantine = "quarantine"  // it couldn't be moved to quarantine
+	failMissingDir = "missing-dir" // its dest doesn't exist
+	failFrozen     = "frozen"      // its dest is frozen
+	failAmbiguous  = "ambiguous"   // its date could be read with the day and month swapped
+	failOrganize   = "organize"    // its dest couldn't be organized
+	failPipeline   = "pipeline"    // a pipeline step failed
+	failFetch      = "fetch"       // a fetcher failed
+	failMail       = "mail"        // a message's attachments couldn't be taken
+	failFile       = "file"        // it couldn't be filed
+	failRun        = "run"         // the run as a whole stopped
+
+	failConflict   = "conflict"    // a different document has its name
+	failCCConflict = "cc-conflict" // a different document has its name in the CC mirror
+	failPermission = "permission"  // we aren't allowed
+	failNotFound   = "not-found"   // it, or where it was going, is gone
+	failNoSpace    = "no-space"    // the disk is full
+	failTransient  = "transient"   // e.g. a network share that went away
+)
+
+// failure is one thing that went wrong during a run, for the JSON
+// summary.
+type failure struct {
+	Path     string `json:"path"`
+	Category string `json:"category"`
+	Error    string `json:"error"`
+}
+
+// newFailure describes err, which happened to name during step.
+func newFailure(name, step string, err error) failure {
+	return failure{Path: name, Category: failCategory(step, err), Error: err.Error()}
+}
+
+// failCategory returns the cause of err if we recognize it, or else
+// step.
+func failCategory(step string, err error) string {
+	// our errors are wrapped with pkg/errors, the library's with %w
+	cause := errors.Cause(err)
+	switch {
+	case cause == nil:
+		return step
+	case stderrors.Is(cause, fileinbox.ErrConflict):
+		return failConflict
+	case stderrors.Is(cause, fileinbox.ErrCCConflict):
+		return failCCConflict
+	case stderrors.Is(cause, os.ErrPermission):
+		return failPermission
+	case stderrors.Is(cause, os.ErrNotExist):
+		return failNotFound
+	case stderrors.Is(cause, syscall.ENOSPC):
+		return failNoSpace
+	case fileinbox.IsTransient(cause):
+		return failTransient
+	}
+	return step
+}
+
+// fail counts a failure, and remembers it for the summary.
+func (fr *fileResult) fail(name, step string, err error) {
+	fr.failureCount++
+	fr.failures = append(fr.failures, newFailure(name, step, err))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestFailCategory(t *testing.T) {
+	_, notFound := os.Stat("/no/such/file")
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{errors.New("no idea"), failFile},
+		{errors.Wrap(notFound, "filing"), failNotFound},
+		{fmt.Errorf("moving: %w", &os.PathError{Op: "rename", Path: "x", Err: syscall.EACCES}), failPermission},
+		{errors.Wrap(fmt.Errorf("x: %w", syscall.ENOSPC), "filing"), failNoSpace},
+		{fmt.Errorf("x: %w", syscall.ESTALE), failTransient},
+		{fmt.Errorf("x: %w", fileinbox.ErrConflict), failConflict},
+	} {
+		equals(t, tc.want, failCategory(failFile, tc.err))
+	}
+}
+
+func TestFailuresInJSON(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/notadate.pdf", "inbox/20160825_gas.pdf"})
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+
+	var out bytes.Buffer
+	assert(t, fr.summarizeJSON(&out, 0, nil) != nil, "expected the failures to be reported")
+	var s jsonSummary
+	ok(t, json.Unmarshal(out.Bytes(), &s))
+	equals(t, 2, len(s.Failed))
+	equals(t, failure{path.Join(root, "inbox/notadate.pdf"), failParse, s.Failed[0].Error}, s.Failed[0])
+	equals(t, path.Join(root, "filed/gas"), s.Failed[1].Path)
+	equals(t, failMissingDir, s.Failed[1].Category)
+}
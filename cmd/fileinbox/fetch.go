@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const defaultFetchTimeout = 10 * time.Minute
+
+// scheduleFetch is the command a Fetcher's When runs.
+const scheduleFetch = "fetch"
+
+// Fetcher is an external program that downloads statements from a
+// vendor's portal, so they go from the portal to the archive without
+// anyone saving them by hand.  Command is run with {dir} replaced by an
+// empty directory to download into, and each file it leaves there must
+// have a name we can file, such as 20240825_pge_bill.pdf.  Once it
+// exits successfully they are moved to the inbox and filed.  What it
+// prints is shown, prefixed with its name.  When, a schedule as for
+// Schedule, has the daemon run it.
+//
+//	fetchers:
+//	- name: pge
+//	  command: [pge-statements, --since, 30d, --out, "{dir}"]
+//	  when: "0 6 * * *"
+type Fetcher struct {
+	Name    string
+	Command []string
+	When    string
+	Timeout time.Duration // 10 minutes if not set
+
+	schedule *Schedule
+}
+
+func (f *Fetcher) validate() error {
+	if f.Name == "" || strings.ContainsAny(f.Name, `/\`) || f.Name == "." || f.Name == ".." {
+		return errors.Errorf("bad fetcher name %q", f.Name)
+	}
+	if len(f.Command) == 0 {
+		return errors.Errorf("fetcher %q has no command", f.Name)
+	}
+	f.schedule = nil
+	if f.When != "" {
+		s := Schedule{When: f.When, Run: []string{scheduleFetch, f.Name}}
+		if err := s.compile(); err != nil {
+			return errors.Wrapf(err, "fetcher %q", f.Name)
+		}
+		f.schedule = &s
+	}
+	return nil
+}
+
+// validateFetchers checks the fetchers, and that each has its own name.
+func (c *Config) validateFetchers() error {
+	seen := map[string]bool{}
+	for i := range c.Fetchers {
+		f := &c.Fetchers[i]
+		if err := f.validate(); err != nil {
+			return err
+		}
+		if seen[f.Name] {
+			return errors.Errorf("there is more than one fetcher named %q", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// schedules returns the Schedules, along with those of the fetchers.
+func (c *Config) schedules() []Schedule {
+	all := append([]Schedule(nil), c.Schedules...)
+	for _, f := range c.Fetchers {
+		if f.schedule != nil {
+			all = append(all, *f.schedule)
+		}
+	}
+	return all
+}
+
+// fetch runs the fetcher, downloading into dir.
+func (f Fetcher) fetch(dir string) error {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := make([]string, len(f.Command))
+	for i, a := range f.Command {
+		args[i] = strings.ReplaceAll(a, "{dir}", dir)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	lines := bufio.NewScanner(&output)
+	for lines.Scan() {
+		printf(progress, stylePlain, "[%s] %s\n", f.Name, lines.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("fetcher %q took longer than %s", f.Name, timeout)
+	}
+	return errors.Wrapf(err, "running fetcher %q", f.Name)
+}
+
+// fetchAndFile runs the fetchers, moves what they downloaded to the
+// inbox, and files it.  A fetcher that fails, or a download we can't
+// file, is counted as a failure and the rest go ahead.  Downloads with
+// names we can't file are left in the run's workspace.
+func fetchAndFile(config *Config, opts fileinbox.ParseOptions, fetchers []Fetcher, fr *fileResult) error {
+	inbox := config.inbox()
+	var fetched []os.FileInfo
+	for _, f := range fetchers {
+		dir, err := fr.work.sub(path.Join("fetch", f.Name))
+		if err != nil {
+			return err
+		}
+		if fr.work == nil {
+			defer os.RemoveAll(dir)
+		}
+		if err := f.fetch(dir); err != nil {
+			printf(progress, styleFailure, "%v\n", err)
+			fr.fail(f.Name, failFetch, err)
+			continue
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range infos {
+			from := path.Join(dir, fi.Name())
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			if _, err := parseFileName(opts, fi.Name()); err != nil {
+				printf(progress, styleFailure, "Fetcher %s downloaded %q, which we can't file: %v\n", f.Name, fi.Name(), err)
+				fr.fail(from, failParse, err)
+				continue
+			}
+			to := path.Join(inbox, fi.Name())
+			if _, err := os.Lstat(to); err == nil {
+				same, err := fileinbox.SameContents(from, to)
+				if err != nil {
+					return err
+				}
+				if !same {
+					err = errors.Errorf("fetcher %s downloaded %s, and a different %s is already in the inbox", f.Name, fi.Name(), to)
+					printf(progress, styleFailure, "%v\n", err)
+					fr.fail(from, failFetch, err)
+					continue
+				}
+				// already waiting to be filed
+				os.Remove(from)
+				continue
+			}
+			if _, err := fileinbox.MoveFile(from, to); err != nil {
+				return errors.Wrapf(err, "moving %s to the inbox", from)
+			}
+			printf(progress, stylePlain, "Fetched %s\n", to)
+			moved, err := os.Stat(to)
+			if err != nil {
+				return err
+			}
+			fetched = append(fetched, moved)
+		}
+	}
+	if len(fetched) == 0 {
+		return nil
+	}
+	// fetchers write the date year first, whatever the inbox's date
+	// order
+	inboxOpts := config.inboxOptions(opts, inbox)
+	inboxOpts.DateOrder = fileinbox.DateOrderYMD
+	return processChunk(inbox, fetched, nil, config, opts, inboxOpts, false, false, fr)
+}
+
+// pickFetchers returns the fetchers called names, or all of them if
+// names is empty.
+func pickFetchers(config *Config, names []string) ([]Fetcher, error) {
+	if len(names) == 0 {
+		if len(config.Fetchers) == 0 {
+			return nil, errors.New("there are no fetchers in the config")
+		}
+		return config.Fetchers, nil
+	}
+	var picked []Fetcher
+	for _, name := range names {
+		found := false
+		for _, f := range config.Fetchers {
+			if f.Name == name {
+				picked = append(picked, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("there is no fetcher %q", name)
+		}
+	}
+	return picked, nil
+}
+
+func doFetchStatements(ctx *cli.Context) error {
+	start := time.Now()
+	fr := fileResult{missingDirs: map[string]bool{}}
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	fetchers, err := pickFetchers(config, ctx.Args().Slice())
+	if err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	startRun(ctx.String(runLabelFlag))
+	if fr.work, err = newWorkspace(config); err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	err = fetchAndFile(config, opts, fetchers, &fr)
+	fr.work.finish(&fr, err)
+	if err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	return fr.summarize(time.Since(start))
+}
+
+func fetchCommand() *cli.Command {
+	return &cli.Command{
+		Name:      scheduleFetch,
+		Usage:     "Run the fetchers, or those named, to download statements into the inbox, and file them.",
+		ArgsUsage: "[fetcher...]",
+		Action:    doFetchStatements,
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// testFetcher downloads a statement we can file, and one we can't.
+const testFetcher = "#!/bin/sh\necho logging in\nprintf 'contents for 20240801_pge.pdf' > \"$1/20240801_pge.pdf\"\nprintf 'who knows' > \"$1/statement.pdf\"\n"
+
+func TestFetch(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"inbox/", "filed/pge/"})
+	script := path.Join(root, "fetch.sh")
+	ok(t, ioutil.WriteFile(script, []byte(testFetcher), 0755))
+	config := &Config{Root: root, Fetchers: []Fetcher{
+		{Name: "pge", Command: []string{"/bin/sh", script, "{dir}"}, When: "0 6 * * *"},
+		{Name: "broken", Command: []string{"/bin/sh", "-c", "echo portal is down; exit 1"}},
+	}}
+	ok(t, config.validate())
+	equals(t, []string{scheduleFetch, "pge"}, config.schedules()[0].Run)
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	fr.work, err = newWorkspace(config)
+	ok(t, err)
+	ok(t, fetchAndFile(config, config.parseOptions(false), config.Fetchers, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, []string{"2024/", "2024/20240801_pge.pdf"}, readFiles(t, path.Join(root, "filed/pge")))
+	equals(t, []string(nil), readFiles(t, path.Join(root, "inbox")))
+	equals(t, uint32(2), fr.failureCount)
+	equals(t, failParse, fr.failures[0].Category)
+	equals(t, failFetch, fr.failures[1].Category)
+	// what we couldn't file is kept for a look
+	_, err = os.Stat(path.Join(fr.work.dir, "fetch/pge/statement.pdf"))
+	ok(t, err)
+
+	_, err = pickFetchers(config, []string{"chase"})
+	assert(t, err != nil, "expected an unknown fetcher to be refused")
+	for _, f := range []Fetcher{{Name: "pge"}, {Command: []string{"true"}}, {Name: "pge", Command: []string{"true"}, When: "someday"}} {
+		config.Fetchers = []Fetcher{f}
+		assert(t, config.validate() != nil, "expected %+v to be rejected", f)
+	}
+	config.Fetchers = []Fetcher{{Name: "pge", Command: []string{"true"}}, {Name: "pge", Command: []string{"true"}}}
+	assert(t, config.validate() != nil, "expected two fetchers with the same name to be rejected")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// What to do with folders dropped into an inbox, see Config.Folders.
+const (
+	foldersLeave = "leave"
+	foldersFile  = "file"
+)
+
+// filesFolder returns true if fi, in inbox, is a folder whose contents
+// we file.  Hotfolders, such as Downloads, are full of folders that
+// were never meant for us, so theirs are always left be.
+func (c *Config) filesFolder(inbox string, fi os.FileInfo) bool {
+	return c.Folders == foldersFile && fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") && c.hotfolder(inbox) == nil
+}
+
+// processFolder files everything in folder, and in the folders within
+// it, as if it were in the inbox whose inboxOpts we are given.  The
+// folder itself is removed once it is empty.
+func processFolder(folder string, config *Config, opts, inboxOpts fileinbox.ParseOptions, force, dryRun bool, fr *fileResult) error {
+	infos, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to dir %q", folder)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	if err := processChunk(folder, infos, nil, config, opts, inboxOpts, force, dryRun, fr); err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if config.filesFolder(folder, fi) {
+			if err := processFolder(path.Join(folder, fi.Name()), config, opts, inboxOpts, force, dryRun, fr); err != nil {
+				return err
+			}
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	// anything we couldn't file keeps the folder around
+	if left, err := ioutil.ReadDir(folder); err == nil && len(left) == 0 {
+		if err := os.Remove(folder); err != nil {
+			return errors.Wrapf(err, "removing the emptied %s", folder)
+		}
+		printf(progress, stylePlain, "Removed the emptied folder %s\n", folder)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestFolders(t *testing.T) {
+	start := []string{
+		"filed/pge/",
+		"inbox/pge-2019/20190105_pge.pdf",
+		"inbox/pge-2019/20190205_pge.pdf",
+		"inbox/pge-2019/q2/20190405_pge.pdf",
+		"inbox/old/20190105_pge_notes.txt",
+		"inbox/old/readme.txt",
+	}
+	expected := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2019/",
+		"filed/pge/2019/20190105_pge.pdf",
+		"filed/pge/2019/20190105_pge_notes.txt",
+		"filed/pge/2019/20190205_pge.pdf",
+		"filed/pge/2019/20190405_pge.pdf",
+		"inbox/",
+		"inbox/old/",
+		"inbox/old/readme.txt",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root, Folders: foldersFile}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, true, &fr))
+	equals(t, 4, len(fr.plan))
+
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(4), fr.okCount)
+	equals(t, uint32(1), fr.failureCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, expected, found)
+
+	config.Folders = "flatten"
+	assert(t, config.validate() != nil, "Expected an unknown folders policy to be rejected")
+}
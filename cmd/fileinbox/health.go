@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const httpFlag string = "http"
+
+// healthTimeout is how long a probe waits on the daemon before deciding
+// it is stuck.
+const healthTimeout = 5 * time.Second
+
+// health is what /healthz and /readyz answer, with 200 when OK and 503
+// when not.
+type health struct {
+	OK bool `json:"ok"`
+	// Error says why the daemon isn't answering, Config why the config
+	// can't be loaded and Root why the root can't be reached.
+	Error       string     `json:"error,omitempty"`
+	Config      string     `json:"config,omitempty"`
+	Root        string     `json:"root,omitempty"`
+	LastRun     *runStatus `json:"lastRun,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// currentStatus asks the serve loop for the status, which also shows
+// that it is still answering.
+func (d *daemon) currentStatus() (*daemonStatus, error) {
+	c := ctlCall{ctlRequest{Command: ctlStatus}, make(chan ctlResponse, 1)}
+	select {
+	case d.requests <- c:
+	case <-time.After(healthTimeout):
+		return nil, errors.Errorf("the daemon has not answered in %s", healthTimeout)
+	}
+	return (<-c.reply).Status, nil
+}
+
+// check reports on the daemon.  Being alive only needs the daemon to
+// answer, while being ready also needs a config that loads and a root
+// we can reach.
+func (d *daemon) check(ready bool) health {
+	s, err := d.currentStatus()
+	if err != nil {
+		return health{Error: err.Error()}
+	}
+	h := health{OK: true, LastRun: s.LastRun, LastSuccess: s.LastSuccess}
+	if !ready {
+		return h
+	}
+	config, err := d.load()
+	if err != nil {
+		h.OK = false
+		h.Config = err.Error()
+		return h
+	}
+	if err := checkRoot(config.Root); err != nil {
+		h.OK = false
+		h.Root = err.Error()
+	}
+	return h
+}
+
+// healthHandler serves /healthz, for whether the daemon is alive, and
+// /readyz, for whether it can file, to container orchestrators and
+// uptime monitors.
+func (d *daemon) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	probe := func(ready bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h := d.check(ready)
+			w.Header().Set("Content-Type", "application/json")
+			if !h.OK {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(h)
+		}
+	}
+	mux.HandleFunc("/healthz", probe(false))
+	mux.HandleFunc("/readyz", probe(true))
+	return mux
+}
+
+// serveHealth serves the probes on addr, such as :8080, until the
+// returned server is closed.
+func (d *daemon) serveHealth(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: d.healthHandler()}
+	go srv.Serve(l)
+	return srv, nil
+}
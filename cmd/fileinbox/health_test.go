@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"inbox/", "filed/"})
+	config := &Config{Root: root, ExtraInboxes: []string{path.Join(root, "inbox")}}
+	config.Watch = WatchConfig{Backend: watchPoll, Interval: time.Hour}
+	ok(t, config.validate())
+
+	var loadErr error
+	d := newDaemon(
+		func() (*Config, error) { return config, loadErr },
+		func() (fileResult, time.Duration, error) { return fileResult{okCount: 1}, time.Millisecond, nil })
+	ok(t, d.watch(config))
+	l, err := listenControl(path.Join(root, "fileinbox.sock"))
+	ok(t, err)
+	defer l.Close()
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- d.serve(l, stop) }()
+
+	probe := func(url string) (int, health) {
+		w := httptest.NewRecorder()
+		d.healthHandler().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		var h health
+		ok(t, json.Unmarshal(w.Body.Bytes(), &h))
+		return w.Code, h
+	}
+	// the first run is under way as soon as we serve
+	for i := 0; ; i++ {
+		_, h := probe("/healthz")
+		if h.LastSuccess != nil {
+			break
+		}
+		assert(t, i < 100, "expected the first run to finish")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, h := probe("/readyz")
+	equals(t, http.StatusOK, code)
+	equals(t, uint32(1), h.LastRun.Filed)
+
+	loadErr = errors.New("bad yaml")
+	code, h = probe("/readyz")
+	equals(t, http.StatusServiceUnavailable, code)
+	equals(t, "bad yaml", h.Config)
+	code, _ = probe("/healthz")
+	equals(t, http.StatusOK, code)
+
+	loadErr = nil
+	config.Root = path.Join(root, "gone")
+	code, h = probe("/readyz")
+	equals(t, http.StatusServiceUnavailable, code)
+	assert(t, h.Root != "", "expected the missing root to be reported")
+
+	stop <- os.Interrupt
+	ok(t, <-served)
+}
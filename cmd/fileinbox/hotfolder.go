@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const globFlag string = "glob"
+
+// Hotfolder is a directory documents get saved to by mistake, such as
+// Downloads, the Desktop or a scanner's share.  Each run sweeps it like
+// an inbox, but only takes files that match Globs, if set, and whose
+// names say where they go.  Everything else is left alone, without
+// complaint, as it was never meant for us.  e.g.
+//
+//	hotfolders:
+//	- dir: /home/me/Downloads
+//	  globs: ["*.pdf"]
+type Hotfolder struct {
+	Dir   string
+	Globs []string
+}
+
+func (h *Hotfolder) validate() error {
+	if !path.IsAbs(h.Dir) {
+		return errors.Errorf("hotfolder %q must be an absolute path", h.Dir)
+	}
+	for _, g := range h.Globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return errors.Wrapf(err, "hotfolder %s has a bad glob %q", h.Dir, g)
+		}
+	}
+	return nil
+}
+
+// takes returns true if fi, in h, should be looked at.
+func (h *Hotfolder) takes(fi os.FileInfo) bool {
+	if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+		return false
+	}
+	if len(h.Globs) == 0 {
+		return true
+	}
+	for _, g := range h.Globs {
+		if ok, _ := filepath.Match(g, fi.Name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hotfolder returns the hotfolder at dir, or nil if it isn't one.
+func (c *Config) hotfolder(dir string) *Hotfolder {
+	for i := range c.Hotfolders {
+		if path.Clean(c.Hotfolders[i].Dir) == path.Clean(dir) {
+			return &c.Hotfolders[i]
+		}
+	}
+	return nil
+}
+
+// sweepHotfolders files what belongs to us in each hotfolder.  One that
+// isn't there, such as an unmounted share, is skipped.
+func sweepHotfolders(config *Config, force, dryRun bool, fr *fileResult) error {
+	opts := config.parseOptions(force)
+	for _, h := range config.Hotfolders {
+		if !isDir(h.Dir) {
+			printf(progress, styleNotice, "Skipping the hotfolder %s, which isn't there\n", h.Dir)
+			continue
+		}
+		if err := processInbox(h.Dir, config, opts, force, dryRun, fr); err != nil {
+			return errors.Wrapf(err, "sweeping %s", h.Dir)
+		}
+	}
+	return nil
+}
+
+func doHotfolderAdd(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		return errors.New("hotfolder add: name the directory to sweep")
+	}
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "hotfolder add")
+	}
+	dir, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "hotfolder add")
+	}
+	if !isDir(dir) {
+		return errors.Errorf("hotfolder add: %s is not a directory", dir)
+	}
+	if config.hotfolder(dir) == nil && hasString(config.inboxes(), dir) {
+		return errors.Errorf("hotfolder add: %s is already an inbox", dir)
+	}
+	h := Hotfolder{Dir: dir, Globs: ctx.StringSlice(globFlag)}
+	if err := h.validate(); err != nil {
+		return errors.Wrap(err, "hotfolder add")
+	}
+	err = config.update(func(c *Config) {
+		for i := range c.Hotfolders {
+			if path.Clean(c.Hotfolders[i].Dir) == dir {
+				c.Hotfolders[i] = h
+				return
+			}
+		}
+		c.Hotfolders = append(c.Hotfolders, h)
+	})
+	if err != nil {
+		return errors.Wrap(err, "hotfolder add")
+	}
+	printf(progress, styleSuccess, "Each run now sweeps %s\n", h.describe())
+	return nil
+}
+
+func doHotfolderRemove(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		return errors.New("hotfolder remove: name the directory to stop sweeping")
+	}
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "hotfolder remove")
+	}
+	dir, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "hotfolder remove")
+	}
+	if config.hotfolder(dir) == nil {
+		return errors.Errorf("hotfolder remove: %s is not a hotfolder", dir)
+	}
+	err = config.update(func(c *Config) {
+		var kept []Hotfolder
+		for _, h := range c.Hotfolders {
+			if path.Clean(h.Dir) != dir {
+				kept = append(kept, h)
+			}
+		}
+		c.Hotfolders = kept
+	})
+	if err != nil {
+		return errors.Wrap(err, "hotfolder remove")
+	}
+	printf(progress, styleSuccess, "No longer sweeping %s\n", dir)
+	return nil
+}
+
+func doHotfolderList(ctx *cli.Context) error {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "hotfolder list")
+	}
+	for _, h := range config.Hotfolders {
+		fmt.Println(h.describe())
+	}
+	return nil
+}
+
+func (h *Hotfolder) describe() string {
+	if len(h.Globs) == 0 {
+		return h.Dir
+	}
+	return fmt.Sprintf("%s, taking %s", h.Dir, strings.Join(h.Globs, " "))
+}
+
+func hotfolderCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "hotfolder",
+		Usage: "Sweep directories such as Downloads for documents saved there by mistake.",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "add",
+				Usage:     "Sweep a directory each run, taking the files whose names say where they go.",
+				ArgsUsage: "<dir>",
+				Action:    doHotfolderAdd,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  globFlag,
+						Usage: "Only take files matching this, e.g. *.pdf.  May be repeated.",
+					},
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "Stop sweeping a directory.",
+				ArgsUsage: "<dir>",
+				Action:    doHotfolderRemove,
+			},
+			{
+				Name:   "list",
+				Usage:  "Show the directories each run sweeps.",
+				Action: doHotfolderList,
+			},
+		},
+	}
+}
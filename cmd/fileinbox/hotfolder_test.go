@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestHotfolders(t *testing.T) {
+	start := []string{
+		"root/filed/pge/",
+		"root/inbox/",
+		"Downloads/20160825_pge.pdf",
+		"Downloads/20160826_pge.txt",
+		"Downloads/vacation.pdf",
+		"Downloads/20160827_pge.pdf/",
+	}
+	expectedDownloads := []string{
+		"20160826_pge.txt",
+		"20160827_pge.pdf/",
+		"vacation.pdf",
+	}
+	expectedRoot := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160825_pge.pdf",
+		"inbox/",
+	}
+
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	createFiles(t, dir, start)
+	configDir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(configDir)
+	defer func() { configFile = "" }()
+	configFile = path.Join(configDir, "fileinbox.yaml")
+	ok(t, ioutil.WriteFile(configFile, []byte("root: "+path.Join(dir, "root")+"\n"), 0600))
+
+	downloads := path.Join(dir, "Downloads")
+	ok(t, newCli().Run([]string{"fileinbox", "hotfolder", "add", "--glob", "*.pdf", downloads}))
+	assert(t, newCli().Run([]string{"fileinbox", "hotfolder", "add", path.Join(dir, "Desktop")}) != nil,
+		"expected a directory that isn't there to be refused")
+	config := &Config{persist: true}
+	ok(t, config.read())
+	ok(t, config.validate())
+	equals(t, []Hotfolder{{Dir: downloads, Globs: []string{"*.pdf"}}}, config.Hotfolders)
+	assert(t, hasString(config.inboxes(), downloads), "expected a hotfolder to count as an inbox")
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, sweepHotfolders(config, false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+
+	found := readFiles(t, downloads)
+	sort.Strings(found)
+	equals(t, expectedDownloads, found)
+	found = readFiles(t, path.Join(dir, "root"))
+	sort.Strings(found)
+	equals(t, expectedRoot, found)
+
+	ok(t, newCli().Run([]string{"fileinbox", "hotfolder", "remove", downloads}))
+	config = &Config{persist: true}
+	ok(t, config.read())
+	equals(t, 0, len(config.Hotfolders))
+}
+
+func TestMissingInbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	createFiles(t, dir, []string{"root/filed/pge/", "root/inbox/20160825_pge.pdf"})
+	root, scanner := path.Join(dir, "root"), path.Join(dir, "scanner")
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	skip, err := missingInbox(config, scanner, false, false)
+	ok(t, err)
+	assert(t, !skip, "expected a required inbox to be left for processInbox to complain about")
+	skip, err = missingInbox(config, scanner, true, true)
+	ok(t, err)
+	assert(t, skip && !isDir(scanner), "expected a dry run not to create the inbox")
+	skip, err = missingInbox(config, scanner, true, false)
+	ok(t, err)
+	assert(t, !skip && isDir(scanner), "expected the missing inbox to be created")
+	ok(t, os.Remove(scanner))
+
+	configDir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(configDir)
+	defer func() { configFile = "" }()
+	configFile = path.Join(configDir, "fileinbox.yaml")
+	yaml := "root: " + root + "\nextrainboxes: [" + path.Join(root, "inbox") + ", " + scanner + "]\n" +
+		"inboxes:\n  scanner:\n    optional: true\n"
+	ok(t, ioutil.WriteFile(configFile, []byte(yaml), 0600))
+	ok(t, newCli().Run([]string{"fileinbox"}))
+	_, err = os.Stat(path.Join(root, "filed/pge/2016/20160825_pge.pdf"))
+	ok(t, err)
+	assert(t, !isDir(scanner), "expected the optional inbox to be left missing")
+}
@@ -0,0 +1,33 @@
+package main
+
+import "path/filepath"
+
+// temporaryGlobs match what browsers, sync clients and office suites
+// leave in a folder while a file is still being written, or after
+// they gave up on it.
+var temporaryGlobs = []string{
+	"*.partial",    // Firefox, and some sync clients
+	"*.part",       // Firefox, wget
+	"*.crdownload", // Chrome
+	"*.download",   // Safari
+	"*.tmp",        // Syncthing, and many others
+	"~$*",          // Microsoft Office
+	".~lock.*#",    // LibreOffice
+	".*.swp",       // vim
+}
+
+// shownIgnored is how many ignored files the summary lists by name.
+const shownIgnored = 10
+
+// ignored returns true if name is a temporary file, or matches one of
+// the config's Ignore globs, and so isn't filed.
+func (c *Config) ignored(name string) bool {
+	for _, globs := range [][]string{temporaryGlobs, c.Ignore} {
+		for _, g := range globs {
+			if ok, _ := filepath.Match(g, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// setImmutable turns the immutable flag on name on or off, using chattr
+// on Linux and chflags on macOS and the BSDs.
+func setImmutable(name string, on bool) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		flag := "-i"
+		if on {
+			flag = "+i"
+		}
+		cmd = exec.Command("chattr", flag, name)
+	case "darwin", "freebsd", "netbsd", "openbsd", "dragonfly":
+		flag := "nouchg"
+		if on {
+			flag = "uchg"
+		}
+		cmd = exec.Command("chflags", flag, name)
+	default:
+		return errors.Errorf("immutable files are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%s: %s", strings.Join(cmd.Args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// immutability applies Config.Immutable while we file: documents are
+// locked once filed, as are the directories for years before this one.
+// Only what we unlock or create is locked again afterwards, so a run
+// costs what it files, not the size of the archive.
+type immutability struct {
+	enabled bool
+	// mu guards lifted, as dests are organized at the same time
+	mu     sync.Mutex
+	lifted map[string]string // directories we unlocked or may create, to their dest directory
+}
+
+func newImmutability(enabled bool) *immutability {
+	return &immutability{
+		enabled: enabled,
+		lifted:  map[string]string{},
+	}
+}
+
+// unlock lifts the flag from every directory from destDir down to dir,
+// so a file can be added to dir.  Call it before creating any of them,
+// as a new month directory can only go in a year directory that has
+// been unlocked.
+func (im *immutability) unlock(destDir, dir string) error {
+	if !im.enabled {
+		return nil
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	cur := destDir
+	for _, part := range strings.Split(strings.TrimPrefix(dir, destDir), "/") {
+		if part == "" {
+			continue
+		}
+		cur = path.Join(cur, part)
+		if _, ok := im.lifted[cur]; ok {
+			continue
+		}
+		// directories that don't exist yet are remembered, so restore
+		// locks them once they have been created
+		if isDir(cur) {
+			if err := setImmutable(cur, false); err != nil {
+				return err
+			}
+		}
+		im.lifted[cur] = destDir
+	}
+	return nil
+}
+
+// lock locks a newly filed document.
+func (im *immutability) lock(name string) error {
+	if !im.enabled {
+		return nil
+	}
+	return setImmutable(name, true)
+}
+
+// restore locks the prior year directories we unlocked or created.
+func (im *immutability) restore() error {
+	if !im.enabled {
+		return nil
+	}
+	thisYear := clock.Now().Year()
+	for dir, destDir := range im.lifted {
+		rel, err := filepath.Rel(destDir, dir)
+		if err != nil {
+			return err
+		}
+		if !isDir(dir) || !priorYear(rel, thisYear) {
+			continue
+		}
+		if err := setImmutable(dir, true); err != nil {
+			return err
+		}
+	}
+	im.lifted = map[string]string{}
+	return nil
+}
+
+// lockDest applies the immutable policy to everything under destDir.
+// Files directly in destDir are waiting to be organized, so they are
+// left alone.  With lift set, the flag is removed from everything
+// instead.
+func lockDest(destDir string, lift bool) error {
+	thisYear := clock.Now().Year()
+	walkFunc := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == destDir {
+			return nil
+		}
+		rel, err := filepath.Rel(destDir, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if lift {
+				return setImmutable(p, false)
+			}
+			if priorYear(rel, thisYear) {
+				return setImmutable(p, true)
+			}
+			return nil
+		}
+		if !strings.Contains(rel, "/") {
+			return nil
+		}
+		return setImmutable(p, !lift)
+	}
+	return walkDest(destDir, walkFunc)
+}
+
+// priorYear returns true if rel, a path relative to a dest, is for a year
+// before thisYear.  A fiscal year counts as the year it ends in.
+func priorYear(rel string, thisYear int) bool {
+	if strings.HasPrefix(rel, "FY") && len(rel) >= len("FY2015-16") {
+		y, err := strconv.Atoi(rel[2:6])
+		return err == nil && y+1 < thisYear
+	}
+	if len(rel) < 4 {
+		return false
+	}
+	y, err := strconv.Atoi(rel[:4])
+	return err == nil && y < thisYear
+}
+
+func immutableCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "immutable",
+		Usage: "Lift or restore the immutable flags on filed documents, e.g. to restructure them.",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "lift",
+				Usage:     "Remove the immutable flags from the dests, or from all of them.",
+				ArgsUsage: "[dest...]",
+				Action:    func(ctx *cli.Context) error { return doImmutable(ctx, true) },
+			},
+			{
+				Name:      "restore",
+				Usage:     "Put the immutable flags back on the dests, or on all of them.",
+				ArgsUsage: "[dest...]",
+				Action:    func(ctx *cli.Context) error { return doImmutable(ctx, false) },
+			},
+		},
+	}
+}
+
+func doImmutable(ctx *cli.Context, lift bool) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "immutable")
+	}
+
+	var dests []string
+	for _, d := range ctx.Args().Slice() {
+		dests = append(dests, opts.ResolveDest(d))
+	}
+	if len(dests) == 0 {
+		children, err := ioutil.ReadDir(config.filed())
+		if err != nil {
+			return errors.Wrap(err, "immutable")
+		}
+		for _, c := range children {
+			if config.destEntry(c) {
+				dests = append(dests, c.Name())
+			}
+		}
+	}
+
+	for _, d := range dests {
+		if err := lockDest(config.dest(d), lift); err != nil {
+			return errors.Wrapf(err, "updating %s", d)
+		}
+		fmt.Fprintf(progress, "Updated %s\n", config.dest(d))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestPriorYear(t *testing.T) {
+	for rel, want := range map[string]bool{
+		"2015":         true,
+		"2015b":        true,
+		"2015/07":      true,
+		"2016":         false,
+		"2017":         false,
+		"misc":         false,
+		"201":          false,
+		"2015/07/a.pd": true,
+		"FY2014-15":    true,
+		"FY2015-16":    false,
+	} {
+		equals(t, want, priorYear(rel, 2016))
+	}
+}
+
+// Filing into a locked prior year, where the file rolls over into a new
+// month directory.  This needs chattr or chflags and the privileges to
+// use them, so it is skipped where they aren't available.
+func TestImmutableRollover(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		lockDest(path.Join(root, "filed", "pge"), true)
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	probe := path.Join(root, "probe")
+	ok(t, ioutil.WriteFile(probe, nil, 0600))
+	if err := setImmutable(probe, true); err != nil {
+		t.Skipf("immutable flags aren't available here: %v", err)
+	}
+	ok(t, setImmutable(probe, false))
+	ok(t, os.Remove(probe))
+
+	defer func() { clock = fileinbox.SystemClock }()
+	clock = fileinbox.FixedClock(time.Date(2016, 8, 25, 12, 0, 0, 0, time.Local))
+	createFiles(t, root, []string{
+		"filed/pge/2015/20150101_pge.pdf",
+		"inbox/20150301_pge.pdf",
+	})
+	ok(t, lockDest(path.Join(root, "filed", "pge"), false))
+
+	config := &Config{
+		Root:      root,
+		Immutable: true,
+		Dests:     map[string]DestConfig{"pge": {MaxFiles: 1, Rollover: rolloverMonth}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+
+	// the year and its new month are locked again
+	for _, dir := range []string{"filed/pge/2015", "filed/pge/2015/03"} {
+		err := ioutil.WriteFile(path.Join(root, dir, "extra"), nil, 0600)
+		assert(t, err != nil, "expected %s to be locked", dir)
+	}
+	ok(t, lockDest(path.Join(root, "filed", "pge"), true))
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2015/",
+		"filed/pge/2015/03/",
+		"filed/pge/2015/03/20150301_pge.pdf",
+		"filed/pge/2015/20150101_pge.pdf",
+		"inbox/",
+	}, found)
+}
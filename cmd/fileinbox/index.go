@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const jobsFlag string = "jobs"
+
+// indexFile holds the hash of every filed document, so finding
+// duplicates and verifying the archive needn't read it all again.
+const indexFile = ".fileinbox-index.json"
+
+// indexEntry is what we knew about a document when we hashed it.  While
+// its size and modification time are the same, we trust the hash.
+type indexEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+	// Tags are what the rule that filed it gave it, see Rule.Tags.
+	// They are kept when it is hashed again.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// hashIndex maps each document, relative to filed, to its entry.
+type hashIndex struct {
+	name    string
+	mode    os.FileMode
+	entries map[string]indexEntry
+}
+
+func (c *Config) index() string {
+	return path.Join(c.Root, indexFile)
+}
+
+// readIndex loads the index for c's root.  A missing index is empty.
+func (c *Config) readIndex() (*hashIndex, error) {
+	idx := &hashIndex{name: c.index(), mode: c.perms.state(), entries: map[string]indexEntry{}}
+	data, err := ioutil.ReadFile(idx.name)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx.entries); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", idx.name)
+	}
+	return idx, nil
+}
+
+func (idx *hashIndex) write() error {
+	data, err := json.MarshalIndent(idx.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(idx.name, data, idx.mode)
+}
+
+// indexTags hashes the documents filed in entries that were given
+// tags, and their copies, into the index along with their tags.
+func (c *Config) indexTags(entries []journalEntry) error {
+	var idx *hashIndex
+	for _, e := range entries {
+		if len(e.Tags) == 0 {
+			continue
+		}
+		if idx == nil {
+			var err error
+			if idx, err = c.readIndex(); err != nil {
+				return err
+			}
+		}
+		for _, name := range append([]string{e.To}, e.Copies...) {
+			rel, err := c.filedRel(name)
+			if err != nil {
+				return err
+			}
+			fi, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			sum, err := hashFile(name)
+			if err != nil {
+				return err
+			}
+			idx.entries[rel] = indexEntry{fi.Size(), fi.ModTime(), sum, e.Tags}
+		}
+	}
+	if idx == nil {
+		return nil
+	}
+	return idx.write()
+}
+
+// indexStats is what an update of the index did.
+type indexStats struct {
+	Hashed    int
+	Unchanged int
+	Removed   int
+}
+
+// updateIndex brings idx up to date with what is filed, hashing with
+// jobs files at a time.  Unless rebuild is set, documents whose size and
+// modification time haven't changed keep their hash.  Documents that
+// are no longer filed are dropped.
+func updateIndex(config *Config, idx *hashIndex, rebuild bool, jobs int) (indexStats, error) {
+	var stats indexStats
+	type todo struct {
+		rel  string
+		info os.FileInfo
+	}
+	var queue []todo
+	seen := map[string]bool{}
+	err := config.walkFiled(func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(config.filed(), p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+		if e, ok := idx.entries[rel]; ok && !rebuild && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+			stats.Unchanged++
+			return nil
+		}
+		queue = append(queue, todo{rel, info})
+		return nil
+	})
+	if err != nil {
+		return stats, errors.Wrap(err, "reading filed")
+	}
+	for rel := range idx.entries {
+		if !seen[rel] {
+			delete(idx.entries, rel)
+			stats.Removed++
+		}
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	work := make(chan todo)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				sum, err := hashFile(path.Join(config.filed(), t.rel))
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					idx.entries[t.rel] = indexEntry{t.info.Size(), t.info.ModTime(), sum, idx.entries[t.rel].Tags}
+					stats.Hashed++
+					if stats.Hashed%100 == 0 {
+						printf(progress, stylePlain, "Hashed %s of %s\n", formatCount(int64(stats.Hashed)), formatCount(int64(len(queue))))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range queue {
+		work <- t
+	}
+	close(work)
+	wg.Wait()
+	return stats, firstErr
+}
+
+// duplicates returns the documents in idx with the same contents as
+// another, grouped by hash.
+func (idx *hashIndex) duplicates() [][]string {
+	byHash := map[string][]string{}
+	for rel, e := range idx.entries {
+		byHash[e.SHA256] = append(byHash[e.SHA256], rel)
+	}
+	var groups [][]string
+	for _, g := range byHash {
+		if len(g) > 1 {
+			sort.Strings(g)
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+func doIndex(ctx *cli.Context, rebuild bool) error {
+	name := ctx.Command.FullName()
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, name)
+	}
+	idx, err := config.readIndex()
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+	start := time.Now()
+	stats, err := updateIndex(config, idx, rebuild, ctx.Int(jobsFlag))
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+	if err := idx.write(); err != nil {
+		return errors.Wrap(err, name)
+	}
+	fmt.Fprintf(progress, "Hashed %s, %s unchanged, %s no longer filed, in %s\n",
+		plural(uint32(stats.Hashed), "document", "documents"),
+		formatCount(int64(stats.Unchanged)),
+		formatCount(int64(stats.Removed)),
+		formatDuration(time.Since(start)))
+	if n := len(idx.duplicates()); n != 0 {
+		printf(progress, styleNotice, "%s filed more than once\n", plural(uint32(n), "document is", "documents are"))
+	}
+	return nil
+}
+
+func indexCommand() *cli.Command {
+	jobs := &cli.IntFlag{
+		Name:  jobsFlag,
+		Value: runtime.NumCPU(),
+		Usage: "How many documents to hash at once.",
+	}
+	return &cli.Command{
+		Name:  "index",
+		Usage: "Keep the hash of every filed document, for finding duplicates and verifying the archive.",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "update",
+				Usage:  "Hash documents filed or changed since the index was last updated.",
+				Action: func(ctx *cli.Context) error { return doIndex(ctx, false) },
+				Flags:  []cli.Flag{jobs},
+			},
+			{
+				Name:   "rebuild",
+				Usage:  "Hash every filed document again.",
+				Action: func(ctx *cli.Context) error { return doIndex(ctx, true) },
+				Flags:  []cli.Flag{jobs},
+			},
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestUpdateIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/att/2016/20160702_att.pdf",
+		"filed/att/2016/20160802_att.pdf",
+		"filed/pge/2016/20160825_pge.pdf",
+	})
+	copied := path.Join(root, "filed/pge/2016/20160826_pge.pdf")
+	ok(t, ioutil.WriteFile(copied, []byte("contents for 20160825_pge.pdf"), 0600))
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	idx, err := config.readIndex()
+	ok(t, err)
+	stats, err := updateIndex(config, idx, false, 2)
+	ok(t, err)
+	equals(t, indexStats{Hashed: 4}, stats)
+	ok(t, idx.write())
+	equals(t, [][]string{{"pge/2016/20160825_pge.pdf", "pge/2016/20160826_pge.pdf"}}, idx.duplicates())
+
+	// only what changed is hashed again
+	idx, err = config.readIndex()
+	ok(t, err)
+	ok(t, os.Remove(path.Join(root, "filed/att/2016/20160802_att.pdf")))
+	ok(t, ioutil.WriteFile(copied, []byte("something else"), 0600))
+	later := time.Now().Add(time.Minute)
+	ok(t, os.Chtimes(copied, later, later))
+	stats, err = updateIndex(config, idx, false, 2)
+	ok(t, err)
+	equals(t, indexStats{Hashed: 1, Unchanged: 2, Removed: 1}, stats)
+	equals(t, 0, len(idx.duplicates()))
+	sum, err := hashFile(copied)
+	ok(t, err)
+	equals(t, sum, idx.entries["pge/2016/20160826_pge.pdf"].SHA256)
+
+	stats, err = updateIndex(config, idx, true, 1)
+	ok(t, err)
+	equals(t, indexStats{Hashed: 3}, stats)
+}
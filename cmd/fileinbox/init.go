@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// doInit creates the layout of a new root, marks it as ours and saves it
+// in the config.  It is safe to run against an existing root.
+func doInit(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("init expects exactly one argument, the root directory")
+	}
+	root, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "init")
+	}
+
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "init")
+	}
+	if err := initRoot(config, root, ctx.StringSlice(destFlag)); err != nil {
+		return err
+	}
+	fmt.Fprintf(progress, "Initialized %s.  Put files in %s and run fileinbox to file them.\n", root, config.inbox())
+	return nil
+}
+
+// initRoot creates the layout of root, with dests, marks it as ours and
+// saves it in the config.
+func initRoot(config *Config, root string, dests []string) error {
+	config.Root = root
+	dirs := []string{config.inbox(), config.filed()}
+	for _, d := range dests {
+		dirs = append(dirs, config.dest(d))
+	}
+	for _, d := range dirs {
+		if err := config.perms.mkdirAll(d); err != nil {
+			return errors.Wrapf(err, "creating %s", d)
+		}
+	}
+	if err := writeRootMarker(root); err != nil {
+		return err
+	}
+
+	inbox := config.inbox()
+	err := config.update(func(c *Config) {
+		c.Root = root
+		if !hasString(c.ExtraInboxes, inbox) {
+			c.ExtraInboxes = append(c.ExtraInboxes, inbox)
+		}
+	})
+	return errors.Wrap(err, "writing config")
+}
+
+func hasString(all []string, s string) bool {
+	for _, a := range all {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
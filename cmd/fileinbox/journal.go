@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// journalFile records every document we file, one JSON object per line,
+// so what a document was called when it arrived is never lost, even
+// once a rename template has changed it.
+const journalFile = ".fileinbox-journal.jsonl"
+
+// journalEntry is one document filed, or, when Action is set, removed
+// to the trash, restored from it or put back in its inbox.
+type journalEntry struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	CC     string    `json:"cc,omitempty"`
+	Copies []string  `json:"copies,omitempty"`
+	Tags   []string  `json:"tags,omitempty"`
+	Action string    `json:"action,omitempty"`
+	// Run is the run that filed the document, or that was undone, and
+	// Label what the run was called, if anything.  See --run-label.
+	Run   string `json:"run,omitempty"`
+	Label string `json:"label,omitempty"`
+	// User is who filed the document, for a root shared by several.
+	User string `json:"user,omitempty"`
+}
+
+// thisRun is what the documents filed by this run are journaled as.
+var thisRun struct {
+	id    string
+	label string
+	user  string
+}
+
+// startRun starts a new run, labeled label.
+func startRun(label string) {
+	thisRun.id = time.Now().Format("20060102T150405.000000")
+	thisRun.label = label
+	if u, err := user.Current(); err == nil {
+		thisRun.user = u.Username
+	}
+}
+
+func (c *Config) journal() string {
+	return path.Join(c.Root, journalFile)
+}
+
+// appendJournal adds entries to the end of the journal.
+func (c *Config) appendJournal(entries []journalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(c.journal(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, c.perms.state())
+	if err != nil {
+		return err
+	}
+	if c.perms.group != 0 {
+		// whatever the umask, the others sharing the root append too.
+		// Only its owner may fix it, so it is fixed as it is created.
+		if fi, err := f.Stat(); err == nil && fi.Mode().Perm() != c.perms.state() {
+			if err := f.Chmod(c.perms.state()); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readJournal returns everything in the journal, oldest first.  A
+// missing journal is empty.
+func (c *Config) readJournal() ([]journalEntry, error) {
+	f, err := os.Open(c.journal())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var e journalEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, errors.Wrapf(err, "reading %s", c.journal())
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
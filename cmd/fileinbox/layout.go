@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// layoutCamera stands for fileinbox.CameraPattern in a Layout.
+const layoutCamera = "camera"
+
+// Layout files names that don't say their dest under Dest, leaving them
+// as they are.  Pattern is a regular expression with the named groups
+// year, month and date, or camera for names like IMG_20240825_123456.jpg
+// and PXL_20240825_123456789.jpg.  For photos, e.g.
+//
+//	layouts:
+//	  - pattern: camera
+//	    dest: photos
+//	dests:
+//	  photos:
+//	    dirs: month
+//
+// files them under filed/photos/2024/08/.
+type Layout struct {
+	Pattern string
+	Dest    string
+}
+
+func (c *Config) compileLayouts() error {
+	c.layouts = nil
+	for _, l := range c.Layouts {
+		if err := checkDest(l.Dest); err != nil {
+			return errors.Wrapf(err, "layout %q", l.Pattern)
+		}
+		re := fileinbox.CameraPattern
+		if l.Pattern != layoutCamera {
+			var err error
+			if re, err = regexp.Compile(l.Pattern); err != nil {
+				return errors.Wrapf(err, "bad layout %q", l.Pattern)
+			}
+			for _, g := range []string{"year", "month", "date"} {
+				if re.SubexpIndex(g) < 0 {
+					return errors.Errorf("layout %q is missing the named group %q", l.Pattern, g)
+				}
+			}
+		}
+		c.layouts = append(c.layouts, fileinbox.Layout{Pattern: re, Dest: l.Dest})
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestCameraLayout(t *testing.T) {
+	start := []string{
+		"filed/photos/PXL_20230704_201500123.jpg",
+		"inbox/IMG_20240825_123456.jpg",
+		"inbox/VID_20240901_080000.mp4",
+		"inbox/20240825_pge.pdf",
+		"filed/pge/",
+	}
+	expected := []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2024/",
+		"filed/pge/2024/20240825_pge.pdf",
+		"filed/photos/",
+		"filed/photos/2023/",
+		"filed/photos/2023/07/",
+		"filed/photos/2023/07/PXL_20230704_201500123.jpg",
+		"filed/photos/2024/",
+		"filed/photos/2024/08/",
+		"filed/photos/2024/08/IMG_20240825_123456.jpg",
+		"filed/photos/2024/09/",
+		"filed/photos/2024/09/VID_20240901_080000.mp4",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{
+		Root:    root,
+		Layouts: []Layout{{Pattern: layoutCamera, Dest: "photos"}},
+		Dests:   map[string]DestConfig{"photos": {Dirs: dirsMonth}},
+	}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+
+	docs, err := findFiled(config, opts, "photos")
+	ok(t, err)
+	equals(t, 3, len(docs))
+	equals(t, "photos", docs[2].dest)
+}
+
+func TestLayoutValidate(t *testing.T) {
+	for _, l := range []Layout{
+		{Pattern: layoutCamera},
+		{Pattern: layoutCamera, Dest: "../photos"},
+		{Pattern: `^scan(?P<year>\d{4})(?P<month>\d\d)`, Dest: "scans"},
+		{Pattern: `(`, Dest: "scans"},
+	} {
+		config := &Config{Layouts: []Layout{l}}
+		assert(t, config.validate() != nil, "expected %+v to be rejected", l)
+	}
+	config := &Config{Layouts: []Layout{{Pattern: `^scan(?P<year>\d{4})(?P<month>\d\d)(?P<date>\d\d)`, Dest: "scans"}}}
+	ok(t, config.validate())
+	_, err := parseFileName(config.parseOptions(false), "scan20240825.tif")
+	ok(t, err)
+
+	config = &Config{Dests: map[string]DestConfig{"photos": {Dirs: dirsMonth, MaxFiles: 10}}}
+	assert(t, config.validate() != nil, "expected dirs month with maxfiles to be rejected")
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+)
+
+// lockFile is best effort on windows: we only make sure the lock file
+// can be created.
+func lockFile(name string) (unlock func(), err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// Mail saved into an inbox as .eml, e.g. by a rule that forwards bills
+// to a mailbox synced there, is taken apart before the inbox is filed:
+// each attachment is put in the inbox, named for the dest of the sender
+// and the day in the Date header, e.g. 20240825_comcast_bill.pdf from a
+// bill.pdf sent by billing@comcast.com.  An attachment whose name we can
+// already file keeps it, and one with the same name as another is
+// numbered, e.g. bill-1.pdf.  Inline parts, such as a logo in a
+// signature, are skipped unless they are all there is.  The message
+// goes once all of its attachments are in the inbox.  Mail from senders without a dest, or without
+// attachments, is left be and counted as a failure.
+
+const mailExt = ".eml"
+
+// validateSenderDests checks SenderDests, keeping them by lower case
+// domain or address.
+func (c *Config) validateSenderDests() error {
+	c.senderDests = map[string]string{}
+	for sender, dest := range c.SenderDests {
+		if err := checkDest(dest); err != nil {
+			return errors.Wrapf(err, "senderdests %s", sender)
+		}
+		key := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(sender), "@"))
+		if key == "" {
+			return errors.Errorf("senderdests has an empty sender for %s", dest)
+		}
+		c.senderDests[key] = dest
+	}
+	return nil
+}
+
+// senderDest returns the dest for mail from address, trying the address
+// itself, then its domain and the domains above that, so comcast.com
+// covers mail from billing.comcast.com.
+func (c *Config) senderDest(address string) (string, bool) {
+	address = strings.ToLower(address)
+	if dest, ok := c.senderDests[address]; ok {
+		return dest, true
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return "", false
+	}
+	for domain := address[at+1:]; domain != ""; {
+		if dest, ok := c.senderDests[domain]; ok {
+			return dest, true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return "", false
+}
+
+// attachment is one file sent with a message.  An inline one, such as
+// a logo in a signature, is shown in the text rather than sent along
+// with it.
+type attachment struct {
+	name   string
+	data   []byte
+	inline bool
+}
+
+// readMail returns who sent the message in file, and its attachments.
+func readMail(file string) (*mail.Message, *mail.Address, []attachment, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "reading who sent it")
+	}
+	var found []attachment
+	if err := readPart(msg.Header, msg.Body, &found); err != nil {
+		return nil, nil, nil, err
+	}
+	return msg, from, uniqueNames(sentAlong(found)), nil
+}
+
+// sentAlong returns the attachments that aren't inline, or if they all
+// are, all of them, as then they are what was sent, such as a scan.
+func sentAlong(found []attachment) []attachment {
+	var kept []attachment
+	for _, a := range found {
+		if !a.inline {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == 0 {
+		return found
+	}
+	return kept
+}
+
+// uniqueNames renames the attachments that have the same name as one
+// before them, e.g. bill-1.pdf for the second bill.pdf, so neither is
+// lost.
+func uniqueNames(found []attachment) []attachment {
+	taken := map[string]bool{}
+	for _, a := range found {
+		taken[strings.ToLower(a.name)] = true
+	}
+	seen := map[string]bool{}
+	for i, a := range found {
+		if !seen[strings.ToLower(a.name)] {
+			seen[strings.ToLower(a.name)] = true
+			continue
+		}
+		ext := path.Ext(a.name)
+		for n := 1; ; n++ {
+			name := strings.TrimSuffix(a.name, ext) + "-" + strconv.Itoa(n) + ext
+			if !taken[strings.ToLower(name)] {
+				found[i].name = name
+				taken[strings.ToLower(name)] = true
+				seen[strings.ToLower(name)] = true
+				break
+			}
+		}
+	}
+	return found
+}
+
+// readPart adds the attachments in the part with header h and body r to
+// found, descending into the parts of multipart parts.
+func readPart(h map[string][]string, r io.Reader, found *[]attachment) error {
+	get := func(key string) string {
+		if v := h[key]; len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := readPart(p.Header, p, found); err != nil {
+				return err
+			}
+		}
+	}
+
+	name, disposition := "", ""
+	if d, dparams, err := mime.ParseMediaType(get("Content-Disposition")); err == nil {
+		name, disposition = dparams["filename"], d
+	}
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" {
+		// the text of the message
+		return nil
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	name = path.Base(filepath.ToSlash(name))
+
+	switch strings.ToLower(get("Content-Transfer-Encoding")) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrapf(err, "reading the attachment %s", name)
+	}
+	// a part with a Content-ID is referred to from the text, unless it
+	// says it is an attachment
+	inline := disposition == "inline" || (disposition != "attachment" && get("Content-Id") != "")
+	*found = append(*found, attachment{name, data, inline})
+	return nil
+}
+
+// attachmentName is what an attachment from dest, sent on day, is put
+// in the inbox as.
+func attachmentName(config *Config, opts fileinbox.ParseOptions, dest, day, name string) string {
+	if _, err := parseFileName(opts, name); err == nil {
+		return name
+	}
+	return day + "_" + strings.ReplaceAll(dest, "/", config.DestSeparator) + "_" + name
+}
+
+// ingestMail puts the attachments of the mail in inbox there to be
+// filed.  It returns the messages still in the inbox, which have been
+// reported on and so should be left alone.
+func ingestMail(config *Config, opts fileinbox.ParseOptions, inbox string, dryRun bool, fr *fileResult) map[string]bool {
+	left := map[string]bool{}
+	if len(config.senderDests) == 0 {
+		return left
+	}
+	infos, err := ioutil.ReadDir(inbox)
+	if err != nil {
+		// processInbox reports this when it reads the inbox
+		return left
+	}
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || !strings.EqualFold(filepath.Ext(fi.Name()), mailExt) {
+			continue
+		}
+		file := path.Join(inbox, fi.Name())
+		fail := func(err error) {
+			printf(progress, styleFailure, "Unable to file the mail %q: %v\n", file, err)
+			fr.fail(file, failMail, err)
+			fr.skippedCount++
+			fr.skippedBytes += fi.Size()
+			left[fi.Name()] = true
+		}
+
+		msg, from, found, err := readMail(file)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		dest, ok := config.senderDest(from.Address)
+		if !ok {
+			fail(errors.Errorf("there is no dest for mail from %s.  Add it to senderdests", from.Address))
+			continue
+		}
+		sent, err := msg.Header.Date()
+		if err != nil {
+			fail(errors.Wrap(err, "reading when it was sent"))
+			continue
+		}
+		if len(found) == 0 {
+			fail(errors.New("it has no attachments"))
+			continue
+		}
+		day := sent.In(opts.Zone()).Format("20060102")
+
+		if dryRun {
+			left[fi.Name()] = true
+			for _, a := range found {
+				printf(progress, stylePlain, "Would take %s from %s\n", attachmentName(config, opts, dest, day, a.name), file)
+			}
+			continue
+		}
+
+		var placed []string
+		for _, a := range found {
+			to := path.Join(inbox, attachmentName(config, opts, dest, day, a.name))
+			var created bool
+			if created, err = placeAttachment(fr, to, a.data); err != nil {
+				break
+			}
+			if created {
+				placed = append(placed, to)
+			}
+		}
+		if err != nil {
+			// all or nothing, so the next run starts over
+			for _, p := range placed {
+				os.Remove(p)
+			}
+			fail(err)
+			continue
+		}
+		for _, p := range placed {
+			printf(progress, stylePlain, "Took %s from %s\n", p, file)
+		}
+		if err := os.Remove(file); err != nil {
+			fail(err)
+		}
+	}
+	return left
+}
+
+// placeAttachment writes data to to, by way of the run's workspace,
+// refusing to replace anything different already there.  It returns
+// true if it wrote to.
+func placeAttachment(fr *fileResult, to string, data []byte) (bool, error) {
+	if _, err := os.Lstat(to); err == nil {
+		same := false
+		if have, err := ioutil.ReadFile(to); err == nil {
+			same = bytes.Equal(have, data)
+		}
+		if same {
+			// already taken, perhaps by an earlier run that stopped
+			return false, nil
+		}
+		return false, errors.Errorf("%q already exists", to)
+	}
+	work, err := fr.work.sub("mail")
+	if err != nil {
+		return false, err
+	}
+	if fr.work == nil {
+		defer os.RemoveAll(work)
+	}
+	tmp, err := ioutil.TempFile(work, "attachment")
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = fileinbox.MoveFile(tmp.Name(), to); err != nil {
+		return false, err
+	}
+	return true, nil
+}
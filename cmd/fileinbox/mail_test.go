@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testMail = `From: Comcast Billing <billing@mail.comcast.com>
+To: me@example.com
+Subject: Your bill
+Date: Sun, 25 Aug 2024 23:30:00 -0700
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+Your bill is attached.
+--b1
+Content-Type: application/pdf; name="bill.pdf"
+Content-Disposition: attachment; filename="bill.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQKJSVFT0YK
+--b1
+Content-Type: text/csv
+Content-Disposition: attachment; filename="20240801_comcast_usage.csv"
+Content-Transfer-Encoding: quoted-printable
+
+day,gb
+--b1--
+`
+
+func TestIngestMail(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"inbox/", "filed/"})
+	inbox := path.Join(root, "inbox")
+	ok(t, ioutil.WriteFile(path.Join(inbox, "bill.eml"), []byte(strings.ReplaceAll(testMail, "\n", "\r\n")), 0600))
+	ok(t, ioutil.WriteFile(path.Join(inbox, "other.eml"), []byte(strings.Replace(testMail, "mail.comcast.com", "example.org", 1)), 0600))
+
+	config := &Config{Root: root, SenderDests: map[string]string{"Comcast.com": "comcast"}, TimeZone: "America/Los_Angeles"}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	left := ingestMail(config, opts, inbox, true, &fr)
+	equals(t, map[string]bool{"bill.eml": true, "other.eml": true}, left)
+	equals(t, 1, len(fr.failures))
+
+	fr = fileResult{missingDirs: map[string]bool{}}
+	left = ingestMail(config, opts, inbox, false, &fr)
+	equals(t, map[string]bool{"other.eml": true}, left)
+	equals(t, failMail, fr.failures[0].Category)
+
+	infos, err := ioutil.ReadDir(inbox)
+	ok(t, err)
+	var found []string
+	for _, fi := range infos {
+		found = append(found, fi.Name())
+	}
+	equals(t, []string{"20240801_comcast_usage.csv", "20240825_comcast_bill.pdf", "other.eml"}, found)
+	data, err := ioutil.ReadFile(path.Join(inbox, "20240825_comcast_bill.pdf"))
+	ok(t, err)
+	equals(t, "%PDF-1.4\n%%EOF\n", string(data))
+	data, err = ioutil.ReadFile(path.Join(inbox, "20240801_comcast_usage.csv"))
+	ok(t, err)
+	equals(t, "day,gb", strings.TrimSpace(string(data)))
+
+	dest, found2 := config.senderDest("billing@comcast.com")
+	assert(t, found2 && dest == "comcast", "expected comcast.com to cover the address")
+	_, found2 = config.senderDest("billing@notcomcast.com")
+	assert(t, !found2, "expected only whole domains to match")
+}
+
+const testInlineMail = `From: Comcast Billing <billing@mail.comcast.com>
+To: me@example.com
+Subject: Your bills
+Date: Sun, 25 Aug 2024 23:30:00 -0700
+MIME-Version: 1.0
+Content-Type: multipart/related; boundary="b1"
+
+--b1
+Content-Type: text/html
+
+<img src="cid:logo">
+--b1
+Content-Type: image/png; name="logo.png"
+Content-ID: <logo>
+Content-Transfer-Encoding: base64
+
+iVBORw0K
+--b1
+Content-Type: image/gif; name="pixel.gif"
+Content-Disposition: inline; filename="pixel.gif"
+
+GIF89a
+--b1
+Content-Type: application/pdf; name="bill.pdf"
+Content-Disposition: attachment; filename="bill.pdf"
+
+first
+--b1
+Content-Type: application/pdf; name="bill.pdf"
+Content-Disposition: attachment; filename="bill.pdf"
+
+second
+--b1--
+`
+
+const testOnlyInlineMail = `From: Comcast Billing <billing@mail.comcast.com>
+To: me@example.com
+Subject: Your scan
+Date: Mon, 26 Aug 2024 09:00:00 -0700
+MIME-Version: 1.0
+Content-Type: multipart/related; boundary="b1"
+
+--b1
+Content-Type: text/html
+
+<img src="cid:scan">
+--b1
+Content-Type: image/jpeg; name="scan.jpg"
+Content-ID: <scan>
+Content-Disposition: inline; filename="scan.jpg"
+
+a scan
+--b1--
+`
+
+func TestMailInlineAndSameNames(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"inbox/", "filed/"})
+	inbox := path.Join(root, "inbox")
+	ok(t, ioutil.WriteFile(path.Join(inbox, "bills.eml"), []byte(testInlineMail), 0600))
+	ok(t, ioutil.WriteFile(path.Join(inbox, "scan.eml"), []byte(testOnlyInlineMail), 0600))
+
+	config := &Config{Root: root, SenderDests: map[string]string{"comcast.com": "comcast"}, TimeZone: "America/Los_Angeles"}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	left := ingestMail(config, config.parseOptions(false), inbox, false, &fr)
+	equals(t, map[string]bool{}, left)
+	equals(t, 0, len(fr.failures))
+
+	// the logo and the tracking pixel are left out, and both bills kept
+	found := map[string]string{}
+	infos, err := ioutil.ReadDir(inbox)
+	ok(t, err)
+	for _, fi := range infos {
+		data, err := ioutil.ReadFile(path.Join(inbox, fi.Name()))
+		ok(t, err)
+		found[fi.Name()] = strings.TrimSpace(string(data))
+	}
+	equals(t, map[string]string{
+		"20240825_comcast_bill.pdf":   "first",
+		"20240825_comcast_bill-1.pdf": "second",
+		"20240826_comcast_scan.jpg":   "a scan",
+	}, found)
+}
@@ -8,22 +8,38 @@ import (
 	"os/signal"
 	"os/user"
 	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
-	"strconv"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
-
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
 )
 
 const (
-	rootFlag       string = "root"
-	skipConfigFlag string = "skipconfig"
-	forceFlag      string = "force"
+	rootFlag          string = "root"
+	rootOnceFlag      string = "root-once"
+	configFlag        string = "config"
+	skipConfigFlag    string = "skipconfig"
+	forceFlag         string = "force"
+	outputFlag        string = "output"
+	noColorFlag       string = "no-color"
+	destFlag          string = "dest"
+	dryRunFlag        string = "dry-run"
+	metricsFlag       string = "metrics-file"
+	nowFlag           string = "now"
+	createInboxesFlag string = "create-inboxes"
+	runLabelFlag      string = "run-label"
+	summaryOnlyFlag   string = "summary-only"
+	progressFlag      string = "progress"
 )
 
 // Config represents some configuration we can store/read
@@ -34,17 +50,255 @@ type Config struct {
 	CC           struct {
 		Root  string
 		Dests []string
+
+		// Conflicts says what to do with a document whose name in the
+		// mirror is taken by a different document: fail (the default)
+		// leaves it in the inbox, skip files it without mirroring it,
+		// replace replaces what is mirrored and version mirrors it as
+		// the next version.  A plan saved with --dry-run shows each
+		// conflict, and its ccResolve may be changed before applying it.
+		Conflicts string
 	}
+	Rules []Rule
+	Dests map[string]DestConfig
+
+	// Hotfolders are swept for documents saved in the wrong place, such
+	// as Downloads.  See Hotfolder.
+	Hotfolders []Hotfolder
+
+	// Immutable locks filed documents, and the directories for past
+	// years, with chattr or chflags.  See the immutable command.
+	Immutable bool
+
+	// Plugins are asked about files nothing else could parse.
+	Plugins []Plugin
+
+	// Unsorted, when set, is the dest for files whose names start with a
+	// date but don't say where they go, e.g. 20240101.pdf or "20240101
+	// scan.pdf".  They are filed by year under it, rather than left in
+	// the inbox.  Plugins get the first chance at them.
+	Unsorted string
+
+	// ExtDests maps extensions, such as jpg or csv, to the dest for
+	// files whose names start with a date but don't say where they go,
+	// e.g. with {jpg: photos, csv: bank/exports}, 20240825.jpg is filed
+	// under photos.  They come before Unsorted.
+	ExtDests map[string]string
+
+	// SenderDests maps who mail comes from to dests, for mail saved into
+	// an inbox as .eml, e.g. {comcast.com: comcast}.  The attachments are
+	// filed under the sender's dest, dated from when it was sent.  Keys
+	// are domains, which cover their subdomains, or whole addresses such
+	// as billing@comcast.com.
+	SenderDests map[string]string
+
+	// Pipelines turn phone photos, such as receipts, into documents to
+	// file.  See Pipeline.
+	Pipelines []Pipeline
+
+	// Scan says how the scan command drives the scanner.  See
+	// ScanConfig.
+	Scan ScanConfig
+
+	// Fetchers download statements from vendor portals into the inbox.
+	// See Fetcher.
+	Fetchers []Fetcher
+
+	// Offload moves old years out to object storage.  See
+	// OffloadConfig.
+	Offload OffloadConfig
+
+	// PruneEmpty removes empty year directories after each run, as the
+	// prune-empty command does.
+	PruneEmpty bool
+
+	Watch WatchConfig
+
+	// Notifications tell other systems, such as Home Assistant, what
+	// each run did.  See Notifications.
+	Notifications Notifications
+
+	// Schedules are run by the daemon.  See Schedule.
+	Schedules []Schedule
+
+	// Retries is how many more times to try filing a document that
+	// failed with a transient error, such as EBUSY or a NAS that is
+	// briefly offline, 3 if not set.  Negative disables retries.
+	// Retries come once everything else is filed, after RetryBackoff
+	// (1s if not set), which doubles each time.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Ignore globs, such as *.sync, match files that are never filed,
+	// on top of the temporary files browsers, sync clients and office
+	// suites leave behind, such as *.partial and *.crdownload.  They
+	// are left in the inbox, and counted in the summary.
+	Ignore []string
+
+	// Folders says what to do with a folder dropped into an inbox, such
+	// as pge-2019 from an older archive: leave (the default) leaves it
+	// be, while file files everything in it, and in folders within it,
+	// as if it had been dropped into the inbox itself.  The folder goes
+	// once it is empty.
+	Folders string
+
+	// OrganizeJobs is how many dests are organized at once, 4 if not
+	// set.  A dest that can't be organized is reported, and the rest
+	// carry on.
+	OrganizeJobs int
+
+	// Duplicates says what to do with a document that is already filed,
+	// with the same contents, under its name: drop (the default) removes
+	// it from the inbox, while keep leaves it there.  A different
+	// document under the same name is always left in the inbox, and
+	// called out in the summary.
+	Duplicates string
+
+	// DuplicatesAnywhere also looks for a document's contents throughout
+	// the archive, under any name and dest, e.g. a statement that came
+	// by email and again as a paper scan, going by the index.  One found
+	// is handled as Duplicates says, and we say where it is filed.
+	DuplicatesAnywhere bool
+
+	// Collisions says what to do with a document whose name is taken by
+	// a different filed document: conflict (the default) leaves it in
+	// the inbox, while versioned files it alongside as the next version,
+	// e.g. 20240101_pge.v2.pdf, as for a corrected statement.  See the
+	// versions command.
+	Collisions string
+
+	// Sniff checks that each file's contents match its extension, and
+	// that it isn't empty, before filing it.  warn just says so, while
+	// quarantine moves the file into <root>/quarantine instead of
+	// filing it.  A bank's download button can produce an HTML error
+	// page named like a pdf.
+	Sniff string
+
+	// Broken says what to do with files that are empty, or cut short,
+	// such as a pdf without its %%EOF.  These are nearly always failed
+	// downloads.  quarantine, the default, moves them into
+	// <root>/quarantine, while file files them anyway.
+	Broken string
+
+	// These control how names are parsed.  See fileinbox.ParseOptions.
+	// With a DestSeparator, dests can nest, e.g. insurance/auto is filed
+	// under filed/insurance/auto/<year>/.  With ".", only the final
+	// extension is left out of the dest, e.g. 20240101_insurance.auto.pdf.
+	Patterns      []string
+	Normalize     bool
+	Aliases       map[string]string
+	DestSeparator string
+
+	// StripPrefixes are regular expressions for what scanners put
+	// before the date, such as Scan_ or SKM_C\d+_.  A name that starts
+	// with one is parsed without it, and filed without it, e.g.
+	// Scan_20240825_pge.pdf is filed as 20240825_pge.pdf.
+	StripPrefixes []string
+
+	// Layouts file names that don't say their dest, such as the
+	// IMG_20240825_123456.jpg a phone writes, under their Dest as they
+	// are.  See Layout.
+	Layouts []Layout
+
+	// PatternPacks turns on built-in patterns for names written by
+	// scanner apps in other languages: monthnames for names like
+	// 25Aug2024_pge.pdf, and cjk for names like 2024年08月25日_pge.pdf.
+	// They are filed with the date rewritten as YYYYMMDD.
+	PatternPacks []string
+
+	// DateOrder is how the leading 8 digit date of a name is read: ymd
+	// (the default), dmy or mdy.  InboxDateOrders overrides it for
+	// inboxes, keyed by path or base name, e.g. for a scanner that
+	// writes dates day first.  A date like 01022024 reads as a valid
+	// date either way round, and AmbiguousDates says what to do with
+	// those: warn (the default) or skip, leaving them in the inbox.
+	DateOrder       string
+	InboxDateOrders map[string]string
+	AmbiguousDates  string
+
+	// TimeZone, such as America/Los_Angeles or UTC, is the zone dates
+	// are judged in: what today is, for dates in the future, and the
+	// day a photo was taken or a file modified.  Set it to the same
+	// zone on every machine that shares an archive, so they agree
+	// around midnight and New Year.  The local time zone if not set.
+	TimeZone string
+
+	// Inboxes configures extra inboxes, keyed by path or base name like
+	// InboxDateOrders.  See InboxConfig.
+	Inboxes map[string]InboxConfig
+
+	// DirMode and FileMode, in octal such as 0750, are given to what we
+	// create in the archive and mirrors.  When not set, the umask
+	// decides for directories, while documents keep the mode, and when
+	// run as root the owner, they had in the inbox.
+	DirMode  string
+	FileMode string
+
+	// Group, a group name or id, is given to what we create in the
+	// archive, for a root shared by a household on a server.  It makes
+	// DirMode and FileMode default to group writable, and the journal
+	// and other records under the root writable by the group.
+	Group string
+
+	patterns    []*regexp.Regexp
+	extDests    map[string]string // keyed by extOf
+	senderDests map[string]string // keyed by lower case domain or address
+	prefixes    []*regexp.Regexp
+	location    *time.Location
+	layouts     []fileinbox.Layout
+	renames     map[string]*renameTemplate // by dest
+	perms       perms
 }
 
+// configFile, when set, is used in place of the usual config location,
+// so tests don't touch the real one.
+var configFile string
+
+// configHomeEnv, when set, is the directory to keep fileinbox.yaml, or
+// fileinbox.toml or fileinbox.json, in, e.g. a volume in a container
+// that has no home directory.
+const configHomeEnv = "FILEINBOX_HOME"
+
 func (c *Config) path() (string, error) {
-	usr, err := user.Current()
+	if configFile != "" {
+		return configFile, nil
+	}
+	if dir := os.Getenv(configHomeEnv); dir != "" {
+		return configIn(dir), nil
+	}
+	home, err := homeDir()
 	if err != nil {
-		fmt.Printf("Unable to determine home directory: %+v", err)
+		printf(progress, styleFailure, "%v\n", err)
 		return "", err
 	}
+	return configIn(path.Join(home, ".config", "fileinbox")), nil
+}
 
-	return path.Join(usr.HomeDir, ".config", "fileinbox", "fileinbox.yaml"), nil
+var (
+	homeOnce sync.Once
+	home     string
+	homeErr  error
+)
+
+// homeDir returns the user's home directory, looked up once per run.
+// Containers often run as a user with no passwd entry, so $HOME is
+// used when the lookup fails.
+func homeDir() (string, error) {
+	homeOnce.Do(func() {
+		usr, err := user.Current()
+		if err == nil && usr.HomeDir != "" {
+			home = usr.HomeDir
+			return
+		}
+		if home = os.Getenv("HOME"); home != "" {
+			return
+		}
+		if err == nil {
+			err = errors.Errorf("%s has no home directory", usr.Username)
+		}
+		homeErr = errors.Errorf("unable to find your home directory, to read the config from (%v).  Set HOME, or set %s to the directory to keep fileinbox.yaml in", err, configHomeEnv)
+	})
+	return home, homeErr
 }
 
 func (c *Config) read() error {
@@ -61,18 +315,25 @@ func (c *Config) read() error {
 		if os.IsNotExist(err) {
 			return nil
 		}
-		fmt.Printf("Failed to read %q due to %+v", p, err)
+		printf(progress, styleFailure, "Failed to read %q due to %+v", p, err)
 		return err
 	}
 
-	err = yaml.Unmarshal(bytes, c)
+	err = unmarshalConfig(configFormat(p), bytes, c)
 	if err != nil {
-		fmt.Printf("Failed to unmarshal %q: %+v", string(bytes), err)
+		printf(progress, styleFailure, "Failed to unmarshal %q: %+v", string(bytes), err)
 	}
 	return err
 }
 
-func (c *Config) write() error {
+// update applies change to the config and saves it, snapshotting what
+// was there first; see restore-config.  The lock is held
+// from reading what is on disk to writing it back, so a concurrent
+// update, e.g. init adding an inbox while a run stores --root, isn't
+// lost.  Only what change touches is saved; the rest comes from disk,
+// not from our copy.
+func (c *Config) update(change func(*Config)) error {
+	change(c)
 	if !c.persist {
 		return nil
 	}
@@ -81,45 +342,451 @@ func (c *Config) write() error {
 		return err
 	}
 
-	bytes, err := yaml.Marshal(c)
+	// The directory must be writable, as we write a temp file next to
+	// the config and rename it into place.
+	err = ensureWritableDir(path.Dir(p))
 	if err != nil {
-		fmt.Printf("Failed to marshal %#v: %+v", c, err)
+		printf(progress, styleFailure, "Failed to create directory %q, %+v", path.Dir(p), err)
 		return err
 	}
 
-	err = os.MkdirAll(path.Dir(p), 0500)
+	unlock, err := lockFile(p + ".lock")
 	if err != nil {
-		fmt.Printf("Failed to create directory %q, %+v", path.Dir(p), err)
+		printf(progress, styleFailure, "Failed to lock %q due to %+v", p, err)
 		return err
 	}
+	defer unlock()
 
-	err = ioutil.WriteFile(p, bytes, 0600)
+	saved := &Config{persist: true}
+	if err = saved.read(); err != nil {
+		return err
+	}
+	if _, err = snapshotConfig(p, saved.Root); err != nil {
+		printf(progress, styleFailure, "Failed to snapshot %q due to %+v", p, err)
+		return err
+	}
+	change(saved)
+
+	bytes, err := marshalConfig(configFormat(p), saved)
+	if err != nil {
+		printf(progress, styleFailure, "Failed to marshal %#v: %+v", saved, err)
+		return err
+	}
+
+	err = writeFileAtomic(p, bytes, 0600)
 	if err != nil {
-		fmt.Printf("Failed to write %q due to %+v", p, err)
+		printf(progress, styleFailure, "Failed to write %q due to %+v", p, err)
 		return err
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes to a temp file and renames it over name, so
+// readers see either the old contents or the new, never a mix.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) (err error) {
+	f, err := ioutil.TempFile(path.Dir(name), "."+path.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// validate checks the parts of the configuration that can be wrong in
+// ways the yaml parser won't notice.
+func (c *Config) validate() error {
+	c.patterns = nil
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return errors.Wrapf(err, "bad pattern %q", p)
+		}
+		for _, g := range []string{"year", "month", "date", "dest"} {
+			if re.SubexpIndex(g) < 0 {
+				return errors.Errorf("pattern %q is missing the named group %q", p, g)
+			}
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	c.prefixes = nil
+	for _, p := range c.StripPrefixes {
+		re, err := regexp.Compile(`^(?:` + p + `)`)
+		if err != nil {
+			return errors.Wrapf(err, "bad strip prefix %q", p)
+		}
+		c.prefixes = append(c.prefixes, re)
+	}
+	if err := c.compileRenames(); err != nil {
+		return err
+	}
+	if err := c.compileLayouts(); err != nil {
+		return err
+	}
+	for _, name := range c.PatternPacks {
+		if _, ok := fileinbox.PatternPack(name); !ok {
+			return errors.Errorf("unknown pattern pack %q.  We expect one of %s", name, strings.Join(fileinbox.PatternPackNames(), ", "))
+		}
+	}
+	var err error
+	if c.perms.dir, err = parseMode(c.DirMode); err != nil {
+		return errors.Wrap(err, "dirmode")
+	}
+	if c.perms.file, err = parseMode(c.FileMode); err != nil {
+		return errors.Wrap(err, "filemode")
+	}
+	if err = c.perms.share(c.Group); err != nil {
+		return errors.Wrap(err, "group")
+	}
+	if err := validateDateOrder(c.DateOrder); err != nil {
+		return err
+	}
+	for inbox, order := range c.InboxDateOrders {
+		if err := validateDateOrder(order); err != nil {
+			return errors.Wrapf(err, "inbox %s", inbox)
+		}
+	}
+	switch c.AmbiguousDates {
+	case "", ambiguousWarn, ambiguousSkip:
+	default:
+		return errors.Errorf("unknown ambiguousdates %q.  We expect %s or %s", c.AmbiguousDates, ambiguousWarn, ambiguousSkip)
+	}
+	switch c.Duplicates {
+	case "", duplicatesDrop, duplicatesKeep:
+	default:
+		return errors.Errorf("unknown duplicates %q.  We expect %s or %s", c.Duplicates, duplicatesDrop, duplicatesKeep)
+	}
+	c.location = time.Local
+	if c.TimeZone != "" {
+		loc, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return errors.Wrapf(err, "bad timezone %q", c.TimeZone)
+		}
+		c.location = loc
+	}
+	for _, g := range c.Ignore {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return errors.Wrapf(err, "bad ignore glob %q", g)
+		}
+	}
+	switch c.Folders {
+	case "", foldersLeave, foldersFile:
+	default:
+		return errors.Errorf("unknown folders %q.  We expect %s or %s", c.Folders, foldersLeave, foldersFile)
+	}
+	if c.Unsorted != "" {
+		if err := checkDest(c.Unsorted); err != nil {
+			return errors.Wrap(err, "unsorted")
+		}
+	}
+	c.extDests = map[string]string{}
+	for ext, dest := range c.ExtDests {
+		if err := checkDest(dest); err != nil {
+			return errors.Wrapf(err, "extdests %s", ext)
+		}
+		key := extOf("." + strings.TrimPrefix(ext, "."))
+		if key == "" {
+			return errors.Errorf("extdests has an empty extension for %s", dest)
+		}
+		c.extDests[key] = dest
+	}
+	if err := c.validateSenderDests(); err != nil {
+		return err
+	}
+	if c.OrganizeJobs < 0 {
+		return errors.Errorf("organizejobs must not be negative, not %d", c.OrganizeJobs)
+	}
+	switch c.Collisions {
+	case "", collisionsConflict, collisionsVersioned:
+	default:
+		return errors.Errorf("unknown collisions %q.  We expect %s or %s", c.Collisions, collisionsConflict, collisionsVersioned)
+	}
+	switch c.Broken {
+	case "", brokenQuarantine, brokenFile:
+	default:
+		return errors.Errorf("unknown broken %q.  We expect %s or %s", c.Broken, brokenQuarantine, brokenFile)
+	}
+	switch c.Sniff {
+	case "", sniffWarn, sniffQuarantine:
+	default:
+		return errors.Errorf("unknown sniff %q.  We expect %s or %s", c.Sniff, sniffWarn, sniffQuarantine)
+	}
+	if strings.ContainsAny(c.DestSeparator, "_/") {
+		return errors.Errorf("dest separator %q may not contain _ or /", c.DestSeparator)
+	}
+	if c.DestSeparator != "." && strings.Contains(c.DestSeparator, ".") {
+		return errors.Errorf("dest separator %q may only be . on its own", c.DestSeparator)
+	}
+	if err := c.validateCC(); err != nil {
+		return err
+	}
+	for i := range c.Schedules {
+		if err := c.Schedules[i].compile(); err != nil {
+			return err
+		}
+	}
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(); err != nil {
+			return errors.Wrapf(err, "rule %d", i+1)
+		}
+	}
+	for _, p := range c.Plugins {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateFetchers(); err != nil {
+		return err
+	}
+	for i := range c.Pipelines {
+		p := &c.Pipelines[i]
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if strings.Contains(p.Dest, "/") && c.DestSeparator == "" {
+			return errors.Errorf("pipeline %s: the nested dest %s needs a dest separator", p.Inbox, p.Dest)
+		}
+	}
+	if c.RetryBackoff < 0 {
+		return errors.Errorf("retry backoff %s must not be negative", c.RetryBackoff)
+	}
+	for i := range c.Hotfolders {
+		if err := c.Hotfolders[i].validate(); err != nil {
+			return err
+		}
+	}
+	if m := c.Notifications.MQTT; m != nil {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Watch.validate(); err != nil {
+		return err
+	}
+	if err := c.Offload.validate(); err != nil {
+		return err
+	}
+	for name, d := range c.Dests {
+		if err := d.validate(); err != nil {
+			return errors.Wrapf(err, "dest %s", name)
+		}
+		if d.Successor == name {
+			return errors.Errorf("dest %s: it can't be its own successor", name)
+		}
+		if s := d.Successor; c.Dests[s].Frozen {
+			return errors.Errorf("dest %s: its successor %s is frozen too", name, s)
+		}
+	}
+	return nil
+}
+
+// ccDest returns where to mirror files for dest, or "" if dest is not
+// mirrored.  A dest's own CC root comes first, then CC.Dests, which may
+// hold glob patterns, such as tax* or *.  A nested dest such as
+// insurance/auto is mirrored like its parents, the nearest one first.
 func (c *Config) ccDest(dest string) string {
+	for p := dest; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if root := c.Dests[p].CC; root != "" {
+			return path.Join(root, dest)
+		}
+	}
 	if c.CC.Root == "" {
 		return ""
 	}
-	for _, d := range c.CC.Dests {
-		if d == dest {
-			return path.Join(c.CC.Root, d)
+	for p := dest; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		for _, d := range c.CC.Dests {
+			if ok, _ := path.Match(d, p); ok {
+				return path.Join(c.CC.Root, dest)
+			}
 		}
 	}
 	return ""
 }
 
+// What to do with a document whose CC is taken, see CC.Conflicts.
+const ccConflictsFail = "fail"
+
+// ccResolve returns the fileinbox.CCResolve for CC.Conflicts.
+func (c *Config) ccResolve() string {
+	if c.CC.Conflicts == ccConflictsFail {
+		return fileinbox.CCFail
+	}
+	return c.CC.Conflicts
+}
+
+// ccResolveSays describes what a move with resolve does about a CC
+// conflict.
+func ccResolveSays(resolve string) string {
+	switch resolve {
+	case fileinbox.CCSkip:
+		return "be filed without mirroring it"
+	case fileinbox.CCReplace:
+		return "replace it"
+	case fileinbox.CCVersion:
+		return "be mirrored as the next version"
+	}
+	return "be left in the inbox"
+}
+
+// ccRoots returns every root we mirror to.
+func (c *Config) ccRoots() []string {
+	var roots []string
+	if c.CC.Root != "" {
+		roots = append(roots, c.CC.Root)
+	}
+	for _, d := range c.Dests {
+		if d.CC != "" {
+			roots = append(roots, d.CC)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// validateCC makes sure the CC.Dests patterns are well formed and that no
+// two of them overlap.  We can't spot every overlap between two
+// wildcards, but we do catch duplicates and one pattern matching
+// another, such as tax* and taxes, or * and anything.  A dest with its
+// own CC root overrides the patterns, but it may not also be listed by
+// name, as then it isn't clear which root was meant.
+func (c *Config) validateCC() error {
+	switch c.CC.Conflicts {
+	case "", ccConflictsFail, fileinbox.CCSkip, fileinbox.CCReplace, fileinbox.CCVersion:
+	default:
+		return errors.Errorf("unknown cc conflicts %q.  We expect %s, %s, %s or %s", c.CC.Conflicts,
+			ccConflictsFail, fileinbox.CCSkip, fileinbox.CCReplace, fileinbox.CCVersion)
+	}
+	for i, a := range c.CC.Dests {
+		if _, err := path.Match(a, ""); err != nil {
+			return errors.Wrapf(err, "bad CC dest %q", a)
+		}
+		for _, b := range c.CC.Dests[:i] {
+			aHasB, _ := path.Match(a, b)
+			bHasA, _ := path.Match(b, a)
+			if aHasB || bHasA {
+				return errors.Errorf("CC dests %q and %q overlap", b, a)
+			}
+		}
+	}
+	for name, d := range c.Dests {
+		if d.CC == "" {
+			continue
+		}
+		for _, a := range c.CC.Dests {
+			if a == name && path.Clean(d.CC) != path.Clean(c.CC.Root) {
+				return errors.Errorf("dest %s is mirrored to both %s and %s", name, c.CC.Root, d.CC)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Config) inbox() string {
 	return path.Join(c.Root, "inbox")
 }
 
+// inboxes returns every inbox, the one under the root and hotfolders
+// included.
+func (c *Config) inboxes() []string {
+	all := []string{c.inbox()}
+	for _, inbox := range c.ExtraInboxes {
+		if !hasString(all, inbox) {
+			all = append(all, inbox)
+		}
+	}
+	for _, h := range c.Hotfolders {
+		if !hasString(all, h.Dir) {
+			all = append(all, h.Dir)
+		}
+	}
+	return all
+}
+
+func (c *Config) filed() string {
+	return path.Join(c.Root, "filed")
+}
+
 func (c *Config) dest(name string) string {
-	return path.Join(c.Root, "filed", name)
+	return path.Join(c.filed(), name)
+}
+
+// checkDest makes sure a dest we didn't parse ourselves, e.g. one a
+// plugin answered with, stays under filed.
+func checkDest(dest string) error {
+	if dest == "" {
+		return errors.New("dest must not be empty")
+	}
+	if path.IsAbs(dest) {
+		return errors.Errorf("dest %q must be relative", dest)
+	}
+	for _, part := range strings.Split(dest, "/") {
+		if part == ".." {
+			return errors.Errorf("dest %q must not contain ..", dest)
+		}
+	}
+	return nil
+}
+
+// loadConfig reads and validates the config, unless --skipconfig is set.
+func loadConfig(ctx *cli.Context) (*Config, error) {
+	config := &Config{
+		persist: !ctx.Bool(skipConfigFlag),
+	}
+	if err := config.read(); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	if s := ctx.String(nowFlag); s != "" && config.TimeZone != "" {
+		// now that we know the zone, a date alone starts the day there
+		t, err := parseNow(s, config.location)
+		if err != nil {
+			return nil, err
+		}
+		clock = fileinbox.FixedClock(t)
+	}
+	if root := ctx.String(rootOnceFlag); root != "" {
+		if ctx.String(rootFlag) != "" {
+			return nil, errors.Errorf("use only one of --%s and --%s", rootFlag, rootOnceFlag)
+		}
+		if err := config.useRoot(root); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// useRoot points config at root for this run only.  The inboxes we were
+// configured with feed the saved root, so root's own inbox stands in for
+// them.
+func (c *Config) useRoot(root string) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return errors.Wrapf(err, "--%s", rootOnceFlag)
+	}
+	c.Root = abs
+	c.ExtraInboxes = []string{c.inbox()}
+	c.Hotfolders = nil
+	return nil
 }
 
 func newCli() *cli.App {
@@ -127,10 +794,46 @@ func newCli() *cli.App {
 	app.Name = "fileinbox"
 	app.Usage = "Move files into the correct place, using their names."
 	app.Action = doFile
+	var prof *profiles
+	app.Before = func(ctx *cli.Context) (err error) {
+		prof, err = startProfiles(ctx.String(cpuProfileFlag), ctx.String(memProfileFlag), ctx.String(traceFlag))
+		if err != nil {
+			return err
+		}
+		noColor = ctx.Bool(noColorFlag) || os.Getenv("NO_COLOR") != ""
+		if err := startProgress(ctx.String(progressFlag)); err != nil {
+			return err
+		}
+		if c := ctx.String(configFlag); c != "" {
+			configFile = c
+		}
+		if s := ctx.String(nowFlag); s != "" {
+			t, err := parseNow(s, time.Local)
+			if err != nil {
+				return err
+			}
+			clock = fileinbox.FixedClock(t)
+		}
+		return nil
+	}
+	app.After = func(ctx *cli.Context) error {
+		return prof.stop()
+	}
+	app.EnableBashCompletion = true
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
 			Name:  rootFlag,
-			Usage: "Specifies the root directory.  Will be saved into ~/.config/fileinbox/fileinbox.yaml"},
+			Usage: fmt.Sprintf("Specifies the root directory.  Will be saved into ~/.config/fileinbox/fileinbox.yaml.  See --%s to leave it alone.", rootOnceFlag)},
+		&cli.StringFlag{
+			Name:    rootOnceFlag,
+			Usage:   "Use this root directory, and only its own inbox, for this run, without saving it.  Handy for a root on a USB drive, or a volume mounted into a container.",
+			EnvVars: []string{"FILEINBOX_ROOT"},
+		},
+		&cli.StringFlag{
+			Name:    configFlag,
+			Usage:   fmt.Sprintf("Use the config at this path rather than ~/.config/fileinbox/fileinbox.yaml, e.g. one mounted into a container.  %s does the same for a directory.", configHomeEnv),
+			EnvVars: []string{"FILEINBOX_CONFIG"},
+		},
 		&cli.BoolFlag{
 			Name:   skipConfigFlag,
 			Usage:  "If set, we don't read or write configuration.  Meant for testing.",
@@ -140,22 +843,181 @@ func newCli() *cli.App {
 			Name:  forceFlag,
 			Usage: "If set, we will create destination directories as needed.",
 		},
+		&cli.StringFlag{
+			Name:  outputFlag,
+			Value: outputText,
+			Usage: fmt.Sprintf("How to report results, one of %s, %s or %s.  %s streams what happens as it happens, one JSON object per line, ending with the summary.", outputText, outputJSON, outputJSONL, outputJSONL),
+		},
+		&cli.BoolFlag{
+			Name:  dryRunFlag,
+			Usage: fmt.Sprintf("If set, we only report what we would file.  With --%s %s, the plan can be given to the apply command.", outputFlag, outputJSON),
+		},
+		&cli.BoolFlag{
+			Name:  savePlanFlag,
+			Usage: fmt.Sprintf("With --%s, save the plan, and what the inboxes hold, for --%s.", dryRunFlag, applyLastPlanFlag),
+		},
+		&cli.BoolFlag{
+			Name:  applyLastPlanFlag,
+			Usage: fmt.Sprintf("File exactly what the last --%s --%s planned, refusing if an inbox has changed since.", dryRunFlag, savePlanFlag),
+		},
+		&cli.StringFlag{
+			Name:    progressFlag,
+			Value:   progressStderr,
+			Usage:   fmt.Sprintf("Where to say what we are doing as we go, %s or %s.  Results, such as lists, reports and JSON, always go to stdout, so they can be piped on.  Machine readable --%s keeps stdout to itself either way.", progressStderr, progressStdout, outputFlag),
+			EnvVars: []string{"FILEINBOX_PROGRESS"},
+		},
+		&cli.BoolFlag{
+			Name:  summaryOnlyFlag,
+			Usage: "Leave out the line for each file, and say nothing at all when there was nothing to do and nothing failed, so cron only mails when something happened.",
+		},
+		&cli.StringFlag{
+			Name:  metricsFlag,
+			Usage: "If set, we write metrics about each run to this file, for node_exporter's textfile collector.  Name it something.prom.",
+		},
+		&cli.StringFlag{
+			Name:  runLabelFlag,
+			Usage: "Label what this run files in the journal, e.g. \"post-vacation scan batch\", so recent and undo can find it later.",
+		},
+		&cli.BoolFlag{
+			Name:  createInboxesFlag,
+			Usage: "If set, we will create inboxes that are missing, rather than stopping.",
+		},
+		&cli.StringFlag{
+			Name:  nowFlag,
+			Usage: "Act as if today were this date, e.g. 2024-01-01.  Useful for reproducing a problem.",
+		},
+		&cli.BoolFlag{
+			Name:  noColorFlag,
+			Usage: "Don't color the output, even on a terminal.  Setting NO_COLOR does the same.",
+		},
+		&cli.StringFlag{
+			Name:  cpuProfileFlag,
+			Usage: "Write a CPU profile of the run to this file, for go tool pprof.",
+		},
+		&cli.StringFlag{
+			Name:  memProfileFlag,
+			Usage: "Write a profile of the memory still in use at the end of the run to this file, for go tool pprof.",
+		},
+		&cli.StringFlag{
+			Name:  traceFlag,
+			Usage: "Write an execution trace of the run to this file, for go tool trace.",
+		},
+	}
+	app.Commands = []*cli.Command{
+		setupCommand(),
+		{
+			Name:      "init",
+			Usage:     "Set up a new root directory and remember it in the config.",
+			ArgsUsage: "<root>",
+			Action:    doInit,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  destFlag,
+					Usage: "A destination directory to create.  May be repeated.",
+				},
+			},
+		},
+		{
+			Name:         "open",
+			Usage:        "Open a filed document in the default viewer.",
+			ArgsUsage:    "<dest>",
+			Action:       doOpen,
+			Flags:        openFlags(),
+			BashComplete: completeDests,
+		},
+		{
+			Name:         "reveal",
+			Usage:        "Show a filed document in the file manager.",
+			ArgsUsage:    "<dest>",
+			Action:       doReveal,
+			Flags:        openFlags(),
+			BashComplete: completeDests,
+		},
+		{
+			Name:   "dests",
+			Usage:  "List the dests, with how many documents each has and when the latest is from.",
+			Action: doDests,
+		},
+		{
+			Name:   "watch",
+			Usage:  "File everything in the inboxes, then keep filing as more arrives.",
+			Action: doWatch,
+		},
+		{
+			Name:   "prune-empty",
+			Usage:  "Remove empty year directories, and dests left empty without them.  Inboxes are never touched.",
+			Action: doPrune,
+		},
+		duCommand(),
+		exportCommand(),
+		immutableCommand(),
+		restoreConfigCommand(),
+		ccCommand(),
+		migrateLayoutCommand(),
+		whyCommand(),
+		hotfolderCommand(),
+		reportCommand(),
+		indexCommand(),
+		rmCommand(),
+		versionsCommand(),
+		recentCommand(),
+		undoCommand(),
+		offloadCommand(),
+		scanCommand(),
+		mergeDestsCommand(),
+		fetchCommand(),
+		treeSnapshotCommand(),
+		compareCommand(),
+		configCommand(),
+		benchCommand(),
+		{
+			Name:      "apply",
+			Usage:     "File exactly the moves in a plan, as written by --dry-run, from a JSON or CSV file or - for stdin.",
+			ArgsUsage: "<plan>",
+			Action:    doApply,
+		},
 	}
+	app.Commands = append(app.Commands, daemonCommands()...)
 	return app
 }
 
+// termChan, when set, exits on SIGTERM and interrupts.  As PID 1 in a
+// container, nothing else would, since the kernel ignores signals PID 1
+// hasn't asked for.
+var termChan chan os.Signal
+
+// stopExitOnTerm is for commands that handle SIGTERM themselves, to
+// stop cleanly.
+func stopExitOnTerm() {
+	if termChan != nil {
+		signal.Stop(termChan)
+	}
+}
+
 func main() {
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	go func() {
 		stacktrace := make([]byte, 8192)
 		for range sigChan {
 			length := runtime.Stack(stacktrace, true)
-			fmt.Println(string(stacktrace[:length]))
+			fmt.Fprintln(os.Stderr, string(stacktrace[:length]))
 		}
 	}()
 	signal.Notify(sigChan, syscall.SIGQUIT)
+	if os.Getpid() == 1 {
+		termChan = make(chan os.Signal, 1)
+		go func() {
+			sig := <-termChan
+			printf(os.Stderr, styleNotice, "Stopping on %v\n", sig)
+			os.Exit(1)
+		}()
+		signal.Notify(termChan, os.Interrupt, syscall.SIGTERM)
+	}
 
-	newCli().Run(os.Args)
+	if err := newCli().Run(os.Args); err != nil {
+		printf(os.Stderr, styleFailure, "Error: %+v\n", err)
+		os.Exit(1)
+	}
 }
 
 func isDir(name string) bool {
@@ -168,29 +1030,153 @@ func isDir(name string) bool {
 
 type fileResult struct {
 	okCount      uint32
-	orgCount     uint32
+	retriedCount uint32 // the part of okCount that was only filed when retried
+	orgCount     uint32 // files moved into place while organizing
+	orgDests     uint32 // dests organized
+	orgUpToDate  uint32 // dests skipped, as nothing had changed
 	orgDuration  time.Duration
 	failureCount uint32
+	failures     []failure // what failed, and why
+	skippedCount uint32    // files left in the inbox, other than held ones
 	missingDirs  map[string]bool
+	waiting      []waitingFile   // files left for dests that were missing, see fileWaiting
+	refusedDests map[string]bool // dests that are links we won't follow
+
+	movedBytes   int64 // everything filed
+	copiedBytes  int64 // the part of movedBytes that had to be copied across devices
+	ccBytes      int64 // mirrored to CC
+	skippedBytes int64 // left in the inbox
+
+	held        map[string]int // files left for review, by dest
+	heldBytes   int64          // the size of the held files
+	touched     map[string]int // files filed, by dest
+	quarantined uint32         // files that were broken, or whose contents didn't match their names
+	duplicates  uint32         // files already filed with the same contents
+	versions    uint32         // files filed as a new version of another
+	copies      uint32         // copies filed under the other dests of a document
+	conflicts   []string       // files whose names are taken by different filed documents
+	ccConflicts []string       // files whose names in the CC mirror are taken by different documents
+	unlocked    []string       // filed documents that Config.Immutable couldn't lock
+	archive     *filedHashes   // what is filed, by hash, for Config.DuplicatesAnywhere
+	ignored     []string       // temporary files, and others matching Config.Ignore
+
+	work        *workspace       // this run's, see workspaceDir
+	plan        []fileinbox.Move // what a dry run would have done
+	readInboxes []string         // the inboxes a dry run read, for --save-plan
 }
 
 func (fr fileResult) summarize(duration time.Duration) error {
-	fmt.Printf("\n\n%d files moved in %s.", fr.okCount, duration)
-	fmt.Printf("\n\n%d directories organized in %s.", fr.orgCount, fr.orgDuration)
+	fmt.Print("\n\n")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Filed:\t%s files in %s\n", formatCount(int64(fr.okCount)), formatDuration(duration))
+	if fr.retriedCount != 0 {
+		fmt.Fprintf(tw, "Retried:\t%s of them were filed when retried\n", formatCount(int64(fr.retriedCount)))
+	}
+	if len(fr.touched) != 0 {
+		fmt.Fprintf(tw, "By dest:\t%s\n", fr.byDest())
+	}
+	fmt.Fprintf(tw, "Moved:\t%s at %s/s, %s of it across devices\n",
+		formatBytes(fr.movedBytes), formatBytes(throughput(fr.movedBytes, duration)), formatBytes(fr.copiedBytes))
+	fmt.Fprintf(tw, "Mirrored:\t%s to CC\n", formatBytes(fr.ccBytes))
+	if fr.skippedBytes != 0 {
+		fmt.Fprintf(tw, "Left:\t%s in the inbox\n", formatBytes(fr.skippedBytes))
+	}
+	if fr.duplicates != 0 {
+		fmt.Fprintf(tw, "Duplicates:\t%s files were already filed\n", formatCount(int64(fr.duplicates)))
+	}
+	if fr.versions != 0 {
+		fmt.Fprintf(tw, "Versions:\t%s files were filed as new versions\n", formatCount(int64(fr.versions)))
+	}
+	if fr.copies != 0 {
+		fmt.Fprintf(tw, "Copies:\t%s copies were filed under other dests\n", formatCount(int64(fr.copies)))
+	}
+	fmt.Fprintf(tw, "Organized:\t%s dests, %s up to date, moving %s files in %s\n",
+		formatCount(int64(fr.orgDests)), formatCount(int64(fr.orgUpToDate)), formatCount(int64(fr.orgCount)), formatDuration(fr.orgDuration))
+	tw.Flush()
+	if len(fr.conflicts) != 0 {
+		printf(os.Stdout, styleFailure, "\nThese files were left in the inbox, as a different document is filed under the same name.  One of them probably has the wrong date:\n")
+		for _, c := range fr.conflicts {
+			printf(os.Stdout, styleFailure, "    %s\n", c)
+		}
+	}
+	if len(fr.ccConflicts) != 0 {
+		printf(os.Stdout, styleFailure, "\nThese files conflict with a different document mirrored to CC under the same name.  Set cc conflicts in the config, or ccResolve for each in a saved plan, to file them:\n")
+		for _, c := range fr.ccConflicts {
+			printf(os.Stdout, styleFailure, "    %s\n", c)
+		}
+	}
+	if len(fr.unlocked) != 0 {
+		printf(os.Stdout, styleFailure, "\nThese were filed, but could not be locked.  Run fileinbox immutable restore once what stopped it is fixed:\n")
+		for _, name := range fr.unlocked {
+			printf(os.Stdout, styleFailure, "    %s\n", name)
+		}
+	}
+	var others []failure
+	for _, f := range fr.failures {
+		// conflicts and missing directories have their own say
+		if f.Category != failConflict && f.Category != failCCConflict && f.Category != failMissingDir {
+			others = append(others, f)
+		}
+	}
+	if len(others) != 0 {
+		printf(os.Stdout, styleFailure, "\nThese failed:\n")
+		for _, f := range others {
+			printf(os.Stdout, styleFailure, "    %s (%s): %s\n", f.Path, f.Category, f.Error)
+		}
+	}
+	if len(fr.ignored) != 0 {
+		printf(os.Stdout, styleNotice, "\n%s ignored:\n", plural(uint32(len(fr.ignored)), "temporary file", "temporary files"))
+		for i, name := range fr.ignored {
+			if i == shownIgnored {
+				printf(os.Stdout, styleNotice, "    and %s more\n", formatCount(int64(len(fr.ignored)-shownIgnored)))
+				break
+			}
+			printf(os.Stdout, styleNotice, "    %s\n", name)
+		}
+	}
+	if fr.quarantined != 0 {
+		printf(os.Stdout, styleNotice, "\n%s files quarantined, as they were empty, cut short or not what their names said.\n", formatCount(int64(fr.quarantined)))
+	}
+	for _, dest := range fr.heldDests() {
+		printf(os.Stdout, styleNotice, "\n%s files waiting for review for dest=%s.\n", formatCount(int64(fr.held[dest])), dest)
+	}
 	if len(fr.missingDirs) != 0 {
-		fmt.Println("\n\nThe following directories are missing:")
+		printf(os.Stdout, styleNotice, "\nThe following directories are missing:\n")
 		for k := range fr.missingDirs {
-			fmt.Printf("    %s\n", k)
+			printf(os.Stdout, styleNotice, "    %s\n", k)
 		}
-		fmt.Printf("\n\nYou can automatically create the above directories by running this command again with the --%s flag", forceFlag)
+		printf(os.Stdout, styleNotice, "\nYou can automatically create the above directories by running this command again with the --%s flag\n", forceFlag)
 	}
 	if fr.failureCount != 0 {
 		return fmt.Errorf("there were %d failures", fr.failureCount)
 	}
-	fmt.Println()
 	return nil
 }
 
+// byDest says how many files were filed in each dest, e.g. "chase: 2,
+// pge: 3".  A dest that shows up here unexpectedly is often a typo.
+func (fr fileResult) byDest() string {
+	var dests []string
+	for d := range fr.touched {
+		dests = append(dests, d)
+	}
+	sort.Strings(dests)
+	for i, d := range dests {
+		dests[i] = fmt.Sprintf("%s: %s", d, formatCount(int64(fr.touched[d])))
+	}
+	return strings.Join(dests, ", ")
+}
+
+// heldDests returns the dests with files held for review, sorted.
+func (fr fileResult) heldDests() []string {
+	var dests []string
+	for d := range fr.held {
+		dests = append(dests, d)
+	}
+	sort.Strings(dests)
+	return dests
+}
+
 type accum map[string]map[string]bool
 
 func newAccum() accum {
@@ -223,174 +1209,487 @@ func (a accum) iter() []destNeeds {
 	return result
 }
 
-func doFileInner(ctx *cli.Context) (fileResult, error) {
-	fr := fileResult{}
+func doFileInner(ctx *cli.Context) (fr fileResult, err error) {
+	start := time.Now()
 	fr.missingDirs = map[string]bool{}
 
-	skipconfig := ctx.Bool(skipConfigFlag)
-	config := &Config{
-		persist: !skipconfig,
-	}
-	if err := config.read(); err != nil {
+	config, err := loadConfig(ctx)
+	if err != nil {
 		return fr, errors.Wrap(err, "doFileInner")
 	}
 
 	force := ctx.Bool(forceFlag)
+	dryRun := ctx.Bool(dryRunFlag)
+	opts := config.parseOptions(force)
+	startRun(ctx.String(runLabelFlag))
+
+	if m := config.Notifications.MQTT; m != nil && !dryRun {
+		if n := startMQTT(m); n != nil {
+			defer func() { n.finish(fr, time.Since(start), err) }()
+		}
+	}
+
+	if o := ctx.String(outputFlag); o != outputText && o != outputJSON && o != outputJSONL {
+		return fr, errors.Errorf("Unknown --%s %q.  We expect %s, %s or %s.", outputFlag, o, outputText, outputJSON, outputJSONL)
+	}
 
 	if ctx.String(rootFlag) == "" && config.Root == "" {
 		return fr, errors.Errorf("You must use the --%s flag to specify a root directory.  This will be stored for later use.", rootFlag)
 	}
 
-	if ctx.String(rootFlag) != "" {
-		config.Root = ctx.String(rootFlag)
-		if err := config.write(); err != nil {
+	if root := ctx.String(rootFlag); root != "" && root != config.Root {
+		if err := config.update(func(c *Config) { c.Root = root }); err != nil {
 			return fr, errors.Wrap(err, "writing config")
 		}
 	}
 
+	if err := checkRoot(config.Root); err != nil {
+		return fr, err
+	}
+	if err := config.checkOverlaps(); err != nil {
+		return fr, err
+	}
+	if err := config.shareRoot(); err != nil {
+		return fr, err
+	}
+	warnCaseVariants(config)
+	if !dryRun {
+		if fr.work, err = newWorkspace(config); err != nil {
+			return fr, errors.Wrap(err, "making the run's workspace")
+		}
+		defer func() { fr.work.finish(&fr, err) }()
+	}
+
 	allInboxes := []string{}
 	allInboxes = append(allInboxes, config.ExtraInboxes...)
 	for _, inbox := range allInboxes {
-		if err := processInbox(inbox, config, force, &fr); err != nil {
+		if skip, err := missingInbox(config, inbox, ctx.Bool(createInboxesFlag), dryRun); err != nil {
+			return fr, err
+		} else if skip {
+			continue
+		}
+		if err := processInbox(inbox, config, opts, force, dryRun, &fr); err != nil {
 			return fr, errors.Wrapf(err, "processing %s", inbox)
 		}
 	}
+	if err := sweepHotfolders(config, force, dryRun, &fr); err != nil {
+		return fr, err
+	}
+	if err := fileWaiting(config, opts, force, dryRun, &fr); err != nil {
+		return fr, err
+	}
+	if err := config.updateDestCache(opts, fr.touched); err != nil {
+		printf(progress, styleNotice, "Unable to update the dest summaries: %v\n", err)
+	}
+	if config.PruneEmpty && !dryRun {
+		if _, err := pruneEmpty(config, false); err != nil {
+			return fr, err
+		}
+	}
+	if dryRun && ctx.Bool(savePlanFlag) {
+		if err := savePlan(config, &fr); err != nil {
+			return fr, errors.Wrap(err, "saving the plan")
+		}
+	}
 
 	return fr, nil
 }
 
-func processInbox(inbox string, config *Config, force bool, fr *fileResult) error {
+// processInbox files everything in inbox.  With dryRun set, nothing is
+// changed and fr.plan gets what would have been moved.
+func processInbox(inbox string, config *Config, opts fileinbox.ParseOptions, force, dryRun bool, fr *fileResult) error {
 	if !isDir(inbox) {
 		return errors.Errorf("%q does not appear to be a directory", inbox)
 	}
 
-	files, err := ioutil.ReadDir(inbox)
+	if dryRun {
+		fr.readInboxes = append(fr.readInboxes, inbox)
+	}
+	inboxOpts := config.inboxOptions(opts, inbox)
+	left := ingestMail(config, inboxOpts, inbox, dryRun, fr)
+	if p := config.pipeline(inbox); p != nil {
+		for name := range p.run(config, inboxOpts, inbox, dryRun, fr) {
+			left[name] = true
+		}
+	}
+
+	dir, err := os.Open(inbox)
 	if err != nil {
 		return errors.Wrapf(err, "Unable to dir %q", inbox)
 	}
+	defer dir.Close()
+
+	// A huge inbox, say from a bulk photo import, is read and filed a
+	// chunk at a time, so we neither hold all of it in memory nor go
+	// quiet until we have listed it all.
+	read := 0
+	var folders []string
+	for {
+		files, readErr := dir.Readdir(inboxChunk)
+		if readErr != nil && readErr != io.EOF {
+			return errors.Wrapf(readErr, "Unable to dir %q", inbox)
+		}
+		if len(files) == 0 {
+			break
+		}
+		for _, f := range files {
+			if config.filesFolder(inbox, f) {
+				folders = append(folders, path.Join(inbox, f.Name()))
+			}
+		}
+		read += len(files)
+		if read >= inboxChunk {
+			printf(progress, stylePlain, "Read %s entries of %s so far\n", formatCount(int64(read)), inbox)
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+		if err := processChunk(inbox, files, left, config, opts, inboxOpts, force, dryRun, fr); err != nil {
+			return err
+		}
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		if err := processFolder(folder, config, opts, inboxOpts, force, dryRun, fr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inboxChunk is how many entries of an inbox are read, and filed, at a
+// time.
+var inboxChunk = 1000
 
+// processChunk files files, which are some of what is in inbox.
+func processChunk(inbox string, files []os.FileInfo, left map[string]bool, config *Config, opts, inboxOpts fileinbox.ParseOptions, force, dryRun bool, fr *fileResult) (err error) {
 	// figure out what we are working on
 	allParsed := []*parsedName{}
 	acc := newAccum()
+	hot := config.hotfolder(inbox)
 	for _, file := range files {
 		b := file.Name()
+		if left[b] {
+			// the pipeline has already said what became of it
+			continue
+		}
+		if sidecarOf(inbox, b) {
+			// filed with its photo
+			continue
+		}
+		if config.filesFolder(inbox, file) {
+			// filed by processFolder
+			continue
+		}
+		if hot != nil && !hot.takes(file) {
+			continue
+		}
+		if config.ignored(b) {
+			fr.ignored = append(fr.ignored, path.Join(inbox, b))
+			continue
+		}
+		if inboxLink(inbox, file) {
+			fr.skippedCount++
+			continue
+		}
 		var parsed *parsedName
-		parsed, err = parseFileName(force, b)
+		parsed, err = planFile(config, inboxOpts, inbox, file)
+		if err != nil && hot != nil {
+			// not one of ours
+			err = nil
+			continue
+		}
 		if err != nil {
-			fmt.Printf("Unable to parse %q, skipping: %+v", path.Join(inbox, b), err)
-			fr.failureCount++
+			printf(progress, styleSkip, "Unable to parse %q, skipping: %v\n", path.Join(inbox, b), err)
+			events.publish(eventFailed, path.Join(inbox, b), "", err)
+			fr.fail(path.Join(inbox, b), failParse, err)
+			fr.skippedCount++
+			fr.skippedBytes += file.Size()
 			continue
 		}
+		if !checkContents(config, inbox, file, dryRun, fr) {
+			continue
+		}
+		if parsed.ambiguous {
+			if config.AmbiguousDates == ambiguousSkip {
+				printf(progress, styleSkip, "The date of %q could be read with the day and month swapped, skipping\n", path.Join(inbox, b))
+				events.publish(eventFailed, path.Join(inbox, b), "", errAmbiguousDate)
+				fr.fail(path.Join(inbox, b), failAmbiguous, errAmbiguousDate)
+				fr.skippedCount++
+				fr.skippedBytes += file.Size()
+				continue
+			}
+			printf(progress, styleNotice, "The date of %q could be read with the day and month swapped, filing it as %s-%s-%s\n",
+				path.Join(inbox, b), parsed.year, parsed.month, parsed.date)
+		}
+		if frozenErr := config.frozenDests(parsed); frozenErr != nil {
+			printf(progress, styleSkip, "Not filing %q: %v\n", path.Join(inbox, b), frozenErr)
+			events.publish(eventFailed, path.Join(inbox, b), "", frozenErr)
+			fr.fail(path.Join(inbox, b), failFrozen, frozenErr)
+			fr.skippedCount++
+			fr.skippedBytes += file.Size()
+			continue
+		}
+		if config.Dests[parsed.dest].Hold {
+			if fr.held == nil {
+				fr.held = map[string]int{}
+			}
+			fr.held[parsed.dest]++
+			fr.heldBytes += file.Size()
+			continue
+		}
+		config.rename(inboxOpts, parsed)
 		allParsed = append(allParsed, parsed)
+		events.publish(eventDetected, path.Join(inbox, b), "", nil)
 		acc.add(parsed.dest, parsed.year)
+		for _, also := range parsed.also {
+			acc.add(also, parsed.year)
+		}
 	}
 
+	im := newImmutability(config.Immutable)
+	defer func() {
+		if restoreErr := im.restore(); restoreErr != nil && err == nil {
+			err = errors.Wrap(restoreErr, "locking filed documents")
+		}
+	}()
+
 	// make sure destination directories are ready
+	buckets := map[string]*bucketer{}
+	marks := config.readOrganized()
+	var tasks []orgTask
 	for _, dn := range acc.iter() {
 		dest := config.dest(dn.dest)
 		if !isDir(dest) {
-			if force {
-				if err = os.MkdirAll(dest, 0700); err != nil {
-					return errors.Wrapf(err, "Failed creating dir for %s", dest)
-				}
-			} else {
+			if !force {
 				fr.missingDirs[dest] = true
-				fr.failureCount++
+				fr.fail(dest, failMissingDir, errors.Errorf("%s does not exist", dest))
 				continue
 			}
+			if !dryRun {
+				if err = config.perms.mkdirAll(dest); err != nil {
+					return errors.Wrapf(err, "Failed creating dir for %s", dest)
+				}
+			}
 		}
 
-		orgStart := time.Now()
-		var orgCount uint32
-		orgCount, err = organize(force, dest, dn.years)
-		fr.orgDuration += time.Since(orgStart)
-		fr.orgCount += orgCount
-		if err != nil {
-			return errors.Wrapf(err, "Failed organizing %q", dest)
+		if _, linkErr := config.destLink(dest); linkErr != nil {
+			printf(progress, styleFailure, "%v\n", linkErr)
+			if fr.refusedDests == nil {
+				fr.refusedDests = map[string]bool{}
+			}
+			fr.refusedDests[dest] = true
+			fr.fail(dest, failMissingDir, linkErr)
+			continue
 		}
-	}
 
-	tasks := len(allParsed)
+		buckets[dn.dest] = newBucketer(dest, config.Dests[dn.dest])
+		if dryRun {
+			continue
+		}
+		years := dn.years
+		if !buckets[dn.dest].yearDirs() {
+			years = nil
+		}
+		if marks.upToDate(dn.dest, dest, years) {
+			fr.orgUpToDate++
+			continue
+		}
+		tasks = append(tasks, orgTask{dn.dest, dest, years, buckets[dn.dest]})
+	}
+	orgStart := time.Now()
+	for _, res := range organizeDests(config, opts, tasks, im) {
+		fr.orgCount += res.count
+		if res.err != nil {
+			printf(progress, styleFailure, "Unable to organize %q: %v\n", res.dir, res.err)
+			fr.fail(res.dir, failOrganize, res.err)
+			continue
+		}
+		marks.mark(res.dest, res.dir)
+		fr.orgDests++
+	}
+	fr.orgDuration += time.Since(orgStart)
+	if err = marks.write(); err != nil {
+		printf(progress, styleNotice, "Unable to remember which dests are organized: %v\n", err)
+		err = nil
+	}
 
-	// move the inbox files into place
-	for i, parsed := range allParsed {
-		if src, dest := cc(config, inbox, parsed); src != "" {
-			dir, _ := path.Split(dest)
-			if !isDir(dir) {
-				if err = os.Mkdir(dir, 0700); err != nil {
-					fmt.Printf("Failed to create dir %q: %+v\n", dir, err)
-					fr.failureCount++
-					continue
-				}
+	// work out the moves, then carry them out
+	plan := &fileinbox.Plan{}
+	for _, parsed := range allParsed {
+		if config.missingDest(parsed, fr) {
+			fr.skippedCount++
+			fr.skippedBytes += parsed.size
+			if !dryRun {
+				fr.waitFor(config, inbox, inboxOpts, parsed)
 			}
-			if err = copyFile(src, dest); err != nil {
-				fmt.Printf("Unable to copy from %q to %q: %+v\n", src, dest, err)
-				fr.failureCount++
-				continue
+			continue
+		}
+		bucket := buckets[parsed.dest].dir(parsed.year, parsed.month, parsed.size)
+		m := fileinbox.Move{
+			From: path.Join(inbox, parsed.baseName),
+			To:   path.Join(config.dest(parsed.dest), bucket, parsed.filedName()),
+			Tags: parsed.tags,
+		}
+		m.CC = cc(config, bucket, parsed)
+		if conflict, ccErr := m.CCConflicted(); ccErr != nil {
+			printf(progress, styleNotice, "Unable to check the CC of %q: %v\n", m.From, ccErr)
+		} else if conflict {
+			m.CCConflict, m.CCResolve = true, config.ccResolve()
+			if dryRun {
+				fr.ccConflicts = append(fr.ccConflicts, m.From)
 			}
 		}
+		for _, also := range parsed.also {
+			alsoBucket := buckets[also].dir(parsed.year, parsed.month, parsed.size)
+			m.Copies = append(m.Copies, path.Join(config.dest(also), alsoBucket, parsed.filedName()))
+		}
+		plan.Moves = append(plan.Moves, m)
+	}
+	if config.DuplicatesAnywhere {
+		if plan.Moves, err = config.dropFiledAnywhere(plan.Moves, dryRun, fr); err != nil {
+			return err
+		}
+	}
 
-		dest := config.dest(parsed.dest)
-		oldPath := path.Join(inbox, parsed.baseName)
-		newPath := path.Join(dest, parsed.year, parsed.baseName)
-		err = move(oldPath, newPath)
-		if err != nil {
-			fmt.Printf("Unable to move from %q to %q: %+v\n", oldPath, newPath, err)
-			if !fr.missingDirs[dest] {
-				fr.failureCount++
+	for _, m := range plan.Moves {
+		events.publish(eventPlanned, m.From, m.To, nil)
+	}
+	if dryRun {
+		for _, m := range plan.Moves {
+			printf(progress, stylePlain, "Would file %s as %s\n", m.From, m.To)
+			if m.CCConflict {
+				printf(progress, styleNotice, "    but a different document is mirrored as %s, so it would %s\n", m.CC, ccResolveSays(m.CCResolve))
 			}
-			continue
 		}
-		fmt.Printf("(%d/%d) Filed\r", i+1, tasks)
-		fr.okCount++
+		fileSidecars(plan.Moves, true, fr)
+		fr.plan = append(fr.plan, plan.Moves...)
+		return nil
 	}
-	fmt.Print(" \n")
+	err = applyPlan(config, plan, im, fr)
+	fr.archive.addFiled(config, plan.Moves)
+	fileSidecars(plan.Moves, false, fr)
+	return err
+}
 
-	return nil
+// missingDest returns true if any of the dests of parsed is missing, in
+// which case it is left in the inbox.
+func (c *Config) missingDest(parsed *parsedName, fr *fileResult) bool {
+	for _, d := range append([]string{parsed.dest}, parsed.also...) {
+		if fr.missingDirs[c.dest(d)] || fr.refusedDests[c.dest(d)] {
+			return true
+		}
+	}
+	return false
 }
 
-func cc(config *Config, inbox string, parsed *parsedName) (src, dest string) {
-	dest = config.ccDest(parsed.dest)
-	if dest == "" {
-		return "", ""
+// waitingFile is a file left in dir because a dest of it was missing.
+type waitingFile struct {
+	dir, name string
+	size      int64
+	dests     []string // the dest directories it needs
+	opts      fileinbox.ParseOptions
+}
+
+// waitFor remembers parsed, in dir, as waiting for its dests to be made,
+// unless one of them is a link we won't follow.
+func (fr *fileResult) waitFor(config *Config, dir string, opts fileinbox.ParseOptions, parsed *parsedName) {
+	w := waitingFile{dir: dir, name: parsed.baseName, size: parsed.size, opts: opts}
+	for _, d := range append([]string{parsed.dest}, parsed.also...) {
+		if fr.refusedDests[config.dest(d)] {
+			return
+		}
+		w.dests = append(w.dests, config.dest(d))
 	}
-	dest = path.Join(dest, parsed.year, parsed.baseName)
-	src = path.Join(inbox, parsed.baseName)
-	return src, dest
+	fr.waiting = append(fr.waiting, w)
 }
 
-func copyFile(src, dest string) error {
-	var from, to *os.File
-	var err error
-	defer func() {
-		if from != nil {
-			from.Close()
+// fileWaiting files what was left in the inboxes for dests that were
+// missing but were made while we ran, e.g. by hand or by a sync, rather
+// than leaving it for the next run.  The failures for those dests are
+// taken back.
+func fileWaiting(config *Config, opts fileinbox.ParseOptions, force, dryRun bool, fr *fileResult) error {
+	made := map[string]bool{}
+	for dir := range fr.missingDirs {
+		if isDir(dir) {
+			made[dir] = true
+			delete(fr.missingDirs, dir)
 		}
-		if to != nil {
-			closeError := to.Close()
-			if err == nil {
-				err = closeError
+	}
+	if len(made) == 0 {
+		return nil
+	}
+	kept := fr.failures[:0]
+	for _, f := range fr.failures {
+		if f.Category == failMissingDir && made[f.Path] {
+			fr.failureCount--
+			continue
+		}
+		kept = append(kept, f)
+	}
+	fr.failures = kept
+
+	// by the directory they are in, in the order they were found
+	var dirs []string
+	ready := map[string][]waitingFile{}
+	var still []waitingFile
+	for _, w := range fr.waiting {
+		if config.missingWaiting(w, fr) {
+			still = append(still, w)
+			continue
+		}
+		if _, ok := ready[w.dir]; !ok {
+			dirs = append(dirs, w.dir)
+		}
+		ready[w.dir] = append(ready[w.dir], w)
+	}
+	fr.waiting = still
+	for _, dir := range dirs {
+		var infos []os.FileInfo
+		for _, w := range ready[dir] {
+			fr.skippedCount--
+			fr.skippedBytes -= w.size
+			fi, err := os.Lstat(path.Join(dir, w.name))
+			if err != nil {
+				// gone while we ran
+				continue
 			}
+			infos = append(infos, fi)
 		}
-	}()
+		if len(infos) == 0 {
+			continue
+		}
+		printf(progress, stylePlain, "Filing %s left in %s for dests made since\n", plural(uint32(len(infos)), "file", "files"), dir)
+		if err := processChunk(dir, infos, nil, config, opts, ready[dir][0].opts, force, dryRun, fr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	from, err = os.Open(src)
-	if err != nil {
-		return err
+// missingWaiting returns true if a dest w needs is still missing.
+func (c *Config) missingWaiting(w waitingFile, fr *fileResult) bool {
+	for _, d := range w.dests {
+		if fr.missingDirs[d] {
+			return true
+		}
 	}
-	to, err = os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
-	if err != nil {
-		return err
+	return false
+}
+
+// cc returns where to mirror parsed, which is filed in bucket, or "" if
+// its dest isn't mirrored.  The mirror is laid out like the archive.
+func cc(config *Config, bucket string, parsed *parsedName) string {
+	dest := config.ccDest(parsed.dest)
+	if dest == "" {
+		return ""
 	}
-	_, err = io.Copy(to, from)
-	return err
+	return path.Join(dest, bucket, parsed.filedName())
 }
 
-func organize(force bool, destDir string, years []string) (cnt uint32, err error) {
+func organize(opts fileinbox.ParseOptions, destDir string, years []string, buckets *bucketer, im *immutability, p perms) (cnt uint32, err error) {
 	start := time.Now()
 
 	dirsHave := map[string]bool{}
-	filesHave := []string{}
+	filesHave := []os.FileInfo{}
 	children, err := ioutil.ReadDir(destDir)
 	if err != nil {
 		return cnt, errors.Wrap(err, "ReadDir")
@@ -400,7 +1699,7 @@ func organize(force bool, destDir string, years []string) (cnt uint32, err error
 		if c.IsDir() {
 			dirsHave[name] = true
 		} else {
-			filesHave = append(filesHave, name)
+			filesHave = append(filesHave, c)
 		}
 	}
 
@@ -408,83 +1707,60 @@ func organize(force bool, destDir string, years []string) (cnt uint32, err error
 	// it often will be a nop (the directory will often already exists)
 	tasks := len(filesHave)
 
-	for i, f := range filesHave {
+	for i, fi := range filesHave {
+		f := fi.Name()
 		var parsed *parsedName
-		parsed, err = parseFileName(force, f)
+		parsed, err = parseFileName(opts, f)
 		if err != nil {
 			return cnt, errors.Wrap(err, "organize")
 		}
-		if err = ensureHave(destDir, parsed.year, &dirsHave); err != nil {
-			return cnt, errors.Wrap(err, "organize")
+		bucket := buckets.dir(parsed.year, parsed.month, fi.Size())
+		if bucket == "" {
+			// a flat dest, where it already is
+			continue
 		}
 		oldPath := path.Join(destDir, f)
-		newPath := path.Join(destDir, parsed.year, f)
-		err = move(oldPath, newPath)
+		newPath := path.Join(destDir, bucket, f)
+		if err = im.unlock(destDir, path.Dir(newPath)); err != nil {
+			return cnt, errors.Wrap(err, "organize")
+		}
+		if buckets.yearDirs() {
+			if err = ensureHave(destDir, parsed.year, &dirsHave, p); err != nil {
+				return cnt, errors.Wrap(err, "organize")
+			}
+		}
+		if err = ensureHave(destDir, bucket, &dirsHave, p); err != nil {
+			return cnt, errors.Wrap(err, "organize")
+		}
+		_, err = fileinbox.MoveFile(oldPath, newPath)
 		if err != nil {
 			return cnt, errors.Wrapf(err, "organizing %q", oldPath)
 		}
+		if err = im.lock(newPath); err != nil {
+			return cnt, errors.Wrap(err, "organize")
+		}
 		cnt++
-		fmt.Printf("(%d/%d) organizing %s\r", i+1, tasks, destDir)
+		fmt.Fprintf(progress, "(%d/%d) organizing %s\r", i+1, tasks, destDir)
 	}
 
 	for _, y := range years {
-		if err = ensureHave(destDir, y, &dirsHave); err != nil {
+		if err = ensureHave(destDir, y, &dirsHave, p); err != nil {
 			return cnt, errors.Wrap(err, "organize")
 		}
 	}
 
 	if tasks != 0 {
-		fmt.Printf("Organized %s in %s\n", destDir, time.Since(start))
+		fmt.Fprintf(progress, "Organized %s in %s\n", destDir, formatDuration(time.Since(start)))
 	}
 
 	return cnt, nil
 }
 
-func move(fromName, toName string) (err error) {
-	err = os.Rename(fromName, toName)
-	if err == nil {
-		return nil
-	}
-	if _, ok := err.(*os.LinkError); !ok {
-		return err
-	}
-
-	var from, to *os.File
-	defer func() {
-		if from != nil {
-			from.Close()
-		}
-		if to != nil {
-			closeError := to.Close()
-			if err == nil {
-				err = closeError
-			}
-		}
-
-		if err == nil {
-			err = os.Remove(fromName)
-		} else {
-			os.Remove(toName)
-		}
-	}()
-
-	from, err = os.Open(fromName)
-	if err != nil {
-		return err
-	}
-	to, err = os.OpenFile(toName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(to, from)
-	return err
-}
-
-func ensureHave(destDir string, year string, dirsHave *map[string]bool) error {
+func ensureHave(destDir string, year string, dirsHave *map[string]bool, p perms) error {
 	if (*dirsHave)[year] {
 		return nil
 	}
-	if err := os.Mkdir(path.Join(destDir, year), 0700); err != nil {
+	if err := p.mkdirAll(path.Join(destDir, year)); err != nil {
 		return errors.Wrap(err, "ensureHave")
 	}
 	(*dirsHave)[year] = true
@@ -492,15 +1768,34 @@ func ensureHave(destDir string, year string, dirsHave *map[string]bool) error {
 }
 
 func doFile(ctx *cli.Context) error {
+	if ctx.Bool(savePlanFlag) && !ctx.Bool(dryRunFlag) {
+		return errors.Errorf("--%s only goes with --%s", savePlanFlag, dryRunFlag)
+	}
+	if ctx.Bool(applyLastPlanFlag) {
+		if ctx.Bool(dryRunFlag) {
+			return errors.Errorf("--%s can't be a --%s", applyLastPlanFlag, dryRunFlag)
+		}
+		return doApplyLastPlan(ctx)
+	}
+	output := ctx.String(outputFlag)
+	startOutput(output)
+	report := startSummaryOnly(ctx)
+
 	start := time.Now()
 	fr, err := doFileInner(ctx)
 	duration := time.Since(start)
-	summarizeErr := fr.summarize(duration)
+	summarizeErr := fr.summarizeIfAny(ctx, output, duration, err)
+	if name := ctx.String(metricsFlag); name != "" && !ctx.Bool(dryRunFlag) {
+		if metricsErr := fr.writeMetrics(name, duration, err); metricsErr != nil {
+			printf(report, styleFailure, "\n\nUnable to write metrics to %q: %v\n", name, metricsErr)
+			summarizeErr = anyError(summarizeErr, metricsErr)
+		}
+	}
 	if err != nil {
-		fmt.Printf("\n\nError: %+v\n", err)
+		printf(report, styleFailure, "\n\nError (%s): %v\n", failCategory(failRun, err), err)
 	}
 	if anyError(err, summarizeErr) != nil {
-		fmt.Printf("\\n\n**** Look above for error(s) ***\n")
+		printf(report, styleFailure, "\\n\n**** Look above for error(s) ***\n")
 		os.Exit(1)
 	}
 	return nil
@@ -516,59 +1811,62 @@ func anyError(errs ...error) error {
 }
 
 type parsedName struct {
-	baseName string // e.g. 20160825_pge_taxes2016.pdf
-	year     string // e.g. 2016
-	month    string // e.g. 08
-	date     string // e.g. 25
-	dest     string // e.g. pge
+	baseName string   // e.g. 20160825_pge_taxes2016.pdf
+	year     string   // e.g. 2016
+	month    string   // e.g. 08
+	date     string   // e.g. 25
+	dest     string   // e.g. pge
+	also     []string // e.g. [tax] for 20240101_pge+tax.pdf, dests that get a copy
+	tags     []string // set by config rules, see Rule.Tags
+	size     int64
+	newName  string // e.g. 20160801_payslip.pdf, if we rename while filing
+
+	ambiguous bool // the day and month could be swapped, see Config.DateOrder
 }
 
-var fileRe = regexp.MustCompile(`^(\d\d\d\d)(\d\d)(\d\d)_([^_.]+).*$`)
-
-func parseFileName(force bool, baseName string) (*parsedName, error) {
-	matches := fileRe.FindStringSubmatch(baseName)
-	if matches == nil || len(matches) != 5 {
-		return nil, fmt.Errorf("unable to parse %q.  We expect an 8 digit value like 20160825_pge_taxes2016.pdf or 20160825_pge.pdf", baseName)
-	}
-	year, month, date, dest := matches[1], matches[2], matches[3], matches[4]
-
-	yearVal, err := yearTest.verify(year)
-	if err != nil {
-		return nil, err
-	}
-	yearDiff := yearVal - time.Now().Year()
-	if !force && yearDiff > 2 {
-		return nil, fmt.Errorf("%s is %d years in the future, which is highly suspect.  To continue, set the --force flag", baseName, yearDiff)
-	}
-	if _, err := monthTest.verify(month); err != nil {
-		return nil, err
+// filedName is the name the file will have once it is filed.
+func (p *parsedName) filedName() string {
+	if p.newName != "" {
+		return p.newName
 	}
-	if _, err := dateTest.verify(date); err != nil {
-		return nil, err
-	}
-
-	return &parsedName{baseName, year, month, date, dest}, nil
+	return p.baseName
 }
 
-var (
-	yearTest  = unitTest{1, 9999, "year"}
-	monthTest = unitTest{1, 12, "month"}
-	dateTest  = unitTest{1, 31, "date"}
-)
+func (p *parsedName) setDate(t time.Time) {
+	p.year = fmt.Sprintf("%04d", t.Year())
+	p.month = fmt.Sprintf("%02d", t.Month())
+	p.date = fmt.Sprintf("%02d", t.Day())
+}
 
-type unitTest struct {
-	min  int
-	max  int
-	unit string
+// parseOptions returns the options for parsing names, taking the
+// config and --force into account.  Names in the archive always have
+// their dates as YYYYMMDD, so DateOrder is left out here and only
+// applied to inboxes, by inboxOptions.
+func (c *Config) parseOptions(force bool) fileinbox.ParseOptions {
+	opts := fileinbox.DefaultParseOptions()
+	if force {
+		opts.FutureYears = -1
+	} else {
+		opts.DestFuture = c.destFuture()
+	}
+	opts.Patterns = c.patterns
+	opts.StripPrefixes = c.prefixes
+	opts.Layouts = c.layouts
+	opts.PatternPacks = c.PatternPacks
+	opts.Normalize = c.Normalize
+	opts.Aliases = c.Aliases
+	opts.DestSeparator = c.DestSeparator
+	opts.Clock = clock
+	opts.Location = c.location
+	return opts
 }
 
-func (ut unitTest) verify(s string) (i int, err error) {
-	i, err = strconv.Atoi(s)
+func parseFileName(opts fileinbox.ParseOptions, baseName string) (*parsedName, error) {
+	p, err := fileinbox.ParseFileName(baseName, opts)
 	if err != nil {
-		return 0, err
-	}
-	if i < ut.min || i > ut.max {
-		return 0, fmt.Errorf("unexpected %s %q.  We expect a value between %d and %d", ut.unit, s, ut.min, ut.max)
+		return nil, err
 	}
-	return i, nil
+	parsed := &parsedName{baseName: baseName, dest: p.Dest, also: p.Also, newName: p.CanonicalName, ambiguous: p.Ambiguous}
+	parsed.setDate(p.Date)
+	return parsed, nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// writeMetrics writes the results of a run in the Prometheus text format,
+// for node_exporter's textfile collector to pick up.  The file is
+// replaced atomically so the collector never sees half of it.
+func (fr fileResult) writeMetrics(name string, duration time.Duration, runErr error) error {
+	success := 1
+	if runErr != nil || fr.failureCount != 0 {
+		success = 0
+	}
+
+	var b bytes.Buffer
+	metric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	metric("fileinbox_last_run_timestamp_seconds", "When the last run finished.", clock.Now().Unix())
+	metric("fileinbox_last_run_success", "1 if the last run filed everything it found, 0 otherwise.", success)
+	metric("fileinbox_last_run_duration_seconds", "How long the last run took.", duration.Seconds())
+	metric("fileinbox_last_run_filed_files", "Files filed by the last run.", fr.okCount)
+	metric("fileinbox_last_run_retried_files", "Files the last run only filed when it retried them.", fr.retriedCount)
+	metric("fileinbox_last_run_filed_bytes", "Bytes filed by the last run.", fr.movedBytes)
+	metric("fileinbox_last_run_copied_bytes", "Bytes the last run had to copy across devices.", fr.copiedBytes)
+	metric("fileinbox_last_run_cc_bytes", "Bytes the last run mirrored to CC.", fr.ccBytes)
+	metric("fileinbox_last_run_organized_dirs", "Directories organized by the last run.", fr.orgCount)
+	metric("fileinbox_last_run_up_to_date_dests", "Dests the last run didn't need to organize.", fr.orgUpToDate)
+	metric("fileinbox_last_run_organize_duration_seconds", "How long the last run spent organizing.", fr.orgDuration.Seconds())
+	held := 0
+	for _, n := range fr.held {
+		held += n
+	}
+	metric("fileinbox_backlog_files", "Files left in the inboxes after the last run.", int(fr.skippedCount)+held)
+	metric("fileinbox_backlog_bytes", "Bytes left in the inboxes after the last run.", fr.skippedBytes+fr.heldBytes)
+	metric("fileinbox_held_files", "Files left in the inboxes for review.", held)
+	metric("fileinbox_last_run_quarantined_files", "Files the last run quarantined, as they were broken or not what their names said.", fr.quarantined)
+	metric("fileinbox_last_run_ignored_files", "Temporary files the last run left in the inboxes.", len(fr.ignored))
+	metric("fileinbox_missing_dirs", "Dest directories that need to be created.", len(fr.missingDirs))
+
+	return writeFileAtomic(name, b.Bytes(), 0644)
+}
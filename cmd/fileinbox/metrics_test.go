@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	name := path.Join(dir, "fileinbox.prom")
+
+	// a missing dir is one failure however many files it leaves behind,
+	// so the backlog counts the files themselves, held ones included
+	fr := fileResult{okCount: 3, failureCount: 2, skippedCount: 3, skippedBytes: 2048, movedBytes: 4096, missingDirs: map[string]bool{"a": true},
+		held: map[string]int{"medical": 1}, heldBytes: 100}
+	ok(t, fr.writeMetrics(name, 1500*time.Millisecond, nil))
+	got, err := ioutil.ReadFile(name)
+	ok(t, err)
+	for _, line := range []string{
+		"fileinbox_last_run_success 0",
+		"fileinbox_last_run_duration_seconds 1.5",
+		"fileinbox_last_run_filed_files 3",
+		"fileinbox_last_run_filed_bytes 4096",
+		"fileinbox_backlog_files 4",
+		"fileinbox_backlog_bytes 2148",
+		"fileinbox_missing_dirs 1",
+		"# TYPE fileinbox_backlog_files gauge",
+	} {
+		assert(t, strings.Contains(string(got), line+"\n"), "expected %q in\n%s", line, got)
+	}
+
+	fr = fileResult{okCount: 1}
+	ok(t, fr.writeMetrics(name, time.Second, nil))
+	got, err = ioutil.ReadFile(name)
+	ok(t, err)
+	assert(t, strings.Contains(string(got), "fileinbox_last_run_success 1\n"), "expected success in\n%s", got)
+
+	ok(t, fr.writeMetrics(name, time.Second, errors.New("boom")))
+	got, err = ioutil.ReadFile(name)
+	ok(t, err)
+	assert(t, strings.Contains(string(got), "fileinbox_last_run_success 0\n"), "expected failure in\n%s", got)
+
+	// only the metrics file is left behind
+	infos, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	equals(t, 1, len(infos))
+}
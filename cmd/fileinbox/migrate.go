@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	toFlag      string = "to"
+	abandonFlag string = "abandon"
+
+	// migrationFile is the checkpoint of a migrate-layout run, so one
+	// that is interrupted can carry on where it left off.
+	migrationFile = ".fileinbox-migration.json"
+
+	// checkpointEvery is how many moves go by between checkpoints.
+	checkpointEvery = 100
+)
+
+// migration moves the documents of some dests into a new layout.  The
+// moves are all worked out before any is made, and Done counts how many
+// have been, so a migration can be resumed.
+type migration struct {
+	To    string           `json:"to"`
+	Dests []string         `json:"dests"`
+	Moves []fileinbox.Move `json:"moves"`
+	Done  int              `json:"done"`
+}
+
+// planMigration works out how to lay out dests as to says, one of the
+// dirs constants.  Documents of nested dests are left to them.
+func planMigration(config *Config, opts fileinbox.ParseOptions, dests []string, to string) (*migration, error) {
+	m := &migration{To: to, Dests: dests}
+	for _, dest := range dests {
+		dc := config.Dests[dest]
+		if dc.MaxFiles > 0 || dc.MaxBytes > 0 {
+			return nil, errors.Errorf("dest %s has maxfiles or maxbytes, which migrate-layout can't keep to", dest)
+		}
+		if !isDir(config.dest(dest)) {
+			return nil, errors.Errorf("there is no dest %q", dest)
+		}
+		docs, err := findFiled(config, opts, dest)
+		if err != nil {
+			return nil, err
+		}
+		dc.Dirs = to
+		buckets := newBucketer(config.dest(dest), dc)
+		taken := map[string]string{}
+		for _, d := range docs {
+			if d.dest != dest {
+				continue
+			}
+			base := path.Base(d.path)
+			target := path.Join(config.dest(dest), buckets.dir(d.date.Format("2006"), d.date.Format("01"), d.size), base)
+			if other, ok := taken[target]; ok {
+				return nil, errors.Errorf("%s and %s would both be moved to %s", other, d.path, target)
+			}
+			taken[target] = d.path
+			if target != d.path {
+				m.Moves = append(m.Moves, fileinbox.Move{From: d.path, To: target})
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *migration) same(to string, dests []string) bool {
+	if m.To != to || len(m.Dests) != len(dests) {
+		return false
+	}
+	for i := range dests {
+		if m.Dests[i] != dests[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func readMigration(name string) (*migration, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &migration{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	return m, nil
+}
+
+func (m *migration) checkpoint(name string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data, 0600)
+}
+
+// run makes the moves that haven't been made yet, checkpointing to name
+// as it goes.
+func (m *migration) run(config *Config, name string) error {
+	if err := m.checkpoint(name); err != nil {
+		return err
+	}
+	for m.Done < len(m.Moves) {
+		mv := m.Moves[m.Done]
+		if _, err := os.Lstat(mv.From); os.IsNotExist(err) {
+			// moved before we were interrupted, but after the last
+			// checkpoint
+			if _, err := os.Lstat(mv.To); err != nil {
+				return errors.Errorf("%s is gone, and is not at %s either", mv.From, mv.To)
+			}
+		} else {
+			if err := config.perms.mkdirAll(path.Dir(mv.To)); err != nil {
+				return errors.Wrapf(err, "creating %s", path.Dir(mv.To))
+			}
+			if _, err := os.Lstat(mv.To); err == nil {
+				return errors.Errorf("%s is in the way of %s", mv.To, mv.From)
+			}
+			if _, err := fileinbox.MoveFile(mv.From, mv.To); err != nil {
+				return errors.Wrapf(err, "moving %s", mv.From)
+			}
+			removeEmptyDirs(path.Dir(mv.From), config.filed())
+		}
+		m.Done++
+		if m.Done%checkpointEvery == 0 {
+			if err := m.checkpoint(name); err != nil {
+				return err
+			}
+		}
+		printf(progress, stylePlain, "(%d/%d) migrating\r", m.Done, len(m.Moves))
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir, and then its parents, while they are
+// empty, stopping at filed.
+func removeEmptyDirs(dir, filed string) {
+	for ; strings.HasPrefix(dir, filed+"/"); dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+func doMigrateLayout(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "migrate-layout")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "migrate-layout")
+	}
+	name := path.Join(config.Root, migrationFile)
+	if ctx.Bool(abandonFlag) {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "migrate-layout")
+		}
+		printf(progress, styleNotice, "Abandoned the unfinished migration.  Documents already moved stay where they are.\n")
+		return nil
+	}
+
+	to := ctx.String(toFlag)
+	switch to {
+	case dirsYear, dirsMonth, dirsQuarter, dirsFlat, dirsFiscal:
+	default:
+		return errors.Errorf("migrate-layout: --%s must be %s, %s, %s, %s or %s", toFlag, dirsYear, dirsMonth, dirsQuarter, dirsFlat, dirsFiscal)
+	}
+	var dests []string
+	for _, d := range ctx.Args().Slice() {
+		dests = append(dests, opts.ResolveDest(d))
+	}
+	if len(dests) == 0 {
+		return errors.New("migrate-layout: name the dests to migrate")
+	}
+	sort.Strings(dests)
+	for _, d := range dests {
+		if err := config.frozen(d); err != nil {
+			return errors.Wrap(err, "migrate-layout")
+		}
+	}
+	if config.Immutable {
+		return errors.Errorf("migrate-layout: filed documents are immutable.  Run fileinbox immutable lift first, and immutable restore once done")
+	}
+
+	m, err := readMigration(name)
+	if err != nil {
+		return errors.Wrap(err, "migrate-layout")
+	}
+	if m != nil && !m.same(to, dests) {
+		return errors.Errorf("migrate-layout: a migration of %v to %s is unfinished.  Run it again to finish it, or use --%s", m.Dests, m.To, abandonFlag)
+	}
+	if m != nil {
+		printf(progress, styleNotice, "Resuming the migration, %d of %d moves were done\n", m.Done, len(m.Moves))
+	} else if m, err = planMigration(config, opts, dests, to); err != nil {
+		return errors.Wrap(err, "migrate-layout")
+	}
+
+	if ctx.Bool(dryRunFlag) {
+		for _, mv := range m.Moves[m.Done:] {
+			printf(progress, stylePlain, "Would move %s to %s\n", mv.From, mv.To)
+		}
+		return nil
+	}
+	if err := m.run(config, name); err != nil {
+		if cpErr := m.checkpoint(name); cpErr != nil {
+			printf(progress, styleFailure, "Unable to save progress: %v\n", cpErr)
+		}
+		return errors.Wrapf(err, "migrate-layout, run it again to carry on")
+	}
+
+	// from now on, organize keeps to the new layout
+	err = config.update(func(c *Config) {
+		if c.Dests == nil {
+			c.Dests = map[string]DestConfig{}
+		}
+		for _, d := range dests {
+			dc := c.Dests[d]
+			dc.Dirs = to
+			if to == dirsYear {
+				dc.Dirs = ""
+			}
+			c.Dests[d] = dc
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "migrate-layout, saving the layout in the config")
+	}
+	if err := os.Remove(name); err != nil {
+		return errors.Wrap(err, "migrate-layout")
+	}
+	printf(progress, styleSuccess, "\nMoved %s documents into the %s layout\n", formatCount(int64(len(m.Moves))), to)
+	return nil
+}
+
+func migrateLayoutCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "migrate-layout",
+		Usage:        "Move the documents of dests into another layout, and save it in the config.  An interrupted migration picks up where it left off when run again.",
+		ArgsUsage:    "<dest>...",
+		Action:       doMigrateLayout,
+		BashComplete: completeDests,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  toFlag,
+				Usage: "The layout to move to: year for 2016/, month for 2016/08/, quarter for 2016/Q3/, fiscal for FY2015-16/ or flat.",
+			},
+			&cli.BoolFlag{
+				Name:  abandonFlag,
+				Usage: "Forget an unfinished migration, leaving documents where they are.",
+			},
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	start := []string{
+		"filed/pge/2015/20150825_pge.pdf",
+		"filed/pge/2016/20160102_pge.pdf",
+		"filed/pge/2016b/20160825_pge.pdf",
+		"filed/pge/solar/2016/20160301_pge-solar.pdf",
+		"filed/bank/2016/20160101_bank.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/bank/",
+		"filed/bank/2016/",
+		"filed/bank/2016/20160101_bank.pdf",
+		"filed/pge/",
+		"filed/pge/2015/",
+		"filed/pge/2015/08/",
+		"filed/pge/2015/08/20150825_pge.pdf",
+		"filed/pge/2016/",
+		"filed/pge/2016/01/",
+		"filed/pge/2016/01/20160102_pge.pdf",
+		"filed/pge/2016/08/",
+		"filed/pge/2016/08/20160825_pge.pdf",
+		"filed/pge/solar/",
+		"filed/pge/solar/2016/",
+		"filed/pge/solar/2016/20160301_pge-solar.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	ok(t, writeRootMarker(root))
+	defer os.Remove(path.Join(root, rootMarker))
+	migrate := func(to string, dests ...string) error {
+		args := []string{"file_inbox", flagify(skipConfigFlag), flagify(rootFlag), root, "migrate-layout", flagify(toFlag), to}
+		return newCli().Run(append(args, dests...))
+	}
+
+	// pretend the first move was made just before we were interrupted
+	config := &Config{Root: root, DestSeparator: "-"}
+	ok(t, config.validate())
+	m, err := planMigration(config, config.parseOptions(true), []string{"pge"}, dirsMonth)
+	ok(t, err)
+	equals(t, 3, len(m.Moves))
+	name := path.Join(root, migrationFile)
+	ok(t, m.checkpoint(name))
+	ok(t, os.MkdirAll(path.Dir(m.Moves[0].To), 0700))
+	_, err = fileinbox.MoveFile(m.Moves[0].From, m.Moves[0].To)
+	ok(t, err)
+
+	assert(t, migrate(dirsFlat, "pge") != nil, "expected a different migration to be refused while one is unfinished")
+	ok(t, migrate(dirsMonth, "pge"))
+	_, err = os.Stat(name)
+	assert(t, os.IsNotExist(err), "expected the checkpoint to be gone once done")
+
+	os.Remove(path.Join(root, rootMarker))
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+	ok(t, writeRootMarker(root))
+
+	// and back again, by way of flat
+	ok(t, migrate(dirsFlat, "pge"))
+	_, err = os.Stat(path.Join(root, "filed/pge/20160825_pge.pdf"))
+	ok(t, err)
+	ok(t, migrate(dirsYear, "pge"))
+	_, err = os.Stat(path.Join(root, "filed/pge/2016/20160825_pge.pdf"))
+	ok(t, err)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMQTTTopic is the topic prefix when MQTTConfig.Topic isn't set.
+const defaultMQTTTopic = "fileinbox"
+
+// mqttTimeout bounds talking to the broker, so a broker that has gone
+// away can't hold up filing.
+const mqttTimeout = 10 * time.Second
+
+// Notifications tell other systems what filing did.
+type Notifications struct {
+	MQTT *MQTTConfig
+}
+
+// MQTTConfig publishes to an MQTT broker, e.g. for Home Assistant to
+// announce that documents were filed.  Each file's events go to
+// <topic>/file as they happen, and how the run went goes to
+// <topic>/run once it is done, retained with Retain.  For example
+//
+//	notifications:
+//	  mqtt:
+//	    broker: homeassistant.local:1883
+//	    username: fileinbox
+//	    password: secret
+//
+// Messages are JSON, and published at most once.
+type MQTTConfig struct {
+	// Broker is host:port.  With TLS, the broker must have a
+	// certificate we trust.
+	Broker   string
+	TLS      bool
+	Username string
+	Password string
+	// ClientID is fileinbox-<hostname>-<pid> if not set, so runs at the
+	// same time don't hang each other up.
+	ClientID string
+	// Topic is the prefix of the topics we publish on, fileinbox if not
+	// set.
+	Topic  string
+	Retain bool
+}
+
+func (m *MQTTConfig) validate() error {
+	if m.Broker == "" {
+		return errors.New("mqtt needs a broker")
+	}
+	if _, _, err := net.SplitHostPort(m.Broker); err != nil {
+		return errors.Wrapf(err, "mqtt broker %q should be host:port", m.Broker)
+	}
+	if m.Password != "" && m.Username == "" {
+		// MQTT 3.1.1 only allows a password along with a username
+		return errors.New("mqtt has a password, but no username")
+	}
+	if strings.ContainsAny(m.Topic, "+#") {
+		return errors.Errorf("mqtt topic %q can't hold + or #", m.Topic)
+	}
+	return nil
+}
+
+func (m *MQTTConfig) topic(sub string) string {
+	prefix := strings.TrimSuffix(m.Topic, "/")
+	if prefix == "" {
+		prefix = defaultMQTTTopic
+	}
+	return prefix + "/" + sub
+}
+
+// mqttRun is what we publish on <topic>/run.
+type mqttRun struct {
+	Time      time.Time `json:"time"`
+	Filed     uint32    `json:"filed"`
+	Failures  uint32    `json:"failures"`
+	Attention uint32    `json:"attention"`
+	Seconds   float64   `json:"seconds"`
+	Message   string    `json:"message"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func newMQTTRun(fr fileResult, duration time.Duration, runErr error) mqttRun {
+	r := mqttRun{
+		Time:      time.Now(),
+		Filed:     fr.okCount,
+		Failures:  fr.failureCount,
+		Attention: fr.failureCount + fr.quarantined + uint32(len(fr.unlocked)),
+		Seconds:   duration.Seconds(),
+	}
+	for _, n := range fr.held {
+		r.Attention += uint32(n)
+	}
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+	r.Message = fmt.Sprintf("%s filed", plural(r.Filed, "document", "documents"))
+	if r.Attention != 0 {
+		r.Message += fmt.Sprintf(", %s", plural(r.Attention, "needs attention", "need attention"))
+	}
+	return r
+}
+
+// plural returns e.g. "1 document" or "3 documents".
+func plural(n uint32, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return formatCount(int64(n)) + " " + many
+}
+
+// mqttNotifier publishes a run's events as they happen, and then the
+// run's result.
+type mqttNotifier struct {
+	config *MQTTConfig
+	client *mqttClient
+	sub    chan fileEvent
+	done   chan bool
+}
+
+// startMQTT connects to the broker and starts publishing events.  It
+// returns nil, after saying why, if the broker can't be reached; filing
+// carries on regardless.
+func startMQTT(config *MQTTConfig) *mqttNotifier {
+	client, err := dialMQTT(config)
+	if err != nil {
+		printf(progress, styleNotice, "Unable to reach the mqtt broker %s, nothing will be published: %v\n", config.Broker, err)
+		return nil
+	}
+	n := &mqttNotifier{config: config, client: client, sub: events.subscribe(), done: make(chan bool)}
+	go func() {
+		defer close(n.done)
+		topic := config.topic("file")
+		for e := range n.sub {
+			data, _ := json.Marshal(e)
+			if err := client.publish(topic, data, false); err != nil {
+				printf(progress, styleNotice, "Unable to publish to mqtt: %v\n", err)
+				// keep draining, so we don't fall behind the bus
+				for range n.sub {
+				}
+				return
+			}
+		}
+	}()
+	return n
+}
+
+// finish publishes how the run went, and hangs up.
+func (n *mqttNotifier) finish(fr fileResult, duration time.Duration, runErr error) {
+	events.unsubscribe(n.sub)
+	close(n.sub)
+	<-n.done
+	defer n.client.close()
+	data, _ := json.Marshal(newMQTTRun(fr, duration, runErr))
+	if err := n.client.publish(n.config.topic("run"), data, n.config.Retain); err != nil {
+		printf(progress, styleNotice, "Unable to publish to mqtt: %v\n", err)
+	}
+}
+
+// mqttClient speaks just enough MQTT 3.1.1 to publish at QoS 0.
+type mqttClient struct {
+	conn net.Conn
+}
+
+// MQTT control packet types, shifted into the high nibble.
+const (
+	mqttConnect    = 1 << 4
+	mqttConnack    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttDisconnect = 14 << 4
+)
+
+// mqttRefused are the reasons a broker gives for refusing to connect.
+var mqttRefused = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client id rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+func dialMQTT(config *MQTTConfig) (*mqttClient, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	if config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", config.Broker, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", config.Broker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttClient{conn: conn}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = fmt.Sprintf("fileinbox-%s-%d", host, os.Getpid())
+	}
+	var flags byte = 0x02 // clean session
+	payload := mqttString(clientID)
+	if config.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(config.Username)...)
+	}
+	if config.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(config.Password)...)
+	}
+	// level 4, and no keep alive, as nothing pings the broker while a
+	// long run is quiet
+	body := append(mqttString("MQTT"), 4, flags, 0, 0)
+	body = append(body, payload...)
+	if err := c.send(mqttConnect, body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ack := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(mqttTimeout))
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "waiting for the broker to accept us")
+	}
+	if ack[0] != mqttConnack || ack[1] != 2 {
+		conn.Close()
+		return nil, errors.New("the broker didn't answer as MQTT 3.1.1")
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		reason, ok := mqttRefused[ack[3]]
+		if !ok {
+			reason = fmt.Sprintf("code %d", ack[3])
+		}
+		return nil, errors.Errorf("the broker refused us: %s", reason)
+	}
+	return c, nil
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	var header byte = mqttPublish
+	if retain {
+		header |= 0x01
+	}
+	return c.send(header, append(mqttString(topic), payload...))
+}
+
+func (c *mqttClient) close() error {
+	c.send(mqttDisconnect, nil)
+	return c.conn.Close()
+}
+
+// send writes a packet: its header, the length of body, then body.
+func (c *mqttClient) send(header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	c.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// mqttString is s as MQTT encodes strings: its length, then s.
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
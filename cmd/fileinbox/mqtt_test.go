@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// mqttPacket is a packet our fake broker got.
+type mqttPacket struct {
+	header byte
+	body   []byte
+}
+
+// fakeBroker accepts one client, acks its connect, and passes on what
+// it is sent.
+func fakeBroker(l net.Listener, refuse byte) chan mqttPacket {
+	got := make(chan mqttPacket, 20)
+	go func() {
+		defer close(got)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			header, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			n, mult := 0, 1
+			for {
+				b, err := r.ReadByte()
+				if err != nil {
+					return
+				}
+				n += int(b&0x7f) * mult
+				mult *= 128
+				if b&0x80 == 0 {
+					break
+				}
+			}
+			body := make([]byte, n)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			if header == mqttConnect {
+				conn.Write([]byte{mqttConnack, 2, 0, refuse})
+			}
+			got <- mqttPacket{header, body}
+		}
+	}()
+	return got
+}
+
+func TestMQTT(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "inbox/20160825_pge.pdf", "inbox/notes.txt"})
+	defer func() { configFile = "" }()
+	configFile = path.Join(root, "fileinbox.yaml")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	defer l.Close()
+	got := fakeBroker(l, 0)
+
+	yaml := "root: " + root + "\nextrainboxes: [" + path.Join(root, "inbox") + "]\n" +
+		"notifications:\n  mqtt:\n    broker: " + l.Addr().String() + "\n    username: me\n    topic: home/docs\n    retain: true\n"
+	ok(t, ioutil.WriteFile(configFile, []byte(yaml), 0600))
+	ok(t, writeRootMarker(root))
+
+	app := newCli()
+	app.Action = func(ctx *cli.Context) error {
+		_, err := doFileInner(ctx)
+		return err
+	}
+	ok(t, app.Run([]string{"fileinbox"}))
+
+	var packets []mqttPacket
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case p, more := <-got:
+			if !more {
+				done = true
+				break
+			}
+			packets = append(packets, p)
+		case <-timeout:
+			t.Fatal("expected the broker to be hung up on")
+		}
+	}
+
+	equals(t, byte(mqttConnect), packets[0].header)
+	assert(t, packets[0].body[7]&0x80 != 0, "expected a username")
+	equals(t, []byte{0, 0}, packets[0].body[8:10]) // no keep alive
+	id := string(packets[0].body[12 : 12+binary.BigEndian.Uint16(packets[0].body[10:])])
+	assert(t, strings.HasSuffix(id, fmt.Sprintf("-%d", os.Getpid())), "expected a client id of its own for the run, got %q", id)
+	equals(t, byte(mqttDisconnect), packets[len(packets)-1].header)
+
+	topics := map[string][]string{}
+	var run mqttRun
+	for _, p := range packets[1 : len(packets)-1] {
+		n := binary.BigEndian.Uint16(p.body)
+		topic, payload := string(p.body[2:2+n]), p.body[2+n:]
+		if topic == "home/docs/run" {
+			equals(t, byte(mqttPublish|0x01), p.header)
+			ok(t, json.Unmarshal(payload, &run))
+			continue
+		}
+		var e fileEvent
+		ok(t, json.Unmarshal(payload, &e))
+		topics[topic] = append(topics[topic], e.Event)
+	}
+	equals(t, map[string][]string{"home/docs/file": {eventDetected, eventFailed, eventPlanned, eventFiled}}, topics)
+	equals(t, "1 document filed, 1 needs attention", run.Message)
+
+	// a broker that won't have us says why
+	fakeBroker(l, 4)
+	_, err = dialMQTT(&MQTTConfig{Broker: l.Addr().String()})
+	assert(t, err != nil && strings.Contains(err.Error(), "bad username or password"), "expected to be refused, got %v", err)
+}
+
+func TestMQTTValidate(t *testing.T) {
+	for _, m := range []MQTTConfig{
+		{},
+		{Broker: "nowhere"},
+		{Broker: "localhost:1883", Topic: "home/#"},
+		{Broker: "localhost:1883", Password: "secret"},
+	} {
+		assert(t, m.validate() != nil, "expected %+v to be rejected", m)
+	}
+	m := MQTTConfig{Broker: "localhost:1883"}
+	ok(t, m.validate())
+	equals(t, "fileinbox/run", m.topic("run"))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const yearsFlag string = "years"
+
+const (
+	defaultOffloadYears   = 7
+	defaultOffloadTimeout = time.Hour
+)
+
+// offloadManifest is the stub left in a year directory once its
+// documents are offloaded.  It says what was there, so fetch can check
+// that what comes back is the same.
+const offloadManifest = ".fileinbox-offloaded.json"
+
+// OffloadConfig moves year directories, once they are old enough that
+// they are rarely looked at, out to object storage such as Backblaze B2
+// or S3, leaving a stub manifest in their place.  Upload and Fetch are
+// commands, such as rclone, with {dir} replaced by the year directory
+// and {rel} by its path under filed, e.g. pge/2016:
+//
+//	offload:
+//	  years: 7
+//	  upload: [rclone, copy, "{dir}", "b2:archive/{rel}"]
+//	  fetch: [rclone, copy, "b2:archive/{rel}", "{dir}"]
+//
+// Once Upload succeeds, what it stored is fetched back into a scratch
+// directory and checked against the manifest, and only then are the
+// local copies removed.
+type OffloadConfig struct {
+	// Years is how many years back to keep locally, 7 if not set.  A
+	// year directory more than Years years old is offloaded.
+	Years   int
+	Upload  []string
+	Fetch   []string
+	Timeout time.Duration // for each command, an hour if not set
+}
+
+func (o *OffloadConfig) validate() error {
+	if o.Years < 0 {
+		return errors.Errorf("offload years %d must not be negative", o.Years)
+	}
+	if (len(o.Upload) == 0) != (len(o.Fetch) == 0) {
+		return errors.New("offload needs both an upload and a fetch command")
+	}
+	return nil
+}
+
+// offloadedFile is a document listed in the manifest.
+type offloadedFile struct {
+	Name   string `json:"name"` // relative to the year directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type manifest struct {
+	Offloaded time.Time       `json:"offloaded"`
+	Files     []offloadedFile `json:"files"`
+}
+
+// yearDirYear returns the year that name, a year directory, is for.  A
+// fiscal year counts as the year it ends in.
+func yearDirYear(name string) int {
+	if strings.HasPrefix(name, "FY") {
+		y, _ := strconv.Atoi(name[2:6])
+		return y + 1
+	}
+	y, _ := strconv.Atoi(name[:4])
+	return y
+}
+
+// offloadable returns the year directories under filed, relative to it,
+// that are more than years years old and not yet offloaded.
+func offloadable(config *Config, years int) ([]string, error) {
+	filed := config.filed()
+	thisYear := clock.Now().Year()
+	var rels []string
+	err := config.walkFiled(func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || p == filed || !yearDir.MatchString(info.Name()) {
+			return nil
+		}
+		_, err = os.Stat(path.Join(p, offloadManifest))
+		if thisYear-yearDirYear(info.Name()) > years && os.IsNotExist(err) {
+			rel, err := filepath.Rel(filed, p)
+			if err != nil {
+				return err
+			}
+			rels = append(rels, filepath.ToSlash(rel))
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", filed)
+	}
+	sort.Strings(rels)
+	return rels, nil
+}
+
+// run runs args with {dir} and {rel} filled in.
+func (o *OffloadConfig) run(args []string, dir, rel string) error {
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultOffloadTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	expanded := make([]string, len(args))
+	for i, a := range args {
+		expanded[i] = strings.ReplaceAll(strings.ReplaceAll(a, "{dir}", dir), "{rel}", rel)
+	}
+	cmd := exec.CommandContext(ctx, expanded[0], expanded[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return errors.Wrapf(err, "running %s: %s", expanded[0], msg)
+		}
+		return errors.Wrapf(err, "running %s", expanded[0])
+	}
+	return nil
+}
+
+// offloadYear uploads the year directory rel, relative to filed, then
+// replaces its documents with a manifest of them.  It returns the
+// manifest, which is nil if there was nothing to offload.
+func offloadYear(config *Config, rel string, dryRun bool) (*manifest, error) {
+	dir := path.Join(config.filed(), rel)
+	m := &manifest{Offloaded: clock.Now()}
+	var subdirs []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir {
+				subdirs = append(subdirs, p)
+			}
+			return nil
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, offloadedFile{filepath.ToSlash(name), info.Size(), sum})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", dir)
+	}
+	if len(m.Files) == 0 {
+		return nil, nil
+	}
+	if dryRun {
+		return m, nil
+	}
+
+	if err := config.Offload.run(config.Offload.Upload, dir, rel); err != nil {
+		return nil, errors.Wrapf(err, "uploading %s", rel)
+	}
+	if err := verifyUpload(config, rel, m); err != nil {
+		return nil, errors.Wrapf(err, "checking the upload of %s, so it is kept", rel)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// the manifest goes first, so a removal that fails part way still
+	// leaves a year that can be fetched
+	if err := writeFileAtomic(path.Join(dir, offloadManifest), data, 0644); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if err := os.Remove(path.Join(dir, f.Name)); err != nil {
+			return nil, err
+		}
+	}
+	// deepest first
+	for i := len(subdirs) - 1; i >= 0; i-- {
+		os.Remove(subdirs[i])
+	}
+	return m, nil
+}
+
+// verifyUpload fetches what was uploaded of rel into a scratch
+// directory in the root's workspaceDir, and checks it against m.
+func verifyUpload(config *Config, rel string, m *manifest) error {
+	base := path.Join(config.Root, workspaceDir)
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return err
+	}
+	scratch, err := ioutil.TempDir(base, "offload-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		os.RemoveAll(scratch)
+		// and the base too, unless a run left something there
+		os.Remove(base)
+	}()
+	if err := config.Offload.run(config.Offload.Fetch, scratch, rel); err != nil {
+		return errors.Wrap(err, "fetching it back")
+	}
+	return checkManifest(scratch, m)
+}
+
+// checkManifest checks that everything in m is in dir as it was.
+func checkManifest(dir string, m *manifest) error {
+	for _, f := range m.Files {
+		name := path.Join(dir, f.Name)
+		sum, err := hashFile(name)
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s did not come back", f.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if sum != f.SHA256 {
+			return errors.Errorf("%s came back different from what was offloaded", f.Name)
+		}
+	}
+	return nil
+}
+
+// fetchYear brings back the offloaded year directory rel, relative to
+// filed, checking it against its manifest.  The manifest is only
+// removed once everything in it is back as it was.
+func fetchYear(config *Config, rel string) (*manifest, error) {
+	dir := path.Join(config.filed(), rel)
+	data, err := ioutil.ReadFile(path.Join(dir, offloadManifest))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("%s is not offloaded", rel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "reading the manifest in %s", rel)
+	}
+
+	if err := config.Offload.run(config.Offload.Fetch, dir, rel); err != nil {
+		return nil, errors.Wrapf(err, "fetching %s", rel)
+	}
+	if err := checkManifest(dir, &m); err != nil {
+		return nil, errors.Wrapf(err, "fetching %s", rel)
+	}
+	if err := os.Remove(path.Join(dir, offloadManifest)); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func manifestBytes(m *manifest) int64 {
+	var n int64
+	for _, f := range m.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// offloadConfig reads the config, checking that offloading is set up
+// and that we may change filed documents.
+func offloadConfig(ctx *cli.Context) (*Config, error) {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return nil, err
+	}
+	if len(config.Offload.Upload) == 0 {
+		return nil, errors.New("offload is not configured.  Set offload.upload and offload.fetch in the config")
+	}
+	if config.Immutable {
+		return nil, errors.New("filed documents are immutable.  Run fileinbox immutable lift first, and immutable restore once done")
+	}
+	return config, nil
+}
+
+func doOffload(ctx *cli.Context) error {
+	config, err := offloadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "offload")
+	}
+	years := config.Offload.Years
+	if years == 0 {
+		years = defaultOffloadYears
+	}
+	if ctx.IsSet(yearsFlag) {
+		years = ctx.Int(yearsFlag)
+	}
+	dryRun := ctx.Bool(dryRunFlag)
+	rels, err := offloadable(config, years)
+	if err != nil {
+		return errors.Wrap(err, "offload")
+	}
+
+	var count, docs uint32
+	var size int64
+	for _, rel := range rels {
+		m, err := offloadYear(config, rel, dryRun)
+		if err != nil {
+			return errors.Wrap(err, "offload")
+		}
+		if m == nil {
+			continue
+		}
+		verb := "Offloaded"
+		if dryRun {
+			verb = "Would offload"
+		}
+		printf(progress, styleSuccess, "%s %s, %s (%s)\n", verb, rel, plural(uint32(len(m.Files)), "document", "documents"), formatBytes(manifestBytes(m)))
+		count++
+		docs += uint32(len(m.Files))
+		size += manifestBytes(m)
+	}
+	if count == 0 {
+		printf(progress, stylePlain, "Nothing is more than %s old.\n", plural(uint32(years), "year", "years"))
+		return nil
+	}
+	printf(progress, stylePlain, "\n%s, %s (%s)\n", plural(count, "year directory", "year directories"), plural(docs, "document", "documents"), formatBytes(size))
+	return nil
+}
+
+func doFetch(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("fetch: which year?  e.g. fileinbox offload fetch pge/2016")
+	}
+	config, err := offloadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch")
+	}
+	for _, rel := range ctx.Args().Slice() {
+		m, err := fetchYear(config, strings.Trim(path.Clean(rel), "/"))
+		if err != nil {
+			return errors.Wrap(err, "fetch")
+		}
+		printf(progress, styleSuccess, "Fetched %s, %s (%s)\n", rel, plural(uint32(len(m.Files)), "document", "documents"), formatBytes(manifestBytes(m)))
+	}
+	return nil
+}
+
+func offloadCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "offload",
+		Usage:  "Move old year directories out to object storage, leaving a manifest of what was there.",
+		Action: doOffload,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  yearsFlag,
+				Usage: "Offload year directories more than this many years old, rather than the configured number.",
+			},
+			&cli.BoolFlag{
+				Name:  dryRunFlag,
+				Usage: "Only say what would be offloaded.",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "fetch",
+				Usage:     "Bring back offloaded year directories, given as paths under filed.",
+				ArgsUsage: "dest/year...",
+				Action:    doFetch,
+			},
+		},
+	}
+}
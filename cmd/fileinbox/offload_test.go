@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	testUpload = "#!/bin/sh\nmkdir -p \"$2/$3\" && cp -R \"$1/.\" \"$2/$3\"\n"
+	testFetch  = "#!/bin/sh\ncp -R \"$2/$3/.\" \"$1\"\n"
+)
+
+func TestOffload(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	defer func() { clock = fileinbox.SystemClock }()
+	clock = fileinbox.FixedClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local))
+
+	createFiles(t, root, []string{
+		"filed/pge/2015/20150825_pge.pdf",
+		"filed/pge/2015/09/20150901_pge.pdf",
+		"filed/pge/2020/20200825_pge.pdf",
+		"filed/tax/FY2015-16/20160101_tax.pdf",
+		"filed/tax/FY2016-17/20170101_tax.pdf",
+	})
+	upload, fetch := path.Join(root, "upload.sh"), path.Join(root, "fetch.sh")
+	ok(t, ioutil.WriteFile(upload, []byte(testUpload), 0755))
+	ok(t, ioutil.WriteFile(fetch, []byte(testFetch), 0755))
+	store := path.Join(root, "store")
+	config := &Config{Root: root, Offload: OffloadConfig{
+		Upload: []string{"/bin/sh", upload, "{dir}", store, "{rel}"},
+		Fetch:  []string{"/bin/sh", fetch, "{dir}", store, "{rel}"},
+	}}
+	ok(t, config.validate())
+
+	rels, err := offloadable(config, 7)
+	ok(t, err)
+	equals(t, []string{"pge/2015", "tax/FY2015-16"}, rels)
+
+	m, err := offloadYear(config, "pge/2015", false)
+	ok(t, err)
+	equals(t, 2, len(m.Files))
+	equals(t, "09/20150901_pge.pdf", m.Files[0].Name)
+	infos, err := ioutil.ReadDir(path.Join(root, "filed/pge/2015"))
+	ok(t, err)
+	equals(t, 1, len(infos))
+	equals(t, offloadManifest, infos[0].Name())
+	equals(t, []string{"09/", "09/20150901_pge.pdf", "20150825_pge.pdf"}, readFiles(t, path.Join(store, "pge/2015")))
+
+	// offloaded years aren't offered again
+	rels, err = offloadable(config, 7)
+	ok(t, err)
+	equals(t, []string{"tax/FY2015-16"}, rels)
+
+	// what comes back has to match the manifest
+	ok(t, ioutil.WriteFile(path.Join(store, "pge/2015/20150825_pge.pdf"), []byte("changed"), 0644))
+	_, err = fetchYear(config, "pge/2015")
+	assert(t, err != nil, "expected a changed document to be refused")
+	_, err = os.Stat(path.Join(root, "filed/pge/2015", offloadManifest))
+	ok(t, err)
+
+	ok(t, ioutil.WriteFile(path.Join(store, "pge/2015/20150825_pge.pdf"), []byte("contents for 20150825_pge.pdf"), 0644))
+	_, err = fetchYear(config, "pge/2015")
+	ok(t, err)
+	equals(t, []string{"09/", "09/20150901_pge.pdf", "20150825_pge.pdf"}, readFiles(t, path.Join(root, "filed/pge/2015")))
+
+	_, err = fetchYear(config, "pge/2020")
+	assert(t, err != nil, "expected a year that was never offloaded to be refused")
+}
+
+// An upload that reports success but didn't store everything leaves
+// the local files be.
+func TestOffloadVerifiesUpload(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/2015/20150825_pge.pdf",
+		"filed/pge/2015/09/20150901_pge.pdf",
+	})
+	// drops the documents in subdirectories, and exits 0 anyway
+	upload, fetch := path.Join(root, "upload.sh"), path.Join(root, "fetch.sh")
+	ok(t, ioutil.WriteFile(upload, []byte("#!/bin/sh\nmkdir -p \"$2/$3\" && cp \"$1\"/*.pdf \"$2/$3\"\n"), 0755))
+	ok(t, ioutil.WriteFile(fetch, []byte(testFetch), 0755))
+	store := path.Join(root, "store")
+	config := &Config{Root: root, Offload: OffloadConfig{
+		Upload: []string{"/bin/sh", upload, "{dir}", store, "{rel}"},
+		Fetch:  []string{"/bin/sh", fetch, "{dir}", store, "{rel}"},
+	}}
+	ok(t, config.validate())
+
+	_, err = offloadYear(config, "pge/2015", false)
+	assert(t, err != nil, "expected an incomplete upload to be refused")
+	equals(t, []string{"09/", "09/20150901_pge.pdf", "20150825_pge.pdf"}, readFiles(t, path.Join(root, "filed/pge/2015")))
+	_, err = os.Stat(path.Join(root, workspaceDir))
+	assert(t, os.IsNotExist(err), "expected the fetched copy to be cleaned up")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	latestFlag string = "latest"
+	dateFlag   string = "date"
+)
+
+func openFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  latestFlag,
+			Usage: "Pick the most recent document.  This is the default.",
+		},
+		&cli.StringFlag{
+			Name:  dateFlag,
+			Usage: "Pick the documents dated on this day, e.g. 20160825.",
+		},
+	}
+}
+
+// doOpen opens the chosen documents in the default viewer.
+func doOpen(ctx *cli.Context) error {
+	docs, err := pickDocs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	for _, d := range docs {
+		fmt.Fprintf(progress, "Opening %s\n", d.path)
+		if err := openerCommand(d.path, false).Run(); err != nil {
+			return errors.Wrapf(err, "opening %s", d.path)
+		}
+	}
+	return nil
+}
+
+// doReveal shows the chosen documents in the file manager.
+func doReveal(ctx *cli.Context) error {
+	docs, err := pickDocs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reveal")
+	}
+	seen := map[string]bool{}
+	for _, d := range docs {
+		if seen[path.Dir(d.path)] {
+			continue
+		}
+		seen[path.Dir(d.path)] = true
+		fmt.Fprintf(progress, "Revealing %s\n", d.path)
+		if err := openerCommand(d.path, true).Run(); err != nil {
+			return errors.Wrapf(err, "revealing %s", d.path)
+		}
+	}
+	return nil
+}
+
+func pickDocs(ctx *cli.Context) ([]filedDoc, error) {
+	if ctx.NArg() != 1 {
+		return nil, errors.New("expected exactly one argument, the destination")
+	}
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dest := opts.ResolveDest(ctx.Args().First())
+	docs, err := findFiled(config, opts, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	var day time.Time
+	if d := ctx.String(dateFlag); d != "" {
+		if day, err = time.ParseInLocation("20060102", d, opts.Zone()); err != nil {
+			return nil, errors.Errorf("unable to parse --%s %q.  We expect a value like 20160825", dateFlag, d)
+		}
+	}
+	picked := selectDocs(docs, day)
+	if len(picked) == 0 {
+		return nil, errors.Errorf("no documents found for %s", dest)
+	}
+	return picked, nil
+}
+
+// selectDocs returns the docs dated day or, if day is zero, the latest.
+// docs must be sorted oldest first.
+func selectDocs(docs []filedDoc, day time.Time) []filedDoc {
+	if day.IsZero() {
+		if len(docs) == 0 {
+			return nil
+		}
+		return docs[len(docs)-1:]
+	}
+	var picked []filedDoc
+	for _, d := range docs {
+		if d.date.Equal(day) {
+			picked = append(picked, d)
+		}
+	}
+	return picked
+}
+
+// openerCommand returns the platform's way to open a file, or to show it
+// in its folder when reveal is set.
+func openerCommand(name string, reveal bool) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		if reveal {
+			return exec.Command("open", "-R", name)
+		}
+		return exec.Command("open", name)
+	case "windows":
+		if reveal {
+			return exec.Command("explorer", "/select,"+name)
+		}
+		return exec.Command("cmd", "/c", "start", "", name)
+	default:
+		if reveal {
+			name = path.Dir(name)
+		}
+		return exec.Command("xdg-open", name)
+	}
+}
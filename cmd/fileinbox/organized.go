@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// organizedFile remembers, for each dest, the modification time of its
+// directory when it was last organized.  Anything dropped straight into
+// a dest changes that time, so while it stays the same there is nothing
+// to organize and we needn't list the dest again.
+const organizedFile = ".fileinbox-organized.json"
+
+// defaultOrganizeJobs is how many dests are organized at once when
+// Config.OrganizeJobs isn't set.
+const defaultOrganizeJobs = 4
+
+// organizedSlack is how long after a dest changed we must have looked
+// at it to trust its time.  A file can land within the same tick of a
+// coarse clock, e.g. 2s on FAT, without changing it.
+const organizedSlack = 2 * time.Second
+
+type organizedMark struct {
+	ModTime time.Time `json:"modTime"`
+	Checked time.Time `json:"checked"`
+}
+
+// organizedMarks are what organizedFile holds, by dest.
+type organizedMarks struct {
+	name    string
+	mode    os.FileMode
+	marks   map[string]organizedMark
+	changed bool
+}
+
+// readOrganized loads the marks for c's root.  Marks we can't read are
+// as good as none; everything is organized, as it was before we kept
+// them.
+func (c *Config) readOrganized() *organizedMarks {
+	m := &organizedMarks{name: path.Join(c.Root, organizedFile), mode: c.perms.state(), marks: map[string]organizedMark{}}
+	if data, err := ioutil.ReadFile(m.name); err == nil {
+		if json.Unmarshal(data, &m.marks) != nil {
+			m.marks = map[string]organizedMark{}
+		}
+	}
+	return m
+}
+
+// upToDate returns true if nothing has been put in destDir since dest
+// was organized, and it already has a directory for each of years.
+func (m *organizedMarks) upToDate(dest, destDir string, years []string) bool {
+	mark, ok := m.marks[dest]
+	if !ok || mark.Checked.Sub(mark.ModTime) < organizedSlack {
+		return false
+	}
+	fi, err := os.Stat(destDir)
+	if err != nil || !fi.ModTime().Equal(mark.ModTime) {
+		return false
+	}
+	for _, y := range years {
+		if !isDir(path.Join(destDir, y)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mark records that dest, in destDir, has just been organized.
+func (m *organizedMarks) mark(dest, destDir string) {
+	fi, err := os.Stat(destDir)
+	if err != nil {
+		delete(m.marks, dest)
+	} else {
+		m.marks[dest] = organizedMark{ModTime: fi.ModTime(), Checked: time.Now()}
+	}
+	m.changed = true
+}
+
+func (m *organizedMarks) write() error {
+	if !m.changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.marks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.name, data, m.mode)
+}
+
+// orgTask is a dest to organize.
+type orgTask struct {
+	dest    string
+	dir     string
+	years   []string
+	buckets *bucketer
+}
+
+// orgResult is how organizing a dest went.
+type orgResult struct {
+	orgTask
+	count uint32
+	err   error
+}
+
+// organizeDests organizes each of tasks, Config.OrganizeJobs at a time,
+// returning how each went in the same order.  A dest that fails doesn't
+// stop the others.
+func organizeDests(config *Config, opts fileinbox.ParseOptions, tasks []orgTask, im *immutability) []orgResult {
+	jobs := config.OrganizeJobs
+	if jobs == 0 {
+		jobs = defaultOrganizeJobs
+	}
+	results := make([]orgResult, len(tasks))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs && i < len(tasks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				t := tasks[i]
+				count, err := organize(opts, t.dir, t.years, t.buckets, im, config.perms)
+				results[i] = orgResult{t, count, err}
+			}
+		}()
+	}
+	for i := range tasks {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
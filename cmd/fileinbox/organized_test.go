@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestOrganizedMarks(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/20150101_pge.pdf", "inbox/20160101_pge.pdf"})
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	inbox, dest := path.Join(root, "inbox"), path.Join(root, "filed", "pge")
+
+	run := func() fileResult {
+		fr := fileResult{missingDirs: map[string]bool{}}
+		ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+		return fr
+	}
+	// age the mark, as if the run had been a while ago
+	age := func() {
+		marks := config.readOrganized()
+		m := marks.marks["pge"]
+		m.Checked = m.ModTime.Add(time.Hour)
+		marks.marks["pge"] = m
+		marks.changed = true
+		ok(t, marks.write())
+	}
+
+	fr := run()
+	equals(t, uint32(1), fr.orgDests)
+	equals(t, uint32(1), fr.orgCount)
+	age()
+
+	createFiles(t, root, []string{"inbox/20160102_pge.pdf"})
+	fr = run()
+	equals(t, uint32(0), fr.orgDests)
+	equals(t, uint32(1), fr.orgUpToDate)
+	equals(t, uint32(1), fr.okCount)
+
+	// a new year needs its directory, so it is organized
+	createFiles(t, root, []string{"inbox/20170101_pge.pdf"})
+	fr = run()
+	equals(t, uint32(1), fr.orgDests)
+	age()
+
+	// as does something dropped straight into the dest
+	createFiles(t, root, []string{"filed/pge/20140101_pge.pdf", "inbox/20160103_pge.pdf"})
+	fr = run()
+	equals(t, uint32(1), fr.orgDests)
+	equals(t, uint32(1), fr.orgCount)
+	_, err = os.Stat(path.Join(dest, "2014", "20140101_pge.pdf"))
+	ok(t, err)
+
+	// a mark taken right after the dest changed isn't trusted
+	createFiles(t, root, []string{"inbox/20160104_pge.pdf"})
+	fr = run()
+	equals(t, uint32(1), fr.orgDests)
+}
+
+func TestOrganizeDestsIsolatesFailures(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/att/20150101_att.pdf",
+		"filed/bank/20150101_bank.pdf",
+		"filed/bank/notes.txt",
+		"filed/pge/20150101_pge.pdf",
+		"inbox/20160101_att.pdf",
+		"inbox/20160101_bank.pdf",
+		"inbox/20160101_pge.pdf",
+	})
+	config := &Config{Root: root, OrganizeJobs: 2}
+	ok(t, config.validate())
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(2), fr.orgDests)
+	equals(t, uint32(1), fr.failureCount)
+	equals(t, uint32(3), fr.okCount)
+	for _, organized := range []string{"filed/att/2015/20150101_att.pdf", "filed/pge/2015/20150101_pge.pdf"} {
+		_, err = os.Stat(path.Join(root, organized))
+		ok(t, err)
+	}
+
+	config.OrganizeJobs = -1
+	assert(t, config.validate() != nil, "expected negative organizejobs to be rejected")
+}
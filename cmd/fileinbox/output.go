@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl" // events as they happen, then the summary
+)
+
+// progress is where we report what we are doing as we go, stderr
+// unless --progress says stdout.  What a command was asked for, such as
+// a list, a report or JSON, goes to stdout, so it can be piped on
+// without progress getting mixed in.
+var progress io.Writer = os.Stderr
+
+// Where --progress may send progress.
+const (
+	progressStderr = "stderr"
+	progressStdout = "stdout"
+)
+
+// startProgress sends progress where --progress asks.
+func startProgress(to string) error {
+	switch to {
+	case "", progressStderr:
+		progress = os.Stderr
+	case progressStdout:
+		progress = os.Stdout
+	default:
+		return fmt.Errorf("unknown --%s %q.  We expect %s or %s", progressFlag, to, progressStderr, progressStdout)
+	}
+	return nil
+}
+
+// style is how a message is shown on a terminal.
+type style int
+
+const (
+	stylePlain style = iota
+	styleSuccess
+	styleSkip
+	styleFailure
+	styleNotice
+)
+
+var styleCodes = map[style]string{
+	styleSuccess: "\033[32m",   // green
+	styleSkip:    "\033[33m",   // yellow
+	styleFailure: "\033[31m",   // red
+	styleNotice:  "\033[1;33m", // bold yellow
+}
+
+const resetCode = "\033[0m"
+
+// confirmInput is where we read answers to questions, so tests can
+// answer them.
+var confirmInput io.Reader = os.Stdin
+
+// ask asks question and returns the answer, or def if there is none.
+func ask(question, def string) string {
+	if def != "" {
+		question += " [" + def + "]"
+	}
+	printf(progress, styleNotice, "%s ", question)
+	if answer := strings.TrimSpace(readLine(confirmInput)); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks question and returns true if the answer is yes.
+func confirm(question string) bool {
+	printf(progress, styleNotice, "%s [y/N] ", question)
+	answer := strings.ToLower(strings.TrimSpace(readLine(confirmInput)))
+	return answer == "y" || answer == "yes"
+}
+
+// readLine reads up to the end of the line a byte at a time, so nothing
+// meant for the next question is read ahead.
+func readLine(r io.Reader) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			break
+		}
+	}
+	return string(line)
+}
+
+// noColor turns off color everywhere.  It is set by --no-color or by
+// NO_COLOR in the environment.
+var noColor bool
+
+// printf writes a message to w, colored by st when w is a terminal.
+func printf(w io.Writer, st style, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if code, ok := styleCodes[st]; ok && colorFor(w) {
+		msg = code + msg + resetCode
+	}
+	fmt.Fprint(w, msg)
+}
+
+func colorFor(w io.Writer) bool {
+	if noColor {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// jsonSummary is what --output json prints once the run is over.
+type jsonSummary struct {
+	Moved           uint32           `json:"moved"`
+	Retried         uint32           `json:"retried"`
+	Organized       uint32           `json:"organized"`
+	OrganizedDests  uint32           `json:"organizedDests"`
+	UpToDateDests   uint32           `json:"upToDateDests"`
+	Failures        uint32           `json:"failures"`
+	MissingDirs     []string         `json:"missingDirs"`
+	Seconds         float64          `json:"seconds"`
+	OrganizeSeconds float64          `json:"organizeSeconds"`
+	MovedBytes      int64            `json:"movedBytes"`
+	CopiedBytes     int64            `json:"copiedBytes"`
+	CCBytes         int64            `json:"ccBytes"`
+	SkippedBytes    int64            `json:"skippedBytes"`
+	BytesPerSecond  int64            `json:"bytesPerSecond"`
+	ByDest          map[string]int   `json:"byDest,omitempty"`
+	Held            map[string]int   `json:"held,omitempty"`
+	Quarantined     uint32           `json:"quarantined,omitempty"`
+	Duplicates      uint32           `json:"duplicates,omitempty"`
+	Versions        uint32           `json:"versions,omitempty"`
+	Copies          uint32           `json:"copies,omitempty"`
+	Conflicts       []string         `json:"conflicts,omitempty"`
+	CCConflicts     []string         `json:"ccConflicts,omitempty"`
+	Unlocked        []string         `json:"unlocked,omitempty"`
+	Ignored         []string         `json:"ignored,omitempty"`
+	Failed          []failure        `json:"failed,omitempty"`
+	Plan            []fileinbox.Move `json:"plan,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	ErrorCategory   string           `json:"errorCategory,omitempty"`
+}
+
+func (fr fileResult) summarizeJSON(w io.Writer, duration time.Duration, runErr error) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fr.jsonSummary(duration, runErr)); err != nil {
+		return err
+	}
+	if fr.failureCount != 0 {
+		return fmt.Errorf("there were %d failures", fr.failureCount)
+	}
+	return nil
+}
+
+// summarizeJSONL ends an --output jsonl stream with the summary, on one
+// line, as a summary event.
+func (fr fileResult) summarizeJSONL(w io.Writer, duration time.Duration, runErr error) error {
+	s := struct {
+		Time  time.Time `json:"time"`
+		Event string    `json:"event"`
+		jsonSummary
+	}{time.Now(), eventSummary, fr.jsonSummary(duration, runErr)}
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return err
+	}
+	if fr.failureCount != 0 {
+		return fmt.Errorf("there were %d failures", fr.failureCount)
+	}
+	return nil
+}
+
+// startOutput gets stdout ready for output.  Anything machine readable
+// keeps it to itself, even with --progress stdout, and jsonl streams
+// events to it as they happen.
+func startOutput(output string) {
+	if output == outputJSON || output == outputJSONL {
+		progress = os.Stderr
+	}
+	if output == outputJSONL {
+		events.streamTo(os.Stdout)
+	}
+}
+
+// startSummaryOnly, with --summary-only, stops the lines we write as
+// we go.  It returns where they went, for what must be said anyway, such
+// as why the run failed.
+func startSummaryOnly(ctx *cli.Context) io.Writer {
+	report := progress
+	if ctx.Bool(summaryOnlyFlag) {
+		progress = ioutil.Discard
+	}
+	return report
+}
+
+// idle returns true if the run did nothing, and nothing failed.  Files
+// left in the inbox, or held for review, from before count as nothing.
+func (fr fileResult) idle() bool {
+	return fr.okCount == 0 && fr.orgCount == 0 && fr.failureCount == 0 &&
+		fr.duplicates == 0 && fr.quarantined == 0 && len(fr.conflicts) == 0 && len(fr.ccConflicts) == 0 &&
+		len(fr.missingDirs) == 0 && len(fr.plan) == 0
+}
+
+// summarizeIfAny is summarizeAs, except that with --summary-only an
+// idle run says nothing at all.
+func (fr fileResult) summarizeIfAny(ctx *cli.Context, output string, duration time.Duration, runErr error) error {
+	if ctx.Bool(summaryOnlyFlag) && runErr == nil && fr.idle() {
+		return nil
+	}
+	return fr.summarizeAs(output, duration, runErr)
+}
+
+// summarizeAs writes the summary in the format output asks for.
+func (fr fileResult) summarizeAs(output string, duration time.Duration, runErr error) error {
+	switch output {
+	case outputJSON:
+		return fr.summarizeJSON(os.Stdout, duration, runErr)
+	case outputJSONL:
+		return fr.summarizeJSONL(os.Stdout, duration, runErr)
+	}
+	return fr.summarize(duration)
+}
+
+func (fr fileResult) jsonSummary(duration time.Duration, runErr error) jsonSummary {
+	s := jsonSummary{
+		Moved:           fr.okCount,
+		Retried:         fr.retriedCount,
+		Organized:       fr.orgCount,
+		OrganizedDests:  fr.orgDests,
+		UpToDateDests:   fr.orgUpToDate,
+		Failures:        fr.failureCount,
+		MissingDirs:     []string{},
+		Seconds:         duration.Seconds(),
+		OrganizeSeconds: fr.orgDuration.Seconds(),
+		MovedBytes:      fr.movedBytes,
+		CopiedBytes:     fr.copiedBytes,
+		CCBytes:         fr.ccBytes,
+		SkippedBytes:    fr.skippedBytes,
+		BytesPerSecond:  throughput(fr.movedBytes, duration),
+		ByDest:          fr.touched,
+		Held:            fr.held,
+		Quarantined:     fr.quarantined,
+		Duplicates:      fr.duplicates,
+		Versions:        fr.versions,
+		Copies:          fr.copies,
+		Conflicts:       fr.conflicts,
+		CCConflicts:     fr.ccConflicts,
+		Unlocked:        fr.unlocked,
+		Ignored:         fr.ignored,
+		Failed:          fr.failures,
+		Plan:            fr.plan,
+	}
+	for k := range fr.missingDirs {
+		s.MissingDirs = append(s.MissingDirs, k)
+	}
+	sort.Strings(s.MissingDirs)
+	if runErr != nil {
+		s.Error = runErr.Error()
+		s.ErrorCategory = failCategory(failRun, runErr)
+	}
+	return s
+}
+
+// throughput returns bytes per second.
+func throughput(n int64, duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(float64(n) / duration.Seconds())
+}
+
+// formatBytes, formatCount and formatDuration are how numbers are
+// shown to people, so the summary, dests and du all read alike.
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatCount writes n with thousands separators, e.g. 12,345.
+func formatCount(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// formatDuration rounds d to what a person cares about, e.g. 850ms,
+// 1.2s, 3m 05s or 2h 10m.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "0s"
+	case d < time.Millisecond:
+		return "<1ms"
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%dm %02ds", d/time.Minute, (d%time.Minute)/time.Second)
+	}
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %02dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestFormatting(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		1234567:  "1,234,567",
+		-12345:   "-12,345",
+		100000:   "100,000",
+		12345678: "12,345,678",
+	} {
+		equals(t, want, formatCount(n))
+	}
+
+	for d, want := range map[time.Duration]string{
+		0:                                 "0s",
+		500 * time.Microsecond:            "<1ms",
+		850 * time.Millisecond:            "850ms",
+		1234567891 * time.Nanosecond:      "1.2s",
+		3*time.Minute + 5*time.Second:     "3m 05s",
+		2*time.Hour + 10*time.Minute + 29: "2h 10m",
+	} {
+		equals(t, want, formatDuration(d))
+	}
+
+	equals(t, "1.5 KiB", formatBytes(1536))
+}
+
+func TestByDest(t *testing.T) {
+	fr := fileResult{touched: map[string]int{"pge": 3, "photos": 1041, "chase": 2}}
+	equals(t, "chase: 2, pge: 3, photos: 1,041", fr.byDest())
+}
+
+func TestSummaryOnly(t *testing.T) {
+	defer func(old *os.File) { os.Stdout = old }(os.Stdout)
+	defer func(old io.Writer) { progress = old }(progress)
+
+	// what the run writes to stdout, with --summary-only or not
+	summarize := func(summaryOnly bool, fr fileResult) string {
+		r, w, err := os.Pipe()
+		ok(t, err)
+		os.Stdout = w
+		app := newCli()
+		app.Commands = nil
+		app.Action = func(ctx *cli.Context) error {
+			startSummaryOnly(ctx)
+			printf(progress, stylePlain, "Filed 20240101_pge.pdf\n")
+			return fr.summarizeIfAny(ctx, outputText, time.Second, nil)
+		}
+		args := []string{"fileinbox"}
+		if summaryOnly {
+			args = append(args, flagify(summaryOnlyFlag))
+		}
+		progress = w
+		runErr := app.Run(args)
+		w.Close()
+		out, err := ioutil.ReadAll(r)
+		ok(t, err)
+		ok(t, runErr)
+		return string(out)
+	}
+
+	idle := fileResult{skippedCount: 2, held: map[string]int{"pge": 1}}
+	assert(t, summarize(false, idle) != "", "expected a summary without --%s", summaryOnlyFlag)
+	equals(t, "", summarize(true, idle))
+
+	out := summarize(true, fileResult{okCount: 1})
+	assert(t, strings.Contains(out, "Filed:"), "expected a summary of a run that filed, got %q", out)
+	assert(t, !strings.Contains(out, "20240101_pge.pdf"), "expected no line for each file, got %q", out)
+	assert(t, !fileResult{failureCount: 1}.idle(), "expected a run with failures not to be idle")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// perms are the modes we give the directories and files we create in
+// the archive.  A zero mode means the default, 0777 for directories and
+// 0666 for files, less the umask.  A mode from the config is applied as
+// is, whatever the umask.
+//
+// With a group, for a root shared by a household, what we create gets
+// the group too, directories are setgid so what others create there
+// does as well, and the modes default to group writable.
+type perms struct {
+	dir   os.FileMode
+	file  os.FileMode
+	group int // a gid, or zero for none
+}
+
+// sharedDirMode and sharedFileMode are the modes with a group, unless
+// DirMode and FileMode say otherwise.
+const (
+	sharedDirMode  = 0770
+	sharedFileMode = 0660
+)
+
+// lookupGroup returns the gid of name, which may also be a gid.
+func lookupGroup(name string) (int, error) {
+	if runtime.GOOS == "windows" {
+		return 0, errors.New("groups aren't supported on Windows, where the ACLs of the root decide who may use it")
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if g, err = user.LookupGroupId(name); err != nil {
+			return 0, errors.Errorf("there is no group %q", name)
+		}
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, errors.Wrapf(err, "group %q", name)
+	}
+	if gid == 0 {
+		return 0, errors.Errorf("group %q is root's, which is never shared", name)
+	}
+	return gid, nil
+}
+
+// share sets the perms up for group, from Config.Group.
+func (p *perms) share(group string) error {
+	if group == "" {
+		return nil
+	}
+	gid, err := lookupGroup(group)
+	if err != nil {
+		return err
+	}
+	p.group = gid
+	if p.dir == 0 {
+		p.dir = sharedDirMode
+	}
+	p.dir |= os.ModeSetgid
+	if p.file == 0 {
+		p.file = sharedFileMode
+	}
+	return nil
+}
+
+// state is the mode of the files we keep under the root, such as the
+// journal: ours alone, unless the root is shared.
+func (p perms) state() os.FileMode {
+	if p.group != 0 {
+		return sharedFileMode
+	}
+	return 0600
+}
+
+// shareRoot gives the root, filed and the inbox the group, and makes
+// them setgid, so everything created under them gets the group.  Dests
+// filed before the root was shared keep the group they had; chgrp -R
+// fixes them.
+func (c *Config) shareRoot() error {
+	if c.perms.group == 0 {
+		return nil
+	}
+	for _, dir := range []string{c.Root, c.filed(), c.inbox()} {
+		fi, err := os.Stat(dir)
+		if os.IsNotExist(err) && dir != c.Root {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// only the owner may change them, so what is already right is
+		// left be, for the others sharing the root
+		if gid, ok := fileGroup(fi); !ok || gid != c.perms.group {
+			if err := os.Chown(dir, -1, c.perms.group); err != nil {
+				return errors.Wrapf(err, "giving %s the group %s", dir, c.Group)
+			}
+		}
+		want := fi.Mode().Perm() | sharedDirMode | os.ModeSetgid
+		if fi.Mode()&(os.ModePerm|os.ModeSetgid) != want {
+			if err := os.Chmod(dir, want); err != nil {
+				return errors.Wrapf(err, "sharing %s", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// parseMode parses an octal mode such as 0750 or 750.  The empty string
+// is the zero mode.
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, errors.Errorf("bad mode %q.  We expect an octal value like 0750", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// mkdirAll creates name and any missing parents, giving each the dir
+// mode.
+func (p perms) mkdirAll(name string) error {
+	return fileinbox.MkdirAllGroup(name, p.dir, p.group)
+}
+
+// ensureWritableDir creates dir if needed and makes sure we can write
+// to it, fixing up directories an earlier version created read-only.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0300 != 0300 {
+		return os.Chmod(dir, fi.Mode().Perm()|0700)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]os.FileMode{"": 0, "0750": 0750, "640": 0640} {
+		got, err := parseMode(s)
+		ok(t, err)
+		equals(t, want, got)
+	}
+	for _, s := range []string{"0999", "rwx", "01777"} {
+		_, err := parseMode(s)
+		assert(t, err != nil, "expected an error for %q", s)
+	}
+}
+
+func TestPerms(t *testing.T) {
+	start := []string{
+		"filed/insurance/",
+		"inbox/20160701_insurance-auto.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root, DestSeparator: "-", DirMode: "0750", FileMode: "0440"}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), true, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+
+	for name, want := range map[string]os.FileMode{
+		"filed/insurance/auto":                                  os.ModeDir | 0750,
+		"filed/insurance/auto/2016":                             os.ModeDir | 0750,
+		"filed/insurance/auto/2016/20160701_insurance-auto.pdf": 0440,
+	} {
+		fi, err := os.Stat(path.Join(root, name))
+		ok(t, err)
+		equals(t, want, fi.Mode())
+	}
+
+	config.FileMode = "0888"
+	assert(t, config.validate() != nil, "expected an error for a bad file mode")
+}
+
+func TestEnsureWritableDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+
+	// earlier versions created the config dir as 0500
+	dir := path.Join(root, "fileinbox")
+	ok(t, os.Mkdir(dir, 0500))
+	ok(t, ensureWritableDir(dir))
+	ok(t, writeFileAtomic(path.Join(dir, "fileinbox.yaml"), []byte("root: /tmp\n"), 0600))
+
+	ok(t, ensureWritableDir(path.Join(root, "new", "fileinbox")))
+	assert(t, isDir(path.Join(root, "new", "fileinbox")), "expected the dir to be created")
+}
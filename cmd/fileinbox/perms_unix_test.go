@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	// root may give any group, and others only their own
+	gid := strconv.Itoa(os.Getgid())
+	if os.Geteuid() == 0 {
+		gid = "1"
+	}
+	if _, err := user.LookupGroupId(gid); err != nil || gid == "0" {
+		t.Skip("no group to give")
+	}
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"inbox/20160701_pge.pdf"})
+
+	config := &Config{Root: root, Group: gid}
+	ok(t, config.validate())
+	ok(t, config.shareRoot())
+	startRun("")
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), true, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+
+	for name, want := range map[string]os.FileMode{
+		"":                                os.ModeDir | os.ModeSetgid | 0770,
+		"filed/pge":                       os.ModeDir | os.ModeSetgid | 0770,
+		"filed/pge/2016":                  os.ModeDir | os.ModeSetgid | 0770,
+		"filed/pge/2016/20160701_pge.pdf": 0660,
+		journalFile:                       0660,
+	} {
+		fi, err := os.Stat(path.Join(root, name))
+		ok(t, err)
+		equals(t, want, fi.Mode()&(os.ModeDir|os.ModeSetgid|os.ModePerm))
+		equals(t, gid, strconv.Itoa(int(fi.Sys().(*syscall.Stat_t).Gid)))
+	}
+	entries, err := config.readJournal()
+	ok(t, err)
+	u, err := user.Current()
+	ok(t, err)
+	equals(t, u.Username, entries[0].User)
+
+	config.Group = "0"
+	assert(t, config.validate() != nil, "expected root's group to be refused")
+	config.Group = "no-such-group-here"
+	assert(t, config.validate() != nil, "expected an unknown group to be refused")
+}
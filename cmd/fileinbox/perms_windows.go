@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// fileGroup returns false, as files on Windows have ACLs rather than a
+// group.
+func fileGroup(fi os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	defaultPipelineDest    = "receipts"
+	defaultPipelineTimeout = 2 * time.Minute
+)
+
+var defaultPipelineExts = []string{"heic", "jpg", "jpeg"}
+
+// Pipeline turns photos taken with a phone, such as receipts, into
+// documents that can be filed.  Photos in Inbox are dated from their
+// EXIF data, their sidecar, or when both are missing their modification
+// time, then filed under Dest as YYYYMMDD_<dest>_<original name>.  Along the way
+// HEIC photos may be converted, and a day's photos may be bundled into
+// one pdf.
+//
+// Convert and Bundle are commands, with {in} and {out} in their
+// arguments replaced by the input and output files, e.g.
+//
+//	convert: [heif-convert, "{in}", "{out}"]
+//	bundle: [img2pdf, -o, "{out}", "{in}"]
+//
+// An argument of just {in} in Bundle stands for all of the day's
+// photos.  A photo is only removed from the inbox once everything made
+// from it is there, so a step that fails leaves it to try again.
+type Pipeline struct {
+	Inbox   string        // a path, or the base name of an inbox
+	Exts    []string      // the photos to take, heic, jpg and jpeg if not set
+	Dest    string        // receipts if not set
+	Convert []string      // turns a HEIC {in} into a jpg {out}
+	Bundle  []string      // turns a day's photos {in} into a pdf {out}
+	Timeout time.Duration // for each command, 2m if not set
+}
+
+func (p *Pipeline) validate() error {
+	if p.Inbox == "" {
+		return errors.New("pipeline has no inbox")
+	}
+	if p.Dest == "" {
+		p.Dest = defaultPipelineDest
+	}
+	if err := checkDest(p.Dest); err != nil {
+		return errors.Wrapf(err, "pipeline %s", p.Inbox)
+	}
+	if len(p.Exts) == 0 {
+		p.Exts = append([]string{}, defaultPipelineExts...)
+	}
+	for i, ext := range p.Exts {
+		p.Exts[i] = strings.ToLower(strings.TrimPrefix(ext, "."))
+	}
+	for _, c := range []struct {
+		name string
+		args []string
+	}{{"convert", p.Convert}, {"bundle", p.Bundle}} {
+		if c.args == nil {
+			continue
+		}
+		joined := strings.Join(c.args, " ")
+		if len(c.args) == 0 || !strings.Contains(joined, "{in}") || !strings.Contains(joined, "{out}") {
+			return errors.Errorf("pipeline %s: %s must be a command using {in} and {out}", p.Inbox, c.name)
+		}
+	}
+	return nil
+}
+
+// pipeline returns the pipeline for inbox, or nil if it doesn't have
+// one.
+func (c *Config) pipeline(inbox string) *Pipeline {
+	for _, key := range []string{path.Clean(inbox), path.Base(inbox)} {
+		for i := range c.Pipelines {
+			if c.Pipelines[i].Inbox == key {
+				return &c.Pipelines[i]
+			}
+		}
+	}
+	return nil
+}
+
+// photo is one file a pipeline is working on.
+type photo struct {
+	name string // base name in the inbox
+	size int64
+	date time.Time
+	file string // what to file, after any conversion
+}
+
+func (ph *photo) isHEIC() bool {
+	return strings.EqualFold(filepath.Ext(ph.name), ".heic")
+}
+
+// run takes the photos in inbox through the pipeline, leaving what it
+// makes in the inbox to be filed.  It returns the photos still in the
+// inbox, which have been reported on and so should be left alone.
+func (p *Pipeline) run(config *Config, opts fileinbox.ParseOptions, inbox string, dryRun bool, fr *fileResult) map[string]bool {
+	left := map[string]bool{}
+	infos, err := ioutil.ReadDir(inbox)
+	if err != nil {
+		// processInbox reports this when it reads the inbox
+		return left
+	}
+
+	var photos []*photo
+	for _, fi := range infos {
+		if fi.IsDir() || !p.takes(fi.Name()) {
+			continue
+		}
+		if _, err := parseFileName(opts, fi.Name()); err == nil {
+			// already named, perhaps by us on an earlier run
+			continue
+		}
+		ph := &photo{name: fi.Name(), size: fi.Size(), file: path.Join(inbox, fi.Name())}
+		if ph.date, err = photoDate(ph.file, opts.Zone()); err != nil {
+			printf(progress, styleNotice, "No date in %q (%v), using when it was modified\n", ph.file, err)
+			ph.date = fi.ModTime().In(opts.Zone())
+		}
+		photos = append(photos, ph)
+	}
+	if len(photos) == 0 {
+		return left
+	}
+
+	fail := func(ph *photo, step string, err error) {
+		printf(progress, styleFailure, "Pipeline %s: %s failed for %q: %v\n", p.Inbox, step, path.Join(inbox, ph.name), err)
+		fr.fail(path.Join(inbox, ph.name), failPipeline, err)
+		fr.skippedCount++
+		fr.skippedBytes += ph.size
+		left[ph.name] = true
+	}
+
+	if dryRun {
+		for _, ph := range photos {
+			left[ph.name] = true
+			if ph.isHEIC() && p.Convert != nil {
+				printf(progress, stylePlain, "Would convert %s\n", path.Join(inbox, ph.name))
+			}
+		}
+		for day, phs := range byDay(photos) {
+			if p.Bundle != nil {
+				printf(progress, stylePlain, "Would bundle %d photos into %s\n", len(phs), path.Join(inbox, p.bundleName(config, day)))
+				continue
+			}
+			for _, ph := range phs {
+				printf(progress, stylePlain, "Would rename %s to %s\n", path.Join(inbox, ph.name), p.photoName(config, ph))
+			}
+		}
+		return left
+	}
+
+	work, err := fr.work.sub("pipeline")
+	if err != nil {
+		for _, ph := range photos {
+			fail(ph, "setup", err)
+		}
+		return left
+	}
+	if fr.work == nil {
+		defer os.RemoveAll(work)
+	}
+
+	var ready []*photo
+	for _, ph := range photos {
+		if ph.isHEIC() && p.Convert != nil {
+			out := path.Join(work, strings.TrimSuffix(ph.name, filepath.Ext(ph.name))+".jpg")
+			if err := p.runCommand(p.Convert, []string{ph.file}, out); err != nil {
+				fail(ph, "convert", err)
+				continue
+			}
+			ph.file = out
+		}
+		ready = append(ready, ph)
+	}
+
+	if p.Bundle == nil {
+		for _, ph := range ready {
+			to := path.Join(inbox, p.photoName(config, ph))
+			if err := p.place(ph.file, to); err != nil {
+				fail(ph, "rename", err)
+				continue
+			}
+			if ph.file != path.Join(inbox, ph.name) {
+				if err := os.Remove(path.Join(inbox, ph.name)); err != nil {
+					fail(ph, "cleanup", err)
+				}
+			}
+			if from, suffix, ok := sidecar(inbox, ph.name); ok {
+				if err := p.place(from, to+suffix); err != nil {
+					printf(progress, styleNotice, "Unable to rename %s with its photo: %v\n", from, err)
+				}
+			}
+		}
+		return left
+	}
+
+	days := byDay(ready)
+	var keys []string
+	for day := range days {
+		keys = append(keys, day)
+	}
+	sort.Strings(keys)
+	for _, day := range keys {
+		phs := days[day]
+		var ins []string
+		for _, ph := range phs {
+			ins = append(ins, ph.file)
+		}
+		out := path.Join(work, day+".pdf")
+		err := p.runCommand(p.Bundle, ins, out)
+		if err == nil {
+			err = p.place(out, path.Join(inbox, p.bundleName(config, day)))
+		}
+		if err != nil {
+			for _, ph := range phs {
+				fail(ph, "bundle", err)
+			}
+			continue
+		}
+		for _, ph := range phs {
+			// the sidecar's date is the bundle's now
+			from, _, hasSidecar := sidecar(inbox, ph.name)
+			if err := os.Remove(path.Join(inbox, ph.name)); err != nil {
+				fail(ph, "cleanup", err)
+				continue
+			}
+			if hasSidecar {
+				if err := os.Remove(from); err != nil && !os.IsNotExist(err) {
+					printf(progress, styleFailure, "Pipeline %s: unable to remove %q, bundled with its photo: %v\n", p.Inbox, from, err)
+					fr.fail(from, failPipeline, err)
+					left[path.Base(from)] = true
+				}
+			}
+		}
+	}
+	return left
+}
+
+func (p *Pipeline) takes(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	return hasString(p.Exts, ext)
+}
+
+// nameDest is how the pipeline's dest is written in a name.
+func (p *Pipeline) nameDest(config *Config) string {
+	return strings.ReplaceAll(p.Dest, "/", config.DestSeparator)
+}
+
+// photoName is what a single photo is filed as, keeping its original
+// name as the description, e.g. 20240312_receipts_IMG_1234.jpg.
+func (p *Pipeline) photoName(config *Config, ph *photo) string {
+	orig := strings.TrimSuffix(ph.name, filepath.Ext(ph.name))
+	return ph.date.Format("20060102") + "_" + p.nameDest(config) + "_" + orig + strings.ToLower(filepath.Ext(ph.file))
+}
+
+// bundleName is what a day's bundle is filed as, e.g.
+// 20240312_receipts.pdf.
+func (p *Pipeline) bundleName(config *Config, day string) string {
+	return day + "_" + p.nameDest(config) + ".pdf"
+}
+
+// place moves from to to, refusing to replace anything already there.
+func (p *Pipeline) place(from, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return errors.Errorf("%q already exists", to)
+	}
+	_, err := fileinbox.MoveFile(from, to)
+	return err
+}
+
+// runCommand runs args with {in} and {out} filled in, checking it made
+// out.
+func (p *Pipeline) runCommand(args, ins []string, out string) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPipelineTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var expanded []string
+	for _, a := range args {
+		if a == "{in}" {
+			expanded = append(expanded, ins...)
+			continue
+		}
+		a = strings.ReplaceAll(a, "{in}", ins[0])
+		expanded = append(expanded, strings.ReplaceAll(a, "{out}", out))
+	}
+	cmd := exec.CommandContext(ctx, expanded[0], expanded[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return errors.Wrapf(err, "running %s: %s", expanded[0], msg)
+		}
+		return errors.Wrapf(err, "running %s", expanded[0])
+	}
+	if _, err := os.Stat(out); err != nil {
+		return errors.Errorf("%s did not write %s", expanded[0], out)
+	}
+	return nil
+}
+
+// byDay groups photos by the day they were taken, as YYYYMMDD.
+func byDay(photos []*photo) map[string][]*photo {
+	days := map[string][]*photo{}
+	for _, ph := range photos {
+		day := ph.date.Format("20060102")
+		days[day] = append(days[day], ph)
+	}
+	return days
+}
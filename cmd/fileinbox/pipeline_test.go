@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeHEIC returns something shaped enough like a HEIC photo taken at
+// taken for exifDate to read.
+func fakeHEIC(taken string) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	for _, v := range []interface{}{
+		uint32(8),               // where IFD0 starts
+		uint16(1),               // how many entries it has
+		uint16(exifTagDateTime), // the entry
+		uint16(2),               // ascii
+		uint32(20),              // its length
+		uint32(26),              // where it is
+		uint32(0),               // no more IFDs
+	} {
+		binary.Write(&tiff, binary.LittleEndian, v)
+	}
+	tiff.WriteString(taken + "\x00")
+	return append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00metadata Exif\x00\x00"), tiff.Bytes()...)
+}
+
+const (
+	testConvert = "#!/bin/sh\ncp \"$1\" \"$2\"\n"
+	testBundle  = "#!/bin/sh\nout=\"$1\"\nshift\ncat \"$@\" > \"$out\"\n"
+	testBroken  = "#!/bin/sh\necho no codec >&2\nexit 1\n"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		convert  string
+		bundle   bool
+		failures uint32
+		expected []string
+	}{
+		{
+			name:    "convert",
+			convert: testConvert,
+			expected: []string{
+				"filed/receipts/2024/20240312_receipts_IMG_1.jpg",
+				"filed/receipts/2024/20240312_receipts_IMG_2.jpg",
+				"filed/receipts/2024/20240313_receipts_IMG_3.jpg",
+			},
+		},
+		{
+			name:    "bundle",
+			convert: testConvert,
+			bundle:  true,
+			expected: []string{
+				"filed/receipts/2024/20240312_receipts.pdf",
+				"filed/receipts/2024/20240313_receipts.pdf",
+			},
+		},
+		{
+			name:     "broken convert",
+			convert:  testBroken,
+			failures: 1,
+			expected: []string{
+				"filed/receipts/2024/20240312_receipts_IMG_2.jpg",
+				"filed/receipts/2024/20240313_receipts_IMG_3.jpg",
+				"inbox/IMG_1.heic",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "file_inbox_test")
+			ok(t, err)
+			defer func() {
+				if !t.Failed() {
+					// if the test failed, we leave this around for forensics
+					os.RemoveAll(root)
+				}
+			}()
+			createFiles(t, root, []string{"filed/receipts/", "inbox/IMG_2.jpg", "inbox/IMG_3.jpg"})
+			inbox := path.Join(root, "inbox")
+			ok(t, ioutil.WriteFile(path.Join(inbox, "IMG_1.heic"), fakeHEIC("2024:03:12 09:30:00"), 0600))
+			// no exif in these, so we go by when they were modified
+			ok(t, os.Chtimes(path.Join(inbox, "IMG_2.jpg"), time.Now(), time.Date(2024, 3, 12, 12, 0, 0, 0, time.Local)))
+			ok(t, os.Chtimes(path.Join(inbox, "IMG_3.jpg"), time.Now(), time.Date(2024, 3, 13, 12, 0, 0, 0, time.Local)))
+
+			scripts, err := ioutil.TempDir("", "file_inbox_scripts")
+			ok(t, err)
+			defer os.RemoveAll(scripts)
+			convert := path.Join(scripts, "convert.sh")
+			ok(t, ioutil.WriteFile(convert, []byte(tt.convert), 0700))
+			p := Pipeline{Inbox: "inbox", Convert: []string{"/bin/sh", convert, "{in}", "{out}"}}
+			if tt.bundle {
+				bundle := path.Join(scripts, "bundle.sh")
+				ok(t, ioutil.WriteFile(bundle, []byte(testBundle), 0700))
+				p.Bundle = []string{"/bin/sh", bundle, "{out}", "{in}"}
+			}
+
+			config := &Config{Root: root, Pipelines: []Pipeline{p}}
+			ok(t, config.validate())
+			fr := fileResult{missingDirs: map[string]bool{}}
+			ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+			equals(t, tt.failures, fr.failureCount)
+			equals(t, uint32(len(tt.expected))-tt.failures, fr.okCount)
+
+			// what the converters make won't have the contents readFiles
+			// expects, so just look at the names
+			var found []string
+			ok(t, filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && !strings.HasPrefix(info.Name(), ".fileinbox-") {
+					found = append(found, strings.TrimPrefix(p, root+"/"))
+				}
+				return err
+			}))
+			sort.Strings(found)
+			equals(t, tt.expected, found)
+		})
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+	for _, p := range []Pipeline{
+		{},
+		{Inbox: "photos", Dest: "../receipts"},
+		{Inbox: "photos", Convert: []string{"heif-convert", "{in}"}},
+		{Inbox: "photos", Bundle: []string{}},
+		{Inbox: "photos", Dest: "home/receipts"},
+	} {
+		config := &Config{Pipelines: []Pipeline{p}}
+		assert(t, config.validate() != nil, "expected %+v to be rejected", p)
+	}
+	config := &Config{Pipelines: []Pipeline{{Inbox: "photos"}}}
+	ok(t, config.validate())
+	equals(t, "receipts", config.Pipelines[0].Dest)
+	equals(t, []string{"heic", "jpg", "jpeg"}, config.Pipelines[0].Exts)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	savePlanFlag      string = "save-plan"
+	applyLastPlanFlag string = "apply-last-plan"
+)
+
+// lastPlanFile is where --dry-run --save-plan keeps the plan, under the
+// root, for --apply-last-plan.
+const lastPlanFile = ".fileinbox-plan.json"
+
+// fingerprint is what we remember of a file in an inbox, to tell
+// whether it changed between planning and applying.
+type fingerprint struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// savedPlan is a dry run's plan, along with what the inboxes it read
+// held at the time.
+type savedPlan struct {
+	Made    time.Time                `json:"made"`
+	Moves   []fileinbox.Move         `json:"moves"`
+	Inboxes map[string][]fingerprint `json:"inboxes"`
+}
+
+func (c *Config) lastPlan() string {
+	return path.Join(c.Root, lastPlanFile)
+}
+
+// fingerprints returns what is in inbox, by name.  Only what a hotfolder
+// would take counts for one, so the rest of Downloads can come and go.
+func fingerprints(config *Config, inbox string) ([]fingerprint, error) {
+	infos, err := ioutil.ReadDir(inbox)
+	if err != nil {
+		return nil, err
+	}
+	hot := config.hotfolder(inbox)
+	var fps []fingerprint
+	for _, fi := range infos {
+		if hot != nil && !hot.takes(fi) {
+			continue
+		}
+		fps = append(fps, fingerprint{fi.Name(), fi.Size(), fi.ModTime().UTC()})
+	}
+	return fps, nil
+}
+
+// savePlan writes what the dry run in fr would have done, with the
+// fingerprints of the inboxes it read.
+func savePlan(config *Config, fr *fileResult) error {
+	sp := savedPlan{Made: clock.Now(), Moves: fr.plan, Inboxes: map[string][]fingerprint{}}
+	if sp.Moves == nil {
+		sp.Moves = []fileinbox.Move{}
+	}
+	for _, inbox := range fr.readInboxes {
+		fps, err := fingerprints(config, inbox)
+		if err != nil {
+			return err
+		}
+		sp.Inboxes[inbox] = fps
+	}
+	data, err := json.MarshalIndent(sp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(config.lastPlan(), data, config.perms.state())
+}
+
+// loadLastPlan reads the saved plan, refusing it if any inbox has
+// changed since it was made.
+func loadLastPlan(config *Config) (*fileinbox.Plan, error) {
+	data, err := ioutil.ReadFile(config.lastPlan())
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("there is no saved plan.  Make one with --%s --%s", dryRunFlag, savePlanFlag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sp savedPlan
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", config.lastPlan())
+	}
+	inboxes := make([]string, 0, len(sp.Inboxes))
+	for inbox := range sp.Inboxes {
+		inboxes = append(inboxes, inbox)
+	}
+	sort.Strings(inboxes)
+	for _, inbox := range inboxes {
+		now, err := fingerprints(config, inbox)
+		if err != nil {
+			return nil, err
+		}
+		if change := fingerprintChange(sp.Inboxes[inbox], now); change != "" {
+			return nil, errors.Errorf("%s has changed since the plan was made at %s: %s.  Run --%s --%s again", inbox, sp.Made.Format(time.RFC3339), change, dryRunFlag, savePlanFlag)
+		}
+	}
+	return &fileinbox.Plan{Moves: sp.Moves}, nil
+}
+
+// fingerprintChange describes the first difference between was and now,
+// or returns "" if they are the same.
+func fingerprintChange(was, now []fingerprint) string {
+	byName := map[string]fingerprint{}
+	for _, fp := range now {
+		byName[fp.Name] = fp
+	}
+	for _, fp := range was {
+		n, ok := byName[fp.Name]
+		if !ok {
+			return fp.Name + " is gone"
+		}
+		if n.Size != fp.Size || !n.ModTime.Equal(fp.ModTime) {
+			return fp.Name + " was modified"
+		}
+		delete(byName, fp.Name)
+	}
+	for _, fp := range now {
+		if _, ok := byName[fp.Name]; ok {
+			return fp.Name + " is new"
+		}
+	}
+	return ""
+}
+
+// doApplyLastPlan carries out the plan saved by --dry-run --save-plan,
+// once only.
+func doApplyLastPlan(ctx *cli.Context) error {
+	return runApply(ctx, func(c *Config) (*fileinbox.Plan, error) {
+		plan, err := loadLastPlan(c)
+		if err != nil {
+			return nil, err
+		}
+		// it described the inboxes as they were, and once applied they
+		// aren't any more
+		return plan, os.Remove(c.lastPlan())
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestApplyLastPlan(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	defer func() { configFile = "" }()
+	configFile = path.Join(root, "fileinbox.yaml")
+	inbox := path.Join(root, "inbox")
+	ok(t, ioutil.WriteFile(configFile, []byte(fmt.Sprintf("root: %s\nextrainboxes: [%s]\n", root, inbox)), 0600))
+	createFiles(t, root, []string{"filed/pge/", "inbox/20160825_pge.pdf"})
+
+	app := func(args ...string) error { return newCli().Run(append([]string{"fileinbox"}, args...)) }
+	assert(t, app(flagify(applyLastPlanFlag)) != nil, "expected applying without a saved plan to fail")
+	assert(t, app(flagify(savePlanFlag)) != nil, "expected --save-plan without --dry-run to be rejected")
+	ok(t, app(flagify(dryRunFlag), flagify(savePlanFlag)))
+
+	// something new arrived since
+	late := path.Join(inbox, "20160901_pge.pdf")
+	ok(t, ioutil.WriteFile(late, []byte("late"), 0600))
+	assert(t, app(flagify(applyLastPlanFlag)) != nil, "expected a plan for a changed inbox to be refused")
+	ok(t, os.Remove(late))
+
+	// or what was planned was rewritten
+	planned := path.Join(inbox, "20160825_pge.pdf")
+	ok(t, os.Chtimes(planned, time.Now(), time.Now().Add(time.Hour)))
+	assert(t, app(flagify(applyLastPlanFlag)) != nil, "expected a plan for a modified file to be refused")
+
+	ok(t, app(flagify(dryRunFlag), flagify(savePlanFlag)))
+	ok(t, app(flagify(applyLastPlanFlag)))
+	equals(t, []string{"2016/", "2016/20160825_pge.pdf"}, readFiles(t, path.Join(root, "filed/pge")))
+	assert(t, app(flagify(applyLastPlanFlag)) != nil, "expected a plan to be applied only once")
+}
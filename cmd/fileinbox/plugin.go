@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const defaultPluginTimeout = 30 * time.Second
+
+// Plugin is an external program that can file what we can't parse.  It
+// is run with the path of the inbox file as its last argument, and
+// answers on stdout with JSON like
+//
+//	{"dest": "pge", "date": "20160825", "name": "20160825_pge_bill.pdf"}
+//
+// The date may also be written 2016-08-25, and name is optional; when
+// set the file is renamed while filing.  A plugin that doesn't recognize
+// the file should print {} or exit with a non-zero status, and the next
+// plugin gets a turn.
+type Plugin struct {
+	Name    string
+	Command []string      // the program and any leading arguments
+	Timeout time.Duration // defaults to 30s
+}
+
+type pluginAnswer struct {
+	Dest string `json:"dest"`
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+func (p Plugin) validate() error {
+	if len(p.Command) == 0 {
+		return errors.Errorf("plugin %q has no command", p.Name)
+	}
+	return nil
+}
+
+// run asks the plugin about file.  It returns nil, nil when the plugin
+// doesn't recognize the file.
+func (p Plugin) run(opts fileinbox.ParseOptions, file string) (*parsedName, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := append(append([]string{}, p.Command[1:]...), file)
+	cmd := exec.CommandContext(ctx, p.Command[0], args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "running plugin %q", p.Name)
+	}
+
+	var answer pluginAnswer
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &answer); err != nil {
+		return nil, errors.Wrapf(err, "plugin %q answered %q", p.Name, stdout.String())
+	}
+	if answer.Dest == "" {
+		return nil, nil
+	}
+	return answer.parsed(opts, path.Base(file))
+}
+
+func (a pluginAnswer) parsed(opts fileinbox.ParseOptions, baseName string) (*parsedName, error) {
+	var t time.Time
+	var err error
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		if t, err = time.ParseInLocation(layout, a.Date, opts.Zone()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.Errorf("unable to parse date %q.  We expect a value like 20160825 or 2016-08-25", a.Date)
+	}
+	if strings.ContainsAny(a.Name, `/\`) || a.Name == "." || a.Name == ".." {
+		return nil, errors.Errorf("name %q must be a plain file name", a.Name)
+	}
+
+	dest := opts.ResolveDest(a.Dest)
+	if err = checkDest(dest); err != nil {
+		return nil, err
+	}
+	if err = opts.ForDest(dest).CheckFuture(baseName, t); err != nil {
+		return nil, err
+	}
+
+	parsed := &parsedName{baseName: baseName, dest: dest}
+	parsed.setDate(t)
+	if a.Name != baseName {
+		parsed.newName = a.Name
+	}
+	return parsed, nil
+}
+
+// fromPlugins asks each plugin in turn about file, returning nil, nil if
+// none of them recognize it.
+func (c *Config) fromPlugins(opts fileinbox.ParseOptions, file string) (*parsedName, error) {
+	for _, p := range c.Plugins {
+		parsed, err := p.run(opts, file)
+		if parsed != nil || err != nil {
+			return parsed, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+const testPlugin = `#!/bin/sh
+case "$(basename "$1")" in
+  statement-*.pdf) echo '{"dest": "bank", "date": "2016-07-31", "name": "20160731_bank_statement.pdf"}' ;;
+  *) echo '{}' ;;
+esac
+`
+
+func TestPlugins(t *testing.T) {
+	start := []string{
+		"filed/bank/",
+		"inbox/mystery.pdf",
+	}
+	expected := []string{
+		"filed/",
+		"filed/bank/",
+		"filed/bank/2016/",
+		"filed/bank/2016/20160731_bank_statement.pdf",
+		"inbox/",
+		"inbox/mystery.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	// the plugin renames this one, so give it the contents readFiles
+	// will expect under its new name
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "statement-0731.pdf"), []byte("contents for 20160731_bank_statement.pdf"), 0600))
+	script := path.Join(root, "plugin.sh")
+	ok(t, ioutil.WriteFile(script, []byte(testPlugin), 0700))
+
+	config := &Config{
+		Root:    root,
+		Plugins: []Plugin{{Name: "bank", Command: []string{"/bin/sh", script}}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(1), fr.failureCount)
+
+	ok(t, os.Remove(script))
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+func TestPluginDests(t *testing.T) {
+	opts := (&Config{}).parseOptions(false)
+	for _, dest := range []string{"", "/etc", "../outside", "bank/../../outside"} {
+		a := pluginAnswer{Dest: dest, Date: "2016-07-31", Name: "20160731_bank.pdf"}
+		_, err := a.parsed(opts, "statement.pdf")
+		assert(t, err != nil, "Expected the dest %q to be rejected", dest)
+	}
+	a := pluginAnswer{Dest: "bank/checking", Date: "2016-07-31", Name: "20160731_bank.pdf"}
+	parsed, err := a.parsed(opts, "statement.pdf")
+	ok(t, err)
+	equals(t, "bank/checking", parsed.dest)
+}
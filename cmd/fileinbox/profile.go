@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cpuProfileFlag string = "cpuprofile"
+	memProfileFlag string = "memprofile"
+	traceFlag      string = "trace"
+)
+
+// profiles are what --cpuprofile, --memprofile and --trace are writing
+// for this run, to look into a slow run over a huge archive with go
+// tool pprof or go tool trace.
+type profiles struct {
+	cpu, trace *os.File
+	mem        string
+}
+
+// startProfiles starts the CPU profile and the trace, each if named.
+// The heap profile is written when they stop.
+func startProfiles(cpu, mem, traceTo string) (*profiles, error) {
+	p := &profiles{mem: mem}
+	if cpu != "" {
+		f, err := os.Create(cpu)
+		if err != nil {
+			return p, errors.Wrapf(err, "--%s", cpuProfileFlag)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return p, errors.Wrapf(err, "--%s", cpuProfileFlag)
+		}
+		p.cpu = f
+	}
+	if traceTo != "" {
+		f, err := os.Create(traceTo)
+		if err != nil {
+			return p, errors.Wrapf(err, "--%s", traceFlag)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			return p, errors.Wrapf(err, "--%s", traceFlag)
+		}
+		p.trace = f
+	}
+	return p, nil
+}
+
+// stop finishes whatever was started, and writes the heap profile.  It
+// may be called on a nil p.
+func (p *profiles) stop() error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		errs = append(errs, errors.Wrapf(p.cpu.Close(), "--%s", cpuProfileFlag))
+		p.cpu = nil
+	}
+	if p.trace != nil {
+		trace.Stop()
+		errs = append(errs, errors.Wrapf(p.trace.Close(), "--%s", traceFlag))
+		p.trace = nil
+	}
+	if p.mem != "" {
+		errs = append(errs, errors.Wrapf(writeHeapProfile(p.mem), "--%s", memProfileFlag))
+		p.mem = ""
+	}
+	return anyError(errs...)
+}
+
+func writeHeapProfile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	// so the profile is of what is still in use
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	cpu, mem, trace := path.Join(dir, "cpu.pprof"), path.Join(dir, "mem.pprof"), path.Join(dir, "run.trace")
+	ok(t, newCli().Run([]string{"file_inbox", flagify(cpuProfileFlag), cpu, flagify(memProfileFlag), mem, flagify(traceFlag), trace,
+		"bench", flagify(filesFlag), "10", flagify(onFlag), dir}))
+	for _, name := range []string{cpu, mem, trace} {
+		fi, err := os.Stat(name)
+		ok(t, err)
+		assert(t, fi.Size() > 0, "expected %s to be written", name)
+	}
+
+	// a profile that can't be written stops the run before it starts
+	err = newCli().Run([]string{"file_inbox", flagify(cpuProfileFlag), path.Join(dir, "missing", "cpu.pprof"),
+		"bench", flagify(filesFlag), "10", flagify(onFlag), dir})
+	assert(t, err != nil, "expected an unwritable profile to be rejected")
+}
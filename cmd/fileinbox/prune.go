@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// pruner removes empty directories from the archive.  Year directories,
+// and the rollover and month directories in them, go as soon as they are
+// empty.  A dest only goes once pruning its years has left it empty, so
+// a dest that was created ahead of time is kept.
+type pruner struct {
+	inboxes []string
+	dryRun  bool
+	removed []string
+	failed  int
+}
+
+// visit prunes under dir, returning whether dir is now empty and
+// whether anything under it was pruned.
+func (p *pruner) visit(dir string) (empty, pruned bool) {
+	children, err := ioutil.ReadDir(dir)
+	if err != nil {
+		printf(progress, styleFailure, "Unable to read %q: %+v\n", dir, err)
+		p.failed++
+		return false, false
+	}
+	empty = true
+	for _, c := range children {
+		child := path.Join(dir, c.Name())
+		if !c.IsDir() || p.holdsInbox(child) {
+			empty = false
+			continue
+		}
+		childEmpty, childPruned := p.visit(child)
+		pruned = pruned || childPruned
+		if !childEmpty || !(yearDir.MatchString(c.Name()) || yearDir.MatchString(path.Base(dir)) || childPruned) {
+			empty = false
+			continue
+		}
+		if !p.remove(child) {
+			empty = false
+			continue
+		}
+		pruned = true
+	}
+	return empty, pruned
+}
+
+func (p *pruner) remove(dir string) bool {
+	if p.dryRun {
+		printf(progress, stylePlain, "Would remove empty %s\n", dir)
+	} else if err := os.Remove(dir); err != nil {
+		printf(progress, styleFailure, "Unable to remove %q: %+v\n", dir, err)
+		p.failed++
+		return false
+	} else {
+		printf(progress, stylePlain, "Removed empty %s\n", dir)
+	}
+	p.removed = append(p.removed, dir)
+	return true
+}
+
+// holdsInbox returns true if dir is an inbox or has one under it.
+func (p *pruner) holdsInbox(dir string) bool {
+	for _, inbox := range p.inboxes {
+		if inbox == dir || strings.HasPrefix(inbox, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneEmpty removes the empty directories in the archive, returning
+// the ones it removed.
+func pruneEmpty(config *Config, dryRun bool) ([]string, error) {
+	p := &pruner{dryRun: dryRun}
+	for _, inbox := range append([]string{config.inbox()}, config.ExtraInboxes...) {
+		p.inboxes = append(p.inboxes, path.Clean(inbox))
+	}
+	p.visit(config.filed())
+	if p.failed != 0 {
+		return p.removed, errors.Errorf("unable to prune %d directories", p.failed)
+	}
+	return p.removed, nil
+}
+
+func doPrune(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "prune-empty")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "prune-empty")
+	}
+	removed, err := pruneEmpty(config, ctx.Bool(dryRunFlag))
+	if err != nil {
+		return errors.Wrap(err, "prune-empty")
+	}
+	if len(removed) == 0 {
+		printf(progress, stylePlain, "Nothing to prune\n")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestPruneEmpty(t *testing.T) {
+	start := []string{
+		"filed/pge/2015/",
+		"filed/pge/2016/20160702_pge.pdf",
+		"filed/merged/2014/",
+		"filed/merged/2015b/",
+		"filed/1099forms/",
+		"filed/insurance/auto/2016/07/",
+		"filed/insurance/home/",
+		"filed/scans/",
+		"inbox/",
+	}
+	expected := []string{
+		"filed/",
+		"filed/1099forms/",
+		"filed/insurance/",
+		"filed/insurance/home/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160702_pge.pdf",
+		"filed/scans/",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root, ExtraInboxes: []string{path.Join(root, "filed", "scans")}}
+	before := readFiles(t, root)
+	removed, err := pruneEmpty(config, true)
+	ok(t, err)
+	equals(t, 7, len(removed))
+	equals(t, before, readFiles(t, root))
+
+	removed, err = pruneEmpty(config, false)
+	ok(t, err)
+	sort.Strings(removed)
+	equals(t, []string{
+		path.Join(root, "filed/insurance/auto"),
+		path.Join(root, "filed/insurance/auto/2016"),
+		path.Join(root, "filed/insurance/auto/2016/07"),
+		path.Join(root, "filed/merged"),
+		path.Join(root, "filed/merged/2014"),
+		path.Join(root, "filed/merged/2015b"),
+		path.Join(root, "filed/pge/2015"),
+	}, removed)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// filedDoc is a document that has already been filed.
+type filedDoc struct {
+	path string
+	dest string
+	date time.Time
+	size int64
+}
+
+// findFiled returns the documents filed under dest, oldest first.  We
+// walk the whole dest, so rollover directories and nested dests are
+// included.  Files whose names don't parse are left out.
+func findFiled(config *Config, opts fileinbox.ParseOptions, dest string) ([]filedDoc, error) {
+	var docs []filedDoc
+	destDir := config.dest(dest)
+	walkFunc := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		parsed, err := fileinbox.ParseFileName(info.Name(), opts)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(destDir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		docs = append(docs, filedDoc{p, nestedDest(dest, rel), parsed.Date, info.Size()})
+		return nil
+	}
+	if err := walkDest(destDir, walkFunc); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", destDir)
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		if !docs[i].date.Equal(docs[j].date) {
+			return docs[i].date.Before(docs[j].date)
+		}
+		return docs[i].path < docs[j].path
+	})
+	return docs, nil
+}
+
+// yearDir matches year directories, their letter rollovers and fiscal
+// years, e.g. 2016, 2016b and FY2015-16.
+var yearDir = regexp.MustCompile(`^(?:\d\d\d\d[a-z]?|FY\d\d\d\d-\d\d)$`)
+
+// nestedDest returns the dest a document is in, given the directory it
+// is in relative to dest.  Anything before the year directory is a
+// nested dest.
+func nestedDest(dest, rel string) string {
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if part == "." || yearDir.MatchString(part) {
+			break
+		}
+		dest = path.Join(dest, part)
+	}
+	return dest
+}
+
+// queryConfig loads the config for commands that only look at the
+// archive.  --root is honored but never saved.
+func queryConfig(ctx *cli.Context) (*Config, fileinbox.ParseOptions, error) {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return nil, fileinbox.ParseOptions{}, err
+	}
+	if r := ctx.String(rootFlag); r != "" {
+		config.Root = r
+	}
+	if config.Root == "" {
+		return nil, fileinbox.ParseOptions{}, errors.Errorf("You must use the --%s flag to specify a root directory.", rootFlag)
+	}
+	return config, config.parseOptions(true), nil
+}
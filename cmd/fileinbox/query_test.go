@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFindFiled(t *testing.T) {
+	start := []string{
+		"filed/pge/2015/20150702_pge.pdf",
+		"filed/pge/2016/20160702_pge.pdf",
+		"filed/pge/2016b/20160901_pge.pdf",
+		"filed/pge/2016/20160702_pge_late.pdf",
+		"filed/pge/2016/notes.txt",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root}
+	docs, err := findFiled(config, config.parseOptions(false), "pge")
+	ok(t, err)
+	var names []string
+	for _, d := range docs {
+		names = append(names, path.Base(d.path))
+	}
+	equals(t, []string{"20150702_pge.pdf", "20160702_pge.pdf", "20160702_pge_late.pdf", "20160901_pge.pdf"}, names)
+
+	latest := selectDocs(docs, time.Time{})
+	equals(t, 1, len(latest))
+	equals(t, path.Join(root, "filed/pge/2016b/20160901_pge.pdf"), latest[0].path)
+
+	onDay := selectDocs(docs, time.Date(2016, 7, 2, 0, 0, 0, 0, time.Local))
+	equals(t, 2, len(onDay))
+
+	equals(t, 0, len(selectDocs(docs, time.Date(2016, 7, 3, 0, 0, 0, 0, time.Local))))
+}
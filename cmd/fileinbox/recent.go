@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const daysFlag string = "days"
+
+// recentDoc is a document filed lately, according to the journal.
+type recentDoc struct {
+	Time  time.Time `json:"time"`
+	Dest  string    `json:"dest"`
+	Inbox string    `json:"inbox"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Label string    `json:"label,omitempty"`
+	// Gone is set when nothing is filed as To any more, e.g. it was
+	// removed to the trash or renamed since.
+	Gone bool `json:"gone,omitempty"`
+}
+
+// recentlyFiled returns what was filed since since, oldest first, in dest
+// or the dests under it if dest is set, and by runs labeled label if
+// label is set.
+func recentlyFiled(config *Config, since time.Time, dest, label string) ([]recentDoc, error) {
+	entries, err := config.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	var docs []recentDoc
+	for _, e := range entries {
+		if e.Action != "" || e.Time.Before(since) || (label != "" && e.Label != label) {
+			continue
+		}
+		d := config.destName(e.To)
+		if dest != "" && d != dest && !strings.HasPrefix(d, dest+"/") {
+			continue
+		}
+		_, statErr := os.Lstat(e.To)
+		docs = append(docs, recentDoc{e.Time, d, path.Dir(e.From), e.From, e.To, e.Label, statErr != nil})
+	}
+	return docs, nil
+}
+
+func writeRecent(w io.Writer, docs []recentDoc) error {
+	if len(docs) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing was filed.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "When\tDest\tFrom\tFiled as\tRun\n")
+	for _, d := range docs {
+		to := d.To
+		if d.Gone {
+			to += " (gone since)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Time.Format("2006-01-02 15:04"), d.Dest, d.Inbox, to, d.Label)
+	}
+	return tw.Flush()
+}
+
+func doRecent(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "recent")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "recent")
+	}
+	days := ctx.Int(daysFlag)
+	if days <= 0 {
+		return errors.Errorf("recent: --%s must be at least 1", daysFlag)
+	}
+	dest := ctx.String(destFlag)
+	if dest != "" {
+		dest = opts.ResolveDest(dest)
+	}
+	since := clock.Now().AddDate(0, 0, -days)
+	if ctx.IsSet(labelFlag) && !ctx.IsSet(daysFlag) {
+		// a batch is wanted however long ago it was
+		since = time.Time{}
+	}
+	docs, err := recentlyFiled(config, since, dest, ctx.String(labelFlag))
+	if err != nil {
+		return errors.Wrap(err, "recent")
+	}
+	if ctx.String(outputFlag) == outputJSON {
+		if docs == nil {
+			docs = []recentDoc{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(docs)
+	}
+	return writeRecent(os.Stdout, docs)
+}
+
+func recentCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "recent",
+		Usage:  "Show what was filed lately, when, from which inbox and where it went, according to the journal.",
+		Action: doRecent,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  daysFlag,
+				Value: 7,
+				Usage: "How many days back to look.",
+			},
+			&cli.StringFlag{
+				Name:  destFlag,
+				Usage: "Only show what was filed in this dest, or the dests under it.",
+			},
+			&cli.StringFlag{
+				Name:  labelFlag,
+				Usage: fmt.Sprintf("Only show what was filed by runs with this --%s, however long ago unless --%s is set.", runLabelFlag, daysFlag),
+			},
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestRecentlyFiled(t *testing.T) {
+	defer func() { clock = fileinbox.SystemClock }()
+	now := time.Date(2016, 9, 10, 12, 0, 0, 0, time.Local)
+	clock = fileinbox.FixedClock(now)
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/insurance/auto/2016/20160901_insurance-auto.pdf",
+	})
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	inbox := path.Join(root, "inbox")
+	pge := path.Join(root, "filed/pge/2016/20160825_pge.pdf")
+	auto := path.Join(root, "filed/insurance/auto/2016/20160901_insurance-auto.pdf")
+	gone := path.Join(root, "filed/pge/2016/20160905_pge.pdf")
+	ok(t, config.appendJournal([]journalEntry{
+		{Time: now.AddDate(0, 0, -20), From: path.Join(inbox, "20160801_pge.pdf"), To: path.Join(root, "filed/pge/2016/20160801_pge.pdf")},
+		{Time: now.AddDate(0, 0, -3), From: path.Join(inbox, "20160825_pge.pdf"), To: pge},
+		{Time: now.AddDate(0, 0, -2), From: path.Join(inbox, "20160901_insurance-auto.pdf"), To: auto},
+		{Time: now.AddDate(0, 0, -1), From: path.Join(inbox, "20160905_pge.pdf"), To: gone},
+		{Time: now.AddDate(0, 0, -1), From: gone, To: path.Join(root, "trash/pge/2016/20160905_pge.pdf"), Action: journalRemoved},
+	}))
+
+	docs, err := recentlyFiled(config, now.AddDate(0, 0, -7), "", "")
+	ok(t, err)
+	equals(t, 3, len(docs))
+	equals(t, []string{"pge", "insurance/auto", "pge"}, []string{docs[0].Dest, docs[1].Dest, docs[2].Dest})
+	equals(t, inbox, docs[0].Inbox)
+	equals(t, []bool{false, false, true}, []bool{docs[0].Gone, docs[1].Gone, docs[2].Gone})
+
+	docs, err = recentlyFiled(config, now.AddDate(0, 0, -7), "insurance", "")
+	ok(t, err)
+	equals(t, 1, len(docs))
+	equals(t, auto, docs[0].To)
+
+	var out bytes.Buffer
+	ok(t, writeRecent(&out, docs))
+	assert(t, strings.Contains(out.String(), "2016-09-08 12:00  insurance/auto"), "unexpected output %q", out.String())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// renameTemplate rewrites names as they are filed, for DestConfig.Rename.
+// Each template is also a pattern, so renamed documents can still be
+// parsed, organized and found.
+type renameTemplate struct {
+	parts []templatePart
+	re    *regexp.Regexp
+}
+
+// templatePart is literal text, or a field such as date, along with the
+// text before it.
+type templatePart struct {
+	literal string
+	field   string
+}
+
+// The fields a template may use.  {description} and {ext} may be empty,
+// in which case the text right before them is left out too, so
+// {dest}_{description}.{ext} gives pge.pdf rather than pge_.pdf.
+var templateFields = map[string]string{
+	"date":        `(?P<year>\d\d\d\d)(?P<month>\d\d)(?P<date>\d\d)`,
+	"year":        `(?P<year>\d\d\d\d)`,
+	"month":       `(?P<month>\d\d)`,
+	"day":         `(?P<date>\d\d)`,
+	"dest":        `(?P<dest>.+?)`,
+	"description": `(?P<desc>.*?)`,
+	"ext":         `(?P<ext>[^.]+)`,
+}
+
+var templateField = regexp.MustCompile(`\{([a-z]+)\}`)
+
+func parseTemplate(t string) (*renameTemplate, error) {
+	rt := &renameTemplate{}
+	seen := map[string]bool{}
+	last := 0
+	for _, m := range templateField.FindAllStringSubmatchIndex(t, -1) {
+		field := t[m[2]:m[3]]
+		if _, ok := templateFields[field]; !ok {
+			return nil, errors.Errorf("unknown field {%s} in %q", field, t)
+		}
+		if seen[field] {
+			return nil, errors.Errorf("{%s} appears twice in %q", field, t)
+		}
+		seen[field] = true
+		rt.parts = append(rt.parts, templatePart{literal: t[last:m[0]], field: field})
+		last = m[1]
+	}
+	if last < len(t) {
+		rt.parts = append(rt.parts, templatePart{literal: t[last:]})
+	}
+	if !seen["dest"] {
+		return nil, errors.Errorf("%q must have a {dest}", t)
+	}
+	if !seen["date"] && !(seen["year"] && seen["month"] && seen["day"]) {
+		return nil, errors.Errorf("%q must have a {date}, or a {year}, {month} and {day}", t)
+	}
+	if strings.Contains(t, "/") {
+		return nil, errors.Errorf("%q must make a plain file name", t)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, p := range rt.parts {
+		lit := regexp.QuoteMeta(p.literal)
+		switch p.field {
+		case "":
+			b.WriteString(lit)
+		case "description", "ext":
+			b.WriteString("(?:" + lit + templateFields[p.field] + ")?")
+		default:
+			b.WriteString(lit + templateFields[p.field])
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "compiling %q", t)
+	}
+	rt.re = re
+	return rt, nil
+}
+
+// render returns the name parsed should be filed under.  sep joins the
+// parts of a nested dest.
+func (rt *renameTemplate) render(opts fileinbox.ParseOptions, parsed *parsedName, sep string) string {
+	name := parsed.filedName()
+	ext := filepath.Ext(name)
+	var desc string
+	if p, err := fileinbox.ParseFileName(name, opts); err == nil {
+		desc = p.Description
+	}
+	values := map[string]string{
+		"date":        parsed.year + parsed.month + parsed.date,
+		"year":        parsed.year,
+		"month":       parsed.month,
+		"day":         parsed.date,
+		"dest":        strings.ReplaceAll(parsed.dest, "/", sep),
+		"description": desc,
+		"ext":         strings.TrimPrefix(ext, "."),
+	}
+
+	var b strings.Builder
+	for _, p := range rt.parts {
+		v := values[p.field]
+		if v == "" && (p.field == "description" || p.field == "ext") {
+			continue
+		}
+		b.WriteString(p.literal)
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// compileRenames compiles each dest's Rename, adding its pattern to the
+// ones names are parsed with.
+func (c *Config) compileRenames() error {
+	c.renames = map[string]*renameTemplate{}
+	var dests []string
+	for dest, dc := range c.Dests {
+		if dc.Rename != "" {
+			dests = append(dests, dest)
+		}
+	}
+	// the patterns are tried in order, so keep it the same every time
+	sort.Strings(dests)
+	for _, dest := range dests {
+		dc := c.Dests[dest]
+		rt, err := parseTemplate(dc.Rename)
+		if err != nil {
+			return errors.Wrapf(err, "dest %s", dest)
+		}
+		c.renames[dest] = rt
+		c.patterns = append(c.patterns, rt.re)
+	}
+	return nil
+}
+
+// rename applies the dest's rename template, if it has one, to parsed.
+func (c *Config) rename(opts fileinbox.ParseOptions, parsed *parsedName) {
+	rt := c.renames[parsed.dest]
+	if rt == nil {
+		return
+	}
+	sep := c.DestSeparator
+	if sep == "" {
+		sep = "-"
+	}
+	if name := rt.render(opts, parsed, sep); name != parsed.baseName {
+		parsed.newName = name
+	}
+}
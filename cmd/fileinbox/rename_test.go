@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func TestRenameTemplate(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "filed/bank/", "inbox/20160702_bank.pdf"})
+	// these are renamed while filing, so give them the contents readFiles
+	// will expect under their new names
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "20160825_pge_bill.pdf"), []byte("contents for pge-20160825_bill.pdf"), 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "20160901_pge.pdf"), []byte("contents for pge-20160901.pdf"), 0600))
+
+	config := &Config{
+		Root:  root,
+		Dests: map[string]DestConfig{"pge": {Rename: "{dest}-{date}_{description}.{ext}"}},
+	}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{
+		"filed/",
+		"filed/bank/",
+		"filed/bank/2016/",
+		"filed/bank/2016/20160702_bank.pdf",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/pge-20160825_bill.pdf",
+		"filed/pge/2016/pge-20160901.pdf",
+		"inbox/",
+	}, found)
+
+	// renamed documents can still be found, by their new names
+	docs, err := findFiled(config, opts, "pge")
+	ok(t, err)
+	equals(t, 2, len(docs))
+	equals(t, "2016-08-25", docs[0].date.Format("2006-01-02"))
+
+	// and the journal remembers what they were called
+	entries, err := config.readJournal()
+	ok(t, err)
+	equals(t, 3, len(entries))
+	renamed := map[string]string{}
+	for _, e := range entries {
+		renamed[path.Base(e.To)] = path.Base(e.From)
+	}
+	equals(t, "20160825_pge_bill.pdf", renamed["pge-20160825_bill.pdf"])
+
+	for _, bad := range []string{"{date}.{ext}", "{dest}.{ext}", "{dest}-{date}-{when}", "{dest}/{date}", "{dest}{date}{dest}"} {
+		config.Dests["pge"] = DestConfig{Rename: bad}
+		assert(t, config.validate() != nil, "Expected %q to be rejected", bad)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const (
+	monthFlag string = "month"
+	outFlag   string = "out"
+
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// gapMonths is how many months in a row a dest must have had a document
+// for a month without one to be worth pointing out.
+const gapMonths = 3
+
+// monthReport is what happened in one month, to be archived alongside
+// the documents or mailed to whoever shares the paperwork.
+type monthReport struct {
+	Month time.Time
+	Made  time.Time
+	// Filed is what was filed during the month, by dest, largest first.
+	// Bytes is how much the archive grew by.
+	Filed []usage
+	Total usage
+	// Batches is what was filed by labeled runs, by label, see
+	// --run-label.
+	Batches []usage
+	// Gaps are dests that had a document dated in each of the months
+	// before, but have none dated in this one, e.g. a missing statement.
+	Gaps []string
+	// Failures are what is waiting for someone to look at it, as of when
+	// the report was made.
+	Failures []reportFailure
+}
+
+type reportFailure struct {
+	File string
+	Why  string
+}
+
+// buildReport reports on the month starting at month.  What was filed
+// comes from the journal; gaps come from the dates of what is filed.
+func buildReport(config *Config, opts fileinbox.ParseOptions, month time.Time) (*monthReport, error) {
+	end := month.AddDate(0, 1, 0)
+	r := &monthReport{Month: month, Made: clock.Now(), Total: usage{Name: "total"}}
+
+	entries, err := config.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	byDest := map[string]*usage{}
+	byLabel := map[string]*usage{}
+	for _, e := range entries {
+		if e.Action != "" || e.Time.Before(month) || !e.Time.Before(end) {
+			continue
+		}
+		if e.Label != "" {
+			if byLabel[e.Label] == nil {
+				byLabel[e.Label] = &usage{Name: e.Label}
+			}
+			byLabel[e.Label].Files++
+		}
+		dest := config.destName(e.To)
+		u, ok := byDest[dest]
+		if !ok {
+			u = &usage{Name: dest}
+			byDest[dest] = u
+		}
+		u.Files++
+		r.Total.Files++
+		// anything since moved or pruned no longer takes up space
+		if fi, err := os.Stat(e.To); err == nil {
+			u.Bytes += fi.Size()
+			r.Total.Bytes += fi.Size()
+		}
+	}
+	for _, u := range byDest {
+		r.Filed = append(r.Filed, *u)
+	}
+	sort.Slice(r.Filed, func(i, j int) bool {
+		if r.Filed[i].Bytes != r.Filed[j].Bytes {
+			return r.Filed[i].Bytes > r.Filed[j].Bytes
+		}
+		return r.Filed[i].Name < r.Filed[j].Name
+	})
+	for _, u := range byLabel {
+		r.Batches = append(r.Batches, *u)
+	}
+	sort.Slice(r.Batches, func(i, j int) bool { return r.Batches[i].Name < r.Batches[j].Name })
+
+	if r.Gaps, err = findGaps(config, opts, month); err != nil {
+		return nil, err
+	}
+	if r.Failures, err = findFailures(config, opts); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// findGaps returns the dests with a document dated in each of the
+// gapMonths before month, but none dated in month.
+func findGaps(config *Config, opts fileinbox.ParseOptions, month time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(config.filed())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading dests")
+	}
+	const key = "2006-01"
+	seen := map[string]map[string]bool{}
+	for _, fi := range infos {
+		if !config.destEntry(fi) {
+			continue
+		}
+		docs, err := findFiled(config, opts, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			if seen[d.dest] == nil {
+				seen[d.dest] = map[string]bool{}
+			}
+			seen[d.dest][d.date.Format(key)] = true
+		}
+	}
+
+	var gaps []string
+	for dest, months := range seen {
+		if months[month.Format(key)] {
+			continue
+		}
+		regular := true
+		for i := 1; i <= gapMonths; i++ {
+			if !months[month.AddDate(0, -i, 0).Format(key)] {
+				regular = false
+				break
+			}
+		}
+		if regular {
+			gaps = append(gaps, dest)
+		}
+	}
+	sort.Strings(gaps)
+	return gaps, nil
+}
+
+// findFailures returns what is in quarantine, and what is in the
+// inboxes that we don't know where to file.  Hotfolders are left out,
+// as what we can't file there was never meant for us.
+func findFailures(config *Config, opts fileinbox.ParseOptions) ([]reportFailure, error) {
+	var failures []reportFailure
+	for _, inbox := range config.inboxes() {
+		if config.hotfolder(inbox) != nil || !isDir(inbox) {
+			continue
+		}
+		infos, err := ioutil.ReadDir(inbox)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", inbox)
+		}
+		inboxOpts := config.inboxOptions(opts, inbox)
+		for _, fi := range infos {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			if _, err := planFile(config, inboxOpts, inbox, fi); err != nil {
+				failures = append(failures, reportFailure{path.Join(inbox, fi.Name()), err.Error()})
+			}
+		}
+	}
+	if isDir(config.quarantine()) {
+		infos, err := ioutil.ReadDir(config.quarantine())
+		if err != nil {
+			return nil, errors.Wrap(err, "reading quarantine")
+		}
+		for _, fi := range infos {
+			if !fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+				failures = append(failures, reportFailure{path.Join(config.quarantine(), fi.Name()), "quarantined"})
+			}
+		}
+	}
+	return failures, nil
+}
+
+func (r *monthReport) title() string {
+	return "Filing report for " + r.Month.Format("January 2006")
+}
+
+func (r *monthReport) writeMarkdown(w io.Writer) error {
+	fmt.Fprintf(w, "# %s\n\n", r.title())
+	fmt.Fprintf(w, "Made %s.\n\n", r.Made.Format("2006-01-02 15:04"))
+
+	fmt.Fprintf(w, "## Filed\n\n")
+	if len(r.Filed) == 0 {
+		fmt.Fprintf(w, "Nothing was filed.\n\n")
+	} else {
+		fmt.Fprintf(w, "| Dest | Documents | Size |\n|---|--:|--:|\n")
+		for _, u := range r.Filed {
+			fmt.Fprintf(w, "| %s | %s | %s |\n", u.Name, formatCount(int64(u.Files)), formatBytes(u.Bytes))
+		}
+		fmt.Fprintf(w, "\nIn all, %s.  The archive grew by %s.\n\n", plural(uint32(r.Total.Files), "document", "documents"), formatBytes(r.Total.Bytes))
+	}
+
+	if len(r.Batches) != 0 {
+		fmt.Fprintf(w, "## Batches\n\n")
+		for _, b := range r.Batches {
+			fmt.Fprintf(w, "- %s: %s\n", b.Name, plural(uint32(b.Files), "document", "documents"))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "## Gaps\n\n")
+	if len(r.Gaps) == 0 {
+		fmt.Fprintf(w, "None.\n\n")
+	} else {
+		fmt.Fprintf(w, "These usually have a document every month, but have none dated this month:\n\n")
+		for _, g := range r.Gaps {
+			fmt.Fprintf(w, "- %s\n", g)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "## Needs attention\n\n")
+	if len(r.Failures) == 0 {
+		_, err := fmt.Fprintf(w, "Nothing.\n")
+		return err
+	}
+	for _, f := range r.Failures {
+		fmt.Fprintf(w, "- `%s`: %s\n", f.File, f.Why)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func (r *monthReport) writeHTML(w io.Writer) error {
+	esc := html.EscapeString
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", esc(r.title()))
+	fmt.Fprintf(w, "<h1>%s</h1>\n", esc(r.title()))
+	fmt.Fprintf(w, "<p>Made %s.</p>\n", r.Made.Format("2006-01-02 15:04"))
+
+	fmt.Fprintf(w, "<h2>Filed</h2>\n")
+	if len(r.Filed) == 0 {
+		fmt.Fprintf(w, "<p>Nothing was filed.</p>\n")
+	} else {
+		fmt.Fprintf(w, "<table>\n<tr><th>Dest</th><th>Documents</th><th>Size</th></tr>\n")
+		for _, u := range r.Filed {
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n", esc(u.Name), formatCount(int64(u.Files)), formatBytes(u.Bytes))
+		}
+		fmt.Fprintf(w, "</table>\n<p>In all, %s.  The archive grew by %s.</p>\n", plural(uint32(r.Total.Files), "document", "documents"), formatBytes(r.Total.Bytes))
+	}
+
+	if len(r.Batches) != 0 {
+		fmt.Fprintf(w, "<h2>Batches</h2>\n<ul>\n")
+		for _, b := range r.Batches {
+			fmt.Fprintf(w, "<li>%s: %s</li>\n", esc(b.Name), plural(uint32(b.Files), "document", "documents"))
+		}
+		fmt.Fprintf(w, "</ul>\n")
+	}
+
+	fmt.Fprintf(w, "<h2>Gaps</h2>\n")
+	if len(r.Gaps) == 0 {
+		fmt.Fprintf(w, "<p>None.</p>\n")
+	} else {
+		fmt.Fprintf(w, "<p>These usually have a document every month, but have none dated this month:</p>\n<ul>\n")
+		for _, g := range r.Gaps {
+			fmt.Fprintf(w, "<li>%s</li>\n", esc(g))
+		}
+		fmt.Fprintf(w, "</ul>\n")
+	}
+
+	fmt.Fprintf(w, "<h2>Needs attention</h2>\n")
+	if len(r.Failures) == 0 {
+		fmt.Fprintf(w, "<p>Nothing.</p>\n")
+	} else {
+		fmt.Fprintf(w, "<ul>\n")
+		for _, f := range r.Failures {
+			fmt.Fprintf(w, "<li><code>%s</code>: %s</li>\n", esc(f.File), esc(f.Why))
+		}
+		fmt.Fprintf(w, "</ul>\n")
+	}
+	_, err := fmt.Fprintf(w, "</body>\n</html>\n")
+	return err
+}
+
+// parseMonth parses --month, e.g. 2024-08.  Without one, we report on
+// last month.
+func parseMonth(opts fileinbox.ParseOptions, s string) (time.Time, error) {
+	if s == "" {
+		now := opts.Now()
+		return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, opts.Zone()), nil
+	}
+	t, err := time.ParseInLocation("2006-01", s, opts.Zone())
+	if err != nil {
+		return time.Time{}, errors.Errorf("unable to parse --%s %q.  We expect a month like 2024-08", monthFlag, s)
+	}
+	return t, nil
+}
+
+func doReport(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "report")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "report")
+	}
+	format := ctx.String(formatFlag)
+	if format != formatMarkdown && format != formatHTML {
+		return errors.Errorf("report: unknown --%s %q.  We expect %s or %s", formatFlag, format, formatMarkdown, formatHTML)
+	}
+	month, err := parseMonth(opts, ctx.String(monthFlag))
+	if err != nil {
+		return errors.Wrap(err, "report")
+	}
+	r, err := buildReport(config, opts, month)
+	if err != nil {
+		return errors.Wrap(err, "report")
+	}
+
+	var w io.Writer = os.Stdout
+	if out := ctx.String(outFlag); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return errors.Wrap(err, "report")
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == formatHTML {
+		err = r.writeHTML(w)
+	} else {
+		err = r.writeMarkdown(w)
+	}
+	return errors.Wrap(err, "report")
+}
+
+func reportCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "report",
+		Usage:  "Write up a month's filing: what was filed, by dest, what seems to be missing, and what needs attention.",
+		Action: doReport,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  monthFlag,
+				Usage: "The month to report on, e.g. 2024-08.  Last month if not set.",
+			},
+			&cli.StringFlag{
+				Name:  formatFlag,
+				Value: formatMarkdown,
+				Usage: "One of markdown or html.",
+			},
+			&cli.StringFlag{
+				Name:  outFlag,
+				Usage: "Write the report to this file, rather than to stdout.",
+			},
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/2024/20240505_pge.pdf",
+		"filed/pge/2024/20240605_pge.pdf",
+		"filed/pge/2024/20240705_pge.pdf",
+		"filed/chase/2024/20240510_chase.pdf",
+		"filed/chase/2024/20240610_chase.pdf",
+		"filed/chase/2024/20240710_chase.pdf",
+		"filed/chase/2024/20240810_chase.pdf",
+		"filed/att/2024/20240801_att.pdf",
+		"inbox/notadate.pdf",
+		"quarantine/20240802_att.pdf",
+	})
+
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	filed := func(when, to string) journalEntry {
+		at, err := time.ParseInLocation("2006-01-02", when, time.Local)
+		ok(t, err)
+		return journalEntry{Time: at, From: path.Join(root, "inbox", path.Base(to)), To: path.Join(root, "filed", to)}
+	}
+	batch := filed("2024-08-12", "att/2024/20240801_att.pdf")
+	batch.Label = "scan batch"
+	ok(t, config.appendJournal([]journalEntry{
+		filed("2024-07-11", "chase/2024/20240710_chase.pdf"),
+		filed("2024-08-11", "chase/2024/20240810_chase.pdf"),
+		batch,
+		filed("2024-08-20", "att/2024/gone.pdf"),
+		filed("2024-09-01", "pge/2024/20240705_pge.pdf"),
+	}))
+
+	month, err := parseMonth(config.parseOptions(false), "2024-08")
+	ok(t, err)
+	r, err := buildReport(config, config.parseOptions(false), month)
+	ok(t, err)
+	size := int64(len("contents for 20240810_chase.pdf"))
+	equals(t, []usage{{"chase", 1, size}, {"att", 2, size - 2}}, r.Filed)
+	equals(t, usage{"total", 3, 2*size - 2}, r.Total)
+	equals(t, []usage{{"scan batch", 1, 0}}, r.Batches)
+	equals(t, []string{"pge"}, r.Gaps)
+	equals(t, 2, len(r.Failures))
+	equals(t, path.Join(root, "inbox/notadate.pdf"), r.Failures[0].File)
+	equals(t, "quarantined", r.Failures[1].Why)
+
+	var md bytes.Buffer
+	ok(t, r.writeMarkdown(&md))
+	for _, want := range []string{"# Filing report for August 2024", "| chase | 1 |", "- pge", "- scan batch: 1 document", "notadate.pdf"} {
+		assert(t, strings.Contains(md.String(), want), "expected %q in\n%s", want, md.String())
+	}
+	var page bytes.Buffer
+	ok(t, r.writeHTML(&page))
+	assert(t, strings.Contains(page.String(), "<td>chase</td>"), "expected a row for chase in\n%s", page.String())
+
+	_, err = parseMonth(config.parseOptions(false), "August")
+	assert(t, err != nil, "expected a bad month to be rejected")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// rootMarker marks a directory as a fileinbox root, so a mistyped --root
+// can't send us off reorganizing some unrelated directory.
+const rootMarker = ".fileinbox-root"
+
+// checkRoot makes sure root is somewhere we are allowed to move files
+// around in.
+func checkRoot(root string) error {
+	if !isDir(root) {
+		return errors.Errorf("root %q does not appear to be a directory", root)
+	}
+	_, err := os.Stat(path.Join(root, rootMarker))
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return errors.Wrap(err, "checking root")
+	}
+
+	// Roots from before we had a marker will already have a filed
+	// directory, so we can adopt them.
+	if isDir(path.Join(root, "filed")) {
+		fmt.Fprintf(progress, "Marking %s as a fileinbox root\n", root)
+		return writeRootMarker(root)
+	}
+	return errors.Errorf("%q does not look like a fileinbox root, as it has no %s file.  If it is the right directory, run fileinbox init %s", root, rootMarker, root)
+}
+
+func writeRootMarker(root string) error {
+	p := path.Join(root, rootMarker)
+	if err := ioutil.WriteFile(p, []byte("This directory is managed by fileinbox.\n"), 0600); err != nil {
+		return errors.Wrapf(err, "writing %s", p)
+	}
+	return nil
+}
+
+// realPath returns name made absolute, with links resolved as far as
+// they exist, so two ways of naming a directory compare the same.
+func realPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return path.Clean(name)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+// checkOverlaps refuses configs whose directories are nested in ways
+// that would have us process our own output: an inbox inside filed, or
+// holding it, files the same documents again and again, and a CC root
+// overlapping the root or an inbox mirrors documents onto themselves or
+// back into an inbox.
+func (c *Config) checkOverlaps() error {
+	root, filed := realPath(c.Root), realPath(c.filed())
+	for _, inbox := range c.inboxes() {
+		real := realPath(inbox)
+		switch {
+		case within(real, filed):
+			return errors.Errorf("the inbox %s is inside %s, so what we file would be filed again.  Move the inbox out of the archive", inbox, c.filed())
+		case within(filed, real):
+			return errors.Errorf("the inbox %s holds %s, so the archive itself would be filed.  Use a directory beside it as the inbox", inbox, c.filed())
+		}
+	}
+	for _, cc := range c.ccRoots() {
+		real := realPath(cc)
+		if within(real, root) || within(root, real) {
+			return errors.Errorf("the CC root %s overlaps the root %s, so documents would be mirrored onto themselves.  Mirror to another drive or directory", cc, c.Root)
+		}
+		for _, inbox := range c.inboxes() {
+			if r := realPath(inbox); within(real, r) || within(r, real) {
+				return errors.Errorf("the CC root %s overlaps the inbox %s, so mirrored documents would be filed again", cc, inbox)
+			}
+		}
+	}
+	return nil
+}
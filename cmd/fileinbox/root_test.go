@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCheckRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+
+	assert(t, checkRoot(root) != nil, "Expected %s to be rejected without a marker", root)
+
+	// an existing archive gets adopted
+	createFiles(t, root, []string{"filed/"})
+	ok(t, checkRoot(root))
+	_, err = os.Stat(path.Join(root, rootMarker))
+	ok(t, err)
+}
+
+func TestCheckOverlaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	root := path.Join(dir, "root")
+	createFiles(t, dir, []string{"root/filed/pge/", "root/inbox/", "scans/", "usb/"})
+	ok(t, os.Symlink(path.Join(root, "filed"), path.Join(dir, "archive")))
+
+	tests := []struct {
+		name    string
+		inboxes []string
+		cc      string
+		ok      bool
+	}{
+		{"separate", []string{path.Join(dir, "scans")}, path.Join(dir, "usb"), true},
+		{"inbox in filed", []string{path.Join(root, "filed/pge")}, "", false},
+		{"inbox in filed through a link", []string{path.Join(dir, "archive/pge")}, "", false},
+		{"inbox holding filed", []string{dir}, "", false},
+		{"cc in the root", nil, path.Join(root, "mirror"), false},
+		{"cc holding the root", nil, dir, false},
+		{"cc in an inbox", []string{path.Join(dir, "scans")}, path.Join(dir, "scans/cc"), false},
+	}
+	for _, tc := range tests {
+		config := &Config{Root: root, ExtraInboxes: tc.inboxes}
+		config.CC.Root = tc.cc
+		err := config.checkOverlaps()
+		assert(t, (err == nil) == tc.ok, "%s: unexpected %v", tc.name, err)
+	}
+}
+
+func TestInit(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	root = path.Join(root, "archive")
+
+	args := []string{
+		"file_inbox",
+		flagify(skipConfigFlag),
+		"init",
+		flagify(destFlag), "pge",
+		flagify(destFlag), "bank",
+		root,
+	}
+	ok(t, newCli().Run(args))
+
+	for _, d := range []string{"inbox", "filed", "filed/pge", "filed/bank"} {
+		assert(t, isDir(path.Join(root, d)), "Expected %s to be created", d)
+	}
+	ok(t, checkRoot(root))
+}
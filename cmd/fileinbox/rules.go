@@ -0,0 +1,269 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// Where a rule can get the date of a file from.
+const (
+	dateFromName  = "name"
+	dateFromMtime = "mtime"
+	dateFromExif  = "exif"
+)
+
+// Rule routes inbox files that don't follow the naming convention, or
+// need something other than the name to decide where they go.  Every
+// matcher that is set must match for the rule to apply.
+//
+// e.g. to file photos from a phone by when they were taken:
+//
+//	rules:
+//	- inbox: inbox-photos
+//	  ext: jpg
+//	  dest: photos
+//	  date: exif
+type Rule struct {
+	// Matchers
+	Inbox   string // path or base name of the inbox the file is in
+	Glob    string // e.g. *.jpg
+	Regex   string // matched against the base name
+	Ext     string // e.g. jpg or .jpg, case insensitive
+	MinSize int64  // in bytes
+	MaxSize int64  // in bytes, zero means no limit
+
+	// Actions
+	Dest string   // if not set, the dest comes from the name
+	Also []string // other dests that get a copy, e.g. tax
+	Date string   // one of name (the default), mtime or exif, with name and exif falling back to a photo's sidecar
+	Tags []string // recorded in the journal and the index, e.g. phone
+
+	re *regexp.Regexp
+}
+
+func (r *Rule) compile() error {
+	if r.Inbox == "" && r.Glob == "" && r.Regex == "" && r.Ext == "" && r.MinSize == 0 && r.MaxSize == 0 {
+		return errors.New("a rule must have at least one of inbox, glob, regex, ext, minsize or maxsize")
+	}
+	if r.Glob != "" {
+		if _, err := filepath.Match(r.Glob, ""); err != nil {
+			return errors.Wrapf(err, "bad glob %q", r.Glob)
+		}
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return errors.Wrapf(err, "bad regex %q", r.Regex)
+		}
+		r.re = re
+	}
+	switch r.Date {
+	case "", dateFromName, dateFromMtime, dateFromExif:
+	default:
+		return errors.Errorf("unknown date source %q.  We expect one of %s, %s or %s", r.Date, dateFromName, dateFromMtime, dateFromExif)
+	}
+	for _, d := range append([]string{r.Dest}, r.Also...) {
+		if d == "" {
+			continue
+		}
+		if err := checkDest(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(inbox string, fi os.FileInfo) bool {
+	base := fi.Name()
+	if r.Inbox != "" && r.Inbox != path.Clean(inbox) && r.Inbox != path.Base(inbox) {
+		return false
+	}
+	if r.Glob != "" {
+		if ok, _ := filepath.Match(r.Glob, base); !ok {
+			return false
+		}
+	}
+	if r.re != nil && !r.re.MatchString(base) {
+		return false
+	}
+	if r.Ext != "" && !strings.EqualFold(filepath.Ext(base), "."+strings.TrimPrefix(r.Ext, ".")) {
+		return false
+	}
+	if fi.Size() < r.MinSize {
+		return false
+	}
+	if r.MaxSize != 0 && fi.Size() > r.MaxSize {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) apply(opts fileinbox.ParseOptions, inbox string, fi os.FileInfo) (*parsedName, error) {
+	base := fi.Name()
+
+	parsed := &parsedName{baseName: base, dest: opts.ResolveDest(r.Dest)}
+	if r.Dest == "" {
+		var err error
+		if parsed, err = parseFileName(opts, base); err != nil {
+			return nil, err
+		}
+	}
+
+	var t time.Time
+	switch r.Date {
+	case "", dateFromName:
+		if parsed.year != "" {
+			break
+		}
+		d, err := fileinbox.ParseDate(base, opts.ForDest(parsed.dest))
+		if err != nil {
+			// a photo may have a sidecar saying when it was taken
+			var sidecarErr error
+			if t, sidecarErr = sidecarDate(path.Join(inbox, base), opts.Zone()); sidecarErr != nil {
+				return nil, err
+			}
+			break
+		}
+		parsed.setDate(d)
+	case dateFromMtime:
+		t = fi.ModTime().In(opts.Zone())
+	case dateFromExif:
+		var err error
+		if t, err = photoDate(path.Join(inbox, base), opts.Zone()); err != nil {
+			return nil, err
+		}
+	}
+	if !t.IsZero() {
+		if err := opts.ForDest(parsed.dest).CheckFuture(base, t); err != nil {
+			return nil, err
+		}
+		parsed.setDate(t)
+	}
+	for _, d := range r.Also {
+		if d = opts.ResolveDest(d); d != parsed.dest && !hasString(parsed.also, d) {
+			parsed.also = append(parsed.also, d)
+		}
+	}
+	for _, tag := range r.Tags {
+		if !hasString(parsed.tags, tag) {
+			parsed.tags = append(parsed.tags, tag)
+		}
+	}
+
+	return parsed, nil
+}
+
+// unsorted returns how to file baseName in the unsorted dest, if there is
+// one and baseName starts with a date, or nil.  What we can't place in
+// a hotfolder was never meant for us, so it is left be.
+func (c *Config) unsorted(opts fileinbox.ParseOptions, inbox, baseName string) *parsedName {
+	if c.Unsorted == "" || c.hotfolder(inbox) != nil {
+		return nil
+	}
+	return datedIn(opts, opts.ResolveDest(c.Unsorted), baseName)
+}
+
+// byExtension returns how to file baseName in the dest for its
+// extension, if there is one and baseName starts with a date, or is a
+// photo whose sidecar says when it was taken, or nil.  Like unsorted,
+// it leaves hotfolders be.
+func (c *Config) byExtension(opts fileinbox.ParseOptions, inbox, baseName string) *parsedName {
+	dest, ok := c.extDests[extOf(baseName)]
+	if !ok || c.hotfolder(inbox) != nil {
+		return nil
+	}
+	dest = opts.ResolveDest(dest)
+	if parsed := datedIn(opts, dest, baseName); parsed != nil {
+		return parsed
+	}
+	t, err := sidecarDate(path.Join(inbox, baseName), opts.Zone())
+	if err != nil || opts.ForDest(dest).CheckFuture(baseName, t) != nil {
+		return nil
+	}
+	// named as a pipeline names photos, so the name has the date
+	parsed := &parsedName{baseName: baseName, dest: dest}
+	parsed.setDate(t)
+	parsed.newName = t.Format("20060102") + "_" + strings.ReplaceAll(dest, "/", c.DestSeparator) + "_" + baseName
+	return parsed
+}
+
+// extOf returns the extension of name as ExtDests are keyed, e.g. jpg
+// for IMG.JPG.
+func extOf(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// datedIn returns how to file baseName in dest, or nil if it doesn't
+// start with a date.
+func datedIn(opts fileinbox.ParseOptions, dest, baseName string) *parsedName {
+	t, err := fileinbox.ParseDate(baseName, opts.ForDest(dest))
+	if err != nil {
+		return nil
+	}
+	parsed := &parsedName{baseName: baseName, dest: dest}
+	parsed.setDate(t)
+	if opts.DateOrder != "" && opts.DateOrder != fileinbox.DateOrderYMD {
+		// the archive's dates are always YYYYMMDD
+		parsed.newName = t.Format("20060102") + baseName[8:]
+	}
+	return parsed
+}
+
+// rule returns the first rule that matches the file, or nil.
+func (c *Config) rule(inbox string, fi os.FileInfo) *Rule {
+	for i := range c.Rules {
+		if c.Rules[i].matches(inbox, fi) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// planFile decides where an inbox file goes.  Rules from the config get
+// the first chance, then we fall back to parsing the name, then to dests
+// that supply a default date, then to plugins, then to the dests for
+// extensions, and finally to the unsorted dest.
+func planFile(config *Config, opts fileinbox.ParseOptions, inbox string, fi os.FileInfo) (parsed *parsedName, err error) {
+	if r := config.rule(inbox, fi); r != nil {
+		parsed, err = r.apply(opts, inbox, fi)
+	} else {
+		parsed, err = parseFileName(opts, fi.Name())
+		if err != nil {
+			if undated := config.undated(opts, fi.Name()); undated != nil {
+				parsed, err = undated, nil
+			}
+		}
+		if err != nil {
+			fromPlugin, pluginErr := config.fromPlugins(opts, path.Join(inbox, fi.Name()))
+			if pluginErr != nil {
+				return nil, pluginErr
+			}
+			if fromPlugin != nil {
+				parsed, err = fromPlugin, nil
+			}
+		}
+		if err != nil {
+			if byExt := config.byExtension(opts, inbox, fi.Name()); byExt != nil {
+				parsed, err = byExt, nil
+			}
+		}
+		if err != nil {
+			if unsorted := config.unsorted(opts, inbox, fi.Name()); unsorted != nil {
+				parsed, err = unsorted, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	parsed.size = fi.Size()
+	return parsed, nil
+}
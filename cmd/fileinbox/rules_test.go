@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	start := []string{
+		"filed/photos/",
+		"filed/bank/",
+		"filed/pge/",
+		"photos/IMG_0001.jpg",
+		"photos/notes.txt",
+		"photos/20160701_pge.pdf",
+		"photos/20160702_statement.csv",
+	}
+	expected := []string{
+		"filed/",
+		"filed/photos/",
+		"filed/photos/2015/",
+		"filed/photos/2015/IMG_0001.jpg",
+		"filed/bank/",
+		"filed/bank/2016/",
+		"filed/bank/2016/20160702_statement.csv",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160701_pge.pdf",
+		"photos/",
+		"photos/notes.txt",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+
+	createFiles(t, root, start)
+	inbox := path.Join(root, "photos")
+	taken := time.Date(2015, 3, 4, 12, 0, 0, 0, time.Local)
+	ok(t, os.Chtimes(path.Join(inbox, "IMG_0001.jpg"), taken, taken))
+
+	config := &Config{
+		Root:    root,
+		Aliases: map[string]string{"stmt": "bank"},
+		Rules: []Rule{
+			{Inbox: "photos", Ext: "JPG", Dest: "photos", Date: dateFromMtime},
+			// rule dests are resolved like parsed ones
+			{Glob: "*_statement.csv", Dest: "stmt"},
+		},
+	}
+	ok(t, config.validate())
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.failureCount) // notes.txt has no date
+	equals(t, uint32(3), fr.okCount)
+	var movedBytes int64
+	for _, n := range []string{"IMG_0001.jpg", "20160701_pge.pdf", "20160702_statement.csv"} {
+		movedBytes += int64(len("contents for " + n))
+	}
+	equals(t, movedBytes, fr.movedBytes)
+	equals(t, int64(len("contents for notes.txt")), fr.skippedBytes)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+// exifJPEG returns a JPEG with nothing but EXIF data saying it was
+// taken at when, e.g. 2015:03:04 12:00:00.
+func exifJPEG(when string) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	// IFD0 holds just DateTime, an ASCII string after the IFD
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	for _, v := range []interface{}{uint16(exifTagDateTime), uint16(2), uint32(len(when) + 1), uint32(26)} {
+		binary.Write(&tiff, binary.LittleEndian, v)
+	}
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.WriteString(when + "\x00")
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+len("Exif\x00\x00")+tiff.Len()))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+func TestRuleTags(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/photos/", "photos/"})
+	inbox := path.Join(root, "photos")
+	ok(t, ioutil.WriteFile(path.Join(inbox, "IMG_0002.jpg"), exifJPEG("2015:03:04 12:00:00"), 0600))
+
+	config := &Config{
+		Root:  root,
+		Rules: []Rule{{Inbox: "photos", Ext: "jpg", Dest: "photos", Date: dateFromExif, Tags: []string{"phone", "family"}}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	filed := path.Join(root, "filed", "photos", "2015", "IMG_0002.jpg")
+	_, err = os.Stat(filed)
+	ok(t, err)
+
+	journal, err := config.readJournal()
+	ok(t, err)
+	equals(t, 1, len(journal))
+	equals(t, []string{"phone", "family"}, journal[0].Tags)
+
+	// the tags are kept when the index is brought up to date
+	later := time.Date(2016, 1, 1, 0, 0, 0, 0, time.Local)
+	ok(t, os.Chtimes(filed, later, later))
+	idx, err := config.readIndex()
+	ok(t, err)
+	stats, err := updateIndex(config, idx, false, 1)
+	ok(t, err)
+	equals(t, 1, stats.Hashed)
+	equals(t, []string{"phone", "family"}, idx.entries["photos/2015/IMG_0002.jpg"].Tags)
+}
+
+func TestRuleValidation(t *testing.T) {
+	for _, r := range []Rule{
+		{Dest: "photos"},
+		{Regex: "(", Dest: "photos"},
+		{Glob: "[", Dest: "photos"},
+		{Ext: "jpg", Date: "sundial"},
+		{Ext: "jpg", Dest: "/etc"},
+		{Ext: "jpg", Dest: "photos/../../outside"},
+	} {
+		config := &Config{Rules: []Rule{r}}
+		assert(t, config.validate() != nil, "Expected %#v to be rejected", r)
+	}
+}
+
+func TestAlso(t *testing.T) {
+	start := []string{
+		"filed/insurance/",
+		"filed/hsa/",
+		"filed/tax/",
+		"filed/bank/",
+		"inbox/20160701_insurance+hsa.pdf",
+		"inbox/20160702_bank+gym.pdf",
+		"inbox/20160703_statement.csv",
+	}
+	expected := []string{
+		"filed/",
+		"filed/insurance/",
+		"filed/insurance/2016/",
+		"filed/insurance/2016/20160701_insurance+hsa.pdf",
+		"filed/hsa/",
+		"filed/hsa/2016/",
+		"filed/hsa/2016/20160701_insurance+hsa.pdf",
+		"filed/tax/",
+		"filed/tax/2016/",
+		"filed/tax/2016/20160703_statement.csv",
+		"filed/bank/",
+		"filed/bank/2016/",
+		"filed/bank/2016/20160703_statement.csv",
+		// gym doesn't exist, so it waits until it does
+		"inbox/",
+		"inbox/20160702_bank+gym.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{
+		Root:  root,
+		Rules: []Rule{{Glob: "*_statement.csv", Dest: "bank", Also: []string{"tax", "bank"}}},
+	}
+	ok(t, config.validate())
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(2), fr.okCount)
+	equals(t, uint32(2), fr.copies)
+	equals(t, map[string]bool{path.Join(root, "filed/gym"): true}, fr.missingDirs)
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	sort.Strings(expected)
+	equals(t, expected, found)
+}
+
+func TestUnsorted(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/",
+		"inbox/20160701_pge.pdf",
+		"inbox/20160702.pdf",
+		"inbox/20170103 scan.pdf",
+		"inbox/notes.txt",
+	})
+
+	config := &Config{Root: root, Unsorted: "unsorted"}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(true), true, false, &fr))
+	equals(t, uint32(3), fr.okCount)
+	equals(t, uint32(1), fr.failureCount) // notes.txt has no date
+
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160701_pge.pdf",
+		"filed/unsorted/",
+		"filed/unsorted/2016/",
+		"filed/unsorted/2016/20160702.pdf",
+		"filed/unsorted/2017/",
+		"filed/unsorted/2017/20170103 scan.pdf",
+		"inbox/",
+		"inbox/notes.txt",
+	}, found)
+
+	config.Unsorted = "/unsorted"
+	assert(t, config.validate() != nil, "expected an absolute unsorted dest to be rejected")
+}
+
+func TestExtDests(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/",
+		"inbox/20160701_pge.csv",
+		"inbox/20160702.JPG",
+		"inbox/20160703.csv",
+		"inbox/20160704.pdf",
+		"inbox/export.csv",
+	})
+
+	config := &Config{Root: root, DestSeparator: "-", Unsorted: "unsorted",
+		ExtDests: map[string]string{"jpg": "photos", ".CSV": "bank/exports"}}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(true), true, false, &fr))
+	equals(t, uint32(4), fr.okCount)
+	equals(t, uint32(1), fr.failureCount) // export.csv has no date
+
+	found := readFiles(t, path.Join(root, "filed"))
+	sort.Strings(found)
+	equals(t, []string{
+		"bank/",
+		"bank/exports/",
+		"bank/exports/2016/",
+		"bank/exports/2016/20160703.csv",
+		"pge/",
+		"pge/2016/",
+		"pge/2016/20160701_pge.csv",
+		"photos/",
+		"photos/2016/",
+		"photos/2016/20160702.JPG",
+		"unsorted/",
+		"unsorted/2016/",
+		"unsorted/2016/20160704.pdf",
+	}, found)
+
+	config.ExtDests = map[string]string{"csv": "../exports"}
+	assert(t, config.validate() != nil, "expected an extension dest outside filed to be rejected")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const nameFlag string = "name"
+
+const defaultScanTimeout = 5 * time.Minute
+
+// defaultScanCommand drives the first scanner SANE finds.
+var defaultScanCommand = []string{"scanimage", "--format=pdf", "--output-file={out}"}
+
+// ScanConfig says how the scan command gets a document from the
+// scanner.  Command writes a pdf to {out}, scanimage if not set.  It can
+// be anything that does, e.g. a script that runs scanimage --batch for a
+// document feeder and bundles the pages.
+type ScanConfig struct {
+	Command []string
+	Timeout time.Duration // 5 minutes if not set
+}
+
+// scanName is what a scan for dest is named in the inbox, e.g.
+// 20240825_pge_bill.pdf.
+func scanName(config *Config, dest, desc string, day time.Time) string {
+	name := day.Format("20060102") + "_" + strings.ReplaceAll(dest, "/", config.DestSeparator)
+	if desc != "" {
+		name += "_" + desc
+	}
+	return name + ".pdf"
+}
+
+// scan runs the scan command, leaving what it scanned in the inbox as
+// name.  It writes to a hidden name first, so a run that comes by
+// meanwhile doesn't file half a scan.
+func (s ScanConfig) scan(inbox, name string) (string, error) {
+	to := path.Join(inbox, name)
+	if _, err := os.Lstat(to); err == nil {
+		return "", errors.Errorf("%s is already in the inbox.  Give this one a --%s", name, nameFlag)
+	}
+	out := path.Join(inbox, ".scan-"+name)
+	defer os.Remove(out)
+
+	args := s.Command
+	if len(args) == 0 {
+		args = defaultScanCommand
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	expanded := make([]string, len(args))
+	for i, a := range args {
+		expanded[i] = strings.ReplaceAll(a, "{out}", out)
+	}
+	cmd := exec.CommandContext(ctx, expanded[0], expanded[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return "", errors.Wrapf(err, "running %s: %s", expanded[0], msg)
+		}
+		return "", errors.Wrapf(err, "running %s", expanded[0])
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Size() == 0 {
+		return "", errors.Errorf("%s did not scan anything", expanded[0])
+	}
+	if err := os.Rename(out, to); err != nil {
+		return "", err
+	}
+	return to, nil
+}
+
+// scanAndFile scans a document for dest into the inbox, and files it
+// right away.
+func scanAndFile(config *Config, opts fileinbox.ParseOptions, dest, desc string, fr *fileResult) error {
+	if !isDir(config.dest(dest)) {
+		return errors.Errorf("there is no dest %q", dest)
+	}
+	inbox := config.inbox()
+	scanned, err := config.Scan.scan(inbox, scanName(config, dest, desc, opts.Now()))
+	if err != nil {
+		return err
+	}
+	printf(progress, stylePlain, "Scanned %s\n", scanned)
+	fi, err := os.Stat(scanned)
+	if err != nil {
+		return err
+	}
+	// we wrote the date year first, whatever the inbox's date order
+	inboxOpts := config.inboxOptions(opts, inbox)
+	inboxOpts.DateOrder = fileinbox.DateOrderYMD
+	return processChunk(inbox, []os.FileInfo{fi}, nil, config, opts, inboxOpts, false, false, fr)
+}
+
+func doScan(ctx *cli.Context) error {
+	start := time.Now()
+	fr := fileResult{missingDirs: map[string]bool{}}
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "scan")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "scan")
+	}
+	dest := ctx.String(destFlag)
+	if dest == "" {
+		return errors.Errorf("scan: which dest is it for?  e.g. --%s pge", destFlag)
+	}
+	if err := checkDest(dest); err != nil {
+		return errors.Wrap(err, "scan")
+	}
+	startRun(ctx.String(runLabelFlag))
+	if fr.work, err = newWorkspace(config); err != nil {
+		return errors.Wrap(err, "scan")
+	}
+	err = scanAndFile(config, opts, opts.ResolveDest(dest), ctx.String(nameFlag), &fr)
+	fr.work.finish(&fr, err)
+	if err != nil {
+		return errors.Wrap(err, "scan")
+	}
+	return fr.summarize(time.Since(start))
+}
+
+func scanCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "scan",
+		Usage:  "Scan a document into the inbox, named for today and the dest, and file it.",
+		Action: doScan,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  destFlag,
+				Usage: "The dest the document is for.",
+			},
+			&cli.StringFlag{
+				Name:  nameFlag,
+				Usage: "A description to add to the name, e.g. bill for 20240825_pge_bill.pdf.",
+			},
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const testScan = "#!/bin/sh\nprintf 'contents for %s' \"$2\" > \"$1\"\n"
+
+func TestScan(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	defer func() { clock = fileinbox.SystemClock }()
+	clock = fileinbox.FixedClock(time.Date(2024, 8, 25, 12, 0, 0, 0, time.Local))
+
+	createFiles(t, root, []string{"inbox/", "filed/insurance/auto/"})
+	script := path.Join(root, "scan.sh")
+	ok(t, ioutil.WriteFile(script, []byte(testScan), 0755))
+	config := &Config{Root: root, DestSeparator: "-"}
+	config.Scan.Command = []string{"/bin/sh", script, "{out}", "20240825_insurance-auto_card.pdf"}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, scanAndFile(config, opts, "insurance/auto", "card", &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, []string{"2024/", "2024/20240825_insurance-auto_card.pdf"}, readFiles(t, path.Join(root, "filed/insurance/auto")))
+	equals(t, []string(nil), readFiles(t, path.Join(root, "inbox")))
+
+	err = scanAndFile(config, opts, "pge", "", &fr)
+	assert(t, err != nil, "expected a scan for a missing dest to be refused")
+
+	// a scanner that fails leaves nothing behind
+	config.Scan.Command = []string{"/bin/sh", "-c", "echo no scanner >&2; exit 1"}
+	err = scanAndFile(config, opts, "insurance/auto", "card", &fr)
+	assert(t, err != nil, "expected a failed scan to be reported")
+	equals(t, []string(nil), readFiles(t, path.Join(root, "inbox")))
+}
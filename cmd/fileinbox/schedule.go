@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// scheduleFile is the Run of a schedule that files the inboxes, as a
+// file arriving does.
+const scheduleFile = "file"
+
+// Schedule has the daemon run a command at set times, so nothing else
+// needs to be set up to, say, prune empty directories every night.
+//
+// e.g.
+//
+//	schedules:
+//	- when: "@every 10m"
+//	  run: [file]
+//	- when: "0 3 * * *"
+//	  run: [prune-empty]
+type Schedule struct {
+	// When is a cron expression, minute hour day-of-month month
+	// day-of-week, such as "0 3 * * *" for 3am every day, or one of
+	// @hourly, @daily (or @nightly), @weekly, @monthly or @every
+	// followed by a duration, such as "@every 10m".
+	When string
+
+	// Run is the fileinbox command to run, with its arguments, e.g.
+	// [du, --top, "10"].  [file] files the inboxes.
+	Run []string
+
+	spec *cronSpec
+	// every is set for @every, in place of spec
+	every time.Duration
+}
+
+func (s *Schedule) compile() error {
+	if len(s.Run) == 0 {
+		return errors.Errorf("schedule %q has nothing to run", s.When)
+	}
+	switch s.Run[0] {
+	case "daemon", "ctl", "watch":
+		return errors.Errorf("schedule %q may not run %s", s.When, s.Run[0])
+	case scheduleFile:
+		if len(s.Run) != 1 {
+			return errors.Errorf("schedule %q: %s takes no arguments", s.When, scheduleFile)
+		}
+	}
+	s.spec, s.every = nil, 0
+	if d := strings.TrimPrefix(s.When, "@every "); d != s.When {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return errors.Wrapf(err, "bad schedule %q", s.When)
+		}
+		if every <= 0 {
+			return errors.Errorf("bad schedule %q.  The duration must be positive", s.When)
+		}
+		s.every = every
+		return nil
+	}
+	spec, err := parseCron(s.When)
+	if err != nil {
+		return errors.Wrapf(err, "bad schedule %q", s.When)
+	}
+	s.spec = spec
+	return nil
+}
+
+// next returns when the schedule is next due after t.
+func (s *Schedule) next(t time.Time) time.Time {
+	if s.every != 0 {
+		return t.Add(s.every)
+	}
+	return s.spec.next(t)
+}
+
+// cronSpec holds the values each field of a cron expression allows,
+// as bit sets.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when those fields are *, as cron
+	// matches either of them when both are restricted
+	domStar, dowStar bool
+}
+
+var cronNicknames = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@nightly": "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// cronFields are the ranges of the fields of a cron expression, in
+// order.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronSpec, error) {
+	if n, ok := cronNicknames[expr]; ok {
+		expr = n
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("expected %d fields, found %d", len(cronFields), len(fields))
+	}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, errors.Wrap(err, cronFields[i].name)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: fields[2] == "*", dowStar: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of *, n, n-m, each
+// optionally followed by /step.
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("bad value %q", part)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, errors.Errorf("%q is outside %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first minute after t that matches, or the zero time
+// if none does in the next five years, as with February 30th.
+func (c *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// runCommand runs a scheduled command as a child process, so it reads
+// the config and reports as it would if run by hand.
+func runCommand(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = progress
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "running %s", strings.Join(args, " "))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedules(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 1, 3, 10, 17, 30, 0, time.Local)
+	for _, tc := range []struct {
+		when     string
+		expected time.Time
+	}{
+		{"@every 10m", now.Add(10 * time.Minute)},
+		{"*/15 * * * *", time.Date(2024, 1, 3, 10, 30, 0, 0, time.Local)},
+		{"0 3 * * *", time.Date(2024, 1, 4, 3, 0, 0, 0, time.Local)},
+		{"@nightly", time.Date(2024, 1, 4, 0, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)},
+		{"30 9 * * 1-5", time.Date(2024, 1, 4, 9, 30, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.Local)},
+		// with both days restricted, either will do
+		{"0 12 15 * 5", time.Date(2024, 1, 5, 12, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.Local)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		s := Schedule{When: tc.when, Run: []string{"prune-empty"}}
+		ok(t, s.compile())
+		equals(t, tc.expected, s.next(now))
+	}
+
+	for _, s := range []Schedule{
+		{When: "* * * *", Run: []string{"du"}},
+		{When: "60 * * * *", Run: []string{"du"}},
+		{When: "*/0 * * * *", Run: []string{"du"}},
+		{When: "@every soon", Run: []string{"du"}},
+		{When: "@every -1m", Run: []string{"du"}},
+		{When: "@daily"},
+		{When: "@daily", Run: []string{"daemon"}},
+		{When: "@daily", Run: []string{"file", "--force"}},
+	} {
+		config := &Config{Schedules: []Schedule{s}}
+		assert(t, config.validate() != nil, "Expected %#v to be rejected", s)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// doSetup walks someone through their first root: where it goes, which
+// dests to start with, whether to mirror it, and what filing would do.
+func doSetup(ctx *cli.Context) error {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "setup")
+	}
+	home, _ := homeDir()
+
+	printf(progress, stylePlain, "Let's set up fileinbox.  Press enter to take the answer in brackets.\n\n")
+	def := config.Root
+	if def == "" && home != "" {
+		def = path.Join(home, "Documents", "fileinbox")
+	}
+	root := expandHome(ask("Where should your documents live?", def), home)
+	if root == "" {
+		return errors.New("setup: we need somewhere to keep the documents")
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return errors.Wrap(err, "setup")
+	}
+
+	var dests []string
+	printf(progress, stylePlain, "\nIf your documents are already sorted into folders, such as Bank and Taxes, they can become dests.\n")
+	if dir := expandHome(ask("Which folder holds them?  Leave it blank to skip.", ""), home); dir != "" {
+		folders, err := dirNames(dir)
+		if err != nil {
+			return errors.Wrap(err, "setup")
+		}
+		for _, f := range folders {
+			dest := destName(f)
+			if dest == "" || hasString(dests, dest) {
+				continue
+			}
+			if confirm("Make a dest called " + dest + " for " + f + "?") {
+				dests = append(dests, dest)
+			}
+		}
+	}
+	for _, d := range dests {
+		if err := checkDest(d); err != nil {
+			return errors.Wrap(err, "setup")
+		}
+	}
+
+	if err := initRoot(config, root, dests); err != nil {
+		return errors.Wrap(err, "setup")
+	}
+
+	printf(progress, stylePlain, "\nA copy of some or all dests can be kept somewhere else too, such as a NAS or a USB drive.\n")
+	if cc := expandHome(ask("Where should the copies go?  Leave it blank to skip.", ""), home); cc != "" {
+		if cc, err = filepath.Abs(cc); err != nil {
+			return errors.Wrap(err, "setup")
+		}
+		var ccDests []string
+		for _, d := range strings.Split(ask("Which dests should be copied, separated by commas?", "*"), ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				ccDests = append(ccDests, d)
+			}
+		}
+		err = config.update(func(c *Config) {
+			c.CC.Root = cc
+			c.CC.Dests = ccDests
+		})
+		if err == nil {
+			err = config.validate()
+		}
+		if err != nil {
+			return errors.Wrap(err, "setup")
+		}
+	}
+
+	printf(progress, styleSuccess, "\nAll set.  Your documents live in %s.\n", root)
+	printf(progress, stylePlain, "Put files named like 20240825_pge_bill.pdf in %s and run fileinbox to file them.\n", config.inbox())
+	for _, inbox := range config.inboxes() {
+		infos, err := ioutil.ReadDir(inbox)
+		if err != nil || len(infos) == 0 {
+			continue
+		}
+		printf(progress, stylePlain, "\nHere is what fileinbox would do with what is in %s now:\n", inbox)
+		fr := fileResult{missingDirs: map[string]bool{}}
+		if err := processInbox(inbox, config, config.parseOptions(false), false, true, &fr); err != nil {
+			return errors.Wrap(err, "setup")
+		}
+		for dir := range fr.missingDirs {
+			printf(progress, styleNotice, "%s would need to be created, with --%s\n", dir, forceFlag)
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~ with home.
+func expandHome(p, home string) string {
+	if home != "" && (p == "~" || strings.HasPrefix(p, "~/")) {
+		return path.Join(home, strings.TrimPrefix(p, "~"))
+	}
+	return p
+}
+
+// dirNames returns the names of the directories in dir.
+func dirNames(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// destName turns a folder name such as "Tax Returns" into a dest, such
+// as taxreturns.  Dests can't hold _ or ., which end a dest in a name.
+func destName(folder string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(folder) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func setupCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "setup",
+		Usage:  "Answer a few questions to set up fileinbox for the first time.",
+		Action: doSetup,
+	}
+}
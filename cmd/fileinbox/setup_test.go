@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	defer func() { configFile = "" }()
+	configFile = path.Join(dir, "fileinbox.yaml")
+	defer func() { confirmInput = os.Stdin }()
+
+	createFiles(t, dir, []string{
+		"old/Bank/statement.pdf",
+		"old/Tax Returns/",
+		"old/Misc/",
+	})
+	root := path.Join(dir, "docs")
+	confirmInput = strings.NewReader(strings.Join([]string{
+		root,
+		path.Join(dir, "old"),
+		"y", // bank
+		"n", // misc
+		"y", // taxreturns
+		path.Join(dir, "mirror"),
+		"tax*",
+	}, "\n") + "\n")
+
+	ok(t, newCli().Run([]string{"fileinbox", "setup"}))
+
+	for _, d := range []string{"inbox", "filed/bank", "filed/taxreturns"} {
+		assert(t, isDir(path.Join(root, d)), "expected %s to be created", d)
+	}
+	assert(t, !isDir(path.Join(root, "filed/misc")), "expected misc to be skipped")
+	_, err = os.Stat(path.Join(dir, "old/Bank/statement.pdf"))
+	ok(t, err)
+
+	saved := &Config{persist: true}
+	ok(t, saved.read())
+	equals(t, root, saved.Root)
+	equals(t, path.Join(dir, "mirror"), saved.CC.Root)
+	equals(t, []string{"tax*"}, saved.CC.Dests)
+}
+
+func TestDestName(t *testing.T) {
+	equals(t, "taxreturns", destName("Tax Returns"))
+	equals(t, "2016", destName("2016"))
+	equals(t, "", destName("_.-"))
+}
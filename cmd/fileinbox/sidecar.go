@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// Photos exported from Google Photos, by Takeout, come with a JSON
+// sidecar holding when each was taken, which is all there is to date
+// one whose EXIF was stripped, such as from a burst or a chat app.  For
+// IMG_1234.jpg it is IMG_1234.jpg.json, or in newer exports
+// IMG_1234.jpg.supplemental-metadata.json.  The sidecar goes wherever
+// its photo does.
+var sidecarSuffixes = []string{".supplemental-metadata.json", ".json"}
+
+var errNoSidecarDate = errors.New("no sidecar with when it was taken")
+
+// sidecar returns the sidecar of the photo name in dir, and the suffix
+// it has after the photo's name, if there is one.
+func sidecar(dir, name string) (string, string, bool) {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			// a sidecar has none of its own
+			return "", "", false
+		}
+	}
+	for _, suffix := range sidecarSuffixes {
+		p := path.Join(dir, name+suffix)
+		if fi, err := os.Lstat(p); err == nil && fi.Mode().IsRegular() {
+			return p, suffix, true
+		}
+	}
+	return "", "", false
+}
+
+// sidecarOf returns true if name, in dir, is the sidecar of a photo
+// there, which is filed along with it.  It is only if sidecar would
+// pick it for the photo, so one it passes over, such as IMG_1234.jpg.json
+// beside IMG_1234.jpg.supplemental-metadata.json, is filed on its own
+// rather than left behind.
+func sidecarOf(dir, name string) bool {
+	for _, suffix := range sidecarSuffixes {
+		photo := strings.TrimSuffix(name, suffix)
+		if photo == name || photo == "" {
+			continue
+		}
+		if fi, err := os.Lstat(path.Join(dir, photo)); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if p, _, ok := sidecar(dir, photo); ok && p == path.Join(dir, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// takeoutSidecar is the part of a sidecar we use.  Takeout writes the
+// time as a string of seconds since the epoch.
+type takeoutSidecar struct {
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// sidecarDate returns when the photo file was taken, in loc, according
+// to its sidecar.
+func sidecarDate(file string, loc *time.Location) (time.Time, error) {
+	name, _, ok := sidecar(path.Dir(file), path.Base(file))
+	if !ok {
+		return time.Time{}, errNoSidecarDate
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var s takeoutSidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return time.Time{}, errors.Wrapf(err, "reading %q", name)
+	}
+	secs, err := strconv.ParseInt(s.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil || secs <= 0 {
+		return time.Time{}, errors.Errorf("%q has no photoTakenTime", name)
+	}
+	return time.Unix(secs, 0).In(loc), nil
+}
+
+// photoDate returns when the photo file was taken, from its EXIF data,
+// or failing that its sidecar.  If neither has it, the error is the
+// EXIF one.
+func photoDate(file string, loc *time.Location) (time.Time, error) {
+	t, err := exifDate(file, loc)
+	if err == nil {
+		return t, nil
+	}
+	if fromSidecar, sidecarErr := sidecarDate(file, loc); sidecarErr == nil {
+		return fromSidecar, nil
+	}
+	return t, err
+}
+
+// fileSidecars moves the sidecars of the photos in moves that were
+// filed to sit beside them, named after them.
+func fileSidecars(moves []fileinbox.Move, dryRun bool, fr *fileResult) {
+	for _, m := range moves {
+		from, suffix, ok := sidecar(path.Dir(m.From), path.Base(m.From))
+		if !ok {
+			continue
+		}
+		to := m.To + suffix
+		if dryRun {
+			printf(progress, stylePlain, "Would file %s as %s\n", from, to)
+			continue
+		}
+		if _, err := os.Lstat(m.From); !os.IsNotExist(err) {
+			// the photo wasn't filed, so neither is its sidecar
+			continue
+		}
+		if _, err := os.Lstat(to); err == nil {
+			printf(progress, styleNotice, "Leaving %s in the inbox, as %s already exists\n", from, to)
+			continue
+		}
+		if _, err := fileinbox.MoveFile(from, to); err != nil {
+			printf(progress, styleFailure, "Unable to file %q with its photo: %v\n", from, err)
+			fr.fail(from, failFile, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// 2016-07-01 12:00 UTC
+const testSidecar = `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1467374400", "formatted": "Jul 1, 2016, 12:00:00 PM UTC"}}`
+
+func TestSidecarDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	createFiles(t, dir, []string{"IMG_1234.jpg", "IMG_1235.jpg", "IMG_1236.jpg"})
+	ok(t, ioutil.WriteFile(path.Join(dir, "IMG_1234.jpg.json"), []byte(testSidecar), 0600))
+	ok(t, ioutil.WriteFile(path.Join(dir, "IMG_1235.jpg.supplemental-metadata.json"), []byte(testSidecar), 0600))
+
+	want := time.Date(2016, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"IMG_1234.jpg", "IMG_1235.jpg"} {
+		// there is no EXIF, so the sidecar is used
+		got, err := photoDate(path.Join(dir, name), time.UTC)
+		ok(t, err)
+		equals(t, want, got)
+	}
+	_, err = photoDate(path.Join(dir, "IMG_1236.jpg"), time.UTC)
+	assert(t, err != nil, "expected a photo without EXIF or a sidecar to have no date")
+
+	assert(t, sidecarOf(dir, "IMG_1234.jpg.json"), "expected IMG_1234.jpg.json to be a sidecar")
+	assert(t, sidecarOf(dir, "IMG_1235.jpg.supplemental-metadata.json"), "expected the supplemental metadata to be a sidecar")
+	assert(t, !sidecarOf(dir, "IMG_1236.jpg"), "expected a photo not to be a sidecar")
+
+	// only the sidecar that is used goes with the photo, and a sidecar
+	// has none of its own
+	ok(t, ioutil.WriteFile(path.Join(dir, "IMG_1235.jpg.json"), []byte(testSidecar), 0600))
+	assert(t, !sidecarOf(dir, "IMG_1235.jpg.json"), "expected the sidecar passed over not to be one")
+	ok(t, ioutil.WriteFile(path.Join(dir, "IMG_1234.jpg.json.json"), []byte(testSidecar), 0600))
+	assert(t, !sidecarOf(dir, "IMG_1234.jpg.json.json"), "expected a sidecar of a sidecar not to be one")
+	assert(t, !sidecarOf(dir, "IMG_1236.jpg.supplemental-metadata"), "expected only whole suffixes to match")
+}
+
+func TestSidecarFiling(t *testing.T) {
+	start := []string{
+		"filed/photos/",
+		"filed/shots/",
+		"inbox/IMG_1234.jpg",
+		"inbox/screen.png",
+	}
+	expected := []string{
+		"filed/",
+		"filed/photos/",
+		"filed/photos/2016/",
+		"filed/photos/2016/20160701_photos_IMG_1234.jpg",
+		"filed/photos/2016/20160701_photos_IMG_1234.jpg.supplemental-metadata.json",
+		"filed/shots/",
+		"filed/shots/2016/",
+		"filed/shots/2016/screen.png",
+		"filed/shots/2016/screen.png.json",
+		"inbox/",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox/IMG_1234.jpg.supplemental-metadata.json"), []byte(testSidecar), 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox/screen.png.json"), []byte(testSidecar), 0600))
+
+	config := &Config{
+		Root:     root,
+		TimeZone: "UTC",
+		ExtDests: map[string]string{"jpg": "photos"},
+		Rules:    []Rule{{Ext: "png", Dest: "shots", Date: dateFromExif}},
+	}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, true, &fr))
+	equals(t, 2, len(fr.plan))
+	equals(t, uint32(0), fr.failureCount)
+
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(2), fr.okCount)
+	equals(t, uint32(0), fr.failureCount)
+
+	// readFiles would want the sidecars to hold their names
+	var found []string
+	for _, f := range expected {
+		if _, err := os.Stat(path.Join(root, f)); err == nil {
+			found = append(found, f)
+		}
+	}
+	equals(t, expected, found)
+	left, err := ioutil.ReadDir(path.Join(root, "inbox"))
+	ok(t, err)
+	equals(t, 0, len(left))
+}
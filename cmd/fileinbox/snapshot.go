@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	fromFlag string = "from"
+
+	// snapshotDir holds copies of the config, and of the root's dest
+	// summaries and index, taken before they are rewritten, one
+	// directory per snapshot named for when it was taken.
+	snapshotDir    = "snapshots"
+	snapshotLayout = "20060102T150405"
+	keepSnapshots  = 20
+)
+
+// snapshotFiles are what is kept of the root in a snapshot: the dest
+// summaries, and the index, which merge-dests rewrites.
+var snapshotFiles = []string{destCache, indexFile}
+
+// snapshotConfig copies the config at configPath, and whichever of
+// snapshotFiles root has, into a new snapshot, returning its name.  There
+// is nothing to snapshot before the config is first written, so then
+// it returns "".
+func snapshotConfig(configPath, root string) (string, error) {
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Join(path.Dir(configPath), snapshotDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format(snapshotLayout)
+	// a second snapshot in the same second gets a suffix
+	for i := 2; ; i++ {
+		err = os.Mkdir(path.Join(dir, name), 0700)
+		if !os.IsExist(err) {
+			break
+		}
+		name = time.Now().UTC().Format(snapshotLayout) + "-" + strconv.Itoa(i)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, name, path.Base(configPath)), data, 0600); err != nil {
+		return "", err
+	}
+	if root != "" {
+		for _, f := range snapshotFiles {
+			data, err := ioutil.ReadFile(path.Join(root, f))
+			if err == nil {
+				err = ioutil.WriteFile(path.Join(dir, name, f), data, 0600)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+	return name, pruneSnapshots(dir)
+}
+
+// pruneSnapshots removes all but the newest keepSnapshots snapshots.
+func pruneSnapshots(dir string) error {
+	names, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keepSnapshots {
+		if err := os.RemoveAll(path.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// listSnapshots returns the snapshots in dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return snapshotLess(names[i], names[j]) })
+	return names, nil
+}
+
+// snapshotLess orders snapshot names by time, with 20240101T120000-10
+// after 20240101T120000-9.
+func snapshotLess(a, b string) bool {
+	at, an := splitSnapshot(a)
+	bt, bn := splitSnapshot(b)
+	if at != bt {
+		return at < bt
+	}
+	return an < bn
+}
+
+func splitSnapshot(name string) (string, int) {
+	if len(name) > len(snapshotLayout)+1 && name[len(snapshotLayout)] == '-' {
+		if n, err := strconv.Atoi(name[len(snapshotLayout)+1:]); err == nil {
+			return name[:len(snapshotLayout)], n
+		}
+	}
+	return name, 1
+}
+
+// restoreSnapshot puts the config, and any dest summaries and index,
+// from the snapshot name back in place.  What it replaces is snapshotted first,
+// so a restore can itself be undone.
+func restoreSnapshot(configPath, name string) error {
+	dir := path.Join(path.Dir(configPath), snapshotDir, name)
+	if name == "" || path.Base(name) != name || !isDir(dir) {
+		return errors.Errorf("there is no snapshot %q", name)
+	}
+	// the config may have been in another format then, see config
+	// convert
+	saved := path.Join(dir, path.Base(configPath))
+	for _, f := range configFormats {
+		if _, err := os.Stat(saved); err == nil {
+			break
+		}
+		saved = withFormat(path.Join(dir, path.Base(configPath)), f)
+	}
+	data, err := ioutil.ReadFile(saved)
+	if err != nil {
+		return errors.Wrapf(err, "reading snapshot %s", name)
+	}
+	restored := &Config{}
+	if err := unmarshalConfig(configFormat(saved), data, restored); err != nil {
+		return errors.Wrapf(err, "snapshot %s", name)
+	}
+	if configFormat(saved) != configFormat(configPath) {
+		if data, err = marshalConfig(configFormat(configPath), restored); err != nil {
+			return errors.Wrapf(err, "snapshot %s", name)
+		}
+	}
+	if err := restored.validate(); err != nil {
+		return errors.Wrapf(err, "snapshot %s", name)
+	}
+
+	unlock, err := lockFile(configPath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current := &Config{}
+	if old, err := ioutil.ReadFile(configPath); err == nil {
+		unmarshalConfig(configFormat(configPath), old, current)
+	}
+	if _, err := snapshotConfig(configPath, current.Root); err != nil {
+		return errors.Wrap(err, "snapshotting the current config")
+	}
+	if err := writeFileAtomic(configPath, data, 0600); err != nil {
+		return err
+	}
+
+	if restored.Root == "" || !isDir(restored.Root) {
+		return nil
+	}
+	for _, f := range snapshotFiles {
+		data, err := ioutil.ReadFile(path.Join(dir, f))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path.Join(restored.Root, f), data, restored.perms.state()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func doRestoreConfig(ctx *cli.Context) error {
+	if ctx.Bool(skipConfigFlag) {
+		return errors.Errorf("restore-config: there is no config with --%s", skipConfigFlag)
+	}
+	configPath, err := (&Config{}).path()
+	if err != nil {
+		return errors.Wrap(err, "restore-config")
+	}
+
+	from := ctx.String(fromFlag)
+	if from == "" {
+		names, err := listSnapshots(path.Join(path.Dir(configPath), snapshotDir))
+		if err != nil {
+			return errors.Wrap(err, "restore-config")
+		}
+		if len(names) == 0 {
+			fmt.Fprintln(progress, "There are no snapshots yet.  One is taken each time the config is changed.")
+			return nil
+		}
+		for _, n := range names {
+			fmt.Fprintln(os.Stdout, n)
+		}
+		fmt.Fprintf(progress, "\nRestore one with --%s <snapshot>\n", fromFlag)
+		return nil
+	}
+
+	if err := restoreSnapshot(configPath, from); err != nil {
+		return errors.Wrap(err, "restore-config")
+	}
+	printf(progress, styleSuccess, "Restored the config from %s\n", from)
+	return nil
+}
+
+func restoreConfigCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "restore-config",
+		Usage:  "Put back the config, and the dest summaries and index, from before a change.  Without --from, lists the snapshots there are.",
+		Action: doRestoreConfig,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  fromFlag,
+				Usage: "The snapshot to restore, e.g. 20240101T120000, as listed by restore-config.",
+			},
+		},
+	}
+}
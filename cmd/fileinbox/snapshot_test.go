@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(dir)
+		}
+	}()
+	defer func() { configFile = "" }()
+	configFile = path.Join(dir, "fileinbox.yaml")
+	root := path.Join(dir, "root")
+	ok(t, os.Mkdir(root, 0700))
+	snapshots := path.Join(dir, snapshotDir)
+
+	// nothing to snapshot before the first write
+	config := &Config{persist: true}
+	ok(t, config.update(func(c *Config) { c.Root = root }))
+	names, err := listSnapshots(snapshots)
+	ok(t, err)
+	equals(t, 0, len(names))
+	first, err := ioutil.ReadFile(configFile)
+	ok(t, err)
+	ok(t, (&Config{Root: root}).writeDestCache([]destSummary{{Dest: "pge", Count: 1}}))
+	ok(t, ioutil.WriteFile(path.Join(root, indexFile), []byte("{}"), 0600))
+
+	ok(t, config.update(func(c *Config) { c.DestSeparator = "-" }))
+	ok(t, (&Config{Root: root}).writeDestCache([]destSummary{{Dest: "pge", Count: 2}}))
+	ok(t, ioutil.WriteFile(path.Join(root, indexFile), []byte(`{"pge/2016/20160825_pge.pdf": {}}`), 0600))
+	names, err = listSnapshots(snapshots)
+	ok(t, err)
+	equals(t, 1, len(names))
+
+	ok(t, restoreSnapshot(configFile, names[0]))
+	restored, err := ioutil.ReadFile(configFile)
+	ok(t, err)
+	equals(t, string(first), string(restored))
+	sums, err := readDestCache(path.Join(root, destCache))
+	ok(t, err)
+	equals(t, 1, sums[0].Count)
+	index, err := ioutil.ReadFile(path.Join(root, indexFile))
+	ok(t, err)
+	equals(t, "{}", string(index))
+
+	// the restore can be undone too
+	names, err = listSnapshots(snapshots)
+	ok(t, err)
+	equals(t, 2, len(names))
+
+	assert(t, restoreSnapshot(configFile, "../root") != nil, "expected a name outside the snapshots to be rejected")
+	assert(t, restoreSnapshot(configFile, "19990101T000000") != nil, "expected a missing snapshot to be rejected")
+}
+
+func TestSnapshotOrder(t *testing.T) {
+	names := []string{"20240101T120000-10", "20240101T120001", "20240101T120000", "20240101T120000-9"}
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	for _, n := range names {
+		ok(t, os.Mkdir(path.Join(dir, n), 0700))
+	}
+	found, err := listSnapshots(dir)
+	ok(t, err)
+	equals(t, []string{"20240101T120000", "20240101T120000-9", "20240101T120000-10", "20240101T120001"}, found)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// What to do with a file whose contents don't match its extension.
+const (
+	sniffWarn       = "warn"
+	sniffQuarantine = "quarantine"
+)
+
+// sniffTypes are the extensions we know the magic bytes for, with the
+// content type http.DetectContentType gives them.
+var sniffTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".zip":  "application/zip",
+}
+
+// What to do with a file that is empty or cut short.
+const (
+	brokenQuarantine = "quarantine"
+	brokenFile       = "file"
+)
+
+// brokenTail is how far from the end of a file we look for the marker
+// its type ends with.  Readers give pdfs the same slack for %%EOF.
+const brokenTail = 1024
+
+// brokenEnds are, for the types we know how to check, how a file
+// starts, and what must be near its end when it is whole.
+var brokenEnds = []struct {
+	exts  []string
+	start []byte
+	end   []byte
+}{
+	{[]string{".pdf"}, []byte("%PDF-"), []byte("%%EOF")},
+	{[]string{".png"}, []byte("\x89PNG\r\n\x1a\n"), []byte("IEND")},
+	{[]string{".jpg", ".jpeg"}, []byte{0xff, 0xd8}, []byte{0xff, 0xd9}},
+}
+
+func (c *Config) quarantine() string {
+	return path.Join(c.Root, "quarantine")
+}
+
+// sniff returns what is wrong with name, or "" if nothing is.  Empty
+// files are always wrong.  Otherwise we only check extensions we know
+// the magic bytes for, so a .txt or .csv is never flagged.
+func sniff(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if n == 0 {
+		return "it is empty", nil
+	}
+	want, ok := sniffTypes[strings.ToLower(path.Ext(name))]
+	if !ok {
+		return "", nil
+	}
+	got := http.DetectContentType(buf[:n])
+	if strings.HasPrefix(got, want) {
+		return "", nil
+	}
+	// drop the charset, e.g. text/html; charset=utf-8
+	got = strings.TrimSpace(strings.Split(got, ";")[0])
+	return "it looks like " + got + ", not " + want, nil
+}
+
+// broken returns why name looks like a failed download, or "" if it
+// doesn't.  Empty files are always broken, as are files of a type we
+// know how to check that start as they should but don't end that way.
+// A file that doesn't start as its type should is left to sniff.
+func broken(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() == 0 {
+		return "it is empty", nil
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	for _, b := range brokenEnds {
+		if !hasString(b.exts, ext) {
+			continue
+		}
+		start := make([]byte, len(b.start))
+		if _, err := io.ReadFull(f, start); err != nil || !bytes.Equal(start, b.start) {
+			return "", nil
+		}
+		tail := int64(brokenTail)
+		if tail > fi.Size() {
+			tail = fi.Size()
+		}
+		end := make([]byte, tail)
+		if _, err := f.ReadAt(end, fi.Size()-tail); err != nil {
+			return "", err
+		}
+		if !bytes.Contains(end, b.end) {
+			return "it is cut short, without the end of a " + ext[1:], nil
+		}
+	}
+	return "", nil
+}
+
+// checkContents looks for broken files in inbox, and sniffs file when
+// the config asks for it.  It returns true if the file should be
+// filed.  A broken file, or with quarantine set, one that fails the
+// sniff, is moved out of the way, into the quarantine directory.
+func checkContents(config *Config, inbox string, file os.FileInfo, dryRun bool, fr *fileResult) bool {
+	name := path.Join(inbox, file.Name())
+	var problem string
+	var err error
+	if config.Broken != brokenFile {
+		problem, err = broken(name)
+	}
+	quarantine := true
+	if err == nil && problem == "" && config.Sniff != "" {
+		problem, err = sniff(name)
+		quarantine = config.Sniff == sniffQuarantine
+	}
+	if err != nil {
+		printf(progress, styleSkip, "Unable to check the contents of %q, skipping: %v\n", name, err)
+		fr.fail(name, failContents, err)
+		fr.skippedCount++
+		fr.skippedBytes += file.Size()
+		return false
+	}
+	if problem == "" {
+		return true
+	}
+	if !quarantine {
+		printf(progress, styleNotice, "%q may not be what its name says, %s\n", name, problem)
+		return true
+	}
+
+	to := path.Join(config.quarantine(), file.Name())
+	if dryRun {
+		printf(progress, styleSkip, "Would quarantine %q as %s, because %s\n", name, to, problem)
+		fr.skippedCount++
+		fr.skippedBytes += file.Size()
+		return false
+	}
+	if err := quarantineFile(config, name, to); err != nil {
+		printf(progress, styleFailure, "Unable to quarantine %q, skipping: %v\n", name, err)
+		fr.fail(name, failQuarantine, err)
+		fr.skippedCount++
+		fr.skippedBytes += file.Size()
+		return false
+	}
+	printf(progress, styleSkip, "Quarantined %q as %s, because %s\n", name, to, problem)
+	events.publish(eventFailed, name, to, errors.New("quarantined, as "+problem))
+	fr.quarantined++
+	return false
+}
+
+func quarantineFile(config *Config, from, to string) error {
+	if err := config.perms.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return errors.Errorf("%s already exists", to)
+	}
+	_, err := fileinbox.MoveFile(from, to)
+	return err
+}
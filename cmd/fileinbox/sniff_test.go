@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"20240101_bank.pdf", "%PDF-1.4\n", ""},
+		{"20240101_bank.PDF", "%PDF-1.4\n", ""},
+		{"20240102_bank.pdf", "<!DOCTYPE html><html><body>Session expired</body></html>", "it looks like text/html, not application/pdf"},
+		{"20240103_bank.pdf", "", "it is empty"},
+		{"20240104_bank.csv", "date,amount\n", ""},
+		{"20240105_bank.txt", "", "it is empty"},
+	} {
+		name := path.Join(dir, tc.name)
+		ok(t, ioutil.WriteFile(name, []byte(tc.contents), 0600))
+		problem, err := sniff(name)
+		ok(t, err)
+		equals(t, tc.problem, problem)
+	}
+}
+
+func TestBroken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"20240101_bank.pdf", "%PDF-1.4\n" + strings.Repeat("x", 2000) + "\n%%EOF\n", ""},
+		{"20240102_bank.pdf", "%PDF-1.4\n" + strings.Repeat("x", 2000), "it is cut short, without the end of a pdf"},
+		{"20240103_bank.PDF", "%PDF-1.4\n", "it is cut short, without the end of a pdf"},
+		{"20240104_bank.pdf", "<html><body>Oops</body></html>", ""},
+		{"20240105_bank.jpg", "\xff\xd8\xff\xe0 half a photo", "it is cut short, without the end of a jpg"},
+		{"20240106_bank.jpg", "\xff\xd8\xff\xe0 a photo\xff\xd9", ""},
+		{"20240107_bank.txt", "", "it is empty"},
+		{"20240108_bank.txt", "notes", ""},
+	} {
+		name := path.Join(dir, tc.name)
+		ok(t, ioutil.WriteFile(name, []byte(tc.contents), 0600))
+		problem, err := broken(name)
+		ok(t, err)
+		equals(t, tc.problem, problem)
+	}
+}
+
+func TestBrokenGuard(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	inbox := path.Join(root, "inbox")
+	createFiles(t, root, []string{"filed/bank/", "inbox/20240101_bank.pdf"})
+	ok(t, ioutil.WriteFile(path.Join(inbox, "20240102_bank.pdf"), nil, 0600))
+	ok(t, ioutil.WriteFile(path.Join(inbox, "20240103_bank.pdf"), []byte("%PDF-1.4\nhalf a stat"), 0600))
+
+	// broken files are quarantined without asking
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(2), fr.quarantined)
+	for _, name := range []string{"20240102_bank.pdf", "20240103_bank.pdf"} {
+		_, err = os.Stat(path.Join(root, "quarantine", name))
+		ok(t, err)
+	}
+
+	// unless they are to be filed anyway
+	ok(t, os.Rename(path.Join(root, "quarantine", "20240102_bank.pdf"), path.Join(inbox, "20240102_bank.pdf")))
+	config.Broken = brokenFile
+	ok(t, config.validate())
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	_, err = os.Stat(path.Join(root, "filed", "bank", "2024", "20240102_bank.pdf"))
+	ok(t, err)
+
+	config.Broken = "maybe"
+	assert(t, config.validate() != nil, "Expected an unknown broken to be rejected")
+}
+
+func TestQuarantine(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	inbox := path.Join(root, "inbox")
+	createFiles(t, root, []string{"filed/bank/", "inbox/"})
+	ok(t, ioutil.WriteFile(path.Join(inbox, "20240101_bank.pdf"), []byte("%PDF-1.4\n%%EOF\n"), 0600))
+	ok(t, ioutil.WriteFile(path.Join(inbox, "20240102_bank.pdf"), []byte("<html><body>Oops</body></html>"), 0600))
+
+	config := &Config{Root: root, Sniff: sniffQuarantine}
+	ok(t, config.validate())
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, true, &fr))
+	equals(t, 1, len(fr.plan))
+	_, err = os.Stat(path.Join(inbox, "20240102_bank.pdf"))
+	ok(t, err)
+
+	fr = fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(1), fr.quarantined)
+	equals(t, uint32(0), fr.failureCount)
+	_, err = os.Stat(path.Join(root, "filed", "bank", "2024", "20240101_bank.pdf"))
+	ok(t, err)
+	_, err = os.Stat(path.Join(root, "quarantine", "20240102_bank.pdf"))
+	ok(t, err)
+
+	config.Sniff = "maybe"
+	assert(t, config.validate() != nil, "Expected an unknown sniff to be rejected")
+}
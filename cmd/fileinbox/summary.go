@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// destCache holds a summary of every dest, so completion can show them
+// without walking the archive.  It is written the first time it is
+// needed, and kept up to date as we file.
+const destCache = ".fileinbox-dests.json"
+
+// destSummary is how many documents a dest has and when the latest is
+// from, enough to tell a dest in use from a typo.
+type destSummary struct {
+	Dest   string `json:"dest"`
+	Count  int    `json:"count"`
+	Latest string `json:"latest,omitempty"` // e.g. 2016-08-25
+}
+
+func (s destSummary) String() string {
+	if s.Count == 0 {
+		return "empty"
+	}
+	docs := "documents"
+	if s.Count == 1 {
+		docs = "document"
+	}
+	return fmt.Sprintf("%s %s, latest %s", formatCount(int64(s.Count)), docs, s.Latest)
+}
+
+// summarizeDest walks dest, returning a summary for it and for each
+// dest nested under it.
+func summarizeDest(config *Config, opts fileinbox.ParseOptions, dest string) (map[string]destSummary, error) {
+	docs, err := findFiled(config, opts, dest)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]destSummary{dest: {Dest: dest}}
+	for _, d := range docs {
+		s := sums[d.dest]
+		s.Dest = d.dest
+		s.Count++
+		// docs are sorted oldest first
+		s.Latest = d.date.Format("2006-01-02")
+		sums[d.dest] = s
+	}
+	return sums, nil
+}
+
+// destSummaries returns a summary of every dest, sorted by name.  It
+// reads the cache unless a dest has been added or removed since it was
+// written.
+func (c *Config) destSummaries(opts fileinbox.ParseOptions) ([]destSummary, error) {
+	cacheName := path.Join(c.Root, destCache)
+	if sums, err := readDestCache(cacheName); err == nil && !newer(c.filed(), cacheName) {
+		return sums, nil
+	}
+
+	infos, err := ioutil.ReadDir(c.filed())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading dests")
+	}
+	all := map[string]destSummary{}
+	for _, fi := range infos {
+		if !c.destEntry(fi) {
+			continue
+		}
+		sums, err := summarizeDest(c, opts, fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range sums {
+			all[k] = v
+		}
+	}
+	result := sortedSummaries(all)
+	if err := c.writeDestCache(result); err != nil {
+		printf(progress, styleNotice, "Unable to cache dest summaries: %v\n", err)
+	}
+	return result, nil
+}
+
+// updateDestCache brings the summaries of the dests we filed into up to
+// date.  If there is no cache yet, there is nothing to do.
+func (c *Config) updateDestCache(opts fileinbox.ParseOptions, touched map[string]int) error {
+	if len(touched) == 0 {
+		return nil
+	}
+	cacheName := path.Join(c.Root, destCache)
+	cached, err := readDestCache(cacheName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	all := map[string]destSummary{}
+	for _, s := range cached {
+		all[s.Dest] = s
+	}
+	for dest := range touched {
+		sums, err := summarizeDest(c, opts, dest)
+		if err != nil {
+			return err
+		}
+		for k, v := range sums {
+			all[k] = v
+		}
+	}
+	return c.writeDestCache(sortedSummaries(all))
+}
+
+func sortedSummaries(m map[string]destSummary) []destSummary {
+	result := make([]destSummary, 0, len(m))
+	for _, s := range m {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Dest < result[j].Dest })
+	return result
+}
+
+func readDestCache(name string) ([]destSummary, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var sums []destSummary
+	if err := json.Unmarshal(data, &sums); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	return sums, nil
+}
+
+func (c *Config) writeDestCache(sums []destSummary) error {
+	data, err := json.MarshalIndent(sums, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(c.Root, destCache), data, c.perms.state())
+}
+
+// newer returns true if a was modified after b.
+func newer(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return true
+	}
+	return ai.ModTime().After(bi.ModTime())
+}
+
+// doDests lists every dest with how many documents it has and when the
+// latest is from.
+func doDests(ctx *cli.Context) error {
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "dests")
+	}
+	sums, err := config.destSummaries(opts)
+	if err != nil {
+		return errors.Wrap(err, "dests")
+	}
+	if ctx.String(outputFlag) == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sums)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DEST\tDOCUMENTS\tLATEST")
+	for _, s := range sums {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.Dest, s.Count, s.Latest)
+	}
+	return w.Flush()
+}
+
+// completeDests offers dests for completion.  zsh can show a
+// description next to each, so we say how much each dest is used.
+func completeDests(ctx *cli.Context) {
+	if ctx.NArg() > 0 {
+		return
+	}
+	// anything but the candidates would be taken for one
+	progress = ioutil.Discard
+	config, opts, err := queryConfig(ctx)
+	if err != nil {
+		return
+	}
+	sums, err := config.destSummaries(opts)
+	if err != nil {
+		return
+	}
+	describe := os.Getenv("_CLI_ZSH_AUTOCOMPLETE_HACK") == "1"
+	for _, s := range sums {
+		if describe {
+			fmt.Printf("%s:%s\n", s.Dest, s)
+		} else {
+			fmt.Println(s.Dest)
+		}
+	}
+}
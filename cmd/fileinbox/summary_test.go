@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDestSummaries(t *testing.T) {
+	start := []string{
+		"filed/att/2015/20150702_att.pdf",
+		"filed/att/2016/20160702_att.pdf",
+		"filed/atttypo/2016/20160101_atttypo.pdf",
+		"filed/insurance/auto/2016/20160301_insurance-auto.pdf",
+		"filed/empty/",
+		"inbox/20170101_att.pdf",
+	}
+
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, start)
+
+	config := &Config{Root: root, DestSeparator: "-"}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+
+	// nothing to update until the cache has been written once
+	ok(t, config.updateDestCache(opts, map[string]int{"att": 1}))
+	_, err = os.Stat(path.Join(root, destCache))
+	assert(t, os.IsNotExist(err), "expected no cache yet, got %v", err)
+
+	sums, err := config.destSummaries(opts)
+	ok(t, err)
+	equals(t, []destSummary{
+		{"att", 2, "2016-07-02"},
+		{"atttypo", 1, "2016-01-01"},
+		{"empty", 0, ""},
+		{"insurance", 0, ""},
+		{"insurance/auto", 1, "2016-03-01"},
+	}, sums)
+	equals(t, "2 documents, latest 2016-07-02", sums[0].String())
+	equals(t, "empty", sums[2].String())
+
+	cached, err := readDestCache(path.Join(root, destCache))
+	ok(t, err)
+	equals(t, sums, cached)
+
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(path.Join(root, "inbox"), config, opts, false, false, &fr))
+	equals(t, map[string]int{"att": 1}, fr.touched)
+	ok(t, config.updateDestCache(opts, fr.touched))
+
+	sums, err = config.destSummaries(opts)
+	ok(t, err)
+	equals(t, destSummary{"att", 3, "2017-01-01"}, sums[0])
+	equals(t, destSummary{"atttypo", 1, "2016-01-01"}, sums[1])
+}
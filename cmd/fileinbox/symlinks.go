@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Symbolic links are handled this way:
+//
+// A dest, such as filed/taxes, may be a link to a directory elsewhere,
+// e.g. on another volume.  It is followed when filing, which copies
+// rather than renames when the link crosses devices, and when walking
+// the archive, so the queries see what is filed there.  A dest link that
+// doesn't resolve, that loops, or that leads back to filed, to a
+// directory above it or into an inbox is refused, and what would be
+// filed there is left in the inbox.
+//
+// Links below a dest, such as in its year directories, are not
+// followed.  Links in an inbox are never followed or filed; they are
+// left where they are.
+
+// isLink returns true if fi is a symbolic link.
+func isLink(fi os.FileInfo) bool {
+	return fi.Mode()&os.ModeSymlink != 0
+}
+
+// within returns true if name is dir or under it.
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+string(filepath.Separator))
+}
+
+// destLink returns where destDir leads if it is a link, or "" if it
+// isn't one.  It returns an error for a link we won't follow.
+func (c *Config) destLink(destDir string) (string, error) {
+	fi, err := os.Lstat(destDir)
+	if err != nil || !isLink(fi) {
+		return "", nil
+	}
+	target, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return "", errors.Errorf("%s is a link that doesn't lead anywhere: %v", destDir, err)
+	}
+	if filed, err := filepath.EvalSymlinks(c.filed()); err == nil && within(filed, target) {
+		return "", errors.Errorf("%s is a link back to %s, which contains it", destDir, target)
+	}
+	for _, inbox := range c.inboxes() {
+		real, err := filepath.EvalSymlinks(inbox)
+		if err == nil && (within(target, real) || within(real, target)) {
+			return "", errors.Errorf("%s is a link into the inbox %s", destDir, inbox)
+		}
+	}
+	return target, nil
+}
+
+// destEntry returns true if fi, read from filed, is a dest: a
+// directory, or a link to one that we follow.
+func (c *Config) destEntry(fi os.FileInfo) bool {
+	if !isLink(fi) {
+		return fi.IsDir()
+	}
+	p := c.dest(fi.Name())
+	_, err := c.destLink(p)
+	return err == nil && isDir(p)
+}
+
+// walkDest walks destDir like filepath.Walk, following destDir itself
+// if it is a link, but no links below it.  The paths given to walkFn
+// are under destDir either way.
+func walkDest(destDir string, walkFn filepath.WalkFunc) error {
+	real, err := filepath.EvalSymlinks(destDir)
+	if err != nil || real == destDir {
+		return filepath.Walk(destDir, walkFn)
+	}
+	return filepath.Walk(real, func(p string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(real, p)
+		if relErr != nil {
+			return relErr
+		}
+		return walkFn(filepath.Join(destDir, rel), info, err)
+	})
+}
+
+// walkFiled walks all of filed like filepath.Walk, following the dests
+// that are links, as walkDest does.  Dest links we refuse are passed
+// over.
+func (c *Config) walkFiled(walkFn filepath.WalkFunc) error {
+	filed := c.filed()
+	return filepath.Walk(filed, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !isLink(info) {
+			return walkFn(p, info, err)
+		}
+		rel, relErr := filepath.Rel(filed, p)
+		if relErr != nil {
+			return relErr
+		}
+		if nestedDest("", filepath.ToSlash(rel)) != filepath.ToSlash(rel) || !isDir(p) {
+			// only dests are followed
+			return walkFn(p, info, err)
+		}
+		if _, linkErr := c.destLink(p); linkErr != nil {
+			return nil
+		}
+		// a SkipDir for the dest mustn't skip the rest of its parent
+		if err := walkDest(p, walkFn); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	})
+}
+
+// inboxLink returns true, after saying so, if file in inbox is a link,
+// which we leave be.
+func inboxLink(inbox string, file os.FileInfo) bool {
+	if !isLink(file) {
+		return false
+	}
+	printf(progress, styleSkip, "Leaving %q be, as it is a link, and links in an inbox aren't followed\n", path.Join(inbox, file.Name()))
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDestLinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/",
+		"elsewhere/taxes/",
+		"outside/20160825_pge.pdf",
+		"inbox/20160101_taxes.pdf",
+		"inbox/20160102_loop.pdf",
+		"inbox/20160103_dangling.pdf",
+	})
+	filed := path.Join(root, "filed")
+	ok(t, os.Symlink(path.Join(root, "elsewhere/taxes"), path.Join(filed, "taxes")))
+	ok(t, os.Symlink(root, path.Join(filed, "loop")))
+	ok(t, os.Symlink(path.Join(root, "nowhere"), path.Join(filed, "dangling")))
+	ok(t, os.Symlink(path.Join(root, "outside/20160825_pge.pdf"), path.Join(root, "inbox/20160825_pge.pdf")))
+
+	inbox := path.Join(root, "inbox")
+	config := &Config{Root: root, ExtraInboxes: []string{inbox}}
+	ok(t, config.validate())
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	equals(t, uint32(1), fr.okCount)
+	equals(t, uint32(2), fr.failureCount)
+
+	// filed through the link, and found there again
+	equals(t, []string{"2016/", "2016/20160101_taxes.pdf"}, readFiles(t, path.Join(root, "elsewhere/taxes")))
+	r, err := diskUsage(config, nil, 0)
+	ok(t, err)
+	equals(t, 1, r.Total.Files)
+	equals(t, "taxes", r.Dests[0].Name)
+
+	// the link in the inbox, and what couldn't go through a link, stay
+	infos, err := ioutil.ReadDir(inbox)
+	ok(t, err)
+	equals(t, 3, len(infos))
+	_, err = os.Stat(path.Join(root, "outside/20160825_pge.pdf"))
+	ok(t, err)
+
+	lines, err := explain(config, config.parseOptions(false), path.Join(inbox, "20160102_loop.pdf"))
+	ok(t, err)
+	equals(t, "Outcome", lines[len(lines)-1].what)
+	assert(t, strings.HasPrefix(lines[len(lines)-1].why, "left in the inbox, as "+path.Join(filed, "loop")+" is a link back to "),
+		"unexpected %q", lines[len(lines)-1].why)
+	lines, err = explain(config, config.parseOptions(false), path.Join(inbox, "20160825_pge.pdf"))
+	ok(t, err)
+	equals(t, "left in the inbox, as it is a link, and links in an inbox aren't followed", lines[len(lines)-1].why)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const undoFlag string = "undo"
+
+// What a journal entry records, other than filing.
+const (
+	journalRemoved  = "removed"
+	journalRestored = "restored"
+)
+
+// trash returns where removed documents go.  Each keeps its place under
+// filed, so it can be put back.
+func (c *Config) trash() string {
+	return path.Join(c.Root, "trash")
+}
+
+// filedRel returns name relative to filed, or an error if it isn't
+// under filed.
+func (c *Config) filedRel(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(c.filed(), abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", errors.Errorf("%s is not a filed document", name)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// removeFiled moves the filed document name to the trash, recording it
+// in the journal and dropping it from the index.
+func removeFiled(config *Config, name string) (string, error) {
+	rel, err := config.filedRel(name)
+	if err != nil {
+		return "", err
+	}
+	from, to := path.Join(config.filed(), rel), path.Join(config.trash(), rel)
+	if fi, err := os.Stat(from); err != nil {
+		return "", err
+	} else if fi.IsDir() {
+		return "", errors.Errorf("%s is a directory, not a document", from)
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return "", errors.Errorf("%s is already in the trash", to)
+	}
+	if err := moveAudited(config, from, to, journalRemoved); err != nil {
+		return "", err
+	}
+	return to, updateIndexEntry(config, rel, "")
+}
+
+// restoreFiled puts name, which was filed, back from the trash.
+func restoreFiled(config *Config, name string) (string, error) {
+	rel, err := config.filedRel(name)
+	if err != nil {
+		return "", err
+	}
+	from, to := path.Join(config.trash(), rel), path.Join(config.filed(), rel)
+	if _, err := os.Stat(from); os.IsNotExist(err) {
+		return "", errors.Errorf("%s is not in the trash", name)
+	} else if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return "", errors.Errorf("something else has since been filed as %s", to)
+	}
+	if err := moveAudited(config, from, to, journalRestored); err != nil {
+		return "", err
+	}
+	return to, updateIndexEntry(config, rel, to)
+}
+
+// moveAudited moves from to to, creating to's directory as needed, and
+// journals it as action.  Immutable documents are left where they are.
+func moveAudited(config *Config, from, to, action string) error {
+	if config.Immutable {
+		return errors.New("filed documents are immutable.  Run fileinbox immutable lift first, and immutable restore once done")
+	}
+	if err := config.perms.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	if _, err := fileinbox.MoveFile(from, to); err != nil {
+		return err
+	}
+	return config.appendJournal([]journalEntry{{Time: clock.Now(), From: from, To: to, Action: action}})
+}
+
+// updateIndexEntry hashes name into the index as rel, or drops rel if
+// name is "".  Without an index, there is nothing to keep up to date.
+func updateIndexEntry(config *Config, rel, name string) error {
+	if _, err := os.Stat(config.index()); os.IsNotExist(err) {
+		return nil
+	}
+	idx, err := config.readIndex()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		delete(idx.entries, rel)
+		return idx.write()
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	sum, err := hashFile(name)
+	if err != nil {
+		return err
+	}
+	idx.entries[rel] = indexEntry{fi.Size(), fi.ModTime(), sum, idx.entries[rel].Tags}
+	return idx.write()
+}
+
+func doRm(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("rm: name the filed documents to remove")
+	}
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "rm")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "rm")
+	}
+	for _, name := range ctx.Args().Slice() {
+		if ctx.Bool(undoFlag) {
+			to, err := restoreFiled(config, name)
+			if err != nil {
+				return errors.Wrapf(err, "rm: restoring %s", name)
+			}
+			printf(progress, styleSuccess, "Restored %s\n", to)
+			continue
+		}
+		to, err := removeFiled(config, name)
+		if err != nil {
+			return errors.Wrapf(err, "rm: removing %s", name)
+		}
+		printf(progress, styleSuccess, "Moved %s to %s\n", name, to)
+	}
+	return nil
+}
+
+func rmCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rm",
+		Usage:     "Move filed documents to the trash, keeping a record in the journal, so they can be put back.",
+		ArgsUsage: "<filed document>...",
+		Action:    doRm,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  undoFlag,
+				Usage: "Put documents back from the trash, naming them as they were filed.",
+			},
+		},
+	}
+}
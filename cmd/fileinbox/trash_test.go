@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestRemoveFiled(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	defer func() { clock = fileinbox.SystemClock }()
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	clock = fileinbox.FixedClock(when)
+	createFiles(t, root, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2016/20160925_pge.pdf",
+	})
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	idx, err := config.readIndex()
+	ok(t, err)
+	_, err = updateIndex(config, idx, false, 1)
+	ok(t, err)
+	ok(t, idx.write())
+
+	doc := path.Join(root, "filed/pge/2016/20160825_pge.pdf")
+	config.Immutable = true
+	_, err = removeFiled(config, doc)
+	assert(t, err != nil, "expected an immutable document to be left be")
+	_, err = os.Stat(doc)
+	ok(t, err)
+	config.Immutable = false
+
+	to, err := removeFiled(config, doc)
+	ok(t, err)
+	equals(t, path.Join(root, "trash/pge/2016/20160825_pge.pdf"), to)
+	_, err = removeFiled(config, doc)
+	assert(t, err != nil, "expected removing a removed document to fail")
+	_, err = removeFiled(config, path.Join(root, "inbox/20160825_pge.pdf"))
+	assert(t, err != nil, "expected a document that isn't filed to be refused")
+
+	found := readFiles(t, path.Join(root, "filed"))
+	sort.Strings(found)
+	equals(t, []string{"pge/", "pge/2016/", "pge/2016/20160925_pge.pdf"}, found)
+	found = readFiles(t, path.Join(root, "trash"))
+	sort.Strings(found)
+	equals(t, []string{"pge/", "pge/2016/", "pge/2016/20160825_pge.pdf"}, found)
+	idx, err = config.readIndex()
+	ok(t, err)
+	equals(t, 1, len(idx.entries))
+
+	_, err = restoreFiled(config, doc)
+	ok(t, err)
+	_, err = os.Stat(doc)
+	ok(t, err)
+	idx, err = config.readIndex()
+	ok(t, err)
+	equals(t, 2, len(idx.entries))
+
+	entries, err := config.readJournal()
+	ok(t, err)
+	equals(t, 2, len(entries))
+	equals(t, []string{journalRemoved, journalRestored}, []string{entries[0].Action, entries[1].Action})
+	equals(t, doc, entries[1].To)
+	assert(t, entries[0].Time.Equal(when), "expected the journal to use the clock, got %v", entries[0].Time)
+}
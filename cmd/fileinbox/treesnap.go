@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const rehashFlag string = "rehash"
+
+// treeSnapshotDir, under the root, holds listings of everything filed,
+// one per snapshot, named for when it was taken.  Unlike the config
+// snapshots, they are only taken when asked for, e.g. before and after a
+// layout migration, and are never removed by us.
+const treeSnapshotDir = ".fileinbox-snapshots"
+
+// treeSnapshot lists every filed document at one time.
+type treeSnapshot struct {
+	Taken time.Time      `json:"taken"`
+	Files []snapshotFile `json:"files"` // sorted by Path
+}
+
+type snapshotFile struct {
+	Path   string `json:"path"` // relative to filed
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// takeTreeSnapshot lists and hashes everything filed.  Hashes from the
+// index are trusted for documents whose size and modification time
+// haven't changed, unless rehash is set.  The index itself is left as
+// it was.
+func takeTreeSnapshot(config *Config, rehash bool, jobs int) (*treeSnapshot, error) {
+	idx, err := config.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := updateIndex(config, idx, rehash, jobs); err != nil {
+		return nil, err
+	}
+	s := &treeSnapshot{Taken: clock.Now(), Files: []snapshotFile{}}
+	for rel, e := range idx.entries {
+		s.Files = append(s.Files, snapshotFile{rel, e.Size, e.SHA256})
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Path < s.Files[j].Path })
+	return s, nil
+}
+
+// saveTreeSnapshot writes s under the root, returning its name.
+func saveTreeSnapshot(config *Config, s *treeSnapshot) (string, error) {
+	dir := path.Join(config.Root, treeSnapshotDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := s.Taken.UTC().Format(snapshotLayout)
+	// a second snapshot in the same second gets a suffix, one that sorts
+	// after the first
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(path.Join(dir, name+".json")); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s_%d", s.Taken.UTC().Format(snapshotLayout), i)
+	}
+	return name, writeFileAtomic(path.Join(dir, name+".json"), data, config.perms.state())
+}
+
+// readTreeSnapshot reads the snapshot called name, or at the path name.
+func readTreeSnapshot(config *Config, name string) (*treeSnapshot, error) {
+	file := name
+	if !strings.ContainsAny(name, `/\`) {
+		file = path.Join(config.Root, treeSnapshotDir, strings.TrimSuffix(name, ".json")+".json")
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("there is no snapshot %q.  snapshot list shows them", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s treeSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", file)
+	}
+	return &s, nil
+}
+
+// treeDiff is what changed between two snapshots.
+type treeDiff struct {
+	added, removed, changed []string
+	moved                   [][2]string // from, to, with the same contents
+}
+
+func (d treeDiff) empty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0 && len(d.moved) == 0
+}
+
+// diffTreeSnapshots compares a, from before, with b.  A document that
+// went from one path to another with its contents unchanged is moved,
+// rather than removed and added.
+func diffTreeSnapshots(a, b *treeSnapshot) treeDiff {
+	before := map[string]snapshotFile{}
+	for _, f := range a.Files {
+		before[f.Path] = f
+	}
+	after := map[string]snapshotFile{}
+	for _, f := range b.Files {
+		after[f.Path] = f
+	}
+	var d treeDiff
+	gone := map[string][]string{} // by hash
+	for _, f := range a.Files {
+		if g, ok := after[f.Path]; !ok {
+			gone[f.SHA256] = append(gone[f.SHA256], f.Path)
+		} else if g.SHA256 != f.SHA256 || g.Size != f.Size {
+			d.changed = append(d.changed, f.Path)
+		}
+	}
+	for _, f := range b.Files {
+		if _, ok := before[f.Path]; ok {
+			continue
+		}
+		if from := gone[f.SHA256]; len(from) != 0 {
+			d.moved = append(d.moved, [2]string{from[0], f.Path})
+			gone[f.SHA256] = from[1:]
+			continue
+		}
+		d.added = append(d.added, f.Path)
+	}
+	for _, paths := range gone {
+		d.removed = append(d.removed, paths...)
+	}
+	sort.Strings(d.removed)
+	return d
+}
+
+// listTreeSnapshots returns the names of the snapshots, oldest first.
+func listTreeSnapshots(config *Config) ([]string, error) {
+	infos, err := ioutil.ReadDir(path.Join(config.Root, treeSnapshotDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if strings.HasSuffix(fi.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(fi.Name(), ".json"))
+		}
+	}
+	return names, nil
+}
+
+func doTreeSnapshot(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "snapshot")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "snapshot")
+	}
+	start := time.Now()
+	s, err := takeTreeSnapshot(config, ctx.Bool(rehashFlag), ctx.Int(jobsFlag))
+	if err != nil {
+		return errors.Wrap(err, "snapshot")
+	}
+	name, err := saveTreeSnapshot(config, s)
+	if err != nil {
+		return errors.Wrap(err, "snapshot")
+	}
+	var size int64
+	for _, f := range s.Files {
+		size += f.Size
+	}
+	printf(progress, styleSuccess, "Took snapshot %s of %s, %s, in %s\n", name,
+		plural(uint32(len(s.Files)), "document", "documents"), formatBytes(size), formatDuration(time.Since(start)))
+	return nil
+}
+
+func doTreeSnapshotDiff(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return errors.New("snapshot diff expects two snapshots, the earlier first")
+	}
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "snapshot diff")
+	}
+	a, err := readTreeSnapshot(config, ctx.Args().Get(0))
+	if err != nil {
+		return errors.Wrap(err, "snapshot diff")
+	}
+	b, err := readTreeSnapshot(config, ctx.Args().Get(1))
+	if err != nil {
+		return errors.Wrap(err, "snapshot diff")
+	}
+	d := diffTreeSnapshots(a, b)
+	if d.empty() {
+		printf(os.Stdout, styleSuccess, "Nothing changed\n")
+		return nil
+	}
+	for _, p := range d.added {
+		printf(os.Stdout, styleSuccess, "+ %s\n", p)
+	}
+	for _, p := range d.removed {
+		printf(os.Stdout, styleFailure, "- %s\n", p)
+	}
+	for _, p := range d.changed {
+		printf(os.Stdout, styleNotice, "~ %s\n", p)
+	}
+	for _, m := range d.moved {
+		printf(os.Stdout, stylePlain, "> %s -> %s\n", m[0], m[1])
+	}
+	printf(os.Stdout, stylePlain, "%s added, %s removed, %s changed, %s moved\n",
+		formatCount(int64(len(d.added))), formatCount(int64(len(d.removed))),
+		formatCount(int64(len(d.changed))), formatCount(int64(len(d.moved))))
+	return nil
+}
+
+func doTreeSnapshotList(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "snapshot list")
+	}
+	names, err := listTreeSnapshots(config)
+	if err != nil {
+		return errors.Wrap(err, "snapshot list")
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func treeSnapshotCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "snapshot",
+		Usage:  "Record the path, size and hash of every filed document, e.g. before and after a migration, without changing anything.",
+		Action: doTreeSnapshot,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  rehashFlag,
+				Usage: "Hash every document again, rather than trusting the index for those that look unchanged.",
+			},
+			&cli.IntFlag{
+				Name:  jobsFlag,
+				Value: runtime.NumCPU(),
+				Usage: "How many documents to hash at once.",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "Show what changed between two snapshots: + added, - removed, ~ changed and > moved.",
+				ArgsUsage: "<earlier> <later>",
+				Action:    doTreeSnapshotDiff,
+			},
+			{
+				Name:   "list",
+				Usage:  "List the snapshots, oldest first.",
+				Action: doTreeSnapshotList,
+			},
+		},
+	}
+}
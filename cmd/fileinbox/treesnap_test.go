@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func TestTreeSnapshot(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	clock = fileinbox.FixedClock(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+	defer func() { clock = fileinbox.SystemClock }()
+	createFiles(t, root, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"filed/pge/2017/20170101_pge.pdf",
+		"filed/tax/2016/20160415_tax.pdf",
+		"filed/tax/2016/20160416_tax.pdf",
+		"inbox/",
+	})
+	config := &Config{Root: root}
+	ok(t, config.validate())
+
+	before, err := takeTreeSnapshot(config, false, 2)
+	ok(t, err)
+	equals(t, 4, len(before.Files))
+	equals(t, "pge/2016/20160825_pge.pdf", before.Files[0].Path)
+	first, err := saveTreeSnapshot(config, before)
+	ok(t, err)
+	equals(t, "20200301T120000", first)
+
+	ok(t, os.MkdirAll(path.Join(root, "filed/taxes/2016"), 0700))
+	ok(t, os.Rename(path.Join(root, "filed/tax/2016/20160415_tax.pdf"), path.Join(root, "filed/taxes/2016/20160415_tax.pdf")))
+	ok(t, os.Remove(path.Join(root, "filed/tax/2016/20160416_tax.pdf")))
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/pge/2017/20170101_pge.pdf"), []byte("a correction"), 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "filed/pge/2017/20170201_pge.pdf"), []byte("new"), 0600))
+
+	after, err := takeTreeSnapshot(config, false, 2)
+	ok(t, err)
+	second, err := saveTreeSnapshot(config, after)
+	ok(t, err)
+	equals(t, "20200301T120000_2", second)
+	names, err := listTreeSnapshots(config)
+	ok(t, err)
+	equals(t, []string{first, second}, names)
+
+	a, err := readTreeSnapshot(config, first)
+	ok(t, err)
+	b, err := readTreeSnapshot(config, second+".json")
+	ok(t, err)
+	d := diffTreeSnapshots(a, b)
+	equals(t, []string{"pge/2017/20170201_pge.pdf"}, d.added)
+	equals(t, []string{"tax/2016/20160416_tax.pdf"}, d.removed)
+	equals(t, []string{"pge/2017/20170101_pge.pdf"}, d.changed)
+	equals(t, [][2]string{{"tax/2016/20160415_tax.pdf", "taxes/2016/20160415_tax.pdf"}}, d.moved)
+	assert(t, diffTreeSnapshots(b, b).empty(), "expected a snapshot to match itself")
+
+	_, err = readTreeSnapshot(config, "19990101T000000")
+	assert(t, err != nil, "expected a missing snapshot to be an error")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+const labelFlag string = "label"
+
+// journalUndone records a document put back in its inbox by undo.
+const journalUndone = "undone"
+
+// lastRun returns the latest run that filed something and hasn't been
+// undone, or, with label set, the latest run labeled label.
+func lastRun(entries []journalEntry, label string) (string, error) {
+	undone := map[string]bool{}
+	for _, e := range entries {
+		if e.Action == journalUndone {
+			undone[e.Run] = true
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Action != "" || e.Run == "" || undone[e.Run] {
+			continue
+		}
+		if label == "" || e.Label == label {
+			return e.Run, nil
+		}
+	}
+	if label != "" {
+		return "", errors.Errorf("no run labeled %q is left to undo", label)
+	}
+	return "", errors.New("no run is left to undo")
+}
+
+// undoRun puts everything run filed back in the inbox it came from,
+// latest first, and removes the copies made of it.  Documents since
+// removed, or whose names have since been taken in the inbox, are left
+// where they are.
+func undoRun(config *Config, entries []journalEntry, run string) (undone, left int, err error) {
+	var journal []journalEntry
+	idx, err := config.readIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	indexed := false
+	defer func() {
+		if jErr := config.appendJournal(journal); jErr != nil && err == nil {
+			err = jErr
+		}
+		if indexed {
+			if iErr := idx.write(); iErr != nil && err == nil {
+				err = iErr
+			}
+		}
+	}()
+	unindex := func(name string) {
+		if rel, err := config.filedRel(name); err == nil {
+			if _, ok := idx.entries[rel]; ok {
+				delete(idx.entries, rel)
+				indexed = true
+			}
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Run != run || e.Action != "" {
+			continue
+		}
+		if _, err := os.Lstat(e.To); err != nil {
+			printf(progress, styleNotice, "%s is no longer filed as %s, leaving it be\n", e.From, e.To)
+			left++
+			continue
+		}
+		if _, err := os.Lstat(e.From); err == nil {
+			printf(progress, styleNotice, "Something else is now in the inbox as %s, leaving %s where it is\n", e.From, e.To)
+			left++
+			continue
+		}
+		// the document goes back first, so if it can't, its copies are
+		// still there with it
+		if _, err := fileinbox.MoveFile(e.To, e.From); err != nil {
+			return undone, left, err
+		}
+		journal = append(journal, journalEntry{Time: clock.Now(), From: e.To, To: e.From, Action: journalUndone, Run: run, Label: e.Label})
+		unindex(e.To)
+		printf(progress, styleSuccess, "Put %s back as %s\n", e.To, e.From)
+		undone++
+		for _, c := range append([]string{e.CC}, e.Copies...) {
+			if c == "" {
+				continue
+			}
+			if err := os.Remove(c); err != nil && !os.IsNotExist(err) {
+				return undone, left, errors.Wrapf(err, "%s is back in the inbox, but not all its copies were removed", e.From)
+			}
+			unindex(c)
+		}
+	}
+	return undone, left, nil
+}
+
+func doUndo(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "undo")
+	}
+	if err = checkRoot(config.Root); err != nil {
+		return errors.Wrap(err, "undo")
+	}
+	if config.Immutable {
+		return errors.Errorf("undo: filed documents are immutable.  Run fileinbox immutable lift first, and immutable restore once done")
+	}
+	entries, err := config.readJournal()
+	if err != nil {
+		return errors.Wrap(err, "undo")
+	}
+	run, err := lastRun(entries, ctx.String(labelFlag))
+	if err != nil {
+		return errors.Wrap(err, "undo")
+	}
+	undone, left, err := undoRun(config, entries, run)
+	if err != nil {
+		return errors.Wrap(err, "undo")
+	}
+	printf(progress, styleSuccess, "\nPut %s back in the inbox", plural(uint32(undone), "document", "documents"))
+	if left != 0 {
+		printf(progress, styleNotice, ", leaving %s where it was", plural(uint32(left), "document", "documents"))
+	}
+	printf(progress, stylePlain, "\n")
+	return nil
+}
+
+func undoCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "undo",
+		Usage:  "Put what the latest run filed back in the inbox, according to the journal.",
+		Action: doUndo,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  labelFlag,
+				Usage: fmt.Sprintf("Undo the latest run with this --%s, rather than the latest run.", runLabelFlag),
+			},
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUndoRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "filed/tax/", "inbox/20160825_pge+tax.pdf"})
+	inbox := path.Join(root, "inbox")
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	file := func(label string) {
+		startRun(label)
+		fr := fileResult{missingDirs: map[string]bool{}}
+		ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+		// runs are told apart by when they started
+		time.Sleep(time.Millisecond)
+	}
+	file("vacation")
+	createFiles(t, root, []string{"inbox/20160901_pge.pdf"})
+	file("")
+
+	entries, err := config.readJournal()
+	ok(t, err)
+	vacation, err := lastRun(entries, "vacation")
+	ok(t, err)
+	latest, err := lastRun(entries, "")
+	ok(t, err)
+	assert(t, vacation != latest, "expected two runs, got %s twice", latest)
+	_, err = lastRun(entries, "holiday")
+	assert(t, err != nil, "expected a label no run has to be refused")
+
+	undone, left, err := undoRun(config, entries, vacation)
+	ok(t, err)
+	equals(t, 1, undone)
+	equals(t, 0, left)
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/pge/2016/20160901_pge.pdf",
+		"filed/tax/",
+		"filed/tax/2016/",
+		"inbox/",
+		"inbox/20160825_pge+tax.pdf",
+	}, found)
+
+	// an undone run is done with
+	entries, err = config.readJournal()
+	ok(t, err)
+	_, err = lastRun(entries, "vacation")
+	assert(t, err != nil, "expected an undone run not to be undone again")
+	again, err := lastRun(entries, "")
+	ok(t, err)
+	equals(t, latest, again)
+}
+
+// A document that can't be put back keeps its copies, and once it is,
+// they leave the index along with it.
+func TestUndoKeepsCopiesUntilBack(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{"filed/pge/", "filed/tax/", "inbox/20160825_pge+tax.pdf"})
+	inbox := path.Join(root, "inbox")
+	config := &Config{Root: root}
+	ok(t, config.validate())
+	startRun("")
+	fr := fileResult{missingDirs: map[string]bool{}}
+	ok(t, processInbox(inbox, config, config.parseOptions(false), false, false, &fr))
+	idx, err := config.readIndex()
+	ok(t, err)
+	_, err = updateIndex(config, idx, false, 1)
+	ok(t, err)
+	ok(t, idx.write())
+	equals(t, 2, len(idx.entries))
+
+	entries, err := config.readJournal()
+	ok(t, err)
+	run, err := lastRun(entries, "")
+	ok(t, err)
+
+	// with the inbox gone, there is nowhere to put it back
+	ok(t, os.Rename(inbox, inbox+".away"))
+	_, _, err = undoRun(config, entries, run)
+	assert(t, err != nil, "expected undo to fail without the inbox")
+	for _, name := range []string{"filed/pge/2016/20160825_pge+tax.pdf", "filed/tax/2016/20160825_pge+tax.pdf"} {
+		_, err := os.Stat(path.Join(root, name))
+		ok(t, err)
+	}
+
+	ok(t, os.Rename(inbox+".away", inbox))
+	undone, _, err := undoRun(config, entries, run)
+	ok(t, err)
+	equals(t, 1, undone)
+	found := readFiles(t, root)
+	sort.Strings(found)
+	equals(t, []string{
+		"filed/",
+		"filed/pge/",
+		"filed/pge/2016/",
+		"filed/tax/",
+		"filed/tax/2016/",
+		"inbox/",
+		"inbox/20160825_pge+tax.pdf",
+	}, found)
+	idx, err = config.readIndex()
+	ok(t, err)
+	equals(t, 0, len(idx.entries))
+}
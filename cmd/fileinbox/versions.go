@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+func doVersions(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("versions: name the filed document")
+	}
+	name, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "versions")
+	}
+	versions, err := fileinbox.Versions(name)
+	if err != nil {
+		return errors.Wrap(err, "versions")
+	}
+	if len(versions) == 0 {
+		return errors.Errorf("versions: there is no %s, or any version of it", name)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, v := range versions {
+		fi, err := os.Stat(v)
+		if err != nil {
+			return errors.Wrap(err, "versions")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v, formatBytes(fi.Size()), fi.ModTime().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func versionsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "versions",
+		Usage:     "List the versions of a filed document, oldest first, as filed with collisions: versioned.",
+		ArgsUsage: "<filed document>",
+		Action:    doVersions,
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Ways the watch command can notice new files.
+const (
+	watchAuto    = "auto"
+	watchPoll    = "poll"
+	watchInotify = "inotify"
+)
+
+const defaultPollInterval = 10 * time.Second
+
+// WatchConfig controls the watch command.
+type WatchConfig struct {
+	// Backend is one of inotify, poll, or auto (the default).  auto
+	// polls inboxes on network filesystems such as NFS and SMB, where
+	// inotify misses files written by other machines, and anywhere
+	// inotify isn't available.
+	Backend string
+
+	// Interval is how often to poll, 10s if not set.
+	Interval time.Duration
+}
+
+func (w WatchConfig) validate() error {
+	switch w.Backend {
+	case "", watchAuto, watchPoll, watchInotify:
+	default:
+		return errors.Errorf("unknown watch backend %q.  We expect one of %s, %s or %s", w.Backend, watchAuto, watchPoll, watchInotify)
+	}
+	if w.Interval < 0 {
+		return errors.Errorf("watch interval %s must not be negative", w.Interval)
+	}
+	return nil
+}
+
+// watcher waits for files to arrive in the inboxes.
+type watcher interface {
+	// wait returns once something has changed and settled down, so
+	// files that are still being written aren't filed half done.
+	wait() error
+
+	// close stops the watcher.  A wait in progress returns
+	// errWatcherClosed.
+	close() error
+}
+
+var errWatcherClosed = errors.New("watcher closed")
+
+// newWatcher picks the backend for inboxes.
+func newWatcher(w WatchConfig, inboxes []string) (watcher, string, error) {
+	interval := w.Interval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	backend := w.Backend
+	if backend == "" || backend == watchAuto {
+		backend = watchInotify
+		for _, inbox := range inboxes {
+			if !inotifyWorks(inbox) {
+				backend = watchPoll
+			}
+		}
+	}
+	if backend == watchPoll {
+		p, err := newPoller(inboxes, interval)
+		return p, backend, err
+	}
+	n, err := newNativeWatcher(inboxes)
+	return n, backend, err
+}
+
+// fileState is what polling can tell about a file without reading it.
+type fileState struct {
+	size  int64
+	mtime time.Time
+}
+
+// snapshot records the state of the inboxes and everything in them.
+// A directory's mtime alone isn't enough, as on some network
+// filesystems it only changes when a file is created, not while it is
+// written.
+func snapshot(dirs []string) (map[string]fileState, error) {
+	snap := map[string]fileState{}
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		snap[dir] = fileState{fi.Size(), fi.ModTime()}
+		children, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			snap[path.Join(dir, c.Name())] = fileState{c.Size(), c.ModTime()}
+		}
+	}
+	return snap, nil
+}
+
+// poller notices changes by comparing snapshots.
+type poller struct {
+	dirs     []string
+	interval time.Duration
+	last     map[string]fileState
+	done     chan struct{}
+}
+
+func newPoller(dirs []string, interval time.Duration) (*poller, error) {
+	last, err := snapshot(dirs)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{dirs, interval, last, make(chan struct{})}, nil
+}
+
+func (p *poller) wait() error {
+	changed := false
+	for {
+		select {
+		case <-time.After(p.interval):
+		case <-p.done:
+			return errWatcherClosed
+		}
+		snap, err := snapshot(p.dirs)
+		if err != nil {
+			return err
+		}
+		same := reflect.DeepEqual(snap, p.last)
+		p.last = snap
+		if same && changed {
+			return nil
+		}
+		changed = changed || !same
+	}
+}
+
+func (p *poller) close() error {
+	close(p.done)
+	return nil
+}
+
+// doWatch files everything in the inboxes, then again each time more
+// arrives, until interrupted.
+func doWatch(ctx *cli.Context) error {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "watch")
+	}
+	if len(config.ExtraInboxes) == 0 {
+		return errors.New("there are no inboxes to watch")
+	}
+
+	fileOnce(ctx)
+	w, backend, err := newWatcher(config.Watch, config.ExtraInboxes)
+	if err != nil {
+		return errors.Wrap(err, "watch")
+	}
+	printf(progress, stylePlain, "Watching %d inboxes using %s\n", len(config.ExtraInboxes), backend)
+	for {
+		if err := w.wait(); err != nil {
+			return errors.Wrap(err, "watch")
+		}
+		fileOnce(ctx)
+	}
+}
+
+// fileOnce files what is in the inboxes and reports on it, like a
+// plain run, but carries on after errors.  It writes the metrics file
+// if --metrics-file is set.
+func fileOnce(ctx *cli.Context) (fileResult, time.Duration, error) {
+	start := time.Now()
+	fr, err := doFileInner(ctx)
+	duration := time.Since(start)
+	if name := ctx.String(metricsFlag); name != "" {
+		if metricsErr := fr.writeMetrics(name, duration, err); metricsErr != nil {
+			printf(progress, styleFailure, "\n\nUnable to write metrics to %q: %v\n", name, metricsErr)
+		}
+	}
+	if err == nil && fr.okCount == 0 && fr.failureCount == 0 {
+		return fr, duration, nil
+	}
+	if summarizeErr := fr.summarize(duration); summarizeErr != nil {
+		printf(progress, styleFailure, "\n%v\n", summarizeErr)
+	}
+	if err != nil {
+		printf(progress, styleFailure, "\n\nError (%s): %v\n", failCategory(failRun, err), err)
+	}
+	return fr, duration, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Filesystems where inotify only sees changes made on this machine.
+var networkFS = map[uint32]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFE534D42: "smb2",
+	0xFF534D42: "cifs",
+	0x65735546: "fuse",
+}
+
+func inotifyWorks(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	_, network := networkFS[uint32(st.Type)]
+	return !network
+}
+
+// settle is how long the inboxes must be quiet before we file.
+const settle = time.Second
+
+// nativeWatcher uses inotify.  We only listen for files being finished
+// or moved in, so our own moves out of the inbox don't wake us.
+type nativeWatcher struct {
+	f      *os.File
+	events chan error
+	done   chan struct{}
+}
+
+func newNativeWatcher(dirs []string) (watcher, error) {
+	// non-blocking, so the runtime can poll it and close can interrupt
+	// a read
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "inotify")
+	}
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+			syscall.Close(fd)
+			return nil, errors.Wrapf(err, "watching %s", dir)
+		}
+	}
+
+	w := &nativeWatcher{
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			// what arrived doesn't matter, filing looks at everything
+			_, err := w.f.Read(buf)
+			if err != nil {
+				select {
+				case w.events <- errors.Wrap(err, "reading inotify events"):
+				case <-w.done:
+				}
+				return
+			}
+			select {
+			case w.events <- nil:
+			default:
+				// a wake up is already pending
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *nativeWatcher) wait() error {
+	select {
+	case <-w.done:
+		return errWatcherClosed
+	default:
+	}
+	select {
+	case err := <-w.events:
+		if err != nil {
+			return err
+		}
+	case <-w.done:
+		return errWatcherClosed
+	}
+	for {
+		select {
+		case err := <-w.events:
+			if err != nil {
+				return err
+			}
+		case <-time.After(settle):
+			return nil
+		case <-w.done:
+			return errWatcherClosed
+		}
+	}
+}
+
+func (w *nativeWatcher) close() error {
+	close(w.done)
+	return w.f.Close()
+}
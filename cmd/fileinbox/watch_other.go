@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// inotifyWorks is always false here, so auto falls back to polling.
+func inotifyWorks(dir string) bool {
+	return false
+}
+
+func newNativeWatcher(dirs []string) (watcher, error) {
+	return nil, errors.Errorf("the %s watch backend is not available on %s, use %s", watchInotify, runtime.GOOS, watchPoll)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatchers(t *testing.T) {
+	inbox, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(inbox)
+		}
+	}()
+
+	backends := []string{watchPoll}
+	if runtime.GOOS == "linux" {
+		backends = append(backends, watchInotify)
+	}
+	for _, backend := range backends {
+		w, got, err := newWatcher(WatchConfig{Backend: backend, Interval: 10 * time.Millisecond}, []string{inbox})
+		ok(t, err)
+		equals(t, backend, got)
+
+		done := make(chan error, 1)
+		go func() { done <- w.wait() }()
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("%s woke up with nothing new: %v", backend, err)
+		default:
+		}
+
+		ok(t, ioutil.WriteFile(path.Join(inbox, backend+".pdf"), []byte("hello"), 0600))
+		select {
+		case err := <-done:
+			ok(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s didn't notice a new file", backend)
+		}
+
+		go func() { done <- w.wait() }()
+		ok(t, w.close())
+		select {
+		case err := <-done:
+			equals(t, errWatcherClosed, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s didn't stop when closed", backend)
+		}
+	}
+
+	assert(t, WatchConfig{Backend: "carrier-pigeon"}.validate() != nil, "expected an unknown backend to be rejected")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	fileinbox "github.com/ginabythebay/file_inbox"
+)
+
+// whyLine is one step of an explanation, e.g. {"Dest", "pge"}.
+type whyLine struct {
+	what, why string
+}
+
+// nameOnly stands in for a file that doesn't exist, so we can still say
+// what would become of its name.
+type nameOnly string
+
+func (n nameOnly) Name() string       { return string(n) }
+func (n nameOnly) Size() int64        { return 0 }
+func (n nameOnly) Mode() os.FileMode  { return 0 }
+func (n nameOnly) ModTime() time.Time { return time.Time{} }
+func (n nameOnly) IsDir() bool        { return false }
+func (n nameOnly) Sys() interface{}   { return nil }
+
+// explain works out what filing would do with name, step by step, the
+// way processInbox would, but without changing anything.  A bare name
+// is looked for in the root's inbox.
+func explain(config *Config, opts fileinbox.ParseOptions, name string) ([]whyLine, error) {
+	full := name
+	if !strings.Contains(name, "/") {
+		full = path.Join(config.inbox(), name)
+	}
+	full, err := filepath.Abs(full)
+	if err != nil {
+		return nil, err
+	}
+	inbox, base := path.Dir(full), path.Base(full)
+
+	var lines []whyLine
+	add := func(what, format string, args ...interface{}) {
+		lines = append(lines, whyLine{what, fmt.Sprintf(format, args...)})
+	}
+
+	var fi os.FileInfo = nameOnly(base)
+	exists := false
+	if info, err := os.Stat(full); err == nil {
+		fi, exists = info, true
+		add("File", "%s", full)
+	} else {
+		add("File", "%s, which doesn't exist, so only its name counts", full)
+	}
+	if info, err := os.Lstat(full); err == nil && isLink(info) {
+		add("Outcome", "left in the inbox, as it is a link, and links in an inbox aren't followed")
+		return lines, nil
+	}
+	if !hasString(config.inboxes(), inbox) {
+		add("Inbox", "%s is not one of the inboxes, so this is what would happen if it were", inbox)
+	}
+	if p := config.pipeline(inbox); p != nil && p.takes(base) {
+		add("Pipeline", "the pipeline for %s converts it, and files the result under %s", p.Inbox, p.Dest)
+		return lines, nil
+	}
+
+	inboxOpts := config.inboxOptions(opts, inbox)
+	var parsed *parsedName
+	if r := config.rule(inbox, fi); r != nil {
+		add("Rule", "%s", r)
+		parsed, err = r.apply(inboxOpts, inbox, fi)
+	} else {
+		add("Rule", "none matches")
+		if m := matchedPattern(inboxOpts, base); m != "" {
+			add("Pattern", "%s", m)
+		}
+		parsed, err = parseFileName(inboxOpts, base)
+		if err != nil {
+			if undated := config.undated(inboxOpts, base); undated != nil {
+				add("Pattern", "none, but %s gives undated names the date %s", undated.dest, config.Dests[undated.dest].DefaultDate)
+				parsed, err = undated, nil
+			}
+		}
+		if err != nil && exists && len(config.Plugins) != 0 {
+			fromPlugin, pluginErr := config.fromPlugins(inboxOpts, full)
+			switch {
+			case pluginErr != nil:
+				err = pluginErr
+			case fromPlugin != nil:
+				add("Plugin", "a plugin recognized it")
+				parsed, err = fromPlugin, nil
+			}
+		}
+		if err != nil {
+			if byExt := config.byExtension(inboxOpts, inbox, base); byExt != nil {
+				add("Pattern", "none, but it starts with a date, and %s files go to %s", extOf(base), byExt.dest)
+				parsed, err = byExt, nil
+			}
+		}
+		if err != nil {
+			if unsorted := config.unsorted(inboxOpts, inbox, base); unsorted != nil {
+				add("Pattern", "none, but it starts with a date, so it goes to the unsorted dest %s", unsorted.dest)
+				parsed, err = unsorted, nil
+			}
+		}
+	}
+	if err != nil {
+		add("Outcome", "left in the inbox: %v", err)
+		return lines, nil
+	}
+
+	// parsing without aliases tells us what the name itself said
+	raw := parsed.dest
+	noAliases := inboxOpts
+	noAliases.Aliases = nil
+	if p, err := fileinbox.ParseFileName(base, noAliases); err == nil {
+		raw = p.Dest
+	}
+	if raw != parsed.dest {
+		add("Dest", "%s, as %s is an alias for it", parsed.dest, raw)
+	} else {
+		add("Dest", "%s", parsed.dest)
+	}
+	add("Date", "%s-%s-%s", parsed.year, parsed.month, parsed.date)
+	if parsed.ambiguous {
+		if config.AmbiguousDates == ambiguousSkip {
+			add("Outcome", "left in the inbox, as the day and month could be swapped")
+			return lines, nil
+		}
+		add("Warning", "the day and month could be swapped")
+	}
+
+	if exists {
+		problem, quarantine := "", true
+		if config.Broken != brokenFile {
+			problem, err = broken(full)
+		}
+		if err == nil && problem == "" && config.Sniff != "" {
+			problem, err = sniff(full)
+			quarantine = config.Sniff == sniffQuarantine
+		}
+		switch {
+		case err != nil:
+			add("Outcome", "left in the inbox, as its contents can't be checked: %v", err)
+			return lines, nil
+		case problem != "" && quarantine:
+			add("Outcome", "quarantined in %s, because %s", config.quarantine(), problem)
+			return lines, nil
+		case problem != "":
+			add("Warning", "%s", problem)
+		}
+	}
+	if err := config.frozenDests(parsed); err != nil {
+		add("Outcome", "left in the inbox, as %v", err)
+		return lines, nil
+	}
+	if config.Dests[parsed.dest].Hold {
+		add("Outcome", "held in the inbox for review, as %s is on hold", parsed.dest)
+		return lines, nil
+	}
+
+	config.rename(inboxOpts, parsed)
+	if parsed.newName != "" {
+		add("Renamed", "to %s", parsed.newName)
+	}
+	destDir := config.dest(parsed.dest)
+	bucket := newBucketer(destDir, config.Dests[parsed.dest]).dir(parsed.year, parsed.month, fi.Size())
+	to := path.Join(destDir, bucket, parsed.filedName())
+	add("Filed as", "%s", to)
+	if mirror := cc(config, bucket, parsed); mirror != "" {
+		add("CC", "%s", mirror)
+	} else {
+		add("CC", "not mirrored")
+	}
+	for _, also := range parsed.also {
+		alsoDir := config.dest(also)
+		alsoBucket := newBucketer(alsoDir, config.Dests[also]).dir(parsed.year, parsed.month, fi.Size())
+		add("Copied to", "%s", path.Join(alsoDir, alsoBucket, parsed.filedName()))
+	}
+
+	target, linkErr := config.destLink(destDir)
+	if target != "" {
+		add("Dest link", "%s leads to %s, which is followed", destDir, target)
+	}
+
+	switch {
+	case linkErr != nil:
+		add("Outcome", "left in the inbox, as %v", linkErr)
+	case !isDir(destDir):
+		add("Outcome", "left in the inbox, as %s doesn't exist.  --%s would create it", destDir, forceFlag)
+	case !exists:
+		if _, err := os.Lstat(to); err == nil {
+			add("Outcome", "something is already filed as %s, so it is a duplicate or a conflict, depending on its contents", to)
+		} else {
+			add("Outcome", "filed")
+		}
+	default:
+		add("Outcome", "%s", collision(config, full, to))
+	}
+	return lines, nil
+}
+
+// collision says what happens when from is filed as to.
+func collision(config *Config, from, to string) string {
+	if _, err := os.Lstat(to); err != nil {
+		return "filed"
+	}
+	same, err := fileinbox.SameContents(from, to)
+	if err == nil && !same && config.Collisions == collisionsVersioned {
+		next, dup, verr := fileinbox.NextVersion(from, to)
+		switch {
+		case verr != nil:
+			err = verr
+		case !dup:
+			return fmt.Sprintf("a different document is filed as %s, so it is filed as %s", to, next)
+		default:
+			to, same = next, true
+		}
+	}
+	switch {
+	case err != nil:
+		return fmt.Sprintf("left in the inbox, as it can't be compared with %s: %v", to, err)
+	case !same:
+		return fmt.Sprintf("left in the inbox, as a different document is filed as %s", to)
+	case config.Duplicates == duplicatesKeep:
+		return "already filed with the same contents, so it is left in the inbox"
+	default:
+		return "already filed with the same contents, so it is removed from the inbox"
+	}
+}
+
+// matchedPattern returns the first of the patterns ParseFileName tries
+// that matches base, or "" if none do.
+func matchedPattern(opts fileinbox.ParseOptions, base string) string {
+	for _, re := range opts.Patterns {
+		if re.MatchString(base) {
+			return "the configured pattern " + re.String()
+		}
+	}
+	for _, name := range opts.PatternPacks {
+		pack, _ := fileinbox.PatternPack(name)
+		for _, re := range pack {
+			if re.MatchString(base) {
+				return "the " + name + " pattern pack"
+			}
+		}
+	}
+	if fileinbox.DefaultPattern.MatchString(base) {
+		return "the usual YYYYMMDD_dest_description"
+	}
+	for _, l := range opts.Layouts {
+		if l.Pattern.MatchString(base) {
+			return "the layout for " + l.Dest
+		}
+	}
+	return ""
+}
+
+func (r *Rule) String() string {
+	var s []string
+	for _, m := range []struct{ what, val string }{
+		{"inbox", r.Inbox},
+		{"glob", r.Glob},
+		{"regex", r.Regex},
+		{"ext", r.Ext},
+	} {
+		if m.val != "" {
+			s = append(s, fmt.Sprintf("%s %s", m.what, m.val))
+		}
+	}
+	if r.MinSize != 0 {
+		s = append(s, "minsize "+formatBytes(r.MinSize))
+	}
+	if r.MaxSize != 0 {
+		s = append(s, "maxsize "+formatBytes(r.MaxSize))
+	}
+	desc := "the rule for " + strings.Join(s, ", ")
+	if r.Dest != "" {
+		desc += ", filing under " + r.Dest
+	}
+	if r.Date != "" && r.Date != dateFromName {
+		desc += ", dated by " + r.Date
+	}
+	return desc
+}
+
+func doWhy(ctx *cli.Context) error {
+	config, _, err := queryConfig(ctx)
+	if err != nil {
+		return errors.Wrap(err, "why")
+	}
+	if ctx.Args().Len() != 1 {
+		return errors.New("why: name the file to explain")
+	}
+	lines, err := explain(config, config.parseOptions(ctx.Bool(forceFlag)), ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "why")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, l := range lines {
+		fmt.Fprintf(tw, "%s:\t%s\n", l.what, l.why)
+	}
+	return tw.Flush()
+}
+
+func whyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "why",
+		Usage:     "Explain what filing would do with a file, and why, without doing it.",
+		ArgsUsage: "<file>",
+		Action:    doWhy,
+	}
+}
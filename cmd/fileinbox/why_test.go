@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWhy(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	createFiles(t, root, []string{
+		"filed/pge/2016/20160825_pge.pdf",
+		"inbox/20160825_pge.pdf",
+		"inbox/20160901_pacificgas_bill.pdf",
+		"inbox/20160902_bank.pdf",
+		"inbox/notes.txt",
+		"inbox/scan.tif",
+	})
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "20160903_pge.pdf"), nil, 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "inbox", "20160826_pge.pdf"), []byte("a different bill"), 0600))
+	ok(t, ioutil.WriteFile(path.Join(root, "filed", "pge", "2016", "20160826_pge.pdf"), []byte("the bill"), 0600))
+
+	config := &Config{Root: root, Aliases: map[string]string{"pacificgas": "pge"}}
+	config.CC.Root = path.Join(root, "mirror")
+	config.CC.Dests = []string{"pge"}
+	config.Rules = []Rule{{Ext: "tif", Dest: "scans", Date: dateFromMtime}}
+	ok(t, config.validate())
+	opts := config.parseOptions(false)
+
+	for _, tc := range []struct {
+		name string
+		want map[string]string
+	}{
+		{"20160901_pacificgas_bill.pdf", map[string]string{
+			"Pattern":  "the usual YYYYMMDD_dest_description",
+			"Dest":     "pge, as pacificgas is an alias for it",
+			"Date":     "2016-09-01",
+			"Filed as": path.Join(root, "filed/pge/2016/20160901_pacificgas_bill.pdf"),
+			"CC":       path.Join(root, "mirror/pge/2016/20160901_pacificgas_bill.pdf"),
+			"Outcome":  "filed",
+		}},
+		{"20160825_pge.pdf", map[string]string{
+			"Outcome": "already filed with the same contents, so it is removed from the inbox",
+		}},
+		{"20160826_pge.pdf", map[string]string{
+			"Outcome": "left in the inbox, as a different document is filed as " + path.Join(root, "filed/pge/2016/20160826_pge.pdf"),
+		}},
+		{"20160902_bank.pdf", map[string]string{
+			"CC":      "not mirrored",
+			"Outcome": "left in the inbox, as " + path.Join(root, "filed/bank") + " doesn't exist.  --force would create it",
+		}},
+		{"20160903_pge.pdf", map[string]string{
+			"Outcome": "quarantined in " + path.Join(root, "quarantine") + ", because it is empty",
+		}},
+		{"notes.txt", map[string]string{
+			"Rule":    "none matches",
+			"Outcome": `left in the inbox: unable to parse "notes.txt".  We expect an 8 digit value like 20160825_pge_taxes2016.pdf or 20160825_pge.pdf`,
+		}},
+		{"scan.tif", map[string]string{
+			"Rule": "the rule for ext tif, filing under scans, dated by mtime",
+			"Dest": "scans",
+		}},
+		{"20170101_pge.pdf", map[string]string{
+			"File":    path.Join(root, "inbox/20170101_pge.pdf") + ", which doesn't exist, so only its name counts",
+			"Outcome": "filed",
+		}},
+	} {
+		lines, err := explain(config, opts, tc.name)
+		ok(t, err)
+		got := map[string]string{}
+		for _, l := range lines {
+			got[l.what] = l.why
+		}
+		for what, why := range tc.want {
+			equals(t, why, got[what])
+		}
+	}
+
+	// nothing moved
+	_, err = os.Stat(path.Join(root, "inbox", "20160903_pge.pdf"))
+	ok(t, err)
+}
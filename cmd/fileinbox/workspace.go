@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// workspaceDir, under the root, holds a directory for each run that
+// files, for what it makes along the way, such as the work of
+// pipelines and what fetchers download.  A run that goes
+// well removes its own.  One that fails leaves it be, so what went
+// wrong can be looked at, and says where it is.
+const workspaceDir = ".fileinbox-work"
+
+// partialDir, in workspaceDir, is shared by the runs, so a copy across
+// devices that one cut short, such as of a large video to a NAS that
+// went away, is picked up where it left off by the next.
+const partialDir = "partial"
+
+// workspace is the directory of a single run.  A nil workspace is a run
+// without one, as in tests that file directly.
+type workspace struct {
+	dir string
+}
+
+// newWorkspace makes a workspace for this run, named for when it
+// started.
+func newWorkspace(config *Config) (*workspace, error) {
+	base := path.Join(config.Root, workspaceDir)
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(base, clock.Now().Format("20060102-150405")+"-")
+	if err != nil {
+		return nil, err
+	}
+	return &workspace{dir}, nil
+}
+
+// sub returns the directory name within the workspace, creating it.
+// Without a workspace, it returns a new temporary directory that is the
+// caller's to remove.
+func (w *workspace) sub(name string) (string, error) {
+	if w == nil {
+		return ioutil.TempDir("", "fileinbox-"+name)
+	}
+	dir := path.Join(w.dir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// partial returns the directory copies across devices are made in,
+// creating it.
+func (w *workspace) partial() (string, error) {
+	dir := path.Join(path.Dir(w.dir), partialDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// finish removes the workspace after a run that went well.  After one
+// that failed, or where files failed and left something in it, it is
+// kept and we say where.
+func (w *workspace) finish(fr *fileResult, err error) {
+	if w == nil {
+		return
+	}
+	if err != nil || (fr.failureCount != 0 && w.holdsFiles()) {
+		printf(os.Stderr, styleNotice, "What this run was working on is kept in %s, to see what went wrong.  Remove it when done\n", w.dir)
+		return
+	}
+	os.RemoveAll(w.dir)
+	// and the base too, unless earlier runs left theirs, or copies that
+	// were cut short
+	os.Remove(path.Join(path.Dir(w.dir), partialDir))
+	os.Remove(path.Dir(w.dir))
+}
+
+// holdsFiles reports whether anything other than directories is in the
+// workspace.
+func (w *workspace) holdsFiles() bool {
+	found := false
+	filepath.Walk(w.dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestWorkspace(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	ok(t, err)
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	config := &Config{Root: root}
+	ok(t, config.validate())
+
+	// a run that goes well leaves nothing behind
+	w, err := newWorkspace(config)
+	ok(t, err)
+	dir, err := w.sub("pipeline")
+	ok(t, err)
+	ok(t, ioutil.WriteFile(path.Join(dir, "20240825.pdf"), []byte("bundle"), 0600))
+	_, err = w.partial()
+	ok(t, err)
+	w.finish(&fileResult{}, nil)
+	_, err = os.Stat(path.Join(root, workspaceDir))
+	assert(t, os.IsNotExist(err), "expected the workspace to be removed, got %v", err)
+
+	// copies that were cut short outlive the run, for the next
+	w, err = newWorkspace(config)
+	ok(t, err)
+	dir, err = w.partial()
+	ok(t, err)
+	ok(t, ioutil.WriteFile(path.Join(dir, "0123-10-0.part"), []byte("half"), 0600))
+	w.finish(&fileResult{}, nil)
+	_, err = os.Stat(path.Join(dir, "0123-10-0.part"))
+	ok(t, err)
+	_, err = os.Stat(w.dir)
+	assert(t, os.IsNotExist(err), "expected the run's own workspace to be removed, got %v", err)
+	ok(t, os.RemoveAll(dir))
+
+	// one that fails, or where documents failed and left something
+	// behind, keeps it
+	for _, tc := range []struct {
+		fr    fileResult
+		err   error
+		files bool
+		kept  bool
+	}{
+		{fileResult{}, errors.New("the archive went away"), false, true},
+		{fileResult{failureCount: 1}, nil, true, true},
+		{fileResult{failureCount: 1}, nil, false, false},
+	} {
+		w, err := newWorkspace(config)
+		ok(t, err)
+		dir, err := w.sub("copies")
+		ok(t, err)
+		if tc.files {
+			ok(t, ioutil.WriteFile(path.Join(dir, "20240825_pge.pdf"), []byte("half"), 0600))
+		}
+		w.finish(&tc.fr, tc.err)
+		_, err = os.Stat(dir)
+		if tc.kept {
+			ok(t, err)
+		} else {
+			assert(t, os.IsNotExist(err), "expected an empty workspace to be removed, got %v", err)
+		}
+		ok(t, os.RemoveAll(w.dir))
+	}
+}
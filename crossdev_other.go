@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package fileinbox
+
+import "syscall"
+
+// crossDevice is what a rename fails with when it would have to move a
+// file between devices, or between mounts of the same one.
+var crossDevice = []error{syscall.EXDEV}
@@ -0,0 +1,8 @@
+package fileinbox
+
+import "syscall"
+
+// crossDevice is what a rename fails with when it would have to move a
+// file between devices.  Windows says ERROR_NOT_SAME_DEVICE, which
+// syscall has no name for.
+var crossDevice = []error{syscall.EXDEV, syscall.Errno(17)}
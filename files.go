@@ -0,0 +1,150 @@
+package fileinbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+)
+
+// MkdirAll is like os.MkdirAll, but gives every directory it creates
+// exactly mode.  A zero mode means 0777 less the umask.
+func MkdirAll(name string, mode os.FileMode) error {
+	return MkdirAllGroup(name, mode, 0)
+}
+
+// MkdirAllGroup is like MkdirAll, and also gives every directory it
+// creates the group gid, unless it is zero.
+func MkdirAllGroup(name string, mode os.FileMode, gid int) error {
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		return nil
+	}
+	if parent := path.Dir(name); parent != name {
+		if err := MkdirAllGroup(parent, mode, gid); err != nil {
+			return err
+		}
+	}
+
+	perm := mode
+	if perm == 0 {
+		perm = 0777
+	}
+	if err := os.Mkdir(name, perm); err != nil {
+		if fi, statErr := os.Stat(name); os.IsExist(err) && statErr == nil && fi.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if gid != 0 {
+		if err := os.Chown(name, -1, gid); err != nil {
+			return err
+		}
+	}
+	if mode != 0 {
+		return os.Chmod(name, mode)
+	}
+	return nil
+}
+
+// CopyFile copies src to dest, which must not exist yet, returning the
+// number of bytes copied.  dest gets the mode of src, as a rename would
+// leave it, and when we run as root, its owner too.
+func CopyFile(src, dest string) (n int64, err error) {
+	var from, to *os.File
+	defer func() {
+		if from != nil {
+			from.Close()
+		}
+		if to != nil {
+			closeError := to.Close()
+			if err == nil {
+				err = closeError
+			}
+		}
+	}()
+
+	from, err = os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := from.Stat()
+	if err != nil {
+		return 0, err
+	}
+	to, err = os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	if n, err = io.Copy(to, from); err != nil {
+		return n, err
+	}
+	// the umask may have taken some of the mode away
+	if err = to.Chmod(fi.Mode().Perm()); err != nil {
+		return n, err
+	}
+	return n, keepOwner(to, fi)
+}
+
+// MoveFile renames fromName to toName, falling back to copying when they
+// are on different devices.  copied is the number of bytes copied, if
+// we had to.
+func MoveFile(fromName, toName string) (copied int64, err error) {
+	return moveFile(sys, fromName, toName)
+}
+
+// MoveFileVia is like MoveFile, but a copy is made in the directory
+// work first and linked into place once complete, so toName never holds
+// part of a document.  A copy that is cut short is kept in work, and
+// the next MoveFileVia of the same, unchanged, file picks up where it
+// left off, so a large document copied to a NAS needn't start over.
+// fromName is only removed once the copy is checked against it.  Where
+// work can't be linked from, it copies as MoveFile does.
+func MoveFileVia(fromName, toName, work string) (copied int64, err error) {
+	return moveFileVia(sys, fromName, toName, work)
+}
+
+// SameContents returns true if a and b hold the same bytes.
+func SameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	sa, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if sa.Size() != sb.Size() {
+		return false, nil
+	}
+
+	ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
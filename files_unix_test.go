@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package fileinbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestCopyFileKeepsMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer syscall.Umask(syscall.Umask(022))
+
+	for _, mode := range []os.FileMode{0600, 0660, 0444} {
+		src, dest := path.Join(dir, "src-"+mode.String()), path.Join(dir, "dest-"+mode.String())
+		if err := ioutil.WriteFile(src, []byte("contents"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(src, mode); err != nil {
+			t.Fatal(err)
+		}
+		if os.Geteuid() == 0 {
+			if err := os.Chown(src, 1234, 5678); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := CopyFile(src, dest); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("expected a copy of a %v file to be %v, got %v", mode, mode, fi.Mode().Perm())
+		}
+		if st := fi.Sys().(*syscall.Stat_t); os.Geteuid() == 0 && (st.Uid != 1234 || st.Gid != 5678) {
+			t.Errorf("expected root's copy to keep the owner 1234:5678, got %d:%d", st.Uid, st.Gid)
+		}
+	}
+}
+
+func TestApplyGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// root may give any group, and others only their own
+	gid := os.Getgid()
+	if os.Geteuid() == 0 {
+		gid = 1234
+	}
+	if gid == 0 {
+		t.Skip("no group to give")
+	}
+
+	from := path.Join(dir, "inbox/20240101_pge.pdf")
+	if err := os.MkdirAll(path.Dir(from), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(from, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	to := path.Join(dir, "filed/pge/2024/20240101_pge.pdf")
+	plan := &Plan{Moves: []Move{{From: from, To: to}}}
+	if r := plan.Apply(ApplyOptions{Group: gid, DirMode: 0770 | os.ModeSetgid, FileMode: 0660}); r.Moved != 1 {
+		t.Fatalf("expected the document to be filed, got %+v", r)
+	}
+	for _, name := range []string{path.Join(dir, "filed"), path.Dir(to), to} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := fi.Sys().(*syscall.Stat_t); int(st.Gid) != gid {
+			t.Errorf("expected %s to have the group %d, got %d", name, gid, st.Gid)
+		}
+	}
+	if fi, err := os.Stat(path.Dir(to)); err != nil || fi.Mode()&os.ModeSetgid == 0 {
+		t.Errorf("expected %s to be setgid, got %v, %v", path.Dir(to), fi.Mode(), err)
+	}
+}
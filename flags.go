@@ -0,0 +1,163 @@
+package fileinbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// errNoFlags is what fileSys gives when a file can't have flags, either
+// because the platform has none or the filesystem doesn't keep them.
+var errNoFlags = errors.New("file flags are not supported")
+
+// The user settable file flags of macOS and the BSDs, as in chflags(1).
+// We carry over all but those that stop a file being changed or
+// removed: locking filed documents is up to the caller, and a source
+// we can't remove would leave us with two copies.
+const (
+	ufSettable  = 0x0000ffff
+	ufImmutable = 0x00000002 // uchg
+	ufAppend    = 0x00000004 // uappnd
+	ufNoUnlink  = 0x00000010 // uunlnk on FreeBSD, compressed on macOS
+
+	keptFlags = ufSettable &^ (ufImmutable | ufAppend | ufNoUnlink)
+)
+
+// fileSys is the system calls moving a file rests on.  It is a
+// variable so tests can stand in for the platform, and check the BSD
+// behaviour anywhere.
+type fileSys interface {
+	rename(from, to string) error
+	// flags returns name's file flags, or errNoFlags.
+	flags(name string) (uint32, error)
+	// setFlags gives name flags, or returns errNoFlags.
+	setFlags(name string, flags uint32) error
+}
+
+var sys fileSys = osFileSys{}
+
+func (osFileSys) rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+// isCrossDevice returns true if err is a rename failing because it
+// would cross devices.
+func isCrossDevice(err error) bool {
+	for _, c := range crossDevice {
+		if errors.Is(err, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameError says why renaming fromName to toName failed with err, for
+// the failures copying can't get around.  Where we have nothing to add,
+// it is err.
+func renameError(fromName, toName string, err error) error {
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("not allowed to move %s to %s, check who may write to both directories: %w", fromName, toName, err)
+	case errors.Is(err, syscall.EROFS):
+		return fmt.Errorf("unable to move %s to %s, as one of them is on a read-only filesystem: %w", fromName, toName, err)
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("no space left to move %s to %s: %w", fromName, toName, err)
+	}
+	return err
+}
+
+// moveFile is MoveFile, on s.  Rename can fail across mounts even
+// within a device, such as between FreeBSD nullfs mounts of the same
+// filesystem, so we don't try to predict it and just fall back to
+// copying when it says so.  A copy keeps the flags of the original,
+// such as nodump or hidden.
+func moveFile(s fileSys, fromName, toName string) (copied int64, err error) {
+	err = s.rename(fromName, toName)
+	if err == nil {
+		return 0, nil
+	}
+	if !isCrossDevice(err) {
+		return 0, renameError(fromName, toName, err)
+	}
+
+	copied, err = CopyFile(fromName, toName)
+	if err == nil {
+		err = copyFlags(s, fromName, toName)
+	}
+	if err != nil {
+		// don't clean up a file that was already there
+		if !os.IsExist(err) {
+			os.Remove(toName)
+		}
+		return copied, err
+	}
+	return copied, os.Remove(fromName)
+}
+
+// moveFileVia is MoveFileVia, on s.
+func moveFileVia(s fileSys, fromName, toName, work string) (copied int64, err error) {
+	err = s.rename(fromName, toName)
+	if err == nil {
+		return 0, nil
+	}
+	if !isCrossDevice(err) {
+		return 0, renameError(fromName, toName, err)
+	}
+
+	if _, err := os.Lstat(toName); err == nil {
+		// don't spend a long copy finding out
+		return 0, &os.LinkError{Op: "link", Old: fromName, New: toName, Err: os.ErrExist}
+	}
+	if err := os.MkdirAll(work, 0700); err != nil {
+		return moveFile(s, fromName, toName)
+	}
+	staged, copied, err := resumeCopy(fromName, work)
+	if err != nil {
+		return copied, err
+	}
+	// unlike a rename, a link won't replace what is there
+	err = os.Link(staged, toName)
+	os.Remove(staged)
+	if err != nil {
+		if os.IsExist(err) {
+			return copied, err
+		}
+		// work is on another device from toName, or links aren't
+		// supported there
+		return moveFile(s, fromName, toName)
+	}
+	if err = copyFlags(s, fromName, toName); err != nil {
+		os.Remove(toName)
+		return copied, err
+	}
+	// the original goes only once we know the copy is whole
+	same, err := SameContents(fromName, toName)
+	if err == nil && !same {
+		err = fmt.Errorf("the copy of %s differs from it", fromName)
+	}
+	if err != nil {
+		os.Remove(toName)
+		return copied, fmt.Errorf("checking %s: %w", toName, err)
+	}
+	return copied, os.Remove(fromName)
+}
+
+// copyFlags gives to the flags of from that we keep.  Where there are
+// no flags, there is nothing to do.
+func copyFlags(s fileSys, from, to string) error {
+	flags, err := s.flags(from)
+	if errors.Is(err, errNoFlags) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags&keptFlags == 0 {
+		return nil
+	}
+	if err := s.setFlags(to, flags&keptFlags); err != nil && !errors.Is(err, errNoFlags) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package fileinbox
+
+import (
+	"os"
+	"syscall"
+)
+
+type osFileSys struct{}
+
+func (osFileSys) flags(name string) (uint32, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(name, &st); err != nil {
+		return 0, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return st.Flags, nil
+}
+
+func (osFileSys) setFlags(name string, flags uint32) error {
+	err := syscall.Chflags(name, int(flags))
+	if err == syscall.EOPNOTSUPP || err == syscall.EINVAL {
+		// e.g. msdosfs, or an NFS export
+		return errNoFlags
+	}
+	if err != nil {
+		return &os.PathError{Op: "chflags", Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package fileinbox
+
+// osFileSys has no flags to keep here.  Linux's attributes, set with
+// chattr, aren't flags in this sense, and illumos' system attributes
+// can't be reached without cgo.
+type osFileSys struct{}
+
+func (osFileSys) flags(name string) (uint32, error) {
+	return 0, errNoFlags
+}
+
+func (osFileSys) setFlags(name string, flags uint32) error {
+	return errNoFlags
+}
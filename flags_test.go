@@ -0,0 +1,250 @@
+package fileinbox
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeFileSys behaves as a BSD does when moving between nullfs mounts:
+// renames fail with EXDEV, unless renameErr says otherwise, and files
+// have flags.
+type fakeFileSys struct {
+	all       map[string]uint32
+	noneOn    string // a file whose filesystem keeps no flags
+	renameErr error
+}
+
+func (f *fakeFileSys) rename(from, to string) error {
+	if f.renameErr != nil {
+		return f.renameErr
+	}
+	return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+}
+
+func (f *fakeFileSys) flags(name string) (uint32, error) {
+	return f.all[name], nil
+}
+
+func (f *fakeFileSys) setFlags(name string, flags uint32) error {
+	if name == f.noneOn {
+		return errNoFlags
+	}
+	f.all[name] = flags
+	return nil
+}
+
+func TestMoveKeepsFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const nodump, hidden = 0x1, 0x8000
+	for _, tc := range []struct {
+		name   string
+		flags  uint32
+		noneOn bool
+		want   uint32
+	}{
+		{"plain.pdf", 0, false, 0},
+		{"nodump.pdf", nodump | hidden, false, nodump | hidden},
+		{"locked.pdf", nodump | ufImmutable | ufAppend | ufNoUnlink, false, nodump},
+		{"msdosfs.pdf", nodump, true, 0},
+	} {
+		from, to := path.Join(dir, "from-"+tc.name), path.Join(dir, "to-"+tc.name)
+		if err := ioutil.WriteFile(from, []byte(tc.name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fake := &fakeFileSys{all: map[string]uint32{from: tc.flags}}
+		if tc.noneOn {
+			fake.noneOn = to
+		}
+		copied, err := moveFile(fake, from, to)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if copied != int64(len(tc.name)) {
+			t.Errorf("%s: expected %d bytes copied, got %d", tc.name, len(tc.name), copied)
+		}
+		if got := fake.all[to]; got != tc.want {
+			t.Errorf("%s: expected flags %#x, got %#x", tc.name, tc.want, got)
+		}
+		if _, err := os.Stat(from); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the original to be removed, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestMoveRenameErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		copies bool
+		is     error
+		says   string
+	}{
+		// not a LinkError, as some platforms and wrappers give
+		{"wrapped.pdf", fmt.Errorf("renaming: %w", &os.PathError{Op: "rename", Path: "a", Err: crossDevice[0]}), true, nil, ""},
+		{"denied.pdf", &os.LinkError{Op: "rename", Err: syscall.EACCES}, false, os.ErrPermission, "not allowed"},
+		{"full.pdf", &os.LinkError{Op: "rename", Err: syscall.ENOSPC}, false, syscall.ENOSPC, "no space left"},
+		{"busy.pdf", &os.LinkError{Op: "rename", Err: syscall.EBUSY}, false, syscall.EBUSY, ""},
+	} {
+		from, to := path.Join(dir, "from-"+tc.name), path.Join(dir, "to-"+tc.name)
+		if err := ioutil.WriteFile(from, []byte(tc.name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fake := &fakeFileSys{all: map[string]uint32{}, renameErr: tc.err}
+		_, err := moveFile(fake, from, to)
+		if tc.copies {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			if _, err := os.Stat(to); err != nil {
+				t.Errorf("%s: expected a copy, got %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tc.is) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.is, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), tc.says) {
+			t.Errorf("%s: expected %q in %q", tc.name, tc.says, err)
+		}
+		// nothing was copied, and the original is where it was
+		if _, err := os.Stat(to); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no copy, got %v", tc.name, err)
+		}
+		if _, err := os.Stat(from); err != nil {
+			t.Errorf("%s: expected the original to stay, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestMoveFileVia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	work := path.Join(dir, "work")
+	if err := os.Mkdir(work, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := path.Join(dir, "from.pdf"), path.Join(dir, "to.pdf")
+	if err := ioutil.WriteFile(from, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeFileSys{all: map[string]uint32{from: 0x1}}
+	copied, err := moveFileVia(fake, from, to, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != int64(len("contents")) {
+		t.Errorf("expected %d bytes copied, got %d", len("contents"), copied)
+	}
+	if got, err := ioutil.ReadFile(to); err != nil || string(got) != "contents" {
+		t.Errorf("expected the document to be filed, got %q, %v", got, err)
+	}
+	if fake.all[to] != 0x1 {
+		t.Errorf("expected the flags to be kept, got %#x", fake.all[to])
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("expected the original to be removed, got %v", err)
+	}
+	if left, _ := ioutil.ReadDir(work); len(left) != 0 {
+		t.Errorf("expected nothing left in the work directory, got %d entries", len(left))
+	}
+
+	// a name that is taken stays as it was
+	if err := ioutil.WriteFile(from, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := moveFileVia(fake, from, to, work); !os.IsExist(err) {
+		t.Errorf("expected an error for a taken name, got %v", err)
+	}
+	if got, _ := ioutil.ReadFile(to); string(got) != "contents" {
+		t.Errorf("expected the filed document to be left be, got %q", got)
+	}
+}
+
+func TestMoveFileViaResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	work := path.Join(dir, "work")
+	if err := os.Mkdir(work, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	const contents = "the first half and the second half"
+	from, to := path.Join(dir, "from.pdf"), path.Join(dir, "to.pdf")
+	if err := ioutil.WriteFile(from, []byte(contents), 0400); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as left by a copy that was cut short, and by one of an older
+	// version
+	if err := ioutil.WriteFile(partialName(work, from, fi), []byte(contents[:14]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stale := path.Join(work, partialPrefix(from)+"-3-0.part")
+	if err := ioutil.WriteFile(stale, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeFileSys{all: map[string]uint32{}}
+	copied, err := moveFileVia(fake, from, to, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(contents) - 14); copied != want {
+		t.Errorf("expected %d bytes copied, got %d", want, copied)
+	}
+	if got, err := ioutil.ReadFile(to); err != nil || string(got) != contents {
+		t.Errorf("expected the document to be filed, got %q, %v", got, err)
+	}
+	if left, _ := ioutil.ReadDir(work); len(left) != 0 {
+		t.Errorf("expected nothing left in the work directory, got %d entries", len(left))
+	}
+
+	// a partial copy that doesn't match fails the check, and the
+	// original stays
+	if err := ioutil.WriteFile(from, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := path.Join(dir, "other.pdf")
+	if fi, err = os.Stat(from); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(partialName(work, from, fi), []byte("THE FIRST HALF"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := moveFileVia(fake, from, other, work); err == nil {
+		t.Errorf("expected a copy that differs to fail")
+	}
+	if _, err := os.Stat(from); err != nil {
+		t.Errorf("expected the original to be kept, got %v", err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Errorf("expected the bad copy to be removed, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/ginabythebay/file_inbox
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/pkg/errors v0.7.1
 	github.com/urfave/cli/v2 v2.2.0
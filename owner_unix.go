@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package fileinbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives f the owner and group of fi, when we are root and so
+// able to.  Otherwise a copy belongs to whoever made it, as usual.
+func keepOwner(f *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() != 0 {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
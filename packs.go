@@ -0,0 +1,86 @@
+package fileinbox
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Pattern packs for names written by scanner apps in other languages.
+// Documents matched by a pack are filed under the canonical YYYYMMDD
+// name.
+const (
+	// PackMonthNames reads a day, a month name and a year, e.g.
+	// 25Aug2024_pge.pdf, 25-août-2024_pge.pdf or 3.Mär.2024_pge.pdf.
+	// Month names and abbreviations are recognized in English, German,
+	// French, Spanish, Italian, Dutch and Portuguese.
+	PackMonthNames = "monthnames"
+
+	// PackCJK reads Chinese, Japanese and Korean dates, e.g.
+	// 2024年08月25日_pge.pdf or 2024년8월25일_pge.pdf.
+	PackCJK = "cjk"
+)
+
+var packs = map[string][]*regexp.Regexp{
+	PackMonthNames: {
+		regexp.MustCompile(`^(?P<date>\d\d?)[-. ]?(?P<month>\pL+)\.?[-. ]?(?P<year>\d\d\d\d)(?:-(?P<seq>\d+))?_(?P<dest>[^_.]+)(?P<desc>.*)$`),
+	},
+	PackCJK: {
+		regexp.MustCompile(`^(?P<year>\d\d\d\d)[年년] ?(?P<month>\d\d?)[月월] ?(?P<date>\d\d?)[日일](?:-(?P<seq>\d+))?_(?P<dest>[^_.]+)(?P<desc>.*)$`),
+	},
+}
+
+// PatternPack returns the patterns in the named pack, and false if there
+// is no such pack.
+func PatternPack(name string) ([]*regexp.Regexp, bool) {
+	p, ok := packs[name]
+	return p, ok
+}
+
+// PatternPackNames returns the names of the packs, for error messages.
+func PatternPackNames() []string {
+	return []string{PackMonthNames, PackCJK}
+}
+
+// monthNames maps lower-cased month names and abbreviations to their
+// numbers.  Where languages share a name, they agree on the month.
+var monthNames = map[string]int{}
+
+func init() {
+	for _, names := range [][]string{
+		// english
+		{"january", "february", "march", "april", "may", "june", "july", "august", "september", "october", "november", "december"},
+		{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"},
+		{"", "", "", "", "", "", "", "", "sept", "", "", ""},
+		// german
+		{"januar", "februar", "märz", "april", "mai", "juni", "juli", "august", "september", "oktober", "november", "dezember"},
+		{"jän", "feb", "mär", "apr", "mai", "jun", "jul", "aug", "sep", "okt", "nov", "dez"},
+		{"", "", "maerz", "", "", "", "", "", "", "", "", ""},
+		// french
+		{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		{"janv", "févr", "mars", "avr", "mai", "juin", "juil", "août", "sept", "oct", "nov", "déc"},
+		{"", "fevrier", "", "", "", "", "", "aout", "", "", "", "decembre"},
+		// spanish
+		{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sep", "oct", "nov", "dic"},
+		// italian
+		{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		// dutch
+		{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		// portuguese
+		{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+	} {
+		for i, name := range names {
+			if name != "" {
+				monthNames[name] = i + 1
+			}
+		}
+	}
+}
+
+// monthNumber returns the number for a month name, or 0 if s isn't one.
+func monthNumber(s string) int {
+	return monthNames[strings.ToLower(s)]
+}
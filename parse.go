@@ -0,0 +1,517 @@
+// Package fileinbox holds the parts of fileinbox that other tools may
+// want to share: the rules for what a document name looks like, and
+// plans for filing documents, which can be made, looked over and
+// applied as separate steps.
+package fileinbox
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFutureYears is how far into the future a date may be before we
+// assume it is a typo.
+const DefaultFutureYears = 2
+
+// DefaultPattern is the naming convention fileinbox has always used,
+// e.g. 20160825_pge_taxes2016.pdf.  A second document for the same day
+// may carry a sequence number after the date, e.g. 20160825-2_pge.pdf.
+// A document for more than one dest lists them all, separated by
+// AlsoSeparator, e.g. 20240101_insurance+hsa.pdf.
+var DefaultPattern = regexp.MustCompile(`^(?P<year>\d\d\d\d)(?P<month>\d\d)(?P<date>\d\d)(?:-(?P<seq>\d+))?_(?P<dest>[^_.]+)(?P<desc>.*)$`)
+
+// CameraPattern matches the names phones and cameras give photos,
+// videos and screenshots, e.g. IMG_20240825_123456.jpg,
+// PXL_20240825_123456789.jpg or VID_20240825_123456.mp4.  Use it in a
+// Layout, as they don't say their dest.
+var CameraPattern = regexp.MustCompile(`^(?:IMG|PXL|VID|MVIMG|PANO|DSC|Screenshot)[_-](?P<year>\d\d\d\d)(?P<month>\d\d)(?P<date>\d\d)[_-]\d{6}`)
+
+// Layout files names that don't carry a dest, such as those written by
+// a camera, under Dest.  Pattern must have the named groups year, month
+// and date.  Names matched by a layout are left as they are.
+type Layout struct {
+	Pattern *regexp.Regexp
+	Dest    string
+}
+
+// AlsoSeparator separates the dests of a document that belongs to more
+// than one.  It is filed under the first, and copied to the others.
+const AlsoSeparator = "+"
+
+var datePattern = regexp.MustCompile(`^(\d\d\d\d)(\d\d)(\d\d)`)
+
+// Orders the leading 8 digit date of a name can be in.
+const (
+	DateOrderYMD = "ymd" // 20240201, the default
+	DateOrderDMY = "dmy" // 01022024
+	DateOrderMDY = "mdy" // 02012024
+)
+
+// ParseOptions controls how names are parsed.  Start from
+// DefaultParseOptions to parse names the same way fileinbox does.
+type ParseOptions struct {
+	// Patterns are tried in order before DefaultPattern.  Each must
+	// have the named groups year, month, date and dest, and may have
+	// seq and desc.
+	Patterns []*regexp.Regexp
+
+	// PatternPacks names built-in patterns, such as PackMonthNames, to
+	// try after Patterns.  Names they match are given a CanonicalName.
+	PatternPacks []string
+
+	// FutureYears is how many years after the current one a date may
+	// be.  Negative disables the check.
+	FutureYears int
+
+	// Layouts are tried after DefaultPattern, for names that don't
+	// carry a dest.
+	Layouts []Layout
+
+	// DestFuture overrides FutureYears for the documents of a dest,
+	// keyed by the dest after aliases are applied.  A nested dest
+	// without its own takes its parent's.
+	DestFuture map[string]FuturePolicy
+
+	// Normalize lower-cases the dest before aliases are applied.
+	Normalize bool
+
+	// Aliases maps alternate dest names to the dest they stand for,
+	// e.g. "pacificgas" to "pge".  An alias for a parent dest also
+	// applies to the dests under it.
+	Aliases map[string]string
+
+	// DestSeparator, when set, lets a name carry a nested dest, e.g.
+	// with "-" the name 20240101_insurance-auto.pdf has the dest
+	// insurance/auto.  With ".", the name 20240101_insurance.auto.pdf
+	// has the dest insurance/auto, as only the final extension is left
+	// out of the dest, so 20240101_pge.tar.gz has the dest pge/tar.
+	DestSeparator string
+
+	// DateOrder is the order of the leading 8 digit date, one of the
+	// DateOrder constants.  Empty means DateOrderYMD.  Patterns name
+	// their groups, so they aren't affected.
+	DateOrder string
+
+	// StripPrefixes are removed from the start of a name before it is
+	// parsed, for scanners that write names like Scan_20240825_pge.pdf
+	// or SKM_C3350_20240825_pge.pdf.  The first that matches at the
+	// start of the name is removed, and the name is given a
+	// CanonicalName without it.
+	StripPrefixes []*regexp.Regexp
+
+	// Clock is what FutureYears is measured from.  Nil means
+	// SystemClock.
+	Clock Clock
+
+	// Location is the time zone dates are in, and today is judged in,
+	// so archives shared between machines in different zones agree
+	// around midnight.  Nil means the local time zone.
+	Location *time.Location
+
+	neverFuture bool
+}
+
+// FuturePolicy is how far into the future the dates of a dest may be.
+type FuturePolicy struct {
+	// Years is like ParseOptions.FutureYears, e.g. 10 for warranties.
+	Years int
+
+	// Never rejects any date after today, for dests such as receipts
+	// where a future date is always a typo.
+	Never bool
+}
+
+// DefaultParseOptions returns the options fileinbox uses when nothing is
+// configured.
+func DefaultParseOptions() ParseOptions {
+	return ParseOptions{FutureYears: DefaultFutureYears}
+}
+
+// ParsedName is everything we can learn from a document name.
+type ParsedName struct {
+	BaseName    string    // e.g. 20160825-2_pge_taxes_2016.pdf
+	Date        time.Time // e.g. 2016-08-25, in ParseOptions.Location
+	Sequence    int       // e.g. 2, zero when there is none
+	Dest        string    // e.g. pge or insurance/auto, after normalization and aliases
+	Description string    // e.g. taxes_2016
+	Also        []string  // e.g. [tax] for 20240101_pge+tax.pdf, dests that get a copy
+	Tags        []string  // e.g. [taxes 2016], the words of the description
+	Ext         string    // e.g. .pdf
+
+	// Ambiguous is set when the date would also be valid with the day
+	// and month swapped, e.g. 01022024 in DateOrderDMY.
+	Ambiguous bool
+
+	// CanonicalName is the name with its date rewritten as YYYYMMDD, set
+	// when DateOrder is day or month first, e.g. 20240201_pge.pdf for
+	// 01022024_pge.pdf, or a pattern pack matched, e.g. 20240825_pge.pdf
+	// for 25Aug2024_pge.pdf.
+	CanonicalName string
+}
+
+// ParseFileName parses a document name, such as 20160825_pge.pdf.
+func ParseFileName(baseName string, opts ParseOptions) (*ParsedName, error) {
+	name := opts.stripPrefix(baseName)
+	p, err := parseFileName(name, opts)
+	if p == nil || name == baseName {
+		return p, err
+	}
+	p.BaseName = baseName
+	if p.CanonicalName == "" {
+		p.CanonicalName = name
+	}
+	return p, nil
+}
+
+// stripPrefix returns baseName without the first of StripPrefixes that
+// matches at its start.
+func (o ParseOptions) stripPrefix(baseName string) string {
+	for _, re := range o.StripPrefixes {
+		if loc := re.FindStringIndex(baseName); loc != nil && loc[0] == 0 && loc[1] > 0 {
+			return baseName[loc[1]:]
+		}
+	}
+	return baseName
+}
+
+func parseFileName(baseName string, opts ParseOptions) (*ParsedName, error) {
+	for _, re := range opts.Patterns {
+		if p, err := parseWith(re, baseName, opts, false); p != nil || err != nil {
+			return p, err
+		}
+	}
+	for _, name := range opts.PatternPacks {
+		pack, ok := PatternPack(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown pattern pack %q", name)
+		}
+		for _, re := range pack {
+			if p, err := parseWith(re, baseName, opts, true); p != nil || err != nil {
+				return p, err
+			}
+		}
+	}
+	if p, err := parseWith(DefaultPattern, baseName, opts, false); p != nil || err != nil {
+		return p, err
+	}
+	for _, l := range opts.Layouts {
+		if p, err := parseLayout(l, baseName, opts); p != nil || err != nil {
+			return p, err
+		}
+	}
+	return nil, fmt.Errorf("unable to parse %q.  We expect an 8 digit value like 20160825_pge_taxes2016.pdf or 20160825_pge.pdf", baseName)
+}
+
+// parseWith returns nil, nil if re doesn't match.  With canonical set,
+// the name is given a CanonicalName.
+func parseWith(re *regexp.Regexp, baseName string, opts ParseOptions, canonical bool) (*ParsedName, error) {
+	groups, starts := match(re, baseName)
+	if groups == nil {
+		return nil, nil
+	}
+
+	// the dest comes first, as it decides how far ahead the date may be
+	p := &ParsedName{
+		BaseName: baseName,
+		Ext:      filepath.Ext(baseName),
+	}
+	dests := strings.Split(groups["dest"], AlsoSeparator)
+	dest, desc := opts.splitDest(dests[0], strings.TrimSuffix(groups["desc"], p.Ext))
+	p.Dest = opts.ResolveDest(dest)
+	if p.Dest == "" {
+		return nil, fmt.Errorf("unable to parse %q.  We could not find the destination", baseName)
+	}
+	for _, d := range dests[1:] {
+		also, _ := opts.splitDest(d, "")
+		also = opts.ResolveDest(also)
+		if also == "" {
+			return nil, fmt.Errorf("unable to parse %q.  It has an empty destination after %s", baseName, AlsoSeparator)
+		}
+		if also != p.Dest && !contains(p.Also, also) {
+			p.Also = append(p.Also, also)
+		}
+	}
+
+	var date time.Time
+	var ambiguous bool
+	var err error
+	dateOpts := opts.ForDest(p.Dest)
+	if re == DefaultPattern {
+		date, ambiguous, err = dateOpts.readDate(baseName, groups["year"]+groups["month"]+groups["date"])
+	} else {
+		date, err = dateOpts.toDate(baseName, groups["year"], groups["month"], groups["date"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Date = date
+	p.Ambiguous = ambiguous
+	if re == DefaultPattern && opts.dayOrMonthFirst() {
+		p.CanonicalName = date.Format("20060102") + baseName[8:]
+	}
+	if canonical {
+		// everything from the _ before the dest is kept as it was
+		p.CanonicalName = date.Format("20060102")
+		if s := groups["seq"]; s != "" {
+			p.CanonicalName += "-" + s
+		}
+		p.CanonicalName += baseName[starts["dest"]-1:]
+	}
+	if s := groups["seq"]; s != "" {
+		if p.Sequence, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	p.Description = strings.Trim(desc, "_.")
+	for _, t := range strings.Split(p.Description, "_") {
+		if t != "" {
+			p.Tags = append(p.Tags, t)
+		}
+	}
+	return p, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the named groups re finds in baseName, and where each
+// starts, or nil if it doesn't match.
+func match(re *regexp.Regexp, baseName string) (groups map[string]string, starts map[string]int) {
+	matches := re.FindStringSubmatchIndex(baseName)
+	if matches == nil {
+		return nil, nil
+	}
+	groups = map[string]string{}
+	starts = map[string]int{}
+	for i, name := range re.SubexpNames() {
+		if name != "" && matches[2*i] >= 0 {
+			groups[name] = baseName[matches[2*i]:matches[2*i+1]]
+			starts[name] = matches[2*i]
+		}
+	}
+	return groups, starts
+}
+
+// parseLayout returns nil, nil if l doesn't match.
+func parseLayout(l Layout, baseName string, opts ParseOptions) (*ParsedName, error) {
+	groups, _ := match(l.Pattern, baseName)
+	if groups == nil {
+		return nil, nil
+	}
+	p := &ParsedName{
+		BaseName: baseName,
+		Dest:     opts.ResolveDest(l.Dest),
+		Ext:      filepath.Ext(baseName),
+	}
+	date, err := opts.ForDest(p.Dest).toDate(baseName, groups["year"], groups["month"], groups["date"])
+	if err != nil {
+		return nil, err
+	}
+	p.Date = date
+	return p, nil
+}
+
+// ParseDate parses just the leading date of a name, in DateOrder, for
+// callers that decide the dest some other way.
+func ParseDate(baseName string, opts ParseOptions) (time.Time, error) {
+	matches := datePattern.FindStringSubmatch(baseName)
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("unable to parse %q.  We expect it to start with an 8 digit value like 20160825", baseName)
+	}
+	t, _, err := opts.readDate(baseName, matches[0])
+	return t, err
+}
+
+func (o ParseOptions) dayOrMonthFirst() bool {
+	return o.DateOrder == DateOrderDMY || o.DateOrder == DateOrderMDY
+}
+
+// readDate reads an 8 digit date in DateOrder.  ambiguous is set if
+// swapping the day and month would give another valid date.
+func (o ParseOptions) readDate(baseName, digits string) (t time.Time, ambiguous bool, err error) {
+	var year, month, day string
+	switch o.DateOrder {
+	case DateOrderDMY:
+		year, month, day = digits[4:8], digits[2:4], digits[0:2]
+	case DateOrderMDY:
+		year, month, day = digits[4:8], digits[0:2], digits[2:4]
+	default:
+		year, month, day = digits[0:4], digits[4:6], digits[6:8]
+	}
+	t, err = o.toDate(baseName, year, month, day)
+	if !o.dayOrMonthFirst() {
+		return t, false, err
+	}
+
+	_, swapErr := o.toDate(baseName, year, day, month)
+	if err != nil && swapErr == nil {
+		other := DateOrderDMY
+		if o.DateOrder == DateOrderDMY {
+			other = DateOrderMDY
+		}
+		return time.Time{}, false, fmt.Errorf("%v.  %s would be valid in %s order", err, baseName, other)
+	}
+	return t, err == nil && swapErr == nil && day != month, err
+}
+
+// splitDest pulls any nested parts of the dest off the front of desc,
+// returning the dest with its parts separated by slashes.
+func (o ParseOptions) splitDest(dest, desc string) (string, string) {
+	sep := o.DestSeparator
+	if sep == "" {
+		return dest, desc
+	}
+	for strings.HasPrefix(desc, sep) {
+		rest := desc[len(sep):]
+		end := len(rest)
+		for _, stop := range []string{"_", ".", sep} {
+			if i := strings.Index(rest, stop); i >= 0 && i < end {
+				end = i
+			}
+		}
+		if end == 0 {
+			break
+		}
+		dest += sep + rest[:end]
+		desc = rest[end:]
+	}
+	var parts []string
+	for _, part := range strings.Split(dest, sep) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/"), desc
+}
+
+// ResolveDest applies normalization and aliases to a dest.  Nested dests
+// are separated by slashes, and the longest aliased parent wins, so with
+// an alias from "ins" to "insurance", "ins/auto" becomes
+// "insurance/auto".
+func (o ParseOptions) ResolveDest(dest string) string {
+	if o.Normalize {
+		parts := strings.Split(dest, "/")
+		for i, part := range parts {
+			parts[i] = strings.ToLower(strings.TrimSpace(part))
+		}
+		dest = strings.Join(parts, "/")
+	}
+	for prefix, rest := dest, ""; prefix != ""; {
+		if alias, ok := o.Aliases[prefix]; ok {
+			return alias + rest
+		}
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			break
+		}
+		prefix, rest = prefix[:i], prefix[i:]+rest
+	}
+	return dest
+}
+
+// Now returns the time according to the Clock, in the Zone.
+func (o ParseOptions) Now() time.Time {
+	if o.Clock == nil {
+		return SystemClock.Now().In(o.Zone())
+	}
+	return o.Clock.Now().In(o.Zone())
+}
+
+// Zone returns Location, or the local time zone if it isn't set.
+func (o ParseOptions) Zone() *time.Location {
+	if o.Location == nil {
+		return time.Local
+	}
+	return o.Location
+}
+
+// ForDest returns the options as they apply to the documents of dest,
+// with its FuturePolicy, if it has one, in place of FutureYears.
+func (o ParseOptions) ForDest(dest string) ParseOptions {
+	for d := dest; d != "" && d != "." && d != "/"; d = path.Dir(d) {
+		if p, ok := o.DestFuture[d]; ok {
+			o.FutureYears = p.Years
+			o.neverFuture = p.Never
+			break
+		}
+	}
+	return o
+}
+
+// CheckFuture returns an error if t is too far in the future to be
+// believable.  Use ForDest first to take a dest's policy into account.
+// Days and years are judged in the Zone, whatever zone t is in.
+func (o ParseOptions) CheckFuture(baseName string, t time.Time) error {
+	now := o.Now()
+	t = t.In(o.Zone())
+	if o.neverFuture {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, o.Zone())
+		if !t.Before(tomorrow) {
+			return fmt.Errorf("%s is dated %s, after today, which is never right for its dest", baseName, t.Format("2006-01-02"))
+		}
+	}
+	yearDiff := t.Year() - now.Year()
+	if o.FutureYears >= 0 && yearDiff > o.FutureYears {
+		return fmt.Errorf("%s is %d years in the future, which is highly suspect.  To continue, set the --force flag", baseName, yearDiff)
+	}
+	return nil
+}
+
+func (o ParseOptions) toDate(baseName, year, month, date string) (time.Time, error) {
+	y, err := yearTest.verify(year)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m := monthNumber(month)
+	if m == 0 {
+		if m, err = monthTest.verify(month); err != nil {
+			return time.Time{}, err
+		}
+	}
+	d, err := dateTest.verify(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, o.Zone())
+	if t.Day() != d {
+		return time.Time{}, fmt.Errorf("unexpected date %q.  %s-%s has no such day", date, year, month)
+	}
+	if err := o.CheckFuture(baseName, t); err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+var (
+	yearTest  = unitTest{1, 9999, "year"}
+	monthTest = unitTest{1, 12, "month"}
+	dateTest  = unitTest{1, 31, "date"}
+)
+
+type unitTest struct {
+	min  int
+	max  int
+	unit string
+}
+
+func (ut unitTest) verify(s string) (i int, err error) {
+	i, err = strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if i < ut.min || i > ut.max {
+		return 0, fmt.Errorf("unexpected %s %q.  We expect a value between %d and %d", ut.unit, s, ut.min, ut.max)
+	}
+	return i, nil
+}
@@ -0,0 +1,352 @@
+package fileinbox
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFileName(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Normalize = true
+	opts.Aliases = map[string]string{"pacificgas": "pge"}
+	opts.Patterns = []*regexp.Regexp{
+		regexp.MustCompile(`^Scan (?P<dest>\w+) (?P<year>\d{4})-(?P<month>\d\d)-(?P<date>\d\d)`),
+	}
+
+	tests := []struct {
+		name string
+		want *ParsedName
+	}{
+		{"20160825_pge.pdf", &ParsedName{
+			BaseName: "20160825_pge.pdf",
+			Date:     time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local),
+			Dest:     "pge",
+			Ext:      ".pdf",
+		}},
+		{"20160825-2_PacificGas_taxes_2016.pdf", &ParsedName{
+			BaseName:    "20160825-2_PacificGas_taxes_2016.pdf",
+			Date:        time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local),
+			Sequence:    2,
+			Dest:        "pge",
+			Description: "taxes_2016",
+			Tags:        []string{"taxes", "2016"},
+			Ext:         ".pdf",
+		}},
+		{"Scan pge 2016-08-25.jpg", &ParsedName{
+			BaseName: "Scan pge 2016-08-25.jpg",
+			Date:     time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local),
+			Dest:     "pge",
+			Ext:      ".jpg",
+		}},
+	}
+	for _, tc := range tests {
+		got, err := ParseFileName(tc.name, opts)
+		if err != nil {
+			t.Errorf("ParseFileName(%q): unexpected error %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("ParseFileName(%q)\n\texp: %#v\n\tgot: %#v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseFileNameRejects(t *testing.T) {
+	future := time.Now().Year() + 3
+	for _, name := range []string{
+		"pge.pdf",
+		"2016082_pge.pdf",
+		"20161325_pge.pdf",
+		"20160231_pge.pdf",
+		"20160825.pdf",
+		time.Date(future, 1, 1, 0, 0, 0, 0, time.Local).Format("20060102") + "_pge.pdf",
+	} {
+		if _, err := ParseFileName(name, DefaultParseOptions()); err == nil {
+			t.Errorf("ParseFileName(%q): expected an error", name)
+		}
+	}
+
+	opts := DefaultParseOptions()
+	opts.FutureYears = -1
+	name := time.Date(future, 1, 1, 0, 0, 0, 0, time.Local).Format("20060102") + "_pge.pdf"
+	if _, err := ParseFileName(name, opts); err != nil {
+		t.Errorf("ParseFileName(%q) with no future check: unexpected error %v", name, err)
+	}
+}
+
+func TestParseNestedDest(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.DestSeparator = "-"
+	opts.Aliases = map[string]string{"ins": "insurance", "car": "insurance/auto"}
+
+	tests := []struct {
+		name, dest, desc string
+	}{
+		{"20240101_insurance.pdf", "insurance", ""},
+		{"20240101_insurance-auto.pdf", "insurance/auto", ""},
+		{"20240101_insurance-auto_policy.pdf", "insurance/auto", "policy"},
+		{"20240101_insurance-auto-rv_renewal_2024.pdf", "insurance/auto/rv", "renewal_2024"},
+		{"20240101_ins-home.pdf", "insurance/home", ""},
+		{"20240101_car.pdf", "insurance/auto", ""},
+		{"20240101_insurance", "insurance", ""},
+		{"20240101_pge.tar.gz", "pge", "tar"},
+		{"20240101_pge-gas.tar.gz", "pge/gas", "tar"},
+	}
+	for _, tc := range tests {
+		got, err := ParseFileName(tc.name, opts)
+		if err != nil {
+			t.Errorf("ParseFileName(%q): unexpected error %v", tc.name, err)
+			continue
+		}
+		if got.Dest != tc.dest || got.Description != tc.desc {
+			t.Errorf("ParseFileName(%q) = %q, %q, expected %q, %q", tc.name, got.Dest, got.Description, tc.dest, tc.desc)
+		}
+	}
+}
+
+func TestParseDotSeparator(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.DestSeparator = "."
+
+	tests := []struct {
+		name, dest, desc, ext string
+	}{
+		{"20240101_insurance.auto.pdf", "insurance/auto", "", ".pdf"},
+		{"20240101_insurance.auto_policy.pdf", "insurance/auto", "policy", ".pdf"},
+		{"20240101_insurance.pdf", "insurance", "", ".pdf"},
+		{"20240101_pge.tar.gz", "pge/tar", "", ".gz"},
+	}
+	for _, tc := range tests {
+		got, err := ParseFileName(tc.name, opts)
+		if err != nil {
+			t.Errorf("ParseFileName(%q): unexpected error %v", tc.name, err)
+			continue
+		}
+		if got.Dest != tc.dest || got.Description != tc.desc || got.Ext != tc.ext {
+			t.Errorf("ParseFileName(%q) = %q, %q, %q, expected %q, %q, %q", tc.name, got.Dest, got.Description, got.Ext, tc.dest, tc.desc, tc.ext)
+		}
+	}
+}
+
+func TestParseDateOrders(t *testing.T) {
+	tests := []struct {
+		order, name string
+		want        time.Time
+		ambiguous   bool
+		canonical   string
+	}{
+		{"", "20240201_pge.pdf", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local), false, ""},
+		{DateOrderYMD, "20240201_pge.pdf", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local), false, ""},
+		{DateOrderDMY, "25082016_pge.pdf", time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local), false, "20160825_pge.pdf"},
+		{DateOrderMDY, "08252016-2_pge.pdf", time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local), false, "20160825-2_pge.pdf"},
+		{DateOrderDMY, "01022024_pge.pdf", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local), true, "20240201_pge.pdf"},
+		{DateOrderMDY, "01022024_pge.pdf", time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local), true, "20240102_pge.pdf"},
+		{DateOrderDMY, "03032024_pge.pdf", time.Date(2024, 3, 3, 0, 0, 0, 0, time.Local), false, "20240303_pge.pdf"},
+	}
+	for _, tc := range tests {
+		opts := DefaultParseOptions()
+		opts.DateOrder = tc.order
+		got, err := ParseFileName(tc.name, opts)
+		if err != nil {
+			t.Errorf("ParseFileName(%q, %s): unexpected error %v", tc.name, tc.order, err)
+			continue
+		}
+		if !got.Date.Equal(tc.want) || got.Ambiguous != tc.ambiguous || got.CanonicalName != tc.canonical {
+			t.Errorf("ParseFileName(%q, %s) = %v, %v, %q, expected %v, %v, %q", tc.name, tc.order,
+				got.Date, got.Ambiguous, got.CanonicalName, tc.want, tc.ambiguous, tc.canonical)
+		}
+	}
+
+	// a day-first date read month first points at the other order
+	opts := DefaultParseOptions()
+	opts.DateOrder = DateOrderMDY
+	_, err := ParseFileName("25082016_pge.pdf", opts)
+	if err == nil || !strings.Contains(err.Error(), "valid in dmy order") {
+		t.Errorf("expected a hint about dmy order, got %v", err)
+	}
+
+	opts.DateOrder = DateOrderDMY
+	if d, err := ParseDate("25082016_whatever.jpg", opts); err != nil || !d.Equal(time.Date(2016, 8, 25, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("ParseDate day first = %v, %v", d, err)
+	}
+}
+
+func TestCheckFutureClock(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Clock = FixedClock(time.Date(2023, 12, 31, 23, 0, 0, 0, time.Local))
+	if _, err := ParseFileName("20250101_pge.pdf", opts); err != nil {
+		t.Errorf("2025 is within %d years of 2023: %v", DefaultFutureYears, err)
+	}
+	if _, err := ParseFileName("20260101_pge.pdf", opts); err == nil {
+		t.Errorf("expected 2026 to be too far after 2023")
+	}
+}
+
+func TestDestFuture(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Clock = FixedClock(time.Date(2023, 6, 15, 12, 0, 0, 0, time.Local))
+	opts.DestSeparator = "-"
+	opts.DestFuture = map[string]FuturePolicy{
+		"warranty":  {Years: 10},
+		"receipts":  {Never: true},
+		"insurance": {Years: -1},
+	}
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"20300101_warranty.pdf", true},
+		{"20340101_warranty.pdf", false},
+		{"20230615_receipts.pdf", true},
+		{"20230616_receipts.pdf", false},
+		{"20500101_insurance-auto.pdf", true}, // from its parent
+		{"20250101_pge.pdf", true},
+		{"20260101_pge.pdf", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseFileName(tt.name, opts)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected its date to be rejected", tt.name)
+		}
+	}
+}
+
+func TestLayouts(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.Layouts = []Layout{{Pattern: CameraPattern, Dest: "photos"}}
+	for _, name := range []string{"IMG_20240825_123456.jpg", "PXL_20240825_123456789.jpg", "VID_20240825_123456.mp4"} {
+		p, err := ParseFileName(name, opts)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if p.Dest != "photos" || p.Date.Format("20060102") != "20240825" || p.CanonicalName != "" {
+			t.Errorf("%s: unexpected %+v", name, p)
+		}
+	}
+	// names that say their dest come first
+	if p, err := ParseFileName("20240825_pge.jpg", opts); err != nil || p.Dest != "pge" {
+		t.Errorf("expected the default pattern to win, got %+v, %v", p, err)
+	}
+	if _, err := ParseFileName("IMG_20241325_123456.jpg", opts); err == nil {
+		t.Errorf("expected a bad month to be rejected")
+	}
+	if _, err := ParseFileName("IMG_1234.jpg", opts); err == nil {
+		t.Errorf("expected a camera name without a date to be rejected")
+	}
+}
+
+func TestPatternPacks(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      time.Time
+		dest      string
+		canonical string
+	}{
+		{"25Aug2024_pge.pdf", time.Date(2024, 8, 25, 0, 0, 0, 0, time.Local), "pge", "20240825_pge.pdf"},
+		{"3-März-2024_stadtwerke_rechnung.pdf", time.Date(2024, 3, 3, 0, 0, 0, 0, time.Local), "stadtwerke", "20240303_stadtwerke_rechnung.pdf"},
+		{"25.AOÛT.2024-2_edf.pdf", time.Date(2024, 8, 25, 0, 0, 0, 0, time.Local), "edf", "20240825-2_edf.pdf"},
+		{"1 dic 2023_luz.pdf", time.Date(2023, 12, 1, 0, 0, 0, 0, time.Local), "luz", "20231201_luz.pdf"},
+		{"2024年08月25日_pge.pdf", time.Date(2024, 8, 25, 0, 0, 0, 0, time.Local), "pge", "20240825_pge.pdf"},
+		{"2024년8월5일_kepco_bill.pdf", time.Date(2024, 8, 5, 0, 0, 0, 0, time.Local), "kepco", "20240805_kepco_bill.pdf"},
+		{"20240825_pge.pdf", time.Date(2024, 8, 25, 0, 0, 0, 0, time.Local), "pge", ""},
+	}
+	opts := DefaultParseOptions()
+	opts.FutureYears = -1
+	opts.PatternPacks = []string{PackMonthNames, PackCJK}
+	for _, tc := range tests {
+		got, err := ParseFileName(tc.name, opts)
+		if err != nil {
+			t.Errorf("ParseFileName(%q): unexpected error %v", tc.name, err)
+			continue
+		}
+		if !got.Date.Equal(tc.want) || got.Dest != tc.dest || got.CanonicalName != tc.canonical {
+			t.Errorf("ParseFileName(%q) = %v, %q, %q, expected %v, %q, %q", tc.name,
+				got.Date, got.Dest, got.CanonicalName, tc.want, tc.dest, tc.canonical)
+		}
+	}
+
+	if _, err := ParseFileName("25Foo2024_pge.pdf", opts); err == nil {
+		t.Errorf("expected an unknown month name to be rejected")
+	}
+	if _, err := ParseFileName("25Aug2024_pge.pdf", DefaultParseOptions()); err == nil {
+		t.Errorf("expected month names to need a pattern pack")
+	}
+	opts.PatternPacks = []string{"klingon"}
+	if _, err := ParseFileName("20240825_pge.pdf", opts); err == nil {
+		t.Errorf("expected an unknown pattern pack to be rejected")
+	}
+}
+
+func TestParseAlso(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.DestSeparator = "-"
+	opts.Aliases = map[string]string{"hsa": "health/hsa"}
+	p, err := ParseFileName("20240101_insurance-auto+hsa+tax+insurance-auto_paid.pdf", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Dest != "insurance/auto" || p.Description != "paid" || !reflect.DeepEqual([]string{"health/hsa", "tax"}, p.Also) {
+		t.Errorf("unexpected %#v", p)
+	}
+
+	if _, err := ParseFileName("20240101_pge+.pdf", DefaultParseOptions()); err == nil {
+		t.Errorf("expected an empty dest after %s to be rejected", AlsoSeparator)
+	}
+}
+
+func TestStripPrefixes(t *testing.T) {
+	opts := DefaultParseOptions()
+	opts.StripPrefixes = []*regexp.Regexp{regexp.MustCompile(`Scan_`), regexp.MustCompile(`SKM_C\d+_`)}
+	for _, name := range []string{"Scan_20240825_pge.pdf", "SKM_C3350_20240825_pge.pdf"} {
+		p, err := ParseFileName(name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.BaseName != name || p.Dest != "pge" || p.CanonicalName != "20240825_pge.pdf" {
+			t.Errorf("unexpected %#v for %s", p, name)
+		}
+	}
+
+	// only at the start
+	if _, err := ParseFileName("20240825_pge_Scan_2.pdf", opts); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseFileName("MyScan_20240825_pge.pdf", opts); err == nil {
+		t.Error("expected a prefix in the middle of a name to be left alone")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tokyo := time.FixedZone("Tokyo", 9*60*60)
+	// New Year's Day in Tokyo, still New Year's Eve in UTC
+	opts := DefaultParseOptions()
+	opts.Clock = FixedClock(time.Date(2023, 12, 31, 18, 0, 0, 0, time.UTC))
+	opts.Location = tokyo
+	opts.DestFuture = map[string]FuturePolicy{"receipts": {Never: true}}
+
+	p, err := ParseFileName("20240101_receipts.pdf", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Date.Location() != tokyo || p.Date.Year() != 2024 {
+		t.Errorf("expected the date in Tokyo, got %v", p.Date)
+	}
+	if _, err := ParseFileName("20240102_receipts.pdf", opts); err == nil {
+		t.Error("expected the day after today in Tokyo to be rejected")
+	}
+
+	opts.Location = time.UTC
+	if _, err := ParseFileName("20240101_receipts.pdf", opts); err == nil {
+		t.Error("expected New Year's Day to be rejected while it is still New Year's Eve in UTC")
+	}
+	if got := opts.Now().Year(); got != 2023 {
+		t.Errorf("expected 2023 in UTC, got %d", got)
+	}
+}
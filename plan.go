@@ -0,0 +1,624 @@
+package fileinbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Move is one step of a plan: a document leaving an inbox for the
+// archive, copied to a mirror first if CC is set.  Copies are filed
+// under the other dests of a document that belongs to more than one,
+// see AlsoSeparator.  Tags are carried along for whoever records where
+// the document went.
+type Move struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	CC     string   `json:"cc,omitempty"`
+	Copies []string `json:"copies,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+
+	// CCConflict is set when planning if CC already holds a different
+	// document, see CCConflicted.  CCResolve says what Apply does then,
+	// one of the CCResolve values, and may be set in a saved plan for
+	// each move.
+	CCConflict bool   `json:"ccConflict,omitempty"`
+	CCResolve  string `json:"ccResolve,omitempty"`
+}
+
+// What Apply does with a move whose CC already holds a different
+// document, see Move.CCResolve.
+const (
+	CCFail    = ""        // leave the document where it was, failing with ErrCCConflict
+	CCSkip    = "skip"    // file it, leaving the mirror as it is
+	CCReplace = "replace" // replace what is mirrored with it
+	CCVersion = "version" // mirror it as the next version, see NextVersion
+)
+
+// CCConflicted returns true if m.CC is taken by a document that isn't
+// the same as m.From.
+func (m Move) CCConflicted() (bool, error) {
+	if m.CC == "" {
+		return false, nil
+	}
+	if _, err := os.Lstat(m.CC); err != nil {
+		return false, nil
+	}
+	same, err := SameContents(m.From, m.CC)
+	if err != nil {
+		return false, fmt.Errorf("comparing %s with %s: %w", m.From, m.CC, err)
+	}
+	return !same, nil
+}
+
+// Plan is a complete set of operations, in the order they will be
+// carried out.  Plans serialize as JSON, so they can be saved, looked
+// over or edited, and given to Apply later.
+type Plan struct {
+	Moves []Move `json:"moves"`
+}
+
+// ReadPlan reads a plan written as JSON, either a Plan, an object with
+// the moves under "plan" as --dry-run --output json prints, or a bare
+// list of moves.  It also reads CSV with a from,to[,cc[,ccresolve]]
+// header.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return &Plan{}, nil
+	}
+
+	switch trimmed[0] {
+	case '{':
+		var s struct {
+			Moves []Move `json:"moves"`
+			Plan  []Move `json:"plan"`
+		}
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return nil, fmt.Errorf("parsing json plan: %w", err)
+		}
+		if s.Moves == nil {
+			s.Moves = s.Plan
+		}
+		return &Plan{Moves: s.Moves}, nil
+	case '[':
+		var moves []Move
+		if err := json.Unmarshal(trimmed, &moves); err != nil {
+			return nil, fmt.Errorf("parsing json plan: %w", err)
+		}
+		return &Plan{Moves: moves}, nil
+	}
+	return readCSVPlan(bytes.NewReader(trimmed))
+}
+
+func readCSVPlan(r io.Reader) (*Plan, error) {
+	records, err := csv.NewReader(bufio.NewReader(r)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing csv plan: %w", err)
+	}
+	plan := &Plan{}
+	if len(records) == 0 {
+		return plan, nil
+	}
+	cols := map[string]int{}
+	for i, h := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	fromCol, hasFrom := cols["from"]
+	toCol, hasTo := cols["to"]
+	if !hasFrom || !hasTo {
+		return nil, errors.New("csv plan must have a header with from and to columns")
+	}
+	ccCol, hasCC := cols["cc"]
+	resolveCol, hasResolve := cols["ccresolve"]
+
+	for _, rec := range records[1:] {
+		m := Move{From: rec[fromCol], To: rec[toCol]}
+		if hasCC {
+			m.CC = rec[ccCol]
+		}
+		if hasResolve {
+			m.CCResolve = strings.TrimSpace(rec[resolveCol])
+		}
+		plan.Moves = append(plan.Moves, m)
+	}
+	return plan, nil
+}
+
+// Check makes sure every move takes a document straight out of one of
+// inboxes and files it under filed, and every copy goes under one of
+// ccRoots, so a plan from elsewhere can't be used to move files
+// anywhere at all.
+func (p *Plan) Check(filed string, inboxes, ccRoots []string) error {
+	for _, m := range p.Moves {
+		if m.From == "" || m.To == "" {
+			return fmt.Errorf("move %+v is missing from or to", m)
+		}
+		if !inAny(m.From, inboxes) {
+			return fmt.Errorf("%s is not in an inbox", m.From)
+		}
+		if !under(m.To, filed) {
+			return fmt.Errorf("%s is not under %s", m.To, filed)
+		}
+		if m.CC != "" && !underAny(m.CC, ccRoots) {
+			return fmt.Errorf("%s is not under a CC root", m.CC)
+		}
+		switch m.CCResolve {
+		case CCFail, CCSkip, CCReplace, CCVersion:
+		default:
+			return fmt.Errorf("%s has an unknown ccResolve %q", m.From, m.CCResolve)
+		}
+		for _, c := range m.Copies {
+			if !under(c, filed) {
+				return fmt.Errorf("%s is not under %s", c, filed)
+			}
+		}
+	}
+	return nil
+}
+
+// inAny returns true if name is directly in one of dirs.
+func inAny(name string, dirs []string) bool {
+	parent := filepath.Dir(filepath.Clean(name))
+	for _, dir := range dirs {
+		if dir != "" && parent == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func underAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if dir != "" && under(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func under(name, dir string) bool {
+	return strings.HasPrefix(filepath.Clean(name), filepath.Clean(dir)+string(filepath.Separator))
+}
+
+// ApplyOptions controls how a plan is carried out.
+type ApplyOptions struct {
+	// DirMode is given to the directories we create, zero meaning 0777
+	// less the umask.
+	DirMode os.FileMode
+
+	// FileMode is given to filed documents and their copies.  Zero
+	// leaves them with the mode they had in the inbox.
+	FileMode os.FileMode
+
+	// Group, unless zero, is the group id given to the directories Apply
+	// creates and the documents it files, for an archive shared by a
+	// household.
+	Group int
+
+	// WorkDir, when set, is where a document copied across devices is
+	// put together before it is filed, as MoveFileVia does.
+	WorkDir string
+
+	// Before, when set, is called before each move, ahead of creating
+	// the directories it needs and making its Copies.  An error skips the
+	// move.  After is called once the document is in place, and an
+	// error counts as a failure even though the document was filed.
+	Before func(m Move) error
+	After  func(m Move) error
+
+	// Report, when set, is told how each move went, and whether the
+	// document was filed, which it can be even with an error, if After
+	// failed.
+	Report func(i int, m Move, filed bool, err error)
+
+	// Halt, when set, is asked before each move whether to carry on, e.g.
+	// whether the archive is still mounted.  An error stops Apply there,
+	// leaving what is left where it was, and is returned as
+	// Result.Halted.
+	Halt func() error
+
+	// Retries is how many more times to try a move that failed with a
+	// transient error, such as a NAS that is briefly offline.  Retries
+	// wait until everything else has been tried, then Backoff, doubling
+	// each round up to MaxBackoff.  Retrying, when set, is told about
+	// each move that will be tried again.
+	Retries  int
+	Backoff  time.Duration
+	Retrying func(i int, m Move, err error)
+
+	// KeepDuplicates leaves a document in its inbox when the same
+	// contents are already filed under its name.  Otherwise the inbox
+	// copy is removed.  Either way it is reported with ErrDuplicate.
+	KeepDuplicates bool
+
+	// Versioned files a document whose name is taken by a different
+	// document as the next version, e.g. 20240101_pge.v2.pdf, rather than
+	// failing with ErrConflict.  See VersionName.  One with the same
+	// contents as any version is a duplicate.
+	Versioned bool
+}
+
+// ErrDuplicate is reported for a document already filed, with the same
+// contents, under the name it would be filed as.
+var ErrDuplicate = errors.New("already filed with the same contents")
+
+// ErrConflict is reported for a document whose name is taken by a
+// different filed document.  It is left where it was.  Usually one of
+// them has the wrong date.
+var ErrConflict = errors.New("a different document is already filed under this name")
+
+// ErrCCConflict is reported for a document whose CC is taken by a
+// different document, unless Move.CCResolve says otherwise.  It is left
+// where it was.
+var ErrCCConflict = errors.New("a different document is already mirrored to CC under this name")
+
+// IsDuplicate returns true if err is, or wraps, ErrDuplicate.
+func IsDuplicate(err error) bool {
+	return errors.Is(err, ErrDuplicate)
+}
+
+// IsConflict returns true if err is, or wraps, ErrConflict.
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsCCConflict returns true if err is, or wraps, ErrCCConflict.
+func IsCCConflict(err error) bool {
+	return errors.Is(err, ErrCCConflict)
+}
+
+// MaxBackoff is the longest Apply waits between rounds of retries.
+const MaxBackoff = time.Minute
+
+// Result totals up what Apply did.
+type Result struct {
+	Moved        int
+	Failed       int   // left where it was, as it couldn't be filed
+	Unlocked     int   // the part of Moved whose After failed, e.g. to lock it
+	Retried      int   // the part of Moved that only worked when retried
+	Skipped      int   // left where it was
+	Duplicates   int   // already filed, see ErrDuplicate
+	Conflicts    int   // the part of Failed with ErrConflict
+	Versions     int   // the part of Moved filed as a new version
+	Copies       int   // filed under other dests, see Move.Copies
+	MovedBytes   int64 // everything filed
+	CopiedBytes  int64 // the part of MovedBytes that had to be copied across devices
+	CCBytes      int64 // mirrored to CC
+	SkippedBytes int64 // left where it was
+
+	// Halted is why Apply stopped early, see ApplyOptions.Halt.
+	Halted error
+}
+
+// Apply carries out the moves in order, creating directories as needed.
+// A failed move is reported and counted, and the rest go ahead.  Moves
+// that failed with transient errors are retried at the end.
+func (p *Plan) Apply(opts ApplyOptions) Result {
+	var r Result
+	var queue []*pending
+	for i, m := range p.Moves {
+		queue = append(queue, &pending{i: i, m: m})
+	}
+	backoff := opts.Backoff
+	for round := 0; len(queue) != 0; round++ {
+		if round != 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > MaxBackoff {
+				backoff = MaxBackoff
+			}
+		}
+		var retry []*pending
+		for j, pm := range queue {
+			if opts.Halt != nil {
+				if r.Halted = opts.Halt(); r.Halted != nil {
+					// what was waiting to be retried is left too
+					r.skip(append(retry, queue[j:]...))
+					return r
+				}
+			}
+			size, filed, err := opts.apply(pm, &r)
+			if errors.Is(err, ErrDuplicate) {
+				r.Duplicates++
+				if opts.KeepDuplicates {
+					r.Skipped++
+					r.SkippedBytes += size
+				}
+				if opts.Report != nil {
+					opts.Report(pm.i, pm.m, false, err)
+				}
+				continue
+			}
+			if errors.Is(err, ErrConflict) {
+				r.Conflicts++
+			}
+			if !filed && err != nil && round < opts.Retries && IsTransient(err) {
+				if opts.Retrying != nil {
+					opts.Retrying(pm.i, pm.m, err)
+				}
+				retry = append(retry, pm)
+				continue
+			}
+			if filed {
+				r.Moved++
+				r.MovedBytes += size
+				if pm.versioned {
+					r.Versions++
+				}
+				if round != 0 {
+					r.Retried++
+				}
+			} else {
+				r.Skipped++
+				r.SkippedBytes += size
+			}
+			switch {
+			case err != nil && filed:
+				r.Unlocked++
+			case err != nil:
+				r.Failed++
+			}
+			if opts.Report != nil {
+				opts.Report(pm.i, pm.m, filed, err)
+			}
+		}
+		queue = retry
+	}
+	return r
+}
+
+// skip counts queue as left where it was.
+func (r *Result) skip(queue []*pending) {
+	for _, pm := range queue {
+		r.Skipped++
+		if fi, err := os.Stat(pm.m.From); err == nil {
+			r.SkippedBytes += fi.Size()
+		}
+	}
+}
+
+// pending is a move that is yet to be done, and how far it got.
+type pending struct {
+	i      int
+	m      Move
+	ccDone bool // the copy to CC was made, and must not be made again
+	copies int  // how many of Copies were made
+	// versioned is set once the move is to a new version
+	versioned bool
+}
+
+// apply carries out a single move, returning the size of the document
+// and whether it was filed.  It can be filed and still fail, if After
+// does.
+func (o ApplyOptions) apply(pm *pending, r *Result) (size int64, filed bool, err error) {
+	m := pm.m
+	fi, err := os.Stat(m.From)
+	if err != nil {
+		return 0, false, err
+	}
+	size = fi.Size()
+
+	// a rename would quietly replace whatever is there
+	if _, statErr := os.Lstat(m.To); statErr == nil {
+		same, err := SameContents(m.From, m.To)
+		if err != nil {
+			return size, false, fmt.Errorf("comparing %s with %s: %w", m.From, m.To, err)
+		}
+		if !same && o.Versioned {
+			next, dup, err := NextVersion(m.From, m.To)
+			if err != nil {
+				return size, false, fmt.Errorf("finding the versions of %s: %w", m.To, err)
+			}
+			// from here on, this is the move, as reported
+			m.To = next
+			if m.CC != "" {
+				m.CC = path.Join(path.Dir(m.CC), path.Base(next))
+			}
+			copies := make([]string, len(m.Copies))
+			for i, c := range m.Copies {
+				copies[i] = path.Join(path.Dir(c), path.Base(next))
+			}
+			m.Copies = copies
+			pm.m, pm.versioned, same = m, !dup, dup
+		}
+		switch {
+		case pm.versioned:
+			// filed below, as a new version
+		case !same:
+			return size, false, fmt.Errorf("%s: %w", m.To, ErrConflict)
+		default:
+			if !o.KeepDuplicates {
+				if err = os.Remove(m.From); err != nil {
+					return size, false, err
+				}
+			}
+			return size, false, fmt.Errorf("%s: %w", m.To, ErrDuplicate)
+		}
+	}
+
+	if m.CC != "" && !pm.ccDone {
+		cc, n, err := o.mirror(m)
+		if err != nil {
+			return size, false, err
+		}
+		// from here on, the mirror is where it went, as reported
+		m.CC, pm.m.CC = cc, cc
+		r.CCBytes += n
+		pm.ccDone = true
+	}
+
+	// Before goes first, as it may need to make room for the
+	// directories, e.g. by unlocking their parent
+	if o.Before != nil {
+		if err = o.Before(m); err != nil {
+			return size, false, err
+		}
+	}
+	for ; pm.copies < len(m.Copies); pm.copies++ {
+		if err = o.copyTo(m.From, m.Copies[pm.copies]); err != nil {
+			return size, false, err
+		}
+		r.Copies++
+	}
+	if err = MkdirAllGroup(path.Dir(m.To), o.DirMode, o.Group); err != nil {
+		return size, false, fmt.Errorf("creating %s: %w", path.Dir(m.To), err)
+	}
+	var copied int64
+	if o.WorkDir != "" {
+		copied, err = MoveFileVia(m.From, m.To, o.WorkDir)
+	} else {
+		copied, err = MoveFile(m.From, m.To)
+	}
+	r.CopiedBytes += copied
+	if err != nil {
+		return size, false, fmt.Errorf("moving %s to %s: %w", m.From, m.To, err)
+	}
+	if err = o.fix(m.To); err == nil && o.After != nil {
+		err = o.After(m)
+	}
+	return size, true, err
+}
+
+// mirror copies m.From to m.CC, returning where the copy went, or ""
+// for none, and how much was copied.  When CC is taken, the same
+// document is left be, while a different one is dealt with as
+// m.CCResolve says.
+func (o ApplyOptions) mirror(m Move) (cc string, n int64, err error) {
+	cc = m.CC
+	replace := false
+	if conflict, err := m.CCConflicted(); err != nil {
+		return cc, 0, err
+	} else if conflict {
+		switch m.CCResolve {
+		case CCSkip:
+			return "", 0, nil
+		case CCReplace:
+			replace = true
+		case CCVersion:
+			next, dup, err := NextVersion(m.From, m.CC)
+			if err != nil {
+				return cc, 0, fmt.Errorf("finding the versions of %s: %w", m.CC, err)
+			}
+			if dup {
+				return next, 0, nil
+			}
+			cc = next
+		default:
+			return cc, 0, fmt.Errorf("%s: %w", m.CC, ErrCCConflict)
+		}
+	} else if _, err := os.Lstat(m.CC); err == nil {
+		// already mirrored, say by a run that stopped part way
+		return cc, 0, nil
+	}
+
+	if err = MkdirAllGroup(path.Dir(cc), o.DirMode, o.Group); err != nil {
+		return cc, 0, fmt.Errorf("creating %s: %w", path.Dir(cc), err)
+	}
+	// a replacement is made alongside, then renamed over what is there
+	to := cc
+	if replace {
+		to = path.Join(path.Dir(cc), "."+path.Base(cc)+".fileinbox")
+		os.Remove(to)
+	}
+	n, err = CopyFile(m.From, to)
+	if err == nil {
+		err = o.fix(to)
+	}
+	if err == nil && replace {
+		err = os.Rename(to, cc)
+	}
+	if err != nil {
+		// don't leave half a copy behind to trip up a retry
+		if !os.IsExist(err) {
+			os.Remove(to)
+		}
+		return cc, n, fmt.Errorf("copying %s to %s: %w", m.From, cc, err)
+	}
+	return cc, n, nil
+}
+
+// copyTo files a copy of from as name, under another of its dests.  A
+// copy already there with the same contents is left be.
+func (o ApplyOptions) copyTo(from, name string) error {
+	if _, err := os.Lstat(name); err == nil {
+		same, err := SameContents(from, name)
+		if err != nil {
+			return fmt.Errorf("comparing %s with %s: %w", from, name, err)
+		}
+		if !same {
+			return fmt.Errorf("%s: %w", name, ErrConflict)
+		}
+		return nil
+	}
+	if err := MkdirAllGroup(path.Dir(name), o.DirMode, o.Group); err != nil {
+		return fmt.Errorf("creating %s: %w", path.Dir(name), err)
+	}
+	_, err := CopyFile(from, name)
+	if err == nil {
+		err = o.fix(name)
+	}
+	if err != nil {
+		if !os.IsExist(err) {
+			os.Remove(name)
+		}
+		return fmt.Errorf("copying %s to %s: %w", from, name, err)
+	}
+	return nil
+}
+
+func (o ApplyOptions) fix(name string) error {
+	if o.Group != 0 {
+		if err := os.Lchown(name, -1, o.Group); err != nil {
+			return err
+		}
+	}
+	if o.FileMode != 0 {
+		return os.Chmod(name, o.FileMode)
+	}
+	return nil
+}
+
+// transient are the errors that may go away if we wait a little: a
+// network filesystem that has gone away, or a file someone else is
+// using.
+var transient = []error{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.ETXTBSY,
+	syscall.EINTR,
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+	syscall.ECONNABORTED,
+	syscall.ECONNRESET,
+	syscall.EHOSTDOWN,
+	syscall.EHOSTUNREACH,
+	syscall.ENETDOWN,
+	syscall.ENETUNREACH,
+}
+
+// IsTransient returns true if err may go away if the operation is
+// tried again later.
+func IsTransient(err error) bool {
+	for _, t := range transient {
+		if errors.Is(err, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,516 @@
+package fileinbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	inbox, filed := path.Join(root, "inbox"), path.Join(root, "filed")
+	if err := os.MkdirAll(inbox, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"20160825_pge.pdf", "20150101_bank_statement.pdf"} {
+		if err := ioutil.WriteFile(path.Join(inbox, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []Move{
+		{From: path.Join(inbox, "20150101_bank_statement.pdf"), To: path.Join(filed, "bank/2015/20150101_bank_statement.pdf")},
+		{From: path.Join(inbox, "20160825_pge.pdf"), To: path.Join(filed, "pge/2016/20160825_pge.pdf")},
+	}
+	plan := &Plan{Moves: want}
+
+	// plans survive a round trip through JSON
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPlan(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan, read) {
+		t.Fatalf("ReadPlan\n\texp: %#v\n\tgot: %#v", plan, read)
+	}
+	if err := read.Check(filed, []string{inbox}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []int
+	r := read.Apply(ApplyOptions{
+		DirMode: 0750,
+		Report: func(i int, m Move, filed bool, err error) {
+			if err != nil {
+				t.Errorf("move %d: %v", i, err)
+			}
+			reported = append(reported, i)
+		},
+	})
+	if r.Moved != 2 || r.Failed != 0 || r.MovedBytes != int64(len("20160825_pge.pdf")+len("20150101_bank_statement.pdf")) {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if !reflect.DeepEqual([]int{0, 1}, reported) {
+		t.Errorf("expected both moves reported, got %v", reported)
+	}
+	for _, m := range want {
+		if _, err := os.Stat(m.To); err != nil {
+			t.Errorf("expected %s to be filed: %v", m.To, err)
+		}
+	}
+	if fi, err := os.Stat(path.Join(filed, "pge")); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("expected filed/pge to be created 0750, got %v, %v", fi.Mode(), err)
+	}
+
+	// applying again finds nothing to move
+	r = read.Apply(ApplyOptions{})
+	if r.Moved != 0 || r.Failed != 2 || r.Skipped != 2 {
+		t.Errorf("unexpected result applying twice %+v", r)
+	}
+}
+
+func TestReadPlanFormats(t *testing.T) {
+	want := &Plan{Moves: []Move{{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/m/a/2016/a.pdf"}}}
+	for _, in := range []string{
+		`{"moves": [{"from": "/r/inbox/a.pdf", "to": "/r/filed/a/2016/a.pdf", "cc": "/m/a/2016/a.pdf"}]}`,
+		`{"moved": 0, "plan": [{"from": "/r/inbox/a.pdf", "to": "/r/filed/a/2016/a.pdf", "cc": "/m/a/2016/a.pdf"}]}`,
+		`[{"from": "/r/inbox/a.pdf", "to": "/r/filed/a/2016/a.pdf", "cc": "/m/a/2016/a.pdf"}]`,
+		"from,to,cc\n/r/inbox/a.pdf,/r/filed/a/2016/a.pdf,/m/a/2016/a.pdf\n",
+	} {
+		got, err := ReadPlan(strings.NewReader(in))
+		if err != nil {
+			t.Errorf("ReadPlan(%q): unexpected error %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("ReadPlan(%q)\n\texp: %#v\n\tgot: %#v", in, want, got)
+		}
+	}
+	got, err := ReadPlan(strings.NewReader("from,to,cc,ccresolve\n/r/inbox/a.pdf,/r/filed/a/2016/a.pdf,/m/a/2016/a.pdf,version\n"))
+	if err != nil || len(got.Moves) != 1 || got.Moves[0].CCResolve != CCVersion {
+		t.Errorf("expected a csv plan to say how to resolve CC conflicts, got %+v, %v", got, err)
+	}
+	if _, err := ReadPlan(strings.NewReader("a,b\n1,2\n")); err == nil {
+		t.Errorf("expected an error for a csv plan without from and to")
+	}
+}
+
+func TestPlanCheck(t *testing.T) {
+	for _, tc := range []struct {
+		m  Move
+		ok bool
+	}{
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf"}, true},
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/m/a.pdf"}, true},
+		{Move{From: "/r/inbox/a.pdf", To: "/etc/passwd"}, false},
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/../../etc/passwd"}, false},
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/etc/passwd"}, false},
+		{Move{To: "/r/filed/a/2016/a.pdf"}, false},
+		{Move{From: "/home/me/.ssh/id_rsa", To: "/r/filed/a/2016/a.pdf"}, false},
+		{Move{From: "/r/inbox/../../etc/passwd", To: "/r/filed/a/2016/a.pdf"}, false},
+		{Move{From: "/r/inbox/sub/a.pdf", To: "/r/filed/a/2016/a.pdf"}, false},
+		{Move{From: "/scans/a.pdf", To: "/r/filed/a/2016/a.pdf"}, true},
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/m/a.pdf", CCResolve: CCVersion}, true},
+		{Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/m/a.pdf", CCResolve: "overwrite"}, false},
+	} {
+		err := (&Plan{Moves: []Move{tc.m}}).Check("/r/filed", []string{"/r/inbox", "/scans/"}, []string{"/m"})
+		if (err == nil) != tc.ok {
+			t.Errorf("Check(%+v) = %v", tc.m, err)
+		}
+	}
+
+	// copies may go under any of the roots
+	m := Move{From: "/r/inbox/a.pdf", To: "/r/filed/a/2016/a.pdf", CC: "/enc/a/2016/a.pdf"}
+	if err := (&Plan{Moves: []Move{m}}).Check("/r/filed", []string{"/r/inbox"}, []string{"/m", "/enc"}); err != nil {
+		t.Errorf("Check(%+v) with two roots = %v", m, err)
+	}
+	if err := (&Plan{Moves: []Move{m}}).Check("/r/filed", []string{"/r/inbox"}, nil); err == nil {
+		t.Errorf("Check(%+v) without roots should fail", m)
+	}
+}
+
+func TestApplyRetries(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	var moves []Move
+	for _, name := range []string{"20160825_pge.pdf", "20160826_pge.pdf", "20160827_pge.pdf"} {
+		from := path.Join(root, "inbox", name)
+		if err := os.MkdirAll(path.Dir(from), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(from, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		moves = append(moves, Move{From: from, To: path.Join(root, "filed/pge/2016", name), CC: path.Join(root, "cc/pge/2016", name)})
+	}
+	plan := &Plan{Moves: moves}
+
+	// the first move is busy once, the second is busy for good and the
+	// third fails in a way retrying won't help
+	failures := map[string]int{moves[0].From: 1, moves[1].From: 100}
+	var retrying []int
+	r := plan.Apply(ApplyOptions{
+		Retries: 2,
+		Backoff: time.Millisecond,
+		Before: func(m Move) error {
+			if m.From == moves[2].From {
+				return os.ErrPermission
+			}
+			if failures[m.From] > 0 {
+				failures[m.From]--
+				return &os.PathError{Op: "rename", Path: m.From, Err: syscall.EBUSY}
+			}
+			return nil
+		},
+		Retrying: func(i int, m Move, err error) {
+			retrying = append(retrying, i)
+		},
+	})
+	if r.Moved != 1 || r.Retried != 1 || r.Failed != 2 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if !reflect.DeepEqual([]int{0, 1, 1}, retrying) {
+		t.Errorf("expected moves 0 and 1 to be retried, got %v", retrying)
+	}
+	if _, err := os.Stat(moves[0].To); err != nil {
+		t.Errorf("expected %s to be filed on retry: %v", moves[0].To, err)
+	}
+	if r.CCBytes != int64(3*len("20160825_pge.pdf")) {
+		t.Errorf("expected each document copied to CC once, got %d bytes", r.CCBytes)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	if !IsTransient(fmt.Errorf("moving: %w", &os.PathError{Op: "rename", Path: "a", Err: syscall.ESTALE})) {
+		t.Errorf("expected ESTALE to be transient")
+	}
+	if IsTransient(&os.PathError{Op: "rename", Path: "a", Err: syscall.ENOENT}) {
+		t.Errorf("expected ENOENT not to be transient")
+	}
+}
+
+func TestApplyCollisions(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	write := func(name, contents string) string {
+		p := path.Join(root, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+
+	dup := write("inbox/20160825_pge.pdf", "bill")
+	dupTo := write("filed/pge/2016/20160825_pge.pdf", "bill")
+	other := write("inbox/20160901_pge.pdf", "a different bill")
+	otherTo := write("filed/pge/2016/20160901_pge.pdf", "bill")
+	plan := &Plan{Moves: []Move{{From: dup, To: dupTo}, {From: other, To: otherTo}}}
+
+	var errs []error
+	r := plan.Apply(ApplyOptions{KeepDuplicates: true, Report: func(i int, m Move, filed bool, err error) { errs = append(errs, err) }})
+	if r.Duplicates != 1 || r.Conflicts != 1 || r.Failed != 1 || r.Moved != 0 || r.Skipped != 2 {
+		t.Errorf("unexpected result keeping duplicates %+v", r)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrDuplicate) || !errors.Is(errs[1], ErrConflict) {
+		t.Errorf("expected a duplicate and a conflict, got %v", errs)
+	}
+	if !exists(dup) || !exists(other) {
+		t.Errorf("expected both to be left in the inbox")
+	}
+	if b, _ := ioutil.ReadFile(otherTo); string(b) != "bill" {
+		t.Errorf("expected the filed document to be left alone, found %q", b)
+	}
+
+	r = plan.Apply(ApplyOptions{})
+	if r.Duplicates != 1 || r.Conflicts != 1 || r.Skipped != 1 {
+		t.Errorf("unexpected result dropping duplicates %+v", r)
+	}
+	if exists(dup) || !exists(dupTo) || !exists(other) {
+		t.Errorf("expected only the duplicate to be removed from the inbox")
+	}
+
+	// a reissued document is filed alongside the first, and a copy of
+	// either version is a duplicate
+	again := write("inbox/20160901_pge_again.pdf", "a different bill")
+	third := write("inbox/20160901_pge_third.pdf", "a third bill")
+	plan = &Plan{Moves: []Move{{From: other, To: otherTo}, {From: again, To: otherTo}, {From: third, To: otherTo}}}
+	var filed []string
+	r = plan.Apply(ApplyOptions{Versioned: true, Report: func(i int, m Move, moved bool, err error) { filed = append(filed, m.To) }})
+	if r.Moved != 2 || r.Versions != 2 || r.Duplicates != 1 || r.Conflicts != 0 {
+		t.Errorf("unexpected result filing versions %+v", r)
+	}
+	v2, v3 := path.Join(root, "filed/pge/2016/20160901_pge.v2.pdf"), path.Join(root, "filed/pge/2016/20160901_pge.v3.pdf")
+	if !reflect.DeepEqual([]string{v2, v2, v3}, filed) {
+		t.Errorf("expected versions 2 and 3 to be filed, got %v", filed)
+	}
+	versions, err := Versions(otherTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{otherTo, v2, v3}, versions) {
+		t.Errorf("unexpected versions %v", versions)
+	}
+}
+
+func TestApplyCopies(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	from := path.Join(root, "inbox/20240101_insurance+hsa.pdf")
+	if err := os.MkdirAll(path.Dir(from), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(from, []byte("claim"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	to := path.Join(root, "filed/insurance/2024/20240101_insurance+hsa.pdf")
+	also := path.Join(root, "filed/hsa/2024/20240101_insurance+hsa.pdf")
+	plan := &Plan{Moves: []Move{{From: from, To: to, Copies: []string{also}}}}
+	if err := plan.Check(path.Join(root, "filed"), []string{path.Join(root, "inbox")}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := plan.Apply(ApplyOptions{})
+	if r.Moved != 1 || r.Copies != 1 || r.Failed != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	for _, name := range []string{to, also} {
+		if b, err := ioutil.ReadFile(name); err != nil || string(b) != "claim" {
+			t.Errorf("expected %s to be filed, got %q, %v", name, b, err)
+		}
+	}
+
+	bad := &Plan{Moves: []Move{{From: from, To: to, Copies: []string{path.Join(root, "elsewhere/x.pdf")}}}}
+	if err := bad.Check(path.Join(root, "filed"), []string{path.Join(root, "inbox")}, nil); err == nil {
+		t.Errorf("expected a copy outside of filed to be rejected")
+	}
+}
+
+func TestApplyCCConflicts(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	write := func(name, contents string) string {
+		p := path.Join(root, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	read := func(name string) string {
+		b, _ := ioutil.ReadFile(name)
+		return string(b)
+	}
+
+	var moves []Move
+	for _, name := range []string{"same", "fail", "skip", "replace", "version"} {
+		base := "20160825_" + name + ".pdf"
+		from := write("inbox/"+base, "bill")
+		cc := write("mirror/2016/"+base, "an older bill")
+		if name == "same" {
+			write("mirror/2016/"+base, "bill")
+		}
+		m := Move{From: from, To: path.Join(root, "filed/2016", base), CC: cc}
+		conflict, err := m.CCConflicted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conflict != (name != "same") {
+			t.Errorf("%s: expected a conflict %v, got %v", name, name != "same", conflict)
+		}
+		if name != "same" && name != "fail" {
+			m.CCResolve = name
+		}
+		moves = append(moves, m)
+	}
+
+	reported := map[string]Move{}
+	var errs []error
+	r := (&Plan{Moves: moves}).Apply(ApplyOptions{Report: func(i int, m Move, filed bool, err error) {
+		reported[path.Base(m.From)] = m
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}})
+	if r.Moved != 4 || r.Failed != 1 || r.CCBytes != int64(2*len("bill")) {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if len(errs) != 1 || !IsCCConflict(errs[0]) {
+		t.Errorf("expected one CC conflict, got %v", errs)
+	}
+	if _, err := os.Stat(moves[1].From); err != nil {
+		t.Errorf("expected the conflicting document to be left in the inbox, got %v", err)
+	}
+	if got := read(moves[2].CC); got != "an older bill" || reported["20160825_skip.pdf"].CC != "" {
+		t.Errorf("expected the mirror to be left be when skipping, got %q", got)
+	}
+	if got := read(moves[3].CC); got != "bill" {
+		t.Errorf("expected the mirror to be replaced, got %q", got)
+	}
+	v2 := path.Join(root, "mirror/2016/20160825_version.v2.pdf")
+	if got := read(v2); got != "bill" || reported["20160825_version.pdf"].CC != v2 {
+		t.Errorf("expected the mirror to get a new version, got %q as %s", got, reported["20160825_version.pdf"].CC)
+	}
+	if left, _ := ioutil.ReadDir(path.Join(root, "mirror/2016")); len(left) != 6 {
+		t.Errorf("expected nothing but the mirrored documents, got %d entries", len(left))
+	}
+}
+
+func TestApplyHalt(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	var moves []Move
+	for _, name := range []string{"20160825_pge.pdf", "20160925_pge.pdf", "20161025_pge.pdf"} {
+		from := path.Join(root, "inbox", name)
+		if err := os.MkdirAll(path.Dir(from), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(from, []byte("bill"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		moves = append(moves, Move{From: from, To: path.Join(root, "filed/pge/2016", name)})
+	}
+
+	// the archive goes away after the first move
+	gone := errors.New("gone")
+	calls := 0
+	r := (&Plan{Moves: moves}).Apply(ApplyOptions{Halt: func() error {
+		if calls++; calls > 1 {
+			return gone
+		}
+		return nil
+	}})
+	if r.Halted != gone || r.Moved != 1 || r.Skipped != 2 || r.SkippedBytes != 8 || r.Failed != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	for _, m := range moves[1:] {
+		if _, err := os.Stat(m.From); err != nil {
+			t.Errorf("expected %s to be left in the inbox: %v", m.From, err)
+		}
+	}
+
+	// what is waiting to be retried is left where it was too
+	calls = 0
+	r = (&Plan{Moves: moves[1:]}).Apply(ApplyOptions{
+		Retries: 1,
+		Backoff: time.Millisecond,
+		Before: func(m Move) error {
+			return &os.PathError{Op: "rename", Path: m.From, Err: syscall.EBUSY}
+		},
+		Halt: func() error {
+			if calls++; calls > 1 {
+				return gone
+			}
+			return nil
+		},
+	})
+	if r.Halted != gone || r.Moved != 0 || r.Skipped != 2 || r.SkippedBytes != 8 || r.Failed != 0 {
+		t.Errorf("unexpected result with a retry pending %+v", r)
+	}
+}
+
+func TestApplyAfterFails(t *testing.T) {
+	root, err := ioutil.TempDir("", "file_inbox_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if !t.Failed() {
+			// if the test failed, we leave this around for forensics
+			os.RemoveAll(root)
+		}
+	}()
+	from := path.Join(root, "inbox/20160825_pge.pdf")
+	if err := os.MkdirAll(path.Dir(from), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(from, []byte("bill"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	to := path.Join(root, "filed/pge/2016/20160825_pge.pdf")
+
+	// filed, but not locked, is neither a failure nor left behind
+	var reported []bool
+	r := (&Plan{Moves: []Move{{From: from, To: to}}}).Apply(ApplyOptions{
+		After:  func(m Move) error { return os.ErrPermission },
+		Report: func(i int, m Move, filed bool, err error) { reported = append(reported, filed) },
+	})
+	if r.Moved != 1 || r.Unlocked != 1 || r.Failed != 0 || r.Skipped != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if !reflect.DeepEqual([]bool{true}, reported) {
+		t.Errorf("expected it to be reported as filed, got %v", reported)
+	}
+	if _, err := os.Stat(to); err != nil {
+		t.Errorf("expected %s to be filed: %v", to, err)
+	}
+}
@@ -0,0 +1,96 @@
+package fileinbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// partialPrefix starts the names of partial copies of fromName.
+func partialPrefix(fromName string) string {
+	sum := sha256.Sum256([]byte(fromName))
+	return hex.EncodeToString(sum[:8])
+}
+
+// partialName is where a copy of the file fromName, with info fi, is put
+// together in work.  It changes with the size and modification time of
+// the file, so a copy is only picked up again from the same version.
+func partialName(work, fromName string, fi os.FileInfo) string {
+	return path.Join(work, fmt.Sprintf("%s-%d-%d.part", partialPrefix(fromName), fi.Size(), fi.ModTime().UnixNano()))
+}
+
+// resumeCopy copies fromName into work, picking up where an earlier copy
+// of it that was cut short, say by a NAS that went away, left off.  It
+// returns the complete copy, and the number of bytes copied this time.
+// What was copied is kept when it fails, for next time.  Partial copies
+// of earlier versions of fromName are removed.
+func resumeCopy(fromName, work string) (staged string, copied int64, err error) {
+	var from, to *os.File
+	defer func() {
+		if from != nil {
+			from.Close()
+		}
+		if to != nil {
+			closeError := to.Close()
+			if err == nil {
+				err = closeError
+			}
+		}
+	}()
+
+	from, err = os.Open(fromName)
+	if err != nil {
+		return "", 0, err
+	}
+	fi, err := from.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	staged = partialName(work, fromName, fi)
+	stale, _ := filepath.Glob(path.Join(work, partialPrefix(fromName)+"-*.part"))
+	for _, s := range stale {
+		if s != staged {
+			os.Remove(s)
+		}
+	}
+
+	// a copy that was finished but not yet linked has the mode of
+	// fromName, which may not let us write
+	os.Chmod(staged, 0600)
+	to, err = os.OpenFile(staged, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return "", 0, err
+	}
+	have, err := to.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+	if have > fi.Size() {
+		// not a copy of this file after all
+		if err = to.Truncate(0); err != nil {
+			return "", 0, err
+		}
+		have = 0
+	}
+	if _, err = to.Seek(have, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	if _, err = from.Seek(have, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	if copied, err = io.Copy(to, from); err != nil {
+		return "", copied, err
+	}
+	if err = to.Sync(); err != nil {
+		return "", copied, err
+	}
+	// the umask may have taken some of the mode away
+	if err = to.Chmod(fi.Mode().Perm()); err != nil {
+		return "", copied, err
+	}
+	return staged, copied, keepOwner(to, fi)
+}
@@ -0,0 +1,96 @@
+package fileinbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionName returns the name of version n of name, e.g. version 2 of
+// 20240101_pge.pdf is 20240101_pge.v2.pdf.  Version 1 is name itself.
+func VersionName(name string, n int) string {
+	if n <= 1 {
+		return name
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + ".v" + strconv.Itoa(n) + ext
+}
+
+// Versions returns the versions of name that exist, oldest first, name
+// itself included if it exists.
+func Versions(name string) ([]string, error) {
+	vs, err := versions(name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(vs))
+	for i, v := range vs {
+		names[i] = v.name
+	}
+	return names, nil
+}
+
+type version struct {
+	n    int
+	name string
+}
+
+func versions(name string) ([]version, error) {
+	dir, base := path.Dir(name), path.Base(name)
+	ext := path.Ext(base)
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(strings.TrimSuffix(base, ext)) + `\.v(\d+)` + regexp.QuoteMeta(ext) + `$`)
+
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var vs []version
+	for _, fi := range infos {
+		n := 0
+		if fi.Name() == base {
+			n = 1
+		} else if m := re.FindStringSubmatch(fi.Name()); m != nil {
+			// .v1 would be name itself
+			if k, _ := strconv.Atoi(m[1]); k > 1 {
+				n = k
+			}
+		}
+		if n != 0 {
+			vs = append(vs, version{n, path.Join(dir, fi.Name())})
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].n < vs[j].n })
+	return vs, nil
+}
+
+// NextVersion returns what to file from as when name is taken by a
+// different document: the existing version with the same contents, if
+// there is one, or else a version after the last.  dup is true for the
+// former.
+func NextVersion(from, name string) (next string, dup bool, err error) {
+	vs, err := versions(name)
+	if err != nil {
+		return "", false, err
+	}
+	last := 1
+	for _, v := range vs {
+		same, err := SameContents(from, v.name)
+		if err != nil {
+			return "", false, err
+		}
+		if same {
+			return v.name, true, nil
+		}
+		if v.n > last {
+			last = v.n
+		}
+	}
+	return VersionName(name, last+1), false, nil
+}